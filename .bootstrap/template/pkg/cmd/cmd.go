@@ -8,6 +8,7 @@ import (
 	"github.com/elixirhealth/service-base/pkg/cmd"
 	bserver "github.com/elixirhealth/service-base/pkg/server"
 	"github.com/elixirhealth/servicename/pkg/server"
+	"github.com/elixirhealth/servicename/pkg/server/storage"
 	"github.com/elixirhealth/servicename/version"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -19,12 +20,6 @@ const (
 	serviceNameCamel = "ServiceName"
 	envVarPrefix     = "SERVICENAME"
 	logLevelFlag     = "logLevel"
-
-	// TODO uncomment or delete
-	//storageMemoryFlag    = "storageMemory"
-	//storageDataStoreFlag = "storageDataStore"
-	//storagePostgresFlag  = "storagePostgres"
-	//dbURLFlag            = "dbURL"
 )
 
 var (
@@ -39,21 +34,27 @@ func init() {
 
 	cmd.Start(serviceNameLower, serviceNameCamel, rootCmd, version.Current, start,
 		func(flags *pflag.FlagSet) {
-			// TODO define other flags here if needed, e.g.,
-			//flags.Bool(storageMemoryFlag, true, "use in-memory storage")
-			//flags.Bool(storageDataStoreFlag, false, "use GCP DataStore storage")
-			//flags.Bool(storagePostgresFlag, false, "use Postgres DB storage")
-			//flags.String(dbURLFlag, "", "Postgres DB URL")
+			cmd.DefineStorageFlags(flags, storage.DefaultType)
+			// TODO define other flags here if needed
 		})
 
 	testCmd := cmd.Test(serviceNameLower, rootCmd)
 	cmd.TestHealth(serviceNameLower, testCmd)
+	cmd.TestAdmin(serviceNameLower, testCmd)
 	cmd.TestIO(serviceNameLower, testCmd, testIO, func(flags *pflag.FlagSet) {
 		// TODO define other flags here if needed
 	})
 
 	cmd.Version(serviceNameLower, rootCmd, version.Current)
 
+	// TODO uncomment if using Postgres storage, with the embedded migrations fs.FS
+	// cmd.MigrateWith(serviceNameLower, rootCmd,
+	// 	func(
+	// 		dbURL string, params *bstorage.MigratorParameters, logger migrate.Logger,
+	// 	) bstorage.Migrator {
+	// 		return bstorage.NewFSMigrator(dbURL, params, migrations.FS, logger)
+	// 	})
+
 	// bind viper flags
 	viper.SetEnvPrefix(envVarPrefix) // look for env vars with prefix
 	viper.AutomaticEnv()             // read in environment variables that match
@@ -82,6 +83,24 @@ func getServiceNameConfig() (*server.Config, error) {
 		WithProfilerPort(uint(viper.GetInt(cmd.ProfilerPortFlag))).
 		WithLogLevel(logging.GetLogLevel(viper.GetString(logLevelFlag))).
 		WithProfile(viper.GetBool(cmd.ProfileFlag))
+	c.WithKeepaliveTime(viper.GetDuration(cmd.KeepaliveTimeFlag)).
+		WithKeepaliveTimeout(viper.GetDuration(cmd.KeepaliveTimeoutFlag)).
+		WithKeepaliveMinTime(viper.GetDuration(cmd.KeepaliveMinTimeFlag)).
+		WithKeepalivePermitWithoutStream(viper.GetBool(cmd.KeepalivePermitWithoutStreamFlag)).
+		WithMaxConnectionIdle(viper.GetDuration(cmd.MaxConnectionIdleFlag)).
+		WithMaxConnectionAge(viper.GetDuration(cmd.MaxConnectionAgeFlag)).
+		WithMaxConnectionAgeGrace(viper.GetDuration(cmd.MaxConnectionAgeGraceFlag)).
+		WithMaxRecvMsgSize(viper.GetInt(cmd.MaxRecvMsgSizeFlag)).
+		WithMaxSendMsgSize(viper.GetInt(cmd.MaxSendMsgSizeFlag)).
+		WithCompression(viper.GetString(cmd.CompressionFlag))
+	c.WithGatewayPort(uint(viper.GetInt(cmd.GatewayPortFlag))).
+		WithGRPCWebPort(uint(viper.GetInt(cmd.GRPCWebPortFlag))).
+		WithCORSAllowedOrigins(viper.GetStringSlice(cmd.CORSAllowedOriginsFlag)...).
+		WithCORSAllowedHeaders(viper.GetStringSlice(cmd.CORSAllowedHeadersFlag)...).
+		WithCORSMaxAge(viper.GetDuration(cmd.CORSMaxAgeFlag))
+	if err := cmd.GetStorageParameters(&c.Storage.Parameters); err != nil {
+		return nil, err
+	}
 	// TODO set other config elements here
 
 	return c, nil
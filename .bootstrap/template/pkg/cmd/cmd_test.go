@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/elixirhealth/service-base/pkg/cmd"
+	bserver "github.com/elixirhealth/service-base/pkg/server"
+	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
@@ -15,6 +18,14 @@ func TestGetServiceNameConfig(t *testing.T) {
 	profilerPort := uint(9012)
 	logLevel := zapcore.DebugLevel.String()
 	profile := true
+	maxConnectionAge := 30 * time.Minute
+	maxRecvMsgSize := 16 * 1024 * 1024
+	compression := bserver.CompressionGzip
+	gatewayPort := uint(3456)
+	grpcWebPort := uint(7890)
+	corsAllowedOrigins := []string{"https://example.com"}
+	storageType := bstorage.Postgres
+	dbURL := "postgres://localhost:5432/servicename"
 	// TODO add other non-default config values
 
 	viper.Set(cmd.ServerPortFlag, serverPort)
@@ -22,6 +33,14 @@ func TestGetServiceNameConfig(t *testing.T) {
 	viper.Set(cmd.ProfilerPortFlag, profilerPort)
 	viper.Set(cmd.LogLevelFlag, logLevel)
 	viper.Set(cmd.ProfileFlag, profile)
+	viper.Set(cmd.MaxConnectionAgeFlag, maxConnectionAge)
+	viper.Set(cmd.MaxRecvMsgSizeFlag, maxRecvMsgSize)
+	viper.Set(cmd.CompressionFlag, compression)
+	viper.Set(cmd.GatewayPortFlag, gatewayPort)
+	viper.Set(cmd.GRPCWebPortFlag, grpcWebPort)
+	viper.Set(cmd.CORSAllowedOriginsFlag, corsAllowedOrigins)
+	viper.Set(cmd.StorageTypeFlag, storageType.String())
+	viper.Set(cmd.DBURLFlag, dbURL)
 	// TODO set other non-default config value
 
 	c, err := getServiceNameConfig()
@@ -31,6 +50,14 @@ func TestGetServiceNameConfig(t *testing.T) {
 	assert.Equal(t, profilerPort, c.ProfilerPort)
 	assert.Equal(t, logLevel, c.LogLevel.String())
 	assert.Equal(t, profile, c.Profile)
+	assert.Equal(t, maxConnectionAge, c.MaxConnectionAge)
+	assert.Equal(t, maxRecvMsgSize, c.MaxRecvMsgSize)
+	assert.Equal(t, compression, c.Compression)
+	assert.Equal(t, gatewayPort, c.GatewayPort)
+	assert.Equal(t, grpcWebPort, c.GRPCWebPort)
+	assert.Equal(t, corsAllowedOrigins, c.CORSAllowedOrigins)
+	assert.Equal(t, storageType, c.Storage.Type)
+	assert.Equal(t, dbURL, c.Storage.DBURL)
 	// TODO assert equal other non-default config values
 
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/elixirhealth/service-base/pkg/server"
 	"github.com/elixirhealth/servicename/pkg/server/storage"
+	"github.com/elixirhealth/servicename/version"
 )
 
 // ServiceName implements the ServiceNameServer interface.
@@ -17,6 +18,8 @@ type ServiceName struct {
 // newServiceName creates a new ServiceNameServer from the given config.
 func newServiceName(config *Config) (*ServiceName, error) {
 	baseServer := server.NewBaseServer(config.BaseConfig)
+	baseServer.SetBuildInfo(version.Current)
+	baseServer.SetEffectiveConfig(config)
 	storer, err := getStorer(config, baseServer.Logger)
 	if err != nil {
 		return nil, err
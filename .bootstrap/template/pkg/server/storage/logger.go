@@ -1,5 +0,0 @@
-package storage
-
-const (
-	logType = "type"
-)
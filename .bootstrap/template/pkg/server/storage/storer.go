@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"github.com/drausin/libri/libri/common/errors"
 	bstorage "github.com/elixirhealth/service-base/pkg/server/storage"
 	"go.uber.org/zap/zapcore"
 )
@@ -17,7 +18,7 @@ type Storer interface {
 
 // Parameters defines the parameters of the Storer.
 type Parameters struct {
-	Type bstorage.Type
+	bstorage.Parameters
 
 	// TODO add other params, often things like query timeouts to backend bstorage
 }
@@ -25,7 +26,7 @@ type Parameters struct {
 // NewDefaultParameters returns a *Parameters object with default values.
 func NewDefaultParameters() *Parameters {
 	return &Parameters{
-		Type: DefaultType,
+		Parameters: bstorage.Parameters{Type: DefaultType},
 
 		// TODO add other params defaults
 	}
@@ -33,7 +34,8 @@ func NewDefaultParameters() *Parameters {
 
 // MarshalLogObject writes the parameters to the given object encoder.
 func (p *Parameters) MarshalLogObject(oe zapcore.ObjectEncoder) error {
-	oe.AddString(logType, p.Type.String())
+	err := p.Parameters.MarshalLogObject(oe)
+	errors.MaybePanic(err) // should never happen
 	// TODO log other params here
 	return nil
 }
@@ -1,18 +1,30 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"time"
 
 	cerrors "github.com/drausin/libri/libri/common/errors"
 	"github.com/drausin/libri/libri/common/logging"
 	"github.com/drausin/libri/libri/common/parse"
 	"github.com/elixirhealth/service-base/pkg/server"
+	"github.com/elixirhealth/service-base/pkg/server/adminapi"
 	"github.com/elixirhealth/service-base/pkg/version"
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	adminTimeout = 3 * time.Second
+	logAddress   = "address"
 )
 
 const (
@@ -36,6 +48,59 @@ const (
 
 	// ProfileFlag gives the flag for whether the profiler is enabled or not.
 	ProfileFlag = "profile"
+
+	// KeepaliveTimeFlag gives the flag for the duration of connection inactivity after which
+	// the server pings the client.
+	KeepaliveTimeFlag = "keepaliveTime"
+
+	// KeepaliveTimeoutFlag gives the flag for the duration the server waits for a keepalive
+	// ping ack.
+	KeepaliveTimeoutFlag = "keepaliveTimeout"
+
+	// KeepaliveMinTimeFlag gives the flag for the minimum duration clients must wait between
+	// keepalive pings.
+	KeepaliveMinTimeFlag = "keepaliveMinTime"
+
+	// KeepalivePermitWithoutStreamFlag gives the flag for whether clients may send keepalive
+	// pings when there are no active streams.
+	KeepalivePermitWithoutStreamFlag = "keepalivePermitWithoutStream"
+
+	// MaxConnectionIdleFlag gives the flag for the duration after which idle connections are
+	// closed.
+	MaxConnectionIdleFlag = "maxConnectionIdle"
+
+	// MaxConnectionAgeFlag gives the flag for the maximum duration a connection may exist.
+	MaxConnectionAgeFlag = "maxConnectionAge"
+
+	// MaxConnectionAgeGraceFlag gives the flag for the duration pending RPCs have to complete
+	// after the max connection age.
+	MaxConnectionAgeGraceFlag = "maxConnectionAgeGrace"
+
+	// MaxRecvMsgSizeFlag gives the flag for the maximum message size the server can receive.
+	MaxRecvMsgSizeFlag = "maxRecvMsgSize"
+
+	// MaxSendMsgSizeFlag gives the flag for the maximum message size the server can send.
+	MaxSendMsgSizeFlag = "maxSendMsgSize"
+
+	// CompressionFlag gives the flag for the compression of server messages.
+	CompressionFlag = "compression"
+
+	// GatewayPortFlag gives the flag for the port to serve the HTTP/JSON gateway on.
+	GatewayPortFlag = "gatewayPort"
+
+	// GRPCWebPortFlag gives the flag for the port to serve gRPC-Web requests on.
+	GRPCWebPortFlag = "grpcWebPort"
+
+	// CORSAllowedOriginsFlag gives the flag for the origins allowed to make cross-origin gateway
+	// and gRPC-Web requests.
+	CORSAllowedOriginsFlag = "corsAllowedOrigins"
+
+	// CORSAllowedHeadersFlag gives the flag for the request headers allowed in cross-origin
+	// gateway requests.
+	CORSAllowedHeadersFlag = "corsAllowedHeaders"
+
+	// CORSMaxAgeFlag gives the flag for the duration browsers may cache CORS preflight responses.
+	CORSMaxAgeFlag = "corsMaxAge"
 )
 
 // Start returns the command to start the server via the passed in start func.
@@ -65,6 +130,39 @@ func Start(
 		"port for profiler endpoints (when enabled)")
 	cmd.Flags().Bool(ProfileFlag, server.DefaultProfile,
 		"whether to enable profiler")
+	cmd.Flags().Duration(KeepaliveTimeFlag, server.DefaultKeepaliveTime,
+		"inactivity duration after which the server pings the client")
+	cmd.Flags().Duration(KeepaliveTimeoutFlag, server.DefaultKeepaliveTimeout,
+		"duration to wait for a keepalive ping ack before closing the connection")
+	cmd.Flags().Duration(KeepaliveMinTimeFlag, server.DefaultKeepaliveMinTime,
+		"minimum duration clients must wait between keepalive pings")
+	cmd.Flags().Bool(KeepalivePermitWithoutStreamFlag,
+		server.DefaultKeepalivePermitWithoutStream,
+		"whether clients may send keepalive pings without active streams")
+	cmd.Flags().Duration(MaxConnectionIdleFlag, server.DefaultMaxConnectionIdle,
+		"duration after which idle connections are closed (0 for no limit)")
+	cmd.Flags().Duration(MaxConnectionAgeFlag, server.DefaultMaxConnectionAge,
+		"maximum duration a connection may exist (0 for no limit)")
+	cmd.Flags().Duration(MaxConnectionAgeGraceFlag, server.DefaultMaxConnectionAgeGrace,
+		"duration pending RPCs have to complete after max connection age (0 for no limit)")
+	cmd.Flags().Int(MaxRecvMsgSizeFlag, server.DefaultMaxRecvMsgSize,
+		"maximum message size (bytes) the server can receive")
+	cmd.Flags().Int(MaxSendMsgSizeFlag, server.DefaultMaxSendMsgSize,
+		"maximum message size (bytes) the server can send")
+	cmd.Flags().String(CompressionFlag, server.DefaultCompression,
+		fmt.Sprintf("compression of server messages (%s or %s)", server.CompressionNone,
+			server.CompressionGzip))
+	cmd.Flags().Uint(GatewayPortFlag, server.DefaultGatewayPort,
+		"port for the HTTP/JSON gateway, which may equal the main port (0 to disable)")
+	cmd.Flags().Uint(GRPCWebPortFlag, server.DefaultGRPCWebPort,
+		"port for gRPC-Web requests (0 to disable)")
+	cmd.Flags().StringSlice(CORSAllowedOriginsFlag, nil,
+		fmt.Sprintf("origins allowed to make cross-origin gateway and gRPC-Web requests "+
+			"(%s for any)", server.CORSAllowAllOrigins))
+	cmd.Flags().StringSlice(CORSAllowedHeadersFlag, server.DefaultCORSAllowedHeaders,
+		"request headers allowed in cross-origin gateway requests")
+	cmd.Flags().Duration(CORSMaxAgeFlag, server.DefaultCORSMaxAge,
+		"duration browsers may cache CORS preflight responses")
 	defineFlags(cmd.Flags())
 
 	err := viper.BindPFlags(cmd.Flags())
@@ -109,6 +207,23 @@ func TestHealth(serviceName string, parent *cobra.Command) *cobra.Command {
 	return cmd
 }
 
+// TestAdmin returns the command for printing the build info, config, and runtime stats of one or
+// more servers.
+func TestAdmin(serviceName string, parent *cobra.Command) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: fmt.Sprintf("print admin info of one or more %s servers", serviceName),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := writeAdminInfos(os.Stdout, server.NewInsecureDialer()); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	parent.AddCommand(cmd)
+	return cmd
+}
+
 // TestIO returns the command for testing the I/O of one or more servers.
 func TestIO(
 	serviceName string,
@@ -160,3 +275,58 @@ func getHealthChecker() (server.HealthChecker, error) {
 	lg := logging.NewDevLogger(logging.GetLogLevel(viper.GetString(LogLevelFlag)))
 	return server.NewHealthChecker(server.NewInsecureDialer(), addrs, lg)
 }
+
+type adminInfo struct {
+	Address string          `json:"address"`
+	Info    json.RawMessage `json:"info"`
+}
+
+// writeAdminInfos writes the JSON admin info of each server in the AddressesFlag to the given
+// writer, returning an error if any server's info could not be fetched.
+func writeAdminInfos(w io.Writer, dialer server.Dialer) error {
+	addrs, err := parse.Addrs(viper.GetStringSlice(AddressesFlag))
+	if err != nil {
+		return err
+	}
+	lg := logging.NewDevLogger(logging.GetLogLevel(viper.GetString(LogLevelFlag)))
+	m := &jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+	infos := make([]*adminInfo, 0, len(addrs))
+	var lastErr error
+	for _, addr := range addrs {
+		info, err := getAdminInfo(dialer, addr.String(), m)
+		if err != nil {
+			lg.Error("failed to get admin info", zap.String(logAddress, addr.String()),
+				zap.Error(err))
+			lastErr = err
+			continue
+		}
+		infos = append(infos, &adminInfo{Address: addr.String(), Info: info})
+	}
+	buf, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+func getAdminInfo(dialer server.Dialer, addr string, m *jsonpb.Marshaler) (json.RawMessage, error) {
+	conn, err := dialer.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { cerrors.MaybePanic(conn.Close()) }()
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+	rp, err := adminapi.NewAdminClient(conn).Info(ctx, &adminapi.InfoRequest{})
+	if err != nil {
+		return nil, err
+	}
+	info, err := m.MarshalToString(rp)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(info), nil
+}
@@ -1,15 +1,20 @@
 package cmd
 
 import (
-	"testing"
-
+	"bytes"
+	"encoding/json"
 	"strings"
+	"testing"
 
+	"github.com/elixirhealth/service-base/pkg/server"
+	"github.com/elixirhealth/service-base/pkg/server/adminapi"
 	"github.com/elixirhealth/service-base/version"
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -41,6 +46,30 @@ func TestStart(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotEmpty(t, val1)
 
+	keepaliveTime, err := cmd.Flags().GetDuration(KeepaliveTimeFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, server.DefaultKeepaliveTime, keepaliveTime)
+
+	maxRecvMsgSize, err := cmd.Flags().GetInt(MaxRecvMsgSizeFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, server.DefaultMaxRecvMsgSize, maxRecvMsgSize)
+
+	compression, err := cmd.Flags().GetString(CompressionFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, server.DefaultCompression, compression)
+
+	gatewayPort, err := cmd.Flags().GetUint(GatewayPortFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, server.DefaultGatewayPort, gatewayPort)
+
+	grpcWebPort, err := cmd.Flags().GetUint(GRPCWebPortFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, server.DefaultGRPCWebPort, grpcWebPort)
+
+	corsAllowedHeaders, err := cmd.Flags().GetStringSlice(CORSAllowedHeadersFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, server.DefaultCORSAllowedHeaders, corsAllowedHeaders)
+
 	val2, err := cmd.Flags().GetString(additionalFlag)
 	assert.Nil(t, err)
 	assert.NotEmpty(t, val2)
@@ -66,6 +95,55 @@ func TestTestHealth(t *testing.T) {
 	assert.NotEmpty(t, parent.Commands())
 }
 
+func TestTestAdmin(t *testing.T) {
+	parent := &cobra.Command{}
+	cmd := TestAdmin(serviceName, parent)
+	assert.NotNil(t, cmd)
+	assert.True(t, strings.Contains(cmd.Short, serviceName))
+	assert.NotNil(t, cmd.Run)
+	assert.NotEmpty(t, parent.Commands())
+}
+
+func TestWriteAdminInfos(t *testing.T) {
+	c := server.NewDefaultBaseConfig().
+		WithServerPort(10170).
+		WithMetricsPort(10171)
+	srv := server.NewBaseServer(c)
+	srv.SetBuildInfo(version.Current)
+	go func() {
+		err := srv.Serve(func(s *grpc.Server) {}, func() {})
+		assert.Nil(t, err)
+	}()
+	srv.WaitUntilStarted()
+	defer srv.StopServer()
+
+	viper.Set(AddressesFlag, "localhost:10170")
+	out := new(bytes.Buffer)
+	err := writeAdminInfos(out, server.NewInsecureDialer())
+	assert.Nil(t, err)
+	infos := []*adminInfo{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &infos))
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "127.0.0.1:10170", infos[0].Address)
+	info := &adminapi.InfoResponse{}
+	assert.Nil(t, jsonpb.UnmarshalString(string(infos[0].Info), info))
+	assert.Equal(t, version.Current.Version.String(), info.BuildInfo.Version)
+
+	// unreachable server
+	viper.Set(AddressesFlag, "localhost:10170 localhost:10179")
+	out.Reset()
+	err = writeAdminInfos(out, server.NewInsecureDialer())
+	assert.NotNil(t, err)
+	infos = []*adminInfo{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &infos))
+	assert.Len(t, infos, 1)
+
+	// bad addresses
+	viper.Set(AddressesFlag, "10170")
+	err = writeAdminInfos(out, server.NewInsecureDialer())
+	assert.NotNil(t, err)
+}
+
 func TestTestIO(t *testing.T) {
 	parent := &cobra.Command{}
 	tested := false
@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drausin/libri/libri/common/logging"
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/source"
+	"github.com/mattes/migrate/source/go-bindata"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DryRunFlag gives the flag for whether to only print the migrations that would be applied.
+	DryRunFlag = "dry-run"
+
+	// MigrationsDirFlag gives the flag for the directory of the migration SQL files.
+	MigrationsDirFlag = "dir"
+
+	// AllFlag gives the flag for whether to migrate all the way down.
+	AllFlag = "all"
+
+	// MigrationLockTimeoutFlag gives the flag for the maximum time to wait for the migration
+	// lock, e.g., while another replica migrates the DB.
+	MigrationLockTimeoutFlag = "lockTimeout"
+
+	// DefaultMigrationsDir is the default directory of the migration SQL files.
+	DefaultMigrationsDir = "migrations"
+
+	migrationsVersionWidth = 3
+	migrationFileMode      = 0644
+)
+
+var (
+	// ErrMigrationVersionNotFound indicates when a goto version isn't among the migrations.
+	ErrMigrationVersionNotFound = storage.ErrMigrationVersionNotFound
+
+	// ErrMigrationExists indicates when creating a migration file that already exists.
+	ErrMigrationExists = errors.New("migration file already exists")
+)
+
+// Migrate returns the parent command for migrating the service's Postgres DB with the migrations in
+// the given go-bindata asset source.
+func Migrate(serviceName string, parent *cobra.Command, as *bindata.AssetSource) *cobra.Command {
+	return MigrateWith(serviceName, parent,
+		func(
+			dbURL string, params *storage.MigratorParameters, logger migrate.Logger,
+		) storage.Migrator {
+			return storage.NewBindataMigratorWithParams(dbURL, params, as, logger)
+		})
+}
+
+// MigrateWith returns the parent command for migrating the service's Postgres DB with the
+// Migrator created by the given function, e.g., one using storage.NewFSMigrator for embedded
+// migrations.
+func MigrateWith(
+	serviceName string,
+	parent *cobra.Command,
+	newMigrator func(
+		dbURL string, params *storage.MigratorParameters, logger migrate.Logger,
+	) storage.Migrator,
+) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: fmt.Sprintf("migrate the %s Postgres DB", serviceName),
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// bind here rather than on construction so these flags don't override those of
+			// the same name bound by other commands (e.g., start's dbURL)
+			if err := viper.BindPFlags(cmd.Flags()); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cmd.PersistentFlags().String(DBURLFlag, "", "Postgres DB URL")
+	cmd.PersistentFlags().Bool(DryRunFlag, false,
+		"print the migrations (and their SQL) that would be applied without applying them")
+	cmd.PersistentFlags().Duration(MigrationLockTimeoutFlag, storage.DefaultMigrationLockTimeout,
+		"maximum time to wait for the migration lock held by another migrator")
+
+	newRunner := func() *migrateRunner {
+		lg := logging.NewDevLogger(logging.GetLogLevel(viper.GetString(LogLevelFlag)))
+		params := storage.NewDefaultMigratorParameters()
+		params.LockTimeout = viper.GetDuration(MigrationLockTimeoutFlag)
+		m := newMigrator(viper.GetString(DBURLFlag), params, &storage.ZapLogger{Logger: lg})
+		return &migrateRunner{m: m, out: os.Stdout, dryRun: viper.GetBool(DryRunFlag)}
+	}
+	run := func(fn func(r *migrateRunner, args []string) error) func(*cobra.Command, []string) {
+		return func(cmd *cobra.Command, args []string) {
+			if err := fn(newRunner(), args); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "apply all pending migrations",
+		Args:  cobra.NoArgs,
+		Run: run(func(r *migrateRunner, args []string) error {
+			return r.up()
+		}),
+	})
+	downCmd := &cobra.Command{
+		Use:   "down [N]",
+		Short: "roll back the last N (default 1) applied migrations",
+		Args:  cobra.MaximumNArgs(1),
+		Run: run(func(r *migrateRunner, args []string) error {
+			n := uint64(1)
+			if len(args) == 1 {
+				var err error
+				if n, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+					return err
+				}
+			}
+			return r.down(int(n), viper.GetBool(AllFlag))
+		}),
+	}
+	downCmd.Flags().Bool(AllFlag, false, "roll back all applied migrations")
+	cmd.AddCommand(downCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "goto VERSION",
+		Short: "migrate up or down to the given version (0 for none)",
+		Args:  cobra.ExactArgs(1),
+		Run: run(func(r *migrateRunner, args []string) error {
+			version, err := strconv.ParseUint(args[0], 10, 32)
+			if err != nil {
+				return err
+			}
+			return r.gotoVersion(uint(version))
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:     "steps N",
+		Short:   "apply the next N migrations or roll back the last -N",
+		Example: "  migrate steps 2\n  migrate steps -- -2",
+		Args:    cobra.ExactArgs(1),
+		Run: run(func(r *migrateRunner, args []string) error {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			return r.steps(n)
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use: "force VERSION",
+		Short: "set the migration version (-1 for none) and clear the dirty flag without " +
+			"running any migrations",
+		Args: cobra.ExactArgs(1),
+		Run: run(func(r *migrateRunner, args []string) error {
+			version, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			return r.force(version)
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "print the applied and pending migrations",
+		Args:  cobra.NoArgs,
+		Run: run(func(r *migrateRunner, args []string) error {
+			return r.status()
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "history",
+		Short: "print the applied and failed migrations, oldest first",
+		Args:  cobra.NoArgs,
+		Run: run(func(r *migrateRunner, args []string) error {
+			return r.history()
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use: "lint",
+		Short: "print risky operations, missing down migrations, and version gaps in the " +
+			"migrations as JSON, exiting non-zero if there are any",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			nFindings, err := newRunner().lint()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if nFindings > 0 {
+				os.Exit(1)
+			}
+		},
+	})
+	createCmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "create empty up and down SQL files for a new migration",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			err := createMigration(os.Stdout, viper.GetString(MigrationsDirFlag), args[0],
+				viper.GetBool(DryRunFlag))
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	createCmd.Flags().String(MigrationsDirFlag, DefaultMigrationsDir,
+		"directory of the migration SQL files")
+	cmd.AddCommand(createCmd)
+
+	parent.AddCommand(cmd)
+	return cmd
+}
+
+// migrateRunner runs the migrate subcommands, printing the planned migrations instead of
+// applying them when dryRun is set.
+type migrateRunner struct {
+	m      storage.Migrator
+	out    io.Writer
+	dryRun bool
+}
+
+func (r *migrateRunner) up() error {
+	if r.dryRun {
+		return r.writePlan(math.MaxInt32)
+	}
+	return r.m.Up()
+}
+
+func (r *migrateRunner) down(n int, all bool) error {
+	if !all {
+		return r.steps(-n)
+	}
+	if r.dryRun {
+		return r.writePlan(-math.MaxInt32)
+	}
+	return r.m.Down()
+}
+
+func (r *migrateRunner) gotoVersion(version uint) error {
+	if r.dryRun {
+		return r.writePlanned(r.m.PlanGoto(version))
+	}
+	return r.m.Goto(version)
+}
+
+func (r *migrateRunner) steps(n int) error {
+	if r.dryRun {
+		return r.writePlan(n)
+	}
+	return r.m.Steps(n)
+}
+
+func (r *migrateRunner) force(version int) error {
+	if r.dryRun {
+		_, err := fmt.Fprintf(r.out, "would force version %d\n", version)
+		return err
+	}
+	return r.m.Force(version)
+}
+
+func (r *migrateRunner) status() error {
+	statuses, err := r.m.Status()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+	if _, err = fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS"); err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		status := "pending"
+		if s.Dirty {
+			status = "dirty"
+		} else if s.Applied {
+			status = "applied"
+		}
+		if _, err = fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Identifier, status); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func (r *migrateRunner) history() error {
+	records, err := r.m.History()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+	_, err = fmt.Fprintln(tw, "VERSION\tNAME\tDIRECTION\tAPPLIED BY\tSTARTED\tDURATION\tERROR")
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		_, err = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", rec.Version, rec.Identifier,
+			rec.Direction, rec.AppliedBy, rec.StartedAt.UTC().Format(time.RFC3339),
+			time.Duration(rec.DurationMS)*time.Millisecond, rec.Error)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// lint writes the lint findings for the migrations as JSON and returns the number of findings.
+// Problems in a migration can be ignored with a "-- lint:ignore rule" comment in its SQL.
+func (r *migrateRunner) lint() (int, error) {
+	findings, err := r.m.Lint()
+	if err != nil {
+		return 0, err
+	}
+	buf, err := json.MarshalIndent(findings, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if _, err = r.out.Write(append(buf, '\n')); err != nil {
+		return 0, err
+	}
+	return len(findings), nil
+}
+
+// writePlan writes the SQL of the migrations that n steps would apply.
+func (r *migrateRunner) writePlan(n int) error {
+	return r.writePlanned(r.m.PlanSteps(n))
+}
+
+// writePlanned writes the given planned migrations, or the error from planning them.
+func (r *migrateRunner) writePlanned(planned []*storage.PlannedMigration, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(planned) == 0 {
+		_, err = fmt.Fprintln(r.out, "no change")
+		return err
+	}
+	for _, pm := range planned {
+		direction := source.Down
+		if pm.Up {
+			direction = source.Up
+		}
+		_, err = fmt.Fprintf(r.out, "-- %d_%s.%s.sql\n%s\n\n", pm.Version, pm.Identifier,
+			direction, pm.SQL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createMigration creates empty up and down SQL files in the given directory for a new migration
+// with the given name and the next version number.
+func createMigration(out io.Writer, dir, name string, dryRun bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	next := uint(1)
+	for _, f := range files {
+		m, err := source.Parse(f.Name())
+		if err != nil {
+			continue // not a migration file
+		}
+		if m.Version >= next {
+			next = m.Version + 1
+		}
+	}
+	for _, direction := range []source.Direction{source.Up, source.Down} {
+		filename := fmt.Sprintf("%0*d_%s.%s.sql", migrationsVersionWidth, next, name, direction)
+		path := filepath.Join(dir, filename)
+		if _, err = os.Stat(path); err == nil {
+			return ErrMigrationExists
+		}
+		if _, err = fmt.Fprintln(out, path); err != nil {
+			return err
+		}
+		if dryRun {
+			continue
+		}
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(path, nil, migrationFileMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/source/go-bindata"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	parent := &cobra.Command{}
+	as := bindata.Resource([]string{}, func(name string) ([]byte, error) {
+		return nil, os.ErrNotExist
+	})
+	cmd := Migrate(serviceName, parent, as)
+	assert.True(t, strings.Contains(cmd.Short, serviceName))
+	assert.Equal(t, []*cobra.Command{cmd}, parent.Commands())
+
+	subcmds := make([]string, 0)
+	for _, subcmd := range cmd.Commands() {
+		subcmds = append(subcmds, subcmd.Name())
+		assert.NotNil(t, subcmd.Run)
+	}
+	expected := []string{
+		"create", "down", "force", "goto", "history", "lint", "status", "steps", "up",
+	}
+	assert.Equal(t, expected, subcmds)
+	assert.NotNil(t, cmd.PersistentFlags().Lookup(DBURLFlag))
+	assert.NotNil(t, cmd.PersistentFlags().Lookup(DryRunFlag))
+	assert.NotNil(t, cmd.PersistentFlags().Lookup(MigrationLockTimeoutFlag))
+}
+
+func TestMigrateWith(t *testing.T) {
+	parent := &cobra.Command{}
+	cmd := MigrateWith(serviceName, parent,
+		func(
+			dbURL string, params *storage.MigratorParameters, logger migrate.Logger,
+		) storage.Migrator {
+			return newFixedMigrator()
+		})
+	assert.Equal(t, []*cobra.Command{cmd}, parent.Commands())
+	assert.Len(t, cmd.Commands(), 9)
+}
+
+func TestMigrateRunner(t *testing.T) {
+	m := newFixedMigrator()
+	r := &migrateRunner{m: m, out: new(bytes.Buffer)}
+
+	assert.Nil(t, r.up())
+	assert.Nil(t, r.down(2, false))
+	assert.Nil(t, r.down(0, true))
+	assert.Nil(t, r.gotoVersion(3))
+	assert.Nil(t, r.steps(-1))
+	assert.Nil(t, r.force(-1))
+	assert.Equal(t, []string{"up", "steps -2", "down", "goto 3", "steps -1", "force -1"},
+		m.calls)
+	assert.Empty(t, r.out.(*bytes.Buffer).String())
+}
+
+func TestMigrateRunner_dryRun(t *testing.T) {
+	cases := map[string]struct {
+		run      func(r *migrateRunner) error
+		expected int
+	}{
+		"up": {
+			run:      func(r *migrateRunner) error { return r.up() },
+			expected: math.MaxInt32,
+		},
+		"down": {
+			run:      func(r *migrateRunner) error { return r.down(1, false) },
+			expected: -1,
+		},
+		"down all": {
+			run:      func(r *migrateRunner) error { return r.down(1, true) },
+			expected: -math.MaxInt32,
+		},
+		"goto 3": {
+			run:      func(r *migrateRunner) error { return r.gotoVersion(3) },
+			expected: 2,
+		},
+		"goto 0": {
+			run:      func(r *migrateRunner) error { return r.gotoVersion(0) },
+			expected: -1,
+		},
+		"steps 1": {
+			run:      func(r *migrateRunner) error { return r.steps(1) },
+			expected: 1,
+		},
+	}
+	for name, c := range cases {
+		m := newFixedMigrator()
+		out := new(bytes.Buffer)
+		r := &migrateRunner{m: m, out: out, dryRun: true}
+		assert.Nil(t, c.run(r), name)
+		assert.Equal(t, []string{"plan " + strconv.Itoa(c.expected)}, m.calls, name)
+		if c.expected > 0 {
+			assert.Contains(t, out.String(), "-- 2_second.up.sql\n-- up second\n", name)
+		} else {
+			assert.Equal(t, "-- 1_first.down.sql\n-- down first\n\n", out.String(), name)
+		}
+	}
+
+	// goto unknown version
+	r := &migrateRunner{m: newFixedMigrator(), out: new(bytes.Buffer), dryRun: true}
+	assert.Equal(t, ErrMigrationVersionNotFound, r.gotoVersion(4))
+
+	// no change
+	out := new(bytes.Buffer)
+	r = &migrateRunner{m: newFixedMigrator(), out: out, dryRun: true}
+	assert.Nil(t, r.steps(0))
+	assert.Equal(t, "no change\n", out.String())
+
+	// force
+	out = new(bytes.Buffer)
+	m := newFixedMigrator()
+	r = &migrateRunner{m: m, out: out, dryRun: true}
+	assert.Nil(t, r.force(2))
+	assert.Equal(t, []string{}, m.calls)
+	assert.Equal(t, "would force version 2\n", out.String())
+}
+
+func TestMigrateRunner_status(t *testing.T) {
+	m := newFixedMigrator()
+	m.statuses[0].Dirty = true
+	out := new(bytes.Buffer)
+	r := &migrateRunner{m: m, out: out}
+	assert.Nil(t, r.status())
+	expected := "VERSION  NAME    STATUS\n" +
+		"1        first   dirty\n" +
+		"2        second  pending\n" +
+		"3        third   pending\n"
+	assert.Equal(t, expected, out.String())
+}
+
+func TestMigrateRunner_history(t *testing.T) {
+	m := newFixedMigrator()
+	m.records = []*storage.MigrationRecord{
+		{
+			Version:    1,
+			Identifier: "first",
+			Direction:  "up",
+			AppliedBy:  "pod-1",
+			StartedAt:  time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+			DurationMS: 1500,
+		},
+		{
+			Version:    2,
+			Identifier: "second",
+			Direction:  "up",
+			AppliedBy:  "pod-1",
+			StartedAt:  time.Date(2018, 1, 2, 3, 4, 7, 0, time.UTC),
+			DurationMS: 20,
+			Error:      "syntax error",
+		},
+	}
+	out := new(bytes.Buffer)
+	r := &migrateRunner{m: m, out: out}
+	assert.Nil(t, r.history())
+	expected := "VERSION  NAME    DIRECTION  APPLIED BY  STARTED               DURATION  ERROR\n" +
+		"1        first   up         pod-1       2018-01-02T03:04:05Z  1.5s      \n" +
+		"2        second  up         pod-1       2018-01-02T03:04:07Z  20ms      syntax error\n"
+	assert.Equal(t, expected, out.String())
+}
+
+func TestMigrateRunner_lint(t *testing.T) {
+	m := newFixedMigrator()
+	out := new(bytes.Buffer)
+	r := &migrateRunner{m: m, out: out}
+	n, err := r.lint()
+	assert.Nil(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, "[]\n", out.String())
+
+	m.findings = append(m.findings, &storage.LintFinding{
+		Version:    3,
+		Identifier: "third",
+		Rule:       storage.LintRuleMissingDown,
+		Message:    "up migration has no matching down migration",
+	})
+	out.Reset()
+	n, err = r.lint()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	findings := make([]*storage.LintFinding, 0)
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &findings))
+	assert.Equal(t, m.findings, findings)
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrations")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	dir = filepath.Join(dir, "migrations") // doesn't exist yet
+
+	out := new(bytes.Buffer)
+	err = createMigration(out, dir, "create-a", true)
+	assert.Nil(t, err)
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, filepath.Join(dir, "001_create-a.up.sql")+"\n"+
+		filepath.Join(dir, "001_create-a.down.sql")+"\n", out.String())
+
+	err = createMigration(new(bytes.Buffer), dir, "create-a", false)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "README.md"), nil, migrationFileMode)
+	assert.Nil(t, err)
+	err = createMigration(new(bytes.Buffer), dir, "create-b", false)
+	assert.Nil(t, err)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	filenames := make([]string, len(files))
+	for i, f := range files {
+		filenames[i] = f.Name()
+	}
+	assert.Equal(t, []string{
+		"001_create-a.down.sql",
+		"001_create-a.up.sql",
+		"002_create-b.down.sql",
+		"002_create-b.up.sql",
+		"README.md",
+	}, filenames)
+}
+
+// fixedMigrator is a storage.Migrator with fixed migrations, the first of which is applied, that
+// records the calls that would change the DB or plan changes.
+type fixedMigrator struct {
+	statuses []*storage.MigrationStatus
+	findings []*storage.LintFinding
+	records  []*storage.MigrationRecord
+	calls    []string
+}
+
+func newFixedMigrator() *fixedMigrator {
+	return &fixedMigrator{
+		statuses: []*storage.MigrationStatus{
+			{Version: 1, Identifier: "first", Applied: true},
+			{Version: 2, Identifier: "second"},
+			{Version: 3, Identifier: "third"},
+		},
+		findings: make([]*storage.LintFinding, 0),
+		records:  make([]*storage.MigrationRecord, 0),
+		calls:    make([]string, 0),
+	}
+}
+
+func (m *fixedMigrator) Up() error {
+	m.calls = append(m.calls, "up")
+	return nil
+}
+
+func (m *fixedMigrator) Down() error {
+	m.calls = append(m.calls, "down")
+	return nil
+}
+
+func (m *fixedMigrator) Version() (uint, bool, error) {
+	return 1, m.statuses[0].Dirty, nil
+}
+
+func (m *fixedMigrator) Goto(version uint) error {
+	m.calls = append(m.calls, "goto "+strconv.Itoa(int(version)))
+	return nil
+}
+
+func (m *fixedMigrator) Steps(n int) error {
+	m.calls = append(m.calls, "steps "+strconv.Itoa(n))
+	return nil
+}
+
+func (m *fixedMigrator) Force(version int) error {
+	m.calls = append(m.calls, "force "+strconv.Itoa(version))
+	return nil
+}
+
+func (m *fixedMigrator) Status() ([]*storage.MigrationStatus, error) {
+	return m.statuses, nil
+}
+
+func (m *fixedMigrator) PlanSteps(n int) ([]*storage.PlannedMigration, error) {
+	m.calls = append(m.calls, "plan "+strconv.Itoa(n))
+	planned := make([]*storage.PlannedMigration, 0)
+	for i := 0; i < n && i+1 < len(m.statuses); i++ {
+		s := m.statuses[i+1]
+		planned = append(planned, &storage.PlannedMigration{
+			Version:    s.Version,
+			Identifier: s.Identifier,
+			Up:         true,
+			SQL:        "-- up " + s.Identifier,
+		})
+	}
+	if n < 0 {
+		planned = append(planned, &storage.PlannedMigration{
+			Version:    1,
+			Identifier: "first",
+			SQL:        "-- down first",
+		})
+	}
+	return planned, nil
+}
+
+func (m *fixedMigrator) PlanGoto(version uint) ([]*storage.PlannedMigration, error) {
+	// the first of the fixed migrations is applied
+	for i, s := range m.statuses {
+		if s.Version == version {
+			return m.PlanSteps(i)
+		}
+	}
+	if version == 0 {
+		return m.PlanSteps(-1)
+	}
+	return nil, ErrMigrationVersionNotFound
+}
+
+func (m *fixedMigrator) Lint() ([]*storage.LintFinding, error) {
+	return m.findings, nil
+}
+
+func (m *fixedMigrator) History() ([]*storage.MigrationRecord, error) {
+	return m.records, nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// StorageTypeFlag gives the flag for the storage backend type.
+	StorageTypeFlag = "storageType"
+
+	// DBURLFlag gives the flag for the Postgres DB URL.
+	DBURLFlag = "dbURL"
+)
+
+// DefineStorageFlags defines the storage type flag, with the given default type, and the DB URL
+// flag. It is usually called within the defineFlags function given to Start.
+func DefineStorageFlags(flags *pflag.FlagSet, defaultType storage.Type) {
+	flags.String(StorageTypeFlag, defaultType.String(),
+		fmt.Sprintf("storage backend type (%s, %s, or %s)", storage.Memory,
+			storage.DataStore, storage.Postgres))
+	flags.String(DBURLFlag, "", "Postgres DB URL (required for Postgres storage)")
+}
+
+// GetStorageParameters sets the storage type and DB URL of the given parameters from the values
+// of the flags defined by DefineStorageFlags (and bound via viper), validating their combination.
+// An unspecified storage type leaves the existing type as-is. The other parameters (e.g., the
+// Postgres pool parameters) are kept, and none are changed if the combination is invalid.
+func GetStorageParameters(p *storage.Parameters) error {
+	tp, err := storage.ParseType(viper.GetString(StorageTypeFlag))
+	if err != nil {
+		return err
+	}
+	params := *p
+	params.DBURL = viper.GetString(DBURLFlag)
+	if tp != storage.Unspecified {
+		params.Type = tp
+	}
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	*p = params
+	return nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefineStorageFlags(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	DefineStorageFlags(flags, storage.DataStore)
+
+	storageType, err := flags.GetString(StorageTypeFlag)
+	assert.Nil(t, err)
+	assert.Equal(t, storage.DataStore.String(), storageType)
+
+	dbURL, err := flags.GetString(DBURLFlag)
+	assert.Nil(t, err)
+	assert.Empty(t, dbURL)
+}
+
+func TestGetStorageParameters_ok(t *testing.T) {
+	defer viper.Reset()
+	dbURL := "postgres://localhost:5432/db"
+	cases := []struct {
+		storageType string
+		dbURL       string
+		expected    *storage.Parameters
+	}{
+		{
+			storageType: "memory",
+			expected:    &storage.Parameters{Type: storage.Memory},
+		},
+		{
+			storageType: "DataStore",
+			expected:    &storage.Parameters{Type: storage.DataStore},
+		},
+		{
+			storageType: "postgres",
+			dbURL:       dbURL,
+			expected:    &storage.Parameters{Type: storage.Postgres, DBURL: dbURL},
+		},
+		{
+			// unspecified type keeps existing type
+			storageType: "",
+			expected:    &storage.Parameters{Type: storage.Memory},
+		},
+	}
+	for _, c := range cases {
+		viper.Set(StorageTypeFlag, c.storageType)
+		viper.Set(DBURLFlag, c.dbURL)
+		p := &storage.Parameters{Type: storage.Memory}
+		err := GetStorageParameters(p)
+		assert.Nil(t, err, c.storageType)
+		assert.Equal(t, c.expected, p, c.storageType)
+	}
+
+	// other parameters are kept
+	viper.Set(StorageTypeFlag, "postgres")
+	viper.Set(DBURLFlag, dbURL)
+	pp := &storage.PostgresParameters{MaxOpenConns: 8}
+	p := &storage.Parameters{Type: storage.Memory, Postgres: pp}
+	err := GetStorageParameters(p)
+	assert.Nil(t, err)
+	assert.Equal(t, &storage.Parameters{Type: storage.Postgres, DBURL: dbURL, Postgres: pp}, p)
+}
+
+func TestGetStorageParameters_err(t *testing.T) {
+	defer viper.Reset()
+	cases := []struct {
+		storageType string
+		dbURL       string
+		expected    error
+	}{
+		{storageType: "mysql", expected: storage.ErrInvalidType},
+		{storageType: "postgres", expected: storage.ErrMissingDBURL},
+		{
+			storageType: "memory",
+			dbURL:       "postgres://localhost:5432/db",
+			expected:    storage.ErrUnexpectedDBURL,
+		},
+	}
+	for _, c := range cases {
+		viper.Set(StorageTypeFlag, c.storageType)
+		viper.Set(DBURLFlag, c.dbURL)
+		p := &storage.Parameters{Type: storage.Memory}
+		err := GetStorageParameters(p)
+		assert.Equal(t, c.expected, err, c.storageType)
+		assert.Equal(t, &storage.Parameters{Type: storage.Memory}, p, c.storageType)
+	}
+}
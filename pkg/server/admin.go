@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/elixirhealth/service-base/pkg/server/adminapi"
+	"github.com/elixirhealth/service-base/pkg/util"
+	"github.com/elixirhealth/service-base/pkg/version"
+	"go.uber.org/zap/zapcore"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// sensitiveConfigKeys are the (lowercase) substrings of config keys whose values are redacted.
+var sensitiveConfigKeys = []string{"password", "secret", "token", "credential", "private"}
+
+// SetBuildInfo sets the build info returned by the Admin service.
+func (b *BaseServer) SetBuildInfo(bi version.BuildInfo) {
+	b.buildInfo = bi
+}
+
+// SetEffectiveConfig sets the config returned (with sensitive values redacted) by the Admin
+// service. It defaults to the BaseConfig but will usually be the full service config.
+func (b *BaseServer) SetEffectiveConfig(config zapcore.ObjectMarshaler) {
+	b.effectiveConfig = config
+}
+
+// SetServingStatus sets the serving status of the given health component, where the empty service
+// name is the server as a whole.
+func (b *BaseServer) SetServingStatus(
+	service string, status healthpb.HealthCheckResponse_ServingStatus,
+) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+	b.healthStatuses[service] = status
+	b.health.SetServingStatus(service, status)
+}
+
+// adminServer implements the adminapi.AdminServer interface for a BaseServer.
+type adminServer struct {
+	b *BaseServer
+}
+
+// Info returns the build info, effective config, and runtime stats of the server.
+func (a *adminServer) Info(
+	ctx context.Context, rq *adminapi.InfoRequest,
+) (*adminapi.InfoResponse, error) {
+	config, err := redactedConfigJSON(a.b.effectiveConfig)
+	if err != nil {
+		return nil, err
+	}
+	rp := &adminapi.InfoResponse{
+		BuildInfo: &adminapi.BuildInfo{
+			Version:     a.b.buildInfo.Version.String(),
+			GitBranch:   a.b.buildInfo.GitBranch,
+			GitRevision: a.b.buildInfo.GitRevision,
+			BuildDate:   a.b.buildInfo.BuildDate,
+			GoVersion:   runtime.Version(),
+		},
+		Config:  config,
+		State:   a.b.State().String(),
+		Runtime: getRuntimeStats(),
+		Health:  a.b.healthStatusNames(),
+	}
+	if !a.b.startTime.IsZero() {
+		rp.UptimeMs = int64(time.Since(a.b.startTime) / time.Millisecond)
+	}
+	return rp, nil
+}
+
+func (b *BaseServer) healthStatusNames() map[string]string {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+	names := make(map[string]string, len(b.healthStatuses))
+	for service, status := range b.healthStatuses {
+		names[service] = status.String()
+	}
+	return names
+}
+
+func getRuntimeStats() *adminapi.RuntimeStats {
+	ms := &runtime.MemStats{}
+	runtime.ReadMemStats(ms)
+	return &adminapi.RuntimeStats{
+		NumGoroutines:   uint32(runtime.NumGoroutine()),
+		NumCpu:          uint32(runtime.NumCPU()),
+		HeapAllocBytes:  ms.HeapAlloc,
+		HeapSysBytes:    ms.HeapSys,
+		SysBytes:        ms.Sys,
+		TotalAllocBytes: ms.TotalAlloc,
+		NumGc:           ms.NumGC,
+	}
+}
+
+// redactedConfigJSON encodes the given config as JSON, redacting the values of sensitive keys and
+// the passwords of URLs.
+func redactedConfigJSON(config zapcore.ObjectMarshaler) (string, error) {
+	if config == nil {
+		return "{}", nil
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	if err := config.MarshalLogObject(enc); err != nil {
+		return "", err
+	}
+	buf, err := json.Marshal(redact(enc.Fields))
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func redact(fields map[string]interface{}) map[string]interface{} {
+	redactedFields := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if isSensitiveKey(key) {
+			redactedFields[key] = util.Redacted
+			continue
+		}
+		redactedFields[key] = redactValue(value)
+	}
+	return redactedFields
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redact(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, elem := range v {
+			values[i] = redactValue(elem)
+		}
+		return values
+	case string:
+		return util.RedactPasswords(v)
+	case time.Duration:
+		return v.String()
+	}
+	return value
+}
+
+func isSensitiveKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, sensitive := range sensitiveConfigKeys {
+		if strings.Contains(lower, sensitive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: pkg/server/adminapi/admin.proto
+
+/*
+Package adminapi is a generated protocol buffer package.
+
+It is generated from these files:
+
+	pkg/server/adminapi/admin.proto
+
+It has these top-level messages:
+
+	InfoRequest
+	InfoResponse
+	BuildInfo
+	RuntimeStats
+*/
+package adminapi
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type InfoRequest struct {
+}
+
+func (m *InfoRequest) Reset()                    { *m = InfoRequest{} }
+func (m *InfoRequest) String() string            { return proto.CompactTextString(m) }
+func (*InfoRequest) ProtoMessage()               {}
+func (*InfoRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type InfoResponse struct {
+	// build_info describes the server binary.
+	BuildInfo *BuildInfo `protobuf:"bytes,1,opt,name=build_info,json=buildInfo" json:"build_info,omitempty"`
+	// config is the JSON-encoded effective config, with sensitive values redacted.
+	Config string `protobuf:"bytes,2,opt,name=config" json:"config,omitempty"`
+	// uptime_ms is the number of milliseconds since the server started serving.
+	UptimeMs int64 `protobuf:"varint,3,opt,name=uptime_ms,json=uptimeMs" json:"uptime_ms,omitempty"`
+	// state is the current server state (e.g., STARTED).
+	State string `protobuf:"bytes,4,opt,name=state" json:"state,omitempty"`
+	// runtime contains the Go runtime stats.
+	Runtime *RuntimeStats `protobuf:"bytes,5,opt,name=runtime" json:"runtime,omitempty"`
+	// health contains the serving status (e.g., SERVING) of each health component, keyed by
+	// service name, where the empty name is the server as a whole.
+	Health map[string]string `protobuf:"bytes,6,rep,name=health" json:"health,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *InfoResponse) Reset()                    { *m = InfoResponse{} }
+func (m *InfoResponse) String() string            { return proto.CompactTextString(m) }
+func (*InfoResponse) ProtoMessage()               {}
+func (*InfoResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *InfoResponse) GetBuildInfo() *BuildInfo {
+	if m != nil {
+		return m.BuildInfo
+	}
+	return nil
+}
+
+func (m *InfoResponse) GetConfig() string {
+	if m != nil {
+		return m.Config
+	}
+	return ""
+}
+
+func (m *InfoResponse) GetUptimeMs() int64 {
+	if m != nil {
+		return m.UptimeMs
+	}
+	return 0
+}
+
+func (m *InfoResponse) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+func (m *InfoResponse) GetRuntime() *RuntimeStats {
+	if m != nil {
+		return m.Runtime
+	}
+	return nil
+}
+
+func (m *InfoResponse) GetHealth() map[string]string {
+	if m != nil {
+		return m.Health
+	}
+	return nil
+}
+
+type BuildInfo struct {
+	Version     string `protobuf:"bytes,1,opt,name=version" json:"version,omitempty"`
+	GitBranch   string `protobuf:"bytes,2,opt,name=git_branch,json=gitBranch" json:"git_branch,omitempty"`
+	GitRevision string `protobuf:"bytes,3,opt,name=git_revision,json=gitRevision" json:"git_revision,omitempty"`
+	BuildDate   string `protobuf:"bytes,4,opt,name=build_date,json=buildDate" json:"build_date,omitempty"`
+	GoVersion   string `protobuf:"bytes,5,opt,name=go_version,json=goVersion" json:"go_version,omitempty"`
+}
+
+func (m *BuildInfo) Reset()                    { *m = BuildInfo{} }
+func (m *BuildInfo) String() string            { return proto.CompactTextString(m) }
+func (*BuildInfo) ProtoMessage()               {}
+func (*BuildInfo) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *BuildInfo) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *BuildInfo) GetGitBranch() string {
+	if m != nil {
+		return m.GitBranch
+	}
+	return ""
+}
+
+func (m *BuildInfo) GetGitRevision() string {
+	if m != nil {
+		return m.GitRevision
+	}
+	return ""
+}
+
+func (m *BuildInfo) GetBuildDate() string {
+	if m != nil {
+		return m.BuildDate
+	}
+	return ""
+}
+
+func (m *BuildInfo) GetGoVersion() string {
+	if m != nil {
+		return m.GoVersion
+	}
+	return ""
+}
+
+type RuntimeStats struct {
+	NumGoroutines   uint32 `protobuf:"varint,1,opt,name=num_goroutines,json=numGoroutines" json:"num_goroutines,omitempty"`
+	NumCpu          uint32 `protobuf:"varint,2,opt,name=num_cpu,json=numCpu" json:"num_cpu,omitempty"`
+	HeapAllocBytes  uint64 `protobuf:"varint,3,opt,name=heap_alloc_bytes,json=heapAllocBytes" json:"heap_alloc_bytes,omitempty"`
+	HeapSysBytes    uint64 `protobuf:"varint,4,opt,name=heap_sys_bytes,json=heapSysBytes" json:"heap_sys_bytes,omitempty"`
+	SysBytes        uint64 `protobuf:"varint,5,opt,name=sys_bytes,json=sysBytes" json:"sys_bytes,omitempty"`
+	TotalAllocBytes uint64 `protobuf:"varint,6,opt,name=total_alloc_bytes,json=totalAllocBytes" json:"total_alloc_bytes,omitempty"`
+	NumGc           uint32 `protobuf:"varint,7,opt,name=num_gc,json=numGc" json:"num_gc,omitempty"`
+}
+
+func (m *RuntimeStats) Reset()                    { *m = RuntimeStats{} }
+func (m *RuntimeStats) String() string            { return proto.CompactTextString(m) }
+func (*RuntimeStats) ProtoMessage()               {}
+func (*RuntimeStats) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *RuntimeStats) GetNumGoroutines() uint32 {
+	if m != nil {
+		return m.NumGoroutines
+	}
+	return 0
+}
+
+func (m *RuntimeStats) GetNumCpu() uint32 {
+	if m != nil {
+		return m.NumCpu
+	}
+	return 0
+}
+
+func (m *RuntimeStats) GetHeapAllocBytes() uint64 {
+	if m != nil {
+		return m.HeapAllocBytes
+	}
+	return 0
+}
+
+func (m *RuntimeStats) GetHeapSysBytes() uint64 {
+	if m != nil {
+		return m.HeapSysBytes
+	}
+	return 0
+}
+
+func (m *RuntimeStats) GetSysBytes() uint64 {
+	if m != nil {
+		return m.SysBytes
+	}
+	return 0
+}
+
+func (m *RuntimeStats) GetTotalAllocBytes() uint64 {
+	if m != nil {
+		return m.TotalAllocBytes
+	}
+	return 0
+}
+
+func (m *RuntimeStats) GetNumGc() uint32 {
+	if m != nil {
+		return m.NumGc
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*InfoRequest)(nil), "adminapi.InfoRequest")
+	proto.RegisterType((*InfoResponse)(nil), "adminapi.InfoResponse")
+	proto.RegisterType((*BuildInfo)(nil), "adminapi.BuildInfo")
+	proto.RegisterType((*RuntimeStats)(nil), "adminapi.RuntimeStats")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Admin service
+
+type AdminClient interface {
+	// Info returns the build info, effective config, and runtime stats of the server.
+	Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+}
+
+type adminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAdminClient(cc *grpc.ClientConn) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error) {
+	out := new(InfoResponse)
+	err := grpc.Invoke(ctx, "/adminapi.Admin/Info", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Admin service
+
+type AdminServer interface {
+	// Info returns the build info, effective config, and runtime stats of the server.
+	Info(context.Context, *InfoRequest) (*InfoResponse, error)
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_Info_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/adminapi.Admin/Info",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Info(ctx, req.(*InfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "adminapi.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Info",
+			Handler:    _Admin_Info_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pkg/server/adminapi/admin.proto",
+}
+
+func init() { proto.RegisterFile("pkg/server/adminapi/admin.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 499 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0xc1, 0x8e, 0xd3, 0x30,
+	0x10, 0x25, 0x6d, 0x93, 0x36, 0xd3, 0x76, 0x59, 0x0c, 0x5b, 0xa2, 0x5d, 0x21, 0x4a, 0x04, 0x52,
+	0xc5, 0xa1, 0x8b, 0xca, 0x01, 0xd8, 0x13, 0x5b, 0x40, 0xc0, 0x81, 0x8b, 0x57, 0xe2, 0x1a, 0xa5,
+	0xa9, 0x9b, 0x5a, 0x9b, 0xd8, 0x21, 0xb6, 0x2b, 0xf5, 0x83, 0xf8, 0x05, 0x3e, 0x0f, 0x21, 0x8f,
+	0x93, 0x6d, 0x41, 0xdc, 0x3c, 0x6f, 0xde, 0xf3, 0x9b, 0x19, 0x8f, 0xe1, 0x69, 0x75, 0x9b, 0x5f,
+	0x2a, 0x56, 0xef, 0x58, 0x7d, 0x99, 0xae, 0x4b, 0x2e, 0xd2, 0x8a, 0xbb, 0xc3, 0xbc, 0xaa, 0xa5,
+	0x96, 0x64, 0xd0, 0xa2, 0xf1, 0x18, 0x86, 0x5f, 0xc5, 0x46, 0x52, 0xf6, 0xc3, 0x30, 0xa5, 0xe3,
+	0x5f, 0x1d, 0x18, 0xb9, 0x58, 0x55, 0x52, 0x28, 0x46, 0x16, 0x00, 0x2b, 0xc3, 0x8b, 0x75, 0xc2,
+	0xc5, 0x46, 0x46, 0xde, 0xd4, 0x9b, 0x0d, 0x17, 0x0f, 0xe7, 0xad, 0x7c, 0xbe, 0xb4, 0x39, 0x14,
+	0x84, 0xab, 0xf6, 0x48, 0x26, 0x10, 0x64, 0x52, 0x6c, 0x78, 0x1e, 0x75, 0xa6, 0xde, 0x2c, 0xa4,
+	0x4d, 0x44, 0x2e, 0x20, 0x34, 0x95, 0xe6, 0x25, 0x4b, 0x4a, 0x15, 0x75, 0xa7, 0xde, 0xac, 0x4b,
+	0x07, 0x0e, 0xf8, 0xa6, 0xc8, 0x23, 0xf0, 0x95, 0x4e, 0x35, 0x8b, 0x7a, 0xa8, 0x71, 0x01, 0x79,
+	0x05, 0xfd, 0xda, 0x08, 0x4b, 0x89, 0x7c, 0xf4, 0x9e, 0x1c, 0xbc, 0xa9, 0x4b, 0xdc, 0xe8, 0x54,
+	0x2b, 0xda, 0xd2, 0xc8, 0x15, 0x04, 0x5b, 0x96, 0x16, 0x7a, 0x1b, 0x05, 0xd3, 0xee, 0x6c, 0xb8,
+	0x88, 0x0f, 0x82, 0xe3, 0xc6, 0xe6, 0x5f, 0x90, 0xf4, 0x49, 0xe8, 0x7a, 0x4f, 0x1b, 0xc5, 0xf9,
+	0x3b, 0x18, 0x1e, 0xc1, 0xe4, 0x14, 0xba, 0xb7, 0x6c, 0x8f, 0x4d, 0x87, 0xd4, 0x1e, 0x6d, 0x91,
+	0xbb, 0xb4, 0x30, 0xac, 0x69, 0xcc, 0x05, 0x57, 0x9d, 0xb7, 0x5e, 0xfc, 0xd3, 0x83, 0xf0, 0x6e,
+	0x18, 0x24, 0x82, 0xfe, 0x8e, 0xd5, 0x8a, 0x4b, 0xd1, 0xa8, 0xdb, 0x90, 0x3c, 0x01, 0xc8, 0xb9,
+	0x4e, 0x56, 0x75, 0x2a, 0xb2, 0x6d, 0x73, 0x4d, 0x98, 0x73, 0xbd, 0x44, 0x80, 0x3c, 0x83, 0x91,
+	0x4d, 0xd7, 0x6c, 0xc7, 0x51, 0xdd, 0x45, 0xc2, 0x30, 0xe7, 0x9a, 0x36, 0x90, 0xbd, 0xc1, 0xbd,
+	0xc8, 0xfa, 0x30, 0x2d, 0x37, 0xfc, 0x8f, 0x76, 0x62, 0xd6, 0x40, 0x26, 0xad, 0xbb, 0xdf, 0x18,
+	0xc8, 0xef, 0x0e, 0x88, 0x7f, 0x7b, 0x30, 0x3a, 0x1e, 0x1c, 0x79, 0x01, 0x27, 0xc2, 0x94, 0x49,
+	0x2e, 0x6b, 0x69, 0x34, 0x17, 0x4c, 0x61, 0xc5, 0x63, 0x3a, 0x16, 0xa6, 0xfc, 0x7c, 0x07, 0x92,
+	0xc7, 0xd0, 0xb7, 0xb4, 0xac, 0x32, 0x58, 0xf4, 0x98, 0x06, 0xc2, 0x94, 0x1f, 0x2a, 0x43, 0x66,
+	0x70, 0xba, 0x65, 0x69, 0x95, 0xa4, 0x45, 0x21, 0xb3, 0x64, 0xb5, 0xd7, 0xcc, 0xbd, 0x6d, 0x8f,
+	0x9e, 0x58, 0xfc, 0xda, 0xc2, 0x4b, 0x8b, 0x92, 0xe7, 0x80, 0x48, 0xa2, 0xf6, 0xaa, 0xe1, 0xf5,
+	0x90, 0x37, 0xb2, 0xe8, 0xcd, 0x5e, 0x39, 0xd6, 0x05, 0x84, 0x07, 0x82, 0x8f, 0x84, 0x81, 0x6a,
+	0x93, 0x2f, 0xe1, 0x81, 0x96, 0x3a, 0x2d, 0xfe, 0x72, 0x0b, 0x90, 0x74, 0x1f, 0x13, 0x47, 0x76,
+	0x67, 0x10, 0x60, 0x63, 0x59, 0xd4, 0xc7, 0x82, 0x7d, 0xdb, 0x50, 0xb6, 0x78, 0x0f, 0xfe, 0xb5,
+	0x5d, 0x08, 0xf2, 0x06, 0x7a, 0xf8, 0x56, 0x67, 0xff, 0x2e, 0x08, 0xfe, 0x84, 0xf3, 0xc9, 0xff,
+	0xf7, 0x26, 0xbe, 0xb7, 0x0a, 0xf0, 0x0f, 0xbd, 0xfe, 0x33, 0x00, 0xed, 0x23, 0x3e, 0xb4, 0x66,
+	0x03, 0x00, 0x00,
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"time"
 
 	"go.uber.org/zap"
@@ -27,7 +30,83 @@ const (
 	// DefaultProfile is the default setting for whether the profiler is enabled.
 	DefaultProfile = false
 
+	// DefaultGatewayPort is the default port for the HTTP/JSON gateway, where zero indicates the
+	// gateway is disabled.
+	DefaultGatewayPort = uint(0)
+
+	// DefaultGRPCWebPort is the default port for gRPC-Web requests, where zero indicates gRPC-Web
+	// is disabled.
+	DefaultGRPCWebPort = uint(0)
+
+	// DefaultCORSMaxAge is the default duration browsers may cache CORS preflight responses.
+	DefaultCORSMaxAge = 10 * time.Minute
+
+	// CORSAllowAllOrigins is the allowed origin indicating that requests from all origins are
+	// allowed.
+	CORSAllowAllOrigins = "*"
+
+	// DefaultMaxInFlightRequests is the default maximum number of requests handled concurrently,
+	// where zero indicates no limit.
+	DefaultMaxInFlightRequests = uint(0)
+
+	// DefaultKeepaliveTime is the default duration of connection inactivity after which the
+	// server pings the client to check the transport is still alive.
+	DefaultKeepaliveTime = 2 * time.Hour
+
+	// DefaultKeepaliveTimeout is the default duration the server waits for a keepalive ping ack
+	// before closing the connection.
+	DefaultKeepaliveTimeout = 20 * time.Second
+
+	// DefaultKeepaliveMinTime is the default minimum duration clients must wait between
+	// keepalive pings.
+	DefaultKeepaliveMinTime = 5 * time.Minute
+
+	// DefaultKeepalivePermitWithoutStream is the default setting for whether clients may send
+	// keepalive pings when there are no active streams.
+	DefaultKeepalivePermitWithoutStream = false
+
+	// DefaultMaxConnectionIdle is the default duration after which an idle connection is
+	// closed, where zero indicates no limit.
+	DefaultMaxConnectionIdle = time.Duration(0)
+
+	// DefaultMaxConnectionAge is the default maximum duration a connection may exist before it
+	// is gracefully closed, where zero indicates no limit.
+	DefaultMaxConnectionAge = time.Duration(0)
+
+	// DefaultMaxConnectionAgeGrace is the default additional duration after MaxConnectionAge
+	// that pending RPCs have to complete before the connection is forcibly closed, where zero
+	// indicates no limit.
+	DefaultMaxConnectionAgeGrace = time.Duration(0)
+
+	// DefaultMaxRecvMsgSize is the default maximum message size (in bytes) the server can
+	// receive.
+	DefaultMaxRecvMsgSize = 4 * 1024 * 1024
+
+	// DefaultMaxSendMsgSize is the default maximum message size (in bytes) the server can send.
+	DefaultMaxSendMsgSize = math.MaxInt32
+
+	// DefaultCompression is the default compression for messages sent by the server.
+	DefaultCompression = CompressionNone
+
+	// CompressionNone indicates that server messages are not compressed.
+	CompressionNone = "none"
+
+	// CompressionGzip indicates that server messages are gzip compressed.
+	CompressionGzip = "gzip"
+
 	postListenNotifyWait = 100 * time.Millisecond
+
+	maxPort = 65535
+)
+
+var (
+	// DefaultCORSAllowedHeaders are the default request headers allowed in CORS requests.
+	DefaultCORSAllowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+
+	errNegativeDuration = errors.New("duration must be non-negative")
+	errNegativeSize     = errors.New("message size must be non-negative")
+	errInvalidRateLimit = errors.New("rate limit must have positive rate")
+	errPortInUse        = errors.New("port already used by another server")
 )
 
 // BaseConfig contains params needed for the base server.
@@ -41,15 +120,92 @@ type BaseConfig struct {
 	// ProfilerPort is the port from which to serve profiler endpoints.
 	ProfilerPort uint
 
+	// GatewayPort is the port from which to serve the HTTP/JSON gateway for the services
+	// registered via RegisterGateway. When equal to the ServerPort, gRPC and HTTP requests are
+	// multiplexed on the same port. Zero indicates the gateway is disabled.
+	GatewayPort uint
+
+	// GRPCWebPort is the port from which to serve gRPC-Web requests (e.g., from browsers) for the
+	// main service. It must differ from the ServerPort and GatewayPort. Zero indicates gRPC-Web is
+	// disabled.
+	GRPCWebPort uint
+
+	// CORSAllowedOrigins are the origins allowed to make cross-origin gateway and gRPC-Web
+	// requests. CORSAllowAllOrigins allows requests from any origin, and no origins disables CORS.
+	CORSAllowedOrigins []string
+
+	// CORSAllowedHeaders are the request headers allowed in cross-origin gateway requests.
+	CORSAllowedHeaders []string
+
+	// CORSMaxAge is the duration browsers may cache CORS preflight responses.
+	CORSMaxAge time.Duration
+
 	// MaxConcurrentStreams is the maximum number of concurrent streams for each server
 	// transport.
 	MaxConcurrentStreams uint32
 
+	// KeepaliveTime is the duration of connection inactivity after which the server pings the
+	// client to check the transport is still alive.
+	KeepaliveTime time.Duration
+
+	// KeepaliveTimeout is the duration the server waits for a keepalive ping ack before closing
+	// the connection.
+	KeepaliveTimeout time.Duration
+
+	// KeepaliveMinTime is the minimum duration clients must wait between keepalive pings. Clients
+	// pinging more frequently have their connections closed.
+	KeepaliveMinTime time.Duration
+
+	// KeepalivePermitWithoutStream indicates whether clients may send keepalive pings when
+	// there are no active streams.
+	KeepalivePermitWithoutStream bool
+
+	// MaxConnectionIdle is the duration after which an idle connection is gracefully closed. Zero
+	// indicates no limit.
+	MaxConnectionIdle time.Duration
+
+	// MaxConnectionAge is the maximum duration a connection may exist before it is gracefully
+	// closed, forcing clients to reconnect (and thus rebalance across servers behind a load
+	// balancer). Zero indicates no limit.
+	MaxConnectionAge time.Duration
+
+	// MaxConnectionAgeGrace is the additional duration after MaxConnectionAge that pending RPCs
+	// have to complete before the connection is forcibly closed. Zero indicates no limit.
+	MaxConnectionAgeGrace time.Duration
+
+	// MaxRecvMsgSize is the maximum message size (in bytes) the server can receive. Zero
+	// indicates DefaultMaxRecvMsgSize.
+	MaxRecvMsgSize int
+
+	// MaxSendMsgSize is the maximum message size (in bytes) the server can send. Zero indicates
+	// DefaultMaxSendMsgSize.
+	MaxSendMsgSize int
+
+	// Compression is the compression (either CompressionNone or CompressionGzip) of messages
+	// sent by the server. Empty indicates CompressionNone.
+	Compression string
+
 	// LogLevel is the log level for the service Logger.
 	LogLevel zapcore.Level
 
 	// Profile indicates whether the profiler endpoints are enabled.
 	Profile bool
+
+	// MaxInFlightRequests is the maximum number of requests handled concurrently across all
+	// methods. Zero indicates no limit.
+	MaxInFlightRequests uint
+
+	// MethodRateLimits are the rate limits for individual methods, keyed by full method name
+	// (e.g., "/test.PingPong/Ping").
+	MethodRateLimits map[string]*RateLimit
+
+	// CallerRateLimit is the rate limit applied to each caller. Nil indicates no limit.
+	CallerRateLimit *RateLimit
+
+	// CallerMetadataKey is the request metadata key whose value identifies a caller for the
+	// CallerRateLimit. When empty or missing from a request, the caller is identified by its
+	// peer host.
+	CallerMetadataKey string
 }
 
 // MarshalLogObject write the config to the given object encoder.
@@ -57,24 +213,147 @@ func (c *BaseConfig) MarshalLogObject(oe zapcore.ObjectEncoder) error {
 	oe.AddUint(logServerPort, c.ServerPort)
 	oe.AddUint(logMetricsPort, c.MetricsPort)
 	oe.AddUint(logProfilerPort, c.ProfilerPort)
+	oe.AddUint(logGatewayPort, c.GatewayPort)
+	oe.AddUint(logGRPCWebPort, c.GRPCWebPort)
+	if len(c.CORSAllowedOrigins) > 0 {
+		origins, headers := stringArray(c.CORSAllowedOrigins), stringArray(c.CORSAllowedHeaders)
+		if err := oe.AddArray(logCORSAllowedOrigins, origins); err != nil {
+			return err
+		}
+		if err := oe.AddArray(logCORSAllowedHeaders, headers); err != nil {
+			return err
+		}
+		oe.AddDuration(logCORSMaxAge, c.CORSMaxAge)
+	}
 	oe.AddUint32(logMaxConcurrentStreams, c.MaxConcurrentStreams)
+	oe.AddDuration(logKeepaliveTime, c.KeepaliveTime)
+	oe.AddDuration(logKeepaliveTimeout, c.KeepaliveTimeout)
+	oe.AddDuration(logKeepaliveMinTime, c.KeepaliveMinTime)
+	oe.AddBool(logKeepalivePermitWithoutStream, c.KeepalivePermitWithoutStream)
+	oe.AddDuration(logMaxConnectionIdle, c.MaxConnectionIdle)
+	oe.AddDuration(logMaxConnectionAge, c.MaxConnectionAge)
+	oe.AddDuration(logMaxConnectionAgeGrace, c.MaxConnectionAgeGrace)
+	oe.AddInt(logMaxRecvMsgSize, c.MaxRecvMsgSize)
+	oe.AddInt(logMaxSendMsgSize, c.MaxSendMsgSize)
+	oe.AddString(logCompression, c.Compression)
 	oe.AddString(logLogLevel, c.LogLevel.String())
 	oe.AddBool(logProfile, c.Profile)
+	oe.AddUint(logMaxInFlightRequests, c.MaxInFlightRequests)
+	if len(c.MethodRateLimits) > 0 {
+		mrls := methodRateLimits(c.MethodRateLimits)
+		if err := oe.AddObject(logMethodRateLimits, mrls); err != nil {
+			return err
+		}
+	}
+	if c.CallerRateLimit != nil {
+		if err := oe.AddObject(logCallerRateLimit, c.CallerRateLimit); err != nil {
+			return err
+		}
+		oe.AddString(logCallerMetadataKey, c.CallerMetadataKey)
+	}
 	return nil
 }
 
 // NewDefaultBaseConfig creates a new default BaseConfig.
 func NewDefaultBaseConfig() *BaseConfig {
 	return &BaseConfig{
-		ServerPort:           DefaultServerPort,
-		MetricsPort:          DefaultMetricsPort,
-		ProfilerPort:         DefaultProfilerPort,
-		MaxConcurrentStreams: DefaultMaxConcurrentStreams,
-		LogLevel:             DefaultLogLevel,
-		Profile:              DefaultProfile,
+		ServerPort:                   DefaultServerPort,
+		MetricsPort:                  DefaultMetricsPort,
+		ProfilerPort:                 DefaultProfilerPort,
+		GatewayPort:                  DefaultGatewayPort,
+		GRPCWebPort:                  DefaultGRPCWebPort,
+		CORSAllowedHeaders:           DefaultCORSAllowedHeaders,
+		CORSMaxAge:                   DefaultCORSMaxAge,
+		MaxConcurrentStreams:         DefaultMaxConcurrentStreams,
+		KeepaliveTime:                DefaultKeepaliveTime,
+		KeepaliveTimeout:             DefaultKeepaliveTimeout,
+		KeepaliveMinTime:             DefaultKeepaliveMinTime,
+		KeepalivePermitWithoutStream: DefaultKeepalivePermitWithoutStream,
+		MaxConnectionIdle:            DefaultMaxConnectionIdle,
+		MaxConnectionAge:             DefaultMaxConnectionAge,
+		MaxConnectionAgeGrace:        DefaultMaxConnectionAgeGrace,
+		MaxRecvMsgSize:               DefaultMaxRecvMsgSize,
+		MaxSendMsgSize:               DefaultMaxSendMsgSize,
+		Compression:                  DefaultCompression,
+		LogLevel:                     DefaultLogLevel,
+		Profile:                      DefaultProfile,
+		MaxInFlightRequests:          DefaultMaxInFlightRequests,
 	}
 }
 
+// Validate checks that the config values are valid, returning an error describing the first
+// invalid value found. Zero message sizes and empty compression are valid and indicate the
+// defaults.
+func (c *BaseConfig) Validate() error {
+	ports := map[string]uint{
+		logServerPort:   c.ServerPort,
+		logMetricsPort:  c.MetricsPort,
+		logProfilerPort: c.ProfilerPort,
+		logGatewayPort:  c.GatewayPort,
+		logGRPCWebPort:  c.GRPCWebPort,
+	}
+	for name, port := range ports {
+		if port > maxPort {
+			return fmt.Errorf("invalid %s %d", name, port)
+		}
+	}
+	if c.GRPCWebPort != 0 && (c.GRPCWebPort == c.ServerPort || c.GRPCWebPort == c.GatewayPort) {
+		return fmt.Errorf("invalid %s %d: %s", logGRPCWebPort, c.GRPCWebPort, errPortInUse)
+	}
+	durations := map[string]time.Duration{
+		logKeepaliveTime:         c.KeepaliveTime,
+		logKeepaliveTimeout:      c.KeepaliveTimeout,
+		logKeepaliveMinTime:      c.KeepaliveMinTime,
+		logMaxConnectionIdle:     c.MaxConnectionIdle,
+		logMaxConnectionAge:      c.MaxConnectionAge,
+		logMaxConnectionAgeGrace: c.MaxConnectionAgeGrace,
+		logCORSMaxAge:            c.CORSMaxAge,
+	}
+	for name, d := range durations {
+		if d < 0 {
+			return fmt.Errorf("invalid %s %v: %s", name, d, errNegativeDuration)
+		}
+	}
+	if c.MaxRecvMsgSize < 0 {
+		return fmt.Errorf("invalid %s %d: %s", logMaxRecvMsgSize, c.MaxRecvMsgSize,
+			errNegativeSize)
+	}
+	if c.MaxSendMsgSize < 0 {
+		return fmt.Errorf("invalid %s %d: %s", logMaxSendMsgSize, c.MaxSendMsgSize,
+			errNegativeSize)
+	}
+	switch c.Compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("invalid %s %q", logCompression, c.Compression)
+	}
+	for method, rl := range c.MethodRateLimits {
+		if rl == nil || rl.Rate <= 0 {
+			return fmt.Errorf("invalid rate limit for %s: %s", method, errInvalidRateLimit)
+		}
+	}
+	if c.CallerRateLimit != nil && c.CallerRateLimit.Rate <= 0 {
+		return fmt.Errorf("invalid %s: %s", logCallerRateLimit, errInvalidRateLimit)
+	}
+	return nil
+}
+
+// maxRecvMsgSize returns the max receive message size or the default if it is zero.
+func (c *BaseConfig) maxRecvMsgSize() int {
+	if c.MaxRecvMsgSize == 0 {
+		return DefaultMaxRecvMsgSize
+	}
+	return c.MaxRecvMsgSize
+}
+
+// maxSendMsgSize returns the max send message size or the default if it is zero.
+func (c *BaseConfig) maxSendMsgSize() int {
+	if c.MaxSendMsgSize == 0 {
+		return DefaultMaxSendMsgSize
+	}
+	return c.MaxSendMsgSize
+}
+
 // WithServerPort sets the main server port to the given value or the default if it is zero.
 func (c *BaseConfig) WithServerPort(p uint) *BaseConfig {
 	if p == 0 {
@@ -117,6 +396,70 @@ func (c *BaseConfig) WithDefaultProfilerPort() *BaseConfig {
 	return c
 }
 
+// WithGatewayPort sets the HTTP/JSON gateway port to the given value, where zero disables the
+// gateway.
+func (c *BaseConfig) WithGatewayPort(p uint) *BaseConfig {
+	c.GatewayPort = p
+	return c
+}
+
+// WithDefaultGatewayPort sets the HTTP/JSON gateway port to the default value.
+func (c *BaseConfig) WithDefaultGatewayPort() *BaseConfig {
+	c.GatewayPort = DefaultGatewayPort
+	return c
+}
+
+// WithGRPCWebPort sets the gRPC-Web port to the given value, where zero disables gRPC-Web.
+func (c *BaseConfig) WithGRPCWebPort(p uint) *BaseConfig {
+	c.GRPCWebPort = p
+	return c
+}
+
+// WithDefaultGRPCWebPort sets the gRPC-Web port to the default value.
+func (c *BaseConfig) WithDefaultGRPCWebPort() *BaseConfig {
+	c.GRPCWebPort = DefaultGRPCWebPort
+	return c
+}
+
+// WithCORSAllowedOrigins sets the origins allowed to make cross-origin gateway and gRPC-Web
+// requests.
+func (c *BaseConfig) WithCORSAllowedOrigins(origins ...string) *BaseConfig {
+	c.CORSAllowedOrigins = origins
+	return c
+}
+
+// WithCORSAllowedHeaders sets the request headers allowed in cross-origin gateway requests to the
+// given values or the default if there are none.
+func (c *BaseConfig) WithCORSAllowedHeaders(headers ...string) *BaseConfig {
+	if len(headers) == 0 {
+		return c.WithDefaultCORSAllowedHeaders()
+	}
+	c.CORSAllowedHeaders = headers
+	return c
+}
+
+// WithDefaultCORSAllowedHeaders sets the CORS allowed headers to the default value.
+func (c *BaseConfig) WithDefaultCORSAllowedHeaders() *BaseConfig {
+	c.CORSAllowedHeaders = DefaultCORSAllowedHeaders
+	return c
+}
+
+// WithCORSMaxAge sets the duration browsers may cache CORS preflight responses to the given value
+// or the default if it is zero.
+func (c *BaseConfig) WithCORSMaxAge(d time.Duration) *BaseConfig {
+	if d == 0 {
+		return c.WithDefaultCORSMaxAge()
+	}
+	c.CORSMaxAge = d
+	return c
+}
+
+// WithDefaultCORSMaxAge sets the CORS max age to the default value.
+func (c *BaseConfig) WithDefaultCORSMaxAge() *BaseConfig {
+	c.CORSMaxAge = DefaultCORSMaxAge
+	return c
+}
+
 // WithMaxConcurrentStreams set the max concurrent streams for a server transport to the given
 // value or the default if it is zero.
 func (c *BaseConfig) WithMaxConcurrentStreams(m uint32) *BaseConfig {
@@ -133,6 +476,155 @@ func (c *BaseConfig) WithDefaultMaxConcurrentStreams() *BaseConfig {
 	return c
 }
 
+// WithKeepaliveTime sets the duration of connection inactivity after which the server pings the
+// client to the given value or the default if it is zero.
+func (c *BaseConfig) WithKeepaliveTime(d time.Duration) *BaseConfig {
+	if d == 0 {
+		return c.WithDefaultKeepaliveTime()
+	}
+	c.KeepaliveTime = d
+	return c
+}
+
+// WithDefaultKeepaliveTime sets the keepalive time to the default value.
+func (c *BaseConfig) WithDefaultKeepaliveTime() *BaseConfig {
+	c.KeepaliveTime = DefaultKeepaliveTime
+	return c
+}
+
+// WithKeepaliveTimeout sets the duration the server waits for a keepalive ping ack to the given
+// value or the default if it is zero.
+func (c *BaseConfig) WithKeepaliveTimeout(d time.Duration) *BaseConfig {
+	if d == 0 {
+		return c.WithDefaultKeepaliveTimeout()
+	}
+	c.KeepaliveTimeout = d
+	return c
+}
+
+// WithDefaultKeepaliveTimeout sets the keepalive timeout to the default value.
+func (c *BaseConfig) WithDefaultKeepaliveTimeout() *BaseConfig {
+	c.KeepaliveTimeout = DefaultKeepaliveTimeout
+	return c
+}
+
+// WithKeepaliveMinTime sets the minimum duration clients must wait between keepalive pings to the
+// given value or the default if it is zero.
+func (c *BaseConfig) WithKeepaliveMinTime(d time.Duration) *BaseConfig {
+	if d == 0 {
+		return c.WithDefaultKeepaliveMinTime()
+	}
+	c.KeepaliveMinTime = d
+	return c
+}
+
+// WithDefaultKeepaliveMinTime sets the keepalive min time to the default value.
+func (c *BaseConfig) WithDefaultKeepaliveMinTime() *BaseConfig {
+	c.KeepaliveMinTime = DefaultKeepaliveMinTime
+	return c
+}
+
+// WithKeepalivePermitWithoutStream sets whether clients may send keepalive pings when there are no
+// active streams.
+func (c *BaseConfig) WithKeepalivePermitWithoutStream(on bool) *BaseConfig {
+	c.KeepalivePermitWithoutStream = on
+	return c
+}
+
+// WithDefaultKeepalivePermitWithoutStream sets the default value for whether clients may send
+// keepalive pings when there are no active streams.
+func (c *BaseConfig) WithDefaultKeepalivePermitWithoutStream() *BaseConfig {
+	c.KeepalivePermitWithoutStream = DefaultKeepalivePermitWithoutStream
+	return c
+}
+
+// WithMaxConnectionIdle sets the duration after which idle connections are closed, where zero
+// indicates no limit.
+func (c *BaseConfig) WithMaxConnectionIdle(d time.Duration) *BaseConfig {
+	c.MaxConnectionIdle = d
+	return c
+}
+
+// WithDefaultMaxConnectionIdle sets the max connection idle duration to the default value.
+func (c *BaseConfig) WithDefaultMaxConnectionIdle() *BaseConfig {
+	c.MaxConnectionIdle = DefaultMaxConnectionIdle
+	return c
+}
+
+// WithMaxConnectionAge sets the maximum duration a connection may exist, where zero indicates no
+// limit.
+func (c *BaseConfig) WithMaxConnectionAge(d time.Duration) *BaseConfig {
+	c.MaxConnectionAge = d
+	return c
+}
+
+// WithDefaultMaxConnectionAge sets the max connection age to the default value.
+func (c *BaseConfig) WithDefaultMaxConnectionAge() *BaseConfig {
+	c.MaxConnectionAge = DefaultMaxConnectionAge
+	return c
+}
+
+// WithMaxConnectionAgeGrace sets the additional duration pending RPCs have to complete after the
+// max connection age, where zero indicates no limit.
+func (c *BaseConfig) WithMaxConnectionAgeGrace(d time.Duration) *BaseConfig {
+	c.MaxConnectionAgeGrace = d
+	return c
+}
+
+// WithDefaultMaxConnectionAgeGrace sets the max connection age grace to the default value.
+func (c *BaseConfig) WithDefaultMaxConnectionAgeGrace() *BaseConfig {
+	c.MaxConnectionAgeGrace = DefaultMaxConnectionAgeGrace
+	return c
+}
+
+// WithMaxRecvMsgSize sets the maximum message size the server can receive to the given value or
+// the default if it is zero.
+func (c *BaseConfig) WithMaxRecvMsgSize(m int) *BaseConfig {
+	if m == 0 {
+		return c.WithDefaultMaxRecvMsgSize()
+	}
+	c.MaxRecvMsgSize = m
+	return c
+}
+
+// WithDefaultMaxRecvMsgSize sets the max receive message size to the default value.
+func (c *BaseConfig) WithDefaultMaxRecvMsgSize() *BaseConfig {
+	c.MaxRecvMsgSize = DefaultMaxRecvMsgSize
+	return c
+}
+
+// WithMaxSendMsgSize sets the maximum message size the server can send to the given value or the
+// default if it is zero.
+func (c *BaseConfig) WithMaxSendMsgSize(m int) *BaseConfig {
+	if m == 0 {
+		return c.WithDefaultMaxSendMsgSize()
+	}
+	c.MaxSendMsgSize = m
+	return c
+}
+
+// WithDefaultMaxSendMsgSize sets the max send message size to the default value.
+func (c *BaseConfig) WithDefaultMaxSendMsgSize() *BaseConfig {
+	c.MaxSendMsgSize = DefaultMaxSendMsgSize
+	return c
+}
+
+// WithCompression sets the compression of server messages to the given value or the default if it
+// is empty.
+func (c *BaseConfig) WithCompression(compression string) *BaseConfig {
+	if compression == "" {
+		return c.WithDefaultCompression()
+	}
+	c.Compression = compression
+	return c
+}
+
+// WithDefaultCompression sets the compression of server messages to the default value.
+func (c *BaseConfig) WithDefaultCompression() *BaseConfig {
+	c.Compression = DefaultCompression
+	return c
+}
+
 // WithLogLevel sets the log level to the given value.
 func (c *BaseConfig) WithLogLevel(l zapcore.Level) *BaseConfig {
 	c.LogLevel = l
@@ -156,3 +648,35 @@ func (c *BaseConfig) WithDefaultProfile() *BaseConfig {
 	c.Profile = DefaultProfile
 	return c
 }
+
+// WithMaxInFlightRequests sets the maximum number of concurrently handled requests, where zero
+// indicates no limit.
+func (c *BaseConfig) WithMaxInFlightRequests(m uint) *BaseConfig {
+	c.MaxInFlightRequests = m
+	return c
+}
+
+// WithDefaultMaxInFlightRequests sets the maximum number of concurrently handled requests to the
+// default value.
+func (c *BaseConfig) WithDefaultMaxInFlightRequests() *BaseConfig {
+	c.MaxInFlightRequests = DefaultMaxInFlightRequests
+	return c
+}
+
+// WithMethodRateLimit sets the rate limit for the given full method name (e.g.,
+// "/test.PingPong/Ping").
+func (c *BaseConfig) WithMethodRateLimit(fullMethod string, rl *RateLimit) *BaseConfig {
+	if c.MethodRateLimits == nil {
+		c.MethodRateLimits = make(map[string]*RateLimit)
+	}
+	c.MethodRateLimits[fullMethod] = rl
+	return c
+}
+
+// WithCallerRateLimit sets the rate limit applied to each caller, identified by the value of the
+// given metadata key or by peer host if the key is empty.
+func (c *BaseConfig) WithCallerRateLimit(rl *RateLimit, metadataKey string) *BaseConfig {
+	c.CallerRateLimit = rl
+	c.CallerMetadataKey = metadataKey
+	return c
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -21,6 +22,12 @@ func TestBaseConfig_MarshalLogObject(t *testing.T) {
 	c := NewDefaultBaseConfig()
 	err := c.MarshalLogObject(oe)
 	assert.Nil(t, err)
+
+	c.WithMethodRateLimit("/test.PingPong/Ping", &RateLimit{Rate: 1, Burst: 2}).
+		WithCallerRateLimit(&RateLimit{Rate: 1, Burst: 2}, "caller-id").
+		WithCORSAllowedOrigins("https://example.com")
+	err = c.MarshalLogObject(oe)
+	assert.Nil(t, err)
 }
 
 func TestBaseConfig_WithServerPort(t *testing.T) {
@@ -44,6 +51,42 @@ func TestBaseConfig_WithProfilerPort(t *testing.T) {
 	assert.NotEqual(t, c1.ProfilerPort, c3.WithProfilerPort(1000).ProfilerPort)
 }
 
+func TestBaseConfig_WithGatewayPort(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultGatewayPort()
+	assert.Equal(t, c1.GatewayPort, c2.WithGatewayPort(0).GatewayPort)
+	assert.NotEqual(t, c1.GatewayPort, c3.WithGatewayPort(1000).GatewayPort)
+}
+
+func TestBaseConfig_WithGRPCWebPort(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultGRPCWebPort()
+	assert.Equal(t, c1.GRPCWebPort, c2.WithGRPCWebPort(0).GRPCWebPort)
+	assert.NotEqual(t, c1.GRPCWebPort, c3.WithGRPCWebPort(1000).GRPCWebPort)
+}
+
+func TestBaseConfig_WithCORSAllowedOrigins(t *testing.T) {
+	c := &BaseConfig{}
+	assert.Empty(t, c.CORSAllowedOrigins)
+	c.WithCORSAllowedOrigins("https://example.com")
+	assert.Equal(t, []string{"https://example.com"}, c.CORSAllowedOrigins)
+}
+
+func TestBaseConfig_WithCORSAllowedHeaders(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultCORSAllowedHeaders()
+	assert.Equal(t, c1.CORSAllowedHeaders, c2.WithCORSAllowedHeaders().CORSAllowedHeaders)
+	assert.NotEqual(t, c1.CORSAllowedHeaders,
+		c3.WithCORSAllowedHeaders("X-Request-Id").CORSAllowedHeaders)
+}
+
+func TestBaseConfig_WithCORSMaxAge(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultCORSMaxAge()
+	assert.Equal(t, c1.CORSMaxAge, c2.WithCORSMaxAge(0).CORSMaxAge)
+	assert.NotEqual(t, c1.CORSMaxAge, c3.WithCORSMaxAge(time.Minute).CORSMaxAge)
+}
+
 func TestBaseConfig_WithMaxConcurrentStreams(t *testing.T) {
 	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
 	c1.WithDefaultMaxConcurrentStreams()
@@ -65,3 +108,139 @@ func TestBaseConfig_WithProfile(t *testing.T) {
 	assert.Equal(t, c1.Profile, c2.WithProfile(false).Profile)
 	assert.NotEqual(t, c1.Profile, c3.WithProfile(true).Profile)
 }
+
+func TestBaseConfig_WithMaxInFlightRequests(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultMaxInFlightRequests()
+	assert.Equal(t, c1.MaxInFlightRequests, c2.WithMaxInFlightRequests(0).MaxInFlightRequests)
+	assert.NotEqual(t, c1.MaxInFlightRequests,
+		c3.WithMaxInFlightRequests(1000).MaxInFlightRequests)
+}
+
+func TestBaseConfig_WithMethodRateLimit(t *testing.T) {
+	c := &BaseConfig{}
+	rl := &RateLimit{Rate: 1, Burst: 2}
+	c.WithMethodRateLimit("/test.PingPong/Ping", rl)
+	assert.Equal(t, rl, c.MethodRateLimits["/test.PingPong/Ping"])
+}
+
+func TestBaseConfig_WithCallerRateLimit(t *testing.T) {
+	c := &BaseConfig{}
+	rl := &RateLimit{Rate: 1, Burst: 2}
+	c.WithCallerRateLimit(rl, "caller-id")
+	assert.Equal(t, rl, c.CallerRateLimit)
+	assert.Equal(t, "caller-id", c.CallerMetadataKey)
+}
+
+func TestBaseConfig_WithKeepaliveTime(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultKeepaliveTime()
+	assert.Equal(t, c1.KeepaliveTime, c2.WithKeepaliveTime(0).KeepaliveTime)
+	assert.NotEqual(t, c1.KeepaliveTime, c3.WithKeepaliveTime(time.Minute).KeepaliveTime)
+}
+
+func TestBaseConfig_WithKeepaliveTimeout(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultKeepaliveTimeout()
+	assert.Equal(t, c1.KeepaliveTimeout, c2.WithKeepaliveTimeout(0).KeepaliveTimeout)
+	assert.NotEqual(t, c1.KeepaliveTimeout,
+		c3.WithKeepaliveTimeout(time.Minute).KeepaliveTimeout)
+}
+
+func TestBaseConfig_WithKeepaliveMinTime(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultKeepaliveMinTime()
+	assert.Equal(t, c1.KeepaliveMinTime, c2.WithKeepaliveMinTime(0).KeepaliveMinTime)
+	assert.NotEqual(t, c1.KeepaliveMinTime, c3.WithKeepaliveMinTime(time.Second).KeepaliveMinTime)
+}
+
+func TestBaseConfig_WithKeepalivePermitWithoutStream(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultKeepalivePermitWithoutStream()
+	assert.Equal(t, c1.KeepalivePermitWithoutStream,
+		c2.WithKeepalivePermitWithoutStream(false).KeepalivePermitWithoutStream)
+	assert.NotEqual(t, c1.KeepalivePermitWithoutStream,
+		c3.WithKeepalivePermitWithoutStream(true).KeepalivePermitWithoutStream)
+}
+
+func TestBaseConfig_WithMaxConnectionIdle(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultMaxConnectionIdle()
+	assert.Equal(t, c1.MaxConnectionIdle, c2.WithMaxConnectionIdle(0).MaxConnectionIdle)
+	assert.NotEqual(t, c1.MaxConnectionIdle,
+		c3.WithMaxConnectionIdle(time.Minute).MaxConnectionIdle)
+}
+
+func TestBaseConfig_WithMaxConnectionAge(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultMaxConnectionAge()
+	assert.Equal(t, c1.MaxConnectionAge, c2.WithMaxConnectionAge(0).MaxConnectionAge)
+	assert.NotEqual(t, c1.MaxConnectionAge, c3.WithMaxConnectionAge(time.Minute).MaxConnectionAge)
+}
+
+func TestBaseConfig_WithMaxConnectionAgeGrace(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultMaxConnectionAgeGrace()
+	assert.Equal(t, c1.MaxConnectionAgeGrace,
+		c2.WithMaxConnectionAgeGrace(0).MaxConnectionAgeGrace)
+	assert.NotEqual(t, c1.MaxConnectionAgeGrace,
+		c3.WithMaxConnectionAgeGrace(time.Minute).MaxConnectionAgeGrace)
+}
+
+func TestBaseConfig_WithMaxRecvMsgSize(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultMaxRecvMsgSize()
+	assert.Equal(t, c1.MaxRecvMsgSize, c2.WithMaxRecvMsgSize(0).MaxRecvMsgSize)
+	assert.NotEqual(t, c1.MaxRecvMsgSize, c3.WithMaxRecvMsgSize(1000).MaxRecvMsgSize)
+}
+
+func TestBaseConfig_WithMaxSendMsgSize(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultMaxSendMsgSize()
+	assert.Equal(t, c1.MaxSendMsgSize, c2.WithMaxSendMsgSize(0).MaxSendMsgSize)
+	assert.NotEqual(t, c1.MaxSendMsgSize, c3.WithMaxSendMsgSize(1000).MaxSendMsgSize)
+}
+
+func TestBaseConfig_WithCompression(t *testing.T) {
+	c1, c2, c3 := &BaseConfig{}, &BaseConfig{}, &BaseConfig{}
+	c1.WithDefaultCompression()
+	assert.Equal(t, c1.Compression, c2.WithCompression("").Compression)
+	assert.NotEqual(t, c1.Compression, c3.WithCompression(CompressionGzip).Compression)
+}
+
+func TestBaseConfig_Validate_ok(t *testing.T) {
+	c := NewDefaultBaseConfig()
+	assert.Nil(t, c.Validate())
+
+	c.WithCompression(CompressionGzip).
+		WithMaxConnectionAge(time.Minute).
+		WithMethodRateLimit("/test.PingPong/Ping", &RateLimit{Rate: 1, Burst: 2}).
+		WithCallerRateLimit(&RateLimit{Rate: 1}, "")
+	assert.Nil(t, c.Validate())
+
+	// zero values indicate the defaults
+	assert.Nil(t, (&BaseConfig{}).Validate())
+	assert.Equal(t, DefaultMaxRecvMsgSize, (&BaseConfig{}).maxRecvMsgSize())
+	assert.Equal(t, DefaultMaxSendMsgSize, (&BaseConfig{}).maxSendMsgSize())
+}
+
+func TestBaseConfig_Validate_err(t *testing.T) {
+	cases := map[string]*BaseConfig{
+		"bad server port":     NewDefaultBaseConfig().WithServerPort(100000),
+		"bad gateway port":    NewDefaultBaseConfig().WithGatewayPort(100000),
+		"grpc-web port taken": NewDefaultBaseConfig().WithGRPCWebPort(DefaultServerPort),
+		"negative cors age":   NewDefaultBaseConfig().WithCORSMaxAge(-time.Second),
+		"negative keepalive":  NewDefaultBaseConfig().WithKeepaliveTime(-time.Second),
+		"negative age grace":  NewDefaultBaseConfig().WithMaxConnectionAgeGrace(-time.Second),
+		"negative recv size":  NewDefaultBaseConfig().WithMaxRecvMsgSize(-1),
+		"negative send size":  NewDefaultBaseConfig().WithMaxSendMsgSize(-1),
+		"unknown compression": NewDefaultBaseConfig().WithCompression("snappy"),
+		"bad method rate limit": NewDefaultBaseConfig().
+			WithMethodRateLimit("/test.PingPong/Ping", &RateLimit{Burst: 1}),
+		"bad caller rate limit": NewDefaultBaseConfig().
+			WithCallerRateLimit(&RateLimit{Burst: 1}, ""),
+	}
+	for desc, c := range cases {
+		assert.NotNil(t, c.Validate(), desc)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	corsOriginHeader        = "Origin"
+	corsVaryHeader          = "Vary"
+	corsRequestMethodHeader = "Access-Control-Request-Method"
+	corsAllowOriginHeader   = "Access-Control-Allow-Origin"
+	corsAllowMethodsHeader  = "Access-Control-Allow-Methods"
+	corsAllowHeadersHeader  = "Access-Control-Allow-Headers"
+	corsExposeHeadersHeader = "Access-Control-Expose-Headers"
+	corsMaxAgeHeader        = "Access-Control-Max-Age"
+)
+
+// corsHandler wraps an http.Handler, adding CORS response headers to requests from allowed origins
+// and responding to preflight requests.
+type corsHandler struct {
+	next          http.Handler
+	allowed       func(origin string) bool
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// newCORSHandler wraps the given handler with one allowing cross-origin requests from the config
+// CORSAllowedOrigins for the given methods. The given expose headers are the response headers
+// browsers make available to the caller. If no origins are allowed, the given handler is returned
+// as-is.
+func newCORSHandler(
+	config *BaseConfig, methods, exposeHeaders []string, next http.Handler,
+) http.Handler {
+	if len(config.CORSAllowedOrigins) == 0 {
+		return next
+	}
+	return &corsHandler{
+		next:          next,
+		allowed:       newOriginMatcher(config.CORSAllowedOrigins),
+		allowMethods:  strings.Join(methods, ", "),
+		allowHeaders:  strings.Join(config.CORSAllowedHeaders, ", "),
+		exposeHeaders: strings.Join(append([]string{retryAfterHeader}, exposeHeaders...), ", "),
+		maxAge:        strconv.Itoa(int(config.CORSMaxAge.Seconds())),
+	}
+}
+
+func (h *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get(corsOriginHeader)
+	w.Header().Add(corsVaryHeader, corsOriginHeader)
+	if origin == "" || !h.allowed(origin) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(corsAllowOriginHeader, origin)
+	if r.Method == http.MethodOptions && r.Header.Get(corsRequestMethodHeader) != "" {
+		// preflight request
+		w.Header().Set(corsAllowMethodsHeader, h.allowMethods)
+		w.Header().Set(corsAllowHeadersHeader, h.allowHeaders)
+		w.Header().Set(corsMaxAgeHeader, h.maxAge)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set(corsExposeHeadersHeader, h.exposeHeaders)
+	h.next.ServeHTTP(w, r)
+}
+
+// newOriginMatcher returns a function indicating whether an origin is one of the given allowed
+// origins, any origin being allowed if CORSAllowAllOrigins is one of them.
+func newOriginMatcher(allowedOrigins []string) func(origin string) bool {
+	allowed := make(map[string]struct{})
+	for _, origin := range allowedOrigins {
+		if origin == CORSAllowAllOrigins {
+			return func(string) bool { return true }
+		}
+		allowed[origin] = struct{}{}
+	}
+	return func(origin string) bool {
+		_, in := allowed[origin]
+		return in
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCORSHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	c := NewDefaultBaseConfig()
+	assert.IsType(t, next, newCORSHandler(c, gatewayMethods, nil, next))
+
+	c.WithCORSAllowedOrigins(CORSAllowAllOrigins)
+	h := newCORSHandler(c, gatewayMethods, []string{"Grpc-Status"}, next).(*corsHandler)
+	assert.True(t, h.allowed("https://example.com"))
+	assert.Equal(t, "GET, POST, PUT, PATCH, DELETE", h.allowMethods)
+	assert.Equal(t, "Accept, Authorization, Content-Type", h.allowHeaders)
+	assert.Equal(t, "Retry-After, Grpc-Status", h.exposeHeaders)
+	assert.Equal(t, "600", h.maxAge)
+}
+
+func TestCORSHandler_ServeHTTP(t *testing.T) {
+	served := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+		w.WriteHeader(http.StatusOK)
+	})
+	c := NewDefaultBaseConfig().WithCORSAllowedOrigins("https://example.com")
+	h := newCORSHandler(c, gatewayMethods, nil, next)
+
+	// allowed origin
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/ping", nil)
+	req.Header.Set(corsOriginHeader, "https://example.com")
+	h.ServeHTTP(rec, req)
+	assert.True(t, served)
+	assert.Equal(t, "https://example.com", rec.Header().Get(corsAllowOriginHeader))
+	assert.Equal(t, retryAfterHeader, rec.Header().Get(corsExposeHeadersHeader))
+	assert.Equal(t, corsOriginHeader, rec.Header().Get(corsVaryHeader))
+
+	// preflight doesn't reach next handler
+	served = false
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodOptions, "/v1/ping", nil)
+	req.Header.Set(corsOriginHeader, "https://example.com")
+	req.Header.Set(corsRequestMethodHeader, http.MethodPost)
+	h.ServeHTTP(rec, req)
+	assert.False(t, served)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(corsAllowMethodsHeader))
+	assert.NotEmpty(t, rec.Header().Get(corsAllowHeadersHeader))
+	assert.Equal(t, "600", rec.Header().Get(corsMaxAgeHeader))
+
+	// disallowed origin
+	served = false
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/v1/ping", nil)
+	req.Header.Set(corsOriginHeader, "https://other.com")
+	h.ServeHTTP(rec, req)
+	assert.True(t, served)
+	assert.Empty(t, rec.Header().Get(corsAllowOriginHeader))
+}
+
+func TestNewOriginMatcher(t *testing.T) {
+	allowed := newOriginMatcher(nil)
+	assert.False(t, allowed("https://example.com"))
+
+	allowed = newOriginMatcher([]string{"https://example.com"})
+	assert.True(t, allowed("https://example.com"))
+	assert.False(t, allowed("https://other.com"))
+
+	allowed = newOriginMatcher([]string{"https://example.com", CORSAllowAllOrigins})
+	assert.True(t, allowed("https://other.com"))
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/soheilhy/cmux"
+	"go.uber.org/zap"
+	rpccode "google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcContentType    = "application/grpc"
+	jsonContentType    = "application/json"
+	retryAfterHeader   = "Retry-After"
+	gatewayErrFallback = `{"code": 2, "status": "UNKNOWN", "message": "failed to marshal error"}`
+)
+
+var gatewayMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// GatewayRegistrar registers the HTTP/JSON gateway handlers for a service on the given mux,
+// proxying requests to the gRPC server at the given endpoint. The
+// Register<Service>HandlerFromEndpoint functions generated by protoc-gen-grpc-gateway satisfy
+// this type.
+type GatewayRegistrar func(
+	ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption,
+) error
+
+// RegisterGateway adds the HTTP/JSON gateway registrars for one or more services. The gateway is
+// served alongside the gRPC server when the GatewayPort is configured. It must be called before
+// Serve.
+func (b *BaseServer) RegisterGateway(registrars ...GatewayRegistrar) {
+	b.gatewayRegistrars = append(b.gatewayRegistrars, registrars...)
+}
+
+// newGateway creates the gateway HTTP server, which proxies requests to the main gRPC server, or
+// returns nil if no gateway is configured.
+func (b *BaseServer) newGateway() (*http.Server, error) {
+	if b.config.GatewayPort == 0 || len(b.gatewayRegistrars) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard,
+			&runtime.JSONPb{OrigName: true, EmitDefaults: true}),
+		runtime.WithProtoErrorHandler(gatewayErrorHandler),
+	)
+	endpoint := fmt.Sprintf("localhost:%d", b.config.ServerPort)
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	for _, register := range b.gatewayRegistrars {
+		if err := register(ctx, mux, endpoint, opts); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	b.gatewayCancel = cancel
+	handler := newCORSHandler(b.config, gatewayMethods, nil, mux)
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", b.config.GatewayPort),
+		Handler: handler,
+	}, nil
+}
+
+// serveGateway starts serving the gateway, if configured, and returns the listener the gRPC server
+// should serve from. When the gateway and server ports are the same, the connections on the given
+// listener are multiplexed between the gRPC server and the gateway.
+func (b *BaseServer) serveGateway(lis net.Listener) net.Listener {
+	if b.gateway == nil {
+		return lis
+	}
+	b.Logger.Info("serving HTTP/JSON gateway",
+		zap.Uint(logGatewayPort, b.config.GatewayPort),
+	)
+	if b.config.GatewayPort != b.config.ServerPort {
+		go func() {
+			if err := b.gateway.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				b.Logger.Error("error serving gateway", zap.Error(err))
+				b.StopServer()
+			}
+		}()
+		return lis
+	}
+
+	b.muxLis = lis
+	m := cmux.New(lis)
+	grpcLis := m.MatchWithWriters(
+		cmux.HTTP2MatchHeaderFieldSendSettings("content-type", grpcContentType),
+	)
+	httpLis := m.Match(cmux.Any())
+	go func() {
+		if err := b.gateway.Serve(httpLis); err != nil && b.State() < Stopping {
+			b.Logger.Error("error serving gateway", zap.Error(err))
+			b.StopServer()
+		}
+	}()
+	go func() {
+		if err := m.Serve(); err != nil && b.State() < Stopping {
+			b.Logger.Error("error multiplexing connections", zap.Error(err))
+			b.StopServer()
+		}
+	}()
+	return grpcLis
+}
+
+type gatewayError struct {
+	Code    int32             `json:"code"`
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// gatewayErrorHandler writes a JSON error body with the gRPC status code, status name, message and
+// details of the given error along with the corresponding HTTP status code.
+func gatewayErrorHandler(
+	ctx context.Context,
+	_ *runtime.ServeMux,
+	marshaler runtime.Marshaler,
+	w http.ResponseWriter,
+	_ *http.Request,
+	err error,
+) {
+	s, ok := status.FromError(err)
+	if !ok {
+		s = status.New(codes.Unknown, err.Error())
+	}
+	body := &gatewayError{
+		Code:    int32(s.Code()),
+		Status:  rpccode.Code_name[int32(s.Code())],
+		Message: s.Message(),
+	}
+	for _, detail := range s.Proto().GetDetails() {
+		if buf, err := marshaler.Marshal(detail); err == nil {
+			body.Details = append(body.Details, buf)
+		}
+	}
+
+	w.Header().Del("Trailer")
+	w.Header().Set("Content-Type", jsonContentType)
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		if vals := md.TrailerMD[RetryAfterKey]; len(vals) > 0 {
+			w.Header().Set(retryAfterHeader, vals[0])
+		}
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, gatewayErrFallback)
+		return
+	}
+	w.WriteHeader(runtime.HTTPStatusFromCode(s.Code()))
+	_, _ = w.Write(buf)
+}
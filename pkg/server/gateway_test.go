@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elixirhealth/service-base/pkg/server/test"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBaseServer_Serve_gateway(t *testing.T) {
+	c := NewDefaultBaseConfig().
+		WithServerPort(10120).
+		WithMetricsPort(10121).
+		WithGatewayPort(10122).
+		WithCORSAllowedOrigins("https://example.com")
+	c.Profile = false
+	srv := servePingPongGateway(t, c)
+
+	url := fmt.Sprintf("http://localhost:%d/v1/ping", c.GatewayPort)
+	resp, err := http.Post(url, jsonContentType, bytes.NewBufferString("{}"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"pong": true}`, string(body))
+
+	// CORS preflight
+	req, err := http.NewRequest(http.MethodOptions, url, nil)
+	assert.Nil(t, err)
+	req.Header.Set(corsOriginHeader, "https://example.com")
+	req.Header.Set(corsRequestMethodHeader, http.MethodPost)
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "https://example.com", resp.Header.Get(corsAllowOriginHeader))
+
+	srv.StopServer()
+}
+
+func TestBaseServer_Serve_gatewayMultiplexed(t *testing.T) {
+	c := NewDefaultBaseConfig().
+		WithServerPort(10130).
+		WithMetricsPort(10131).
+		WithGatewayPort(10130)
+	c.Profile = false
+	srv := servePingPongGateway(t, c)
+
+	// HTTP/JSON request
+	url := fmt.Sprintf("http://localhost:%d/v1/ping", c.GatewayPort)
+	resp, err := http.Post(url, jsonContentType, bytes.NewBufferString("{}"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// gRPC request on same port
+	cc, err := grpc.Dial(fmt.Sprintf("localhost:%d", c.ServerPort), grpc.WithInsecure())
+	assert.Nil(t, err)
+	rp, err := test.NewPingPongClient(cc).Ping(context.Background(), &test.PingRequest{})
+	assert.Nil(t, err)
+	assert.True(t, rp.Pong)
+	assert.Nil(t, cc.Close())
+
+	srv.StopServer()
+}
+
+func TestBaseServer_newGateway(t *testing.T) {
+	c := NewDefaultBaseConfig()
+	b := NewBaseServer(c)
+	gw, err := b.newGateway()
+	assert.Nil(t, err)
+	assert.Nil(t, gw) // no port or registrars
+
+	c.WithGatewayPort(10140)
+	b.RegisterGateway(test.RegisterPingPongHandlerFromEndpoint)
+	gw, err = b.newGateway()
+	assert.Nil(t, err)
+	assert.NotNil(t, gw)
+	assert.Equal(t, ":10140", gw.Addr)
+	b.gatewayCancel()
+
+	b.RegisterGateway(func(context.Context, *runtime.ServeMux, string,
+		[]grpc.DialOption) error {
+		return errors.New("some register error")
+	})
+	gw, err = b.newGateway()
+	assert.NotNil(t, err)
+	assert.Nil(t, gw)
+}
+
+func TestGatewayErrorHandler(t *testing.T) {
+	md := runtime.ServerMetadata{
+		TrailerMD: metadata.Pairs(RetryAfterKey, "10"),
+	}
+	ctx := runtime.NewServerMetadataContext(context.Background(), md)
+	err := status.Error(codes.ResourceExhausted, "too many requests")
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/ping", strings.NewReader("{}"))
+
+	gatewayErrorHandler(ctx, nil, &runtime.JSONPb{}, rec, req, err)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, jsonContentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "10", rec.Header().Get(retryAfterHeader))
+
+	body := &gatewayError{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), body))
+	assert.Equal(t, int32(codes.ResourceExhausted), body.Code)
+	assert.Equal(t, "RESOURCE_EXHAUSTED", body.Status)
+	assert.Equal(t, "too many requests", body.Message)
+
+	// non-status errors are unknown
+	rec = httptest.NewRecorder()
+	gatewayErrorHandler(context.Background(), nil, &runtime.JSONPb{}, rec, req,
+		errors.New("some error"))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	body = &gatewayError{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), body))
+	assert.Equal(t, "UNKNOWN", body.Status)
+	assert.Equal(t, "some error", body.Message)
+	assert.Empty(t, rec.Header().Get(retryAfterHeader))
+}
+
+func servePingPongGateway(t *testing.T, c *BaseConfig) *pingPong {
+	srv := &pingPong{BaseServer: NewBaseServer(c)}
+	srv.RegisterGateway(test.RegisterPingPongHandlerFromEndpoint)
+	registerFunc := func(s *grpc.Server) { test.RegisterPingPongServer(s, srv) }
+
+	up := make(chan *pingPong, 1)
+	go func() {
+		err := srv.Serve(registerFunc, func() { up <- srv })
+		assert.Nil(t, err)
+	}()
+	return <-up
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// newGRPCWeb creates the gRPC-Web HTTP server wrapping the given gRPC server, or returns nil if no
+// gRPC-Web port is configured. Since requests are handled by the gRPC server itself, they go
+// through the same interceptors (and thus metrics and limits) as native gRPC requests.
+func (b *BaseServer) newGRPCWeb(s *grpc.Server) *http.Server {
+	if b.config.GRPCWebPort == 0 {
+		return nil
+	}
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", b.config.GRPCWebPort),
+		Handler: newGRPCWebHandler(b.config, s),
+	}
+}
+
+// newGRPCWebHandler returns an http.Handler serving gRPC-Web requests, including CORS preflight
+// requests from the config CORSAllowedOrigins, via the given gRPC server.
+func newGRPCWebHandler(config *BaseConfig, s *grpc.Server) http.Handler {
+	wrapped := grpcweb.WrapServer(s,
+		grpcweb.WithOriginFunc(newOriginMatcher(config.CORSAllowedOrigins)),
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wrapped.IsGrpcWebRequest(r) || wrapped.IsAcceptableGrpcCorsRequest(r) {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
+
+func (b *BaseServer) serveGRPCWeb() {
+	if b.grpcWeb == nil {
+		return
+	}
+	b.Logger.Info("serving gRPC-Web",
+		zap.Uint(logGRPCWebPort, b.config.GRPCWebPort),
+	)
+	go func() {
+		if err := b.grpcWeb.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			b.Logger.Error("error serving gRPC-Web", zap.Error(err))
+			b.StopServer()
+		}
+	}()
+}
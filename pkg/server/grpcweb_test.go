@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/elixirhealth/service-base/pkg/server/test"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	grpcWebContentType = "application/grpc-web+proto"
+	grpcWebTrailerFlag = byte(0x80)
+)
+
+func TestBaseServer_newGRPCWeb(t *testing.T) {
+	c := NewDefaultBaseConfig()
+	b := NewBaseServer(c)
+	assert.Nil(t, b.newGRPCWeb(grpc.NewServer()))
+
+	c.WithGRPCWebPort(10150)
+	gw := b.newGRPCWeb(grpc.NewServer())
+	assert.NotNil(t, gw)
+	assert.Equal(t, ":10150", gw.Addr)
+}
+
+func TestGRPCWebHandler_unary(t *testing.T) {
+	srv := newGRPCWebTestServer(NewDefaultBaseConfig())
+	defer srv.Close()
+
+	msgs, trailer := grpcWebPost(t, srv.URL+"/test.PingPong/Ping", &test.PingRequest{Ping: true})
+	assert.Len(t, msgs, 1)
+	rp := &test.PingResponse{}
+	assert.Nil(t, proto.Unmarshal(msgs[0], rp))
+	assert.True(t, rp.Pong)
+	assert.Equal(t, "0", trailer.Get("Grpc-Status"))
+}
+
+func TestGRPCWebHandler_serverStreaming(t *testing.T) {
+	srv := newGRPCWebTestServer(NewDefaultBaseConfig())
+	defer srv.Close()
+
+	msgs, trailer := grpcWebPost(t, srv.URL+"/test.PingPong/Pings", &test.PingsRequest{Count: 3})
+	assert.Len(t, msgs, 3)
+	for _, msg := range msgs {
+		rp := &test.PingResponse{}
+		assert.Nil(t, proto.Unmarshal(msg, rp))
+		assert.True(t, rp.Pong)
+	}
+	assert.Equal(t, "0", trailer.Get("Grpc-Status"))
+}
+
+func TestGRPCWebHandler_interceptors(t *testing.T) {
+	c := NewDefaultBaseConfig().
+		WithMethodRateLimit(testFullMethod, &RateLimit{Rate: 0.1, Burst: 1})
+	srv := newGRPCWebTestServer(c)
+	defer srv.Close()
+
+	_, trailer := grpcWebPost(t, srv.URL+"/test.PingPong/Ping", &test.PingRequest{})
+	assert.Equal(t, "0", trailer.Get("Grpc-Status"))
+
+	// rate limit applies just as to native gRPC requests
+	msgs, trailer := grpcWebPost(t, srv.URL+"/test.PingPong/Ping", &test.PingRequest{})
+	assert.Empty(t, msgs)
+	assert.Equal(t, fmt.Sprint(int(codes.ResourceExhausted)), trailer.Get("Grpc-Status"))
+	assert.Equal(t, "10", trailer.Get(RetryAfterKey))
+}
+
+func TestGRPCWebHandler_cors(t *testing.T) {
+	c := NewDefaultBaseConfig().WithCORSAllowedOrigins("https://example.com")
+	srv := newGRPCWebTestServer(c)
+	defer srv.Close()
+
+	preflight := func(origin string) *http.Response {
+		rq, err := http.NewRequest(http.MethodOptions, srv.URL+"/test.PingPong/Ping", nil)
+		assert.Nil(t, err)
+		rq.Header.Set(corsOriginHeader, origin)
+		rq.Header.Set(corsRequestMethodHeader, http.MethodPost)
+		rq.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		rp, err := http.DefaultClient.Do(rq)
+		assert.Nil(t, err)
+		return rp
+	}
+
+	rp := preflight("https://example.com")
+	assert.Equal(t, "https://example.com", rp.Header.Get(corsAllowOriginHeader))
+
+	rp = preflight("https://other.com")
+	assert.Empty(t, rp.Header.Get(corsAllowOriginHeader))
+}
+
+func TestGRPCWebHandler_notGRPCWeb(t *testing.T) {
+	srv := newGRPCWebTestServer(NewDefaultBaseConfig())
+	defer srv.Close()
+
+	rp, err := http.Get(srv.URL + "/test.PingPong/Ping")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rp.StatusCode)
+}
+
+func newGRPCWebTestServer(c *BaseConfig) *httptest.Server {
+	b := NewBaseServer(c)
+	s := grpc.NewServer(b.serverOptions()...)
+	test.RegisterPingPongServer(s, &pingPong{BaseServer: b})
+	return httptest.NewServer(newGRPCWebHandler(c, s))
+}
+
+// grpcWebPost sends a gRPC-Web request with the given message, returning the response messages
+// and trailer.
+func grpcWebPost(t *testing.T, url string, rq proto.Message) ([][]byte, http.Header) {
+	msg, err := proto.Marshal(rq)
+	assert.Nil(t, err)
+	body := new(bytes.Buffer)
+	writeGRPCWebFrame(body, 0, msg)
+
+	httpRq, err := http.NewRequest(http.MethodPost, url, body)
+	assert.Nil(t, err)
+	httpRq.Header.Set("Content-Type", grpcWebContentType)
+	httpRq.Header.Set("X-Grpc-Web", "1")
+	httpRp, err := http.DefaultClient.Do(httpRq)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, httpRp.StatusCode)
+	rpBody, err := ioutil.ReadAll(httpRp.Body)
+	assert.Nil(t, err)
+
+	var msgs [][]byte
+	trailer := http.Header{}
+	if httpRp.Header.Get("Grpc-Status") != "" {
+		// trailers-only response, with status in the headers
+		trailer = httpRp.Header
+	}
+	r := bytes.NewReader(rpBody)
+	for {
+		flag, data, err := readGRPCWebFrame(r)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		if flag&grpcWebTrailerFlag != 0 {
+			// header keys may be in any case, so parse them into canonical form
+			tr := textproto.NewReader(bufio.NewReader(io.MultiReader(
+				bytes.NewReader(data), strings.NewReader("\r\n"))))
+			frameTrailer, err := tr.ReadMIMEHeader()
+			assert.Nil(t, err)
+			for key, vals := range frameTrailer {
+				trailer[key] = append(trailer[key], vals...)
+			}
+			continue
+		}
+		msgs = append(msgs, data)
+	}
+	return msgs, trailer
+}
+
+func writeGRPCWebFrame(w io.Writer, flag byte, data []byte) {
+	header := make([]byte, 5)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	_, _ = w.Write(header)
+	_, _ = w.Write(data)
+}
+
+func readGRPCWebFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// chainUnaryInterceptors combines the given interceptors into a single one, with the first
+// interceptor being the outermost.
+func chainUnaryInterceptors(
+	interceptors ...grpc.UnaryServerInterceptor,
+) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := chained, interceptors[i]
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// chainStreamInterceptors combines the given interceptors into a single one, with the first
+// interceptor being the outermost.
+func chainStreamInterceptors(
+	interceptors ...grpc.StreamServerInterceptor,
+) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := chained, interceptors[i]
+			chained = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
+}
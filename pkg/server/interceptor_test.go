@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestChainUnaryInterceptors(t *testing.T) {
+	calls := make([]string, 0)
+	newInterceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name)
+			return handler(ctx, req)
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return req, nil
+	}
+	chained := chainUnaryInterceptors(newInterceptor("first"), newInterceptor("second"))
+	rp, err := chained(context.Background(), "req", &grpc.UnaryServerInfo{}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "req", rp)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestChainStreamInterceptors(t *testing.T) {
+	calls := make([]string, 0)
+	newInterceptor := func(name string) grpc.StreamServerInterceptor {
+		return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			calls = append(calls, name)
+			return handler(srv, ss)
+		}
+	}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+	chained := chainStreamInterceptors(newInterceptor("first"), newInterceptor("second"))
+	err := chained(nil, nil, &grpc.StreamServerInfo{}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
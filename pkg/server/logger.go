@@ -1,10 +1,42 @@
 package server
 
+import "go.uber.org/zap/zapcore"
+
 const (
-	logServerPort           = "server_port"
-	logMetricsPort          = "metrics_port"
-	logProfilerPort         = "profiler_port"
-	logMaxConcurrentStreams = "max_concurrent_streams"
-	logLogLevel             = "log_level"
-	logProfile              = "profile"
+	logServerPort                   = "server_port"
+	logMetricsPort                  = "metrics_port"
+	logProfilerPort                 = "profiler_port"
+	logGatewayPort                  = "gateway_port"
+	logGRPCWebPort                  = "grpc_web_port"
+	logCORSAllowedOrigins           = "cors_allowed_origins"
+	logCORSAllowedHeaders           = "cors_allowed_headers"
+	logCORSMaxAge                   = "cors_max_age"
+	logMaxConcurrentStreams         = "max_concurrent_streams"
+	logKeepaliveTime                = "keepalive_time"
+	logKeepaliveTimeout             = "keepalive_timeout"
+	logKeepaliveMinTime             = "keepalive_min_time"
+	logKeepalivePermitWithoutStream = "keepalive_permit_without_stream"
+	logMaxConnectionIdle            = "max_connection_idle"
+	logMaxConnectionAge             = "max_connection_age"
+	logMaxConnectionAgeGrace        = "max_connection_age_grace"
+	logMaxRecvMsgSize               = "max_recv_msg_size"
+	logMaxSendMsgSize               = "max_send_msg_size"
+	logCompression                  = "compression"
+	logLogLevel                     = "log_level"
+	logProfile                      = "profile"
+	logMaxInFlightRequests          = "max_in_flight_requests"
+	logMethodRateLimits             = "method_rate_limits"
+	logCallerRateLimit              = "caller_rate_limit"
+	logCallerMetadataKey            = "caller_metadata_key"
+	logRate                         = "rate"
+	logBurst                        = "burst"
 )
+
+type stringArray []string
+
+func (sa stringArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, s := range sa {
+		enc.AppendString(s)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RetryAfterKey is the metadata key giving the number of seconds a client should wait before
+	// retrying a request rejected with ResourceExhausted.
+	RetryAfterKey = "retry-after"
+
+	// maxCallerBuckets is the maximum number of per-caller buckets, above which the least
+	// recently used bucket is evicted.
+	maxCallerBuckets = 10000
+
+	unknownCaller = "unknown"
+
+	reasonInFlight = "in_flight"
+	reasonMethod   = "method_rate"
+	reasonCaller   = "caller_rate"
+
+	grpcTypeUnary        = "unary"
+	grpcTypeClientStream = "client_stream"
+	grpcTypeServerStream = "server_stream"
+	grpcTypeBidiStream   = "bidi_stream"
+)
+
+var (
+	rejectedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_server_rejected_total",
+			Help: "Total number of RPCs rejected on the server by rate or in-flight limits.",
+		},
+		[]string{"grpc_type", "grpc_service", "grpc_method", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rejectedRequests)
+}
+
+// RateLimit defines a token bucket rate limit.
+type RateLimit struct {
+	// Rate is the sustained number of requests allowed per second.
+	Rate float64
+
+	// Burst is the maximum number of requests allowed at once.
+	Burst uint
+}
+
+// MarshalLogObject writes the rate limit to the given object encoder.
+func (r *RateLimit) MarshalLogObject(oe zapcore.ObjectEncoder) error {
+	oe.AddFloat64(logRate, r.Rate)
+	oe.AddUint(logBurst, r.Burst)
+	return nil
+}
+
+type methodRateLimits map[string]*RateLimit
+
+func (m methodRateLimits) MarshalLogObject(oe zapcore.ObjectEncoder) error {
+	for method, rl := range m {
+		if err := oe.AddObject(method, rl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rateLimiter rejects requests exceeding the configured in-flight, per-method, and per-caller
+// limits.
+type rateLimiter struct {
+	maxInFlight uint
+	inFlight    uint
+	inFlightMu  sync.Mutex
+
+	methods map[string]*tokenBucket
+
+	callerLimit *RateLimit
+	callerKey   string
+	maxCallers  int
+	callers     map[string]*list.Element
+	callersLRU  *list.List
+	callersMu   sync.Mutex
+
+	now func() time.Time
+}
+
+// newRateLimiter creates a new *rateLimiter from the config limits or returns nil if no limits are
+// configured.
+func newRateLimiter(config *BaseConfig) *rateLimiter {
+	if config.MaxInFlightRequests == 0 && len(config.MethodRateLimits) == 0 &&
+		config.CallerRateLimit == nil {
+		return nil
+	}
+	methods := make(map[string]*tokenBucket)
+	now := time.Now()
+	for method, rl := range config.MethodRateLimits {
+		methods[method] = newTokenBucket(rl, now)
+	}
+	return &rateLimiter{
+		maxInFlight: config.MaxInFlightRequests,
+		methods:     methods,
+		callerLimit: config.CallerRateLimit,
+		callerKey:   strings.ToLower(config.CallerMetadataKey),
+		maxCallers:  maxCallerBuckets,
+		callers:     make(map[string]*list.Element),
+		callersLRU:  list.New(),
+		now:         time.Now,
+	}
+}
+
+// admit determines whether a new request for the given method may proceed. If so, the returned
+// release function must be called once the request finishes. Otherwise, it returns the reason for
+// the rejection and how long the caller should wait before retrying.
+func (l *rateLimiter) admit(ctx context.Context, fullMethod string) (
+	release func(), reason string, retryAfter time.Duration) {
+
+	if !l.acquireInFlight() {
+		return nil, reasonInFlight, time.Second
+	}
+	now := l.now()
+	methodTB, limitMethod := l.methods[fullMethod]
+	if limitMethod {
+		if wait, ok := methodTB.take(now); !ok {
+			l.releaseInFlight()
+			return nil, reasonMethod, wait
+		}
+	}
+	if l.callerLimit != nil {
+		if wait, ok := l.callerBucket(ctx, now).take(now); !ok {
+			if limitMethod {
+				// the rejected request shouldn't count toward the method rate limit
+				methodTB.refund()
+			}
+			l.releaseInFlight()
+			return nil, reasonCaller, wait
+		}
+	}
+	return l.releaseInFlight, "", 0
+}
+
+func (l *rateLimiter) acquireInFlight() bool {
+	if l.maxInFlight == 0 {
+		return true
+	}
+	l.inFlightMu.Lock()
+	defer l.inFlightMu.Unlock()
+	if l.inFlight >= l.maxInFlight {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+func (l *rateLimiter) releaseInFlight() {
+	if l.maxInFlight == 0 {
+		return
+	}
+	l.inFlightMu.Lock()
+	l.inFlight--
+	l.inFlightMu.Unlock()
+}
+
+// callerBucket returns the request caller's bucket, creating it if needed. When there are more
+// than maxCallers buckets, the least recently used one is evicted, so a caller idle long enough
+// for it to be evicted starts again with a full bucket.
+func (l *rateLimiter) callerBucket(ctx context.Context, now time.Time) *tokenBucket {
+	caller := l.caller(ctx)
+	l.callersMu.Lock()
+	defer l.callersMu.Unlock()
+	if e, in := l.callers[caller]; in {
+		l.callersLRU.MoveToFront(e)
+		return e.Value.(*callerBucket).tb
+	}
+	if l.callersLRU.Len() >= l.maxCallers {
+		oldest := l.callersLRU.Back()
+		l.callersLRU.Remove(oldest)
+		delete(l.callers, oldest.Value.(*callerBucket).caller)
+	}
+	tb := newTokenBucket(l.callerLimit, now)
+	l.callers[caller] = l.callersLRU.PushFront(&callerBucket{caller: caller, tb: tb})
+	return tb
+}
+
+// caller identifies the request caller by the configured metadata key value if present and
+// otherwise by the peer host.
+func (l *rateLimiter) caller(ctx context.Context) string {
+	if l.callerKey != "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if vals := md[l.callerKey]; len(vals) > 0 && vals[0] != "" {
+				return vals[0]
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return unknownCaller
+}
+
+func (l *rateLimiter) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		release, reason, retryAfter := l.admit(ctx, info.FullMethod)
+		if release == nil {
+			_ = grpc.SetTrailer(ctx, retryAfterMetadata(retryAfter))
+			return nil, reject(grpcTypeUnary, info.FullMethod, reason)
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+func (l *rateLimiter) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		release, reason, retryAfter := l.admit(ss.Context(), info.FullMethod)
+		if release == nil {
+			ss.SetTrailer(retryAfterMetadata(retryAfter))
+			return reject(streamType(info), info.FullMethod, reason)
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+func reject(grpcType, fullMethod, reason string) error {
+	service, method := splitMethodName(fullMethod)
+	rejectedRequests.WithLabelValues(grpcType, service, method, reason).Inc()
+	return status.Errorf(codes.ResourceExhausted, "request rejected by server limit: %s",
+		reason)
+}
+
+func retryAfterMetadata(wait time.Duration) metadata.MD {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return metadata.Pairs(RetryAfterKey, strconv.Itoa(secs))
+}
+
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return unknownCaller, unknownCaller
+}
+
+func streamType(info *grpc.StreamServerInfo) string {
+	if info.IsClientStream && !info.IsServerStream {
+		return grpcTypeClientStream
+	} else if !info.IsClientStream && info.IsServerStream {
+		return grpcTypeServerStream
+	}
+	return grpcTypeBidiStream
+}
+
+type callerBucket struct {
+	caller string
+	tb     *tokenBucket
+}
+
+// tokenBucket is a simple token bucket refilled continuously at a fixed rate.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newTokenBucket(rl *RateLimit, now time.Time) *tokenBucket {
+	burst := float64(rl.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rl.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// take removes a token from the bucket if one is available. If not, it returns how long until the
+// next token will be available.
+func (tb *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill(now)
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0, true
+	}
+	if tb.rate <= 0 {
+		return time.Duration(math.MaxInt64), false
+	}
+	wait := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+	return wait, false
+}
+
+// refund returns a token taken for a request that was ultimately rejected.
+func (tb *tokenBucket) refund() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tokens = math.Min(tb.burst, tb.tokens+1)
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens = math.Min(tb.burst, tb.tokens+elapsed.Seconds()*tb.rate)
+		tb.last = now
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/service-base/pkg/server/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const testFullMethod = "/test.PingPong/Ping"
+
+func TestNewRateLimiter(t *testing.T) {
+	c := NewDefaultBaseConfig()
+	assert.Nil(t, newRateLimiter(c))
+
+	c.WithMaxInFlightRequests(2)
+	l := newRateLimiter(c)
+	assert.NotNil(t, l)
+	assert.Equal(t, uint(2), l.maxInFlight)
+
+	c = NewDefaultBaseConfig().
+		WithMethodRateLimit(testFullMethod, &RateLimit{Rate: 1, Burst: 1}).
+		WithCallerRateLimit(&RateLimit{Rate: 1, Burst: 1}, "Caller-ID")
+	l = newRateLimiter(c)
+	assert.NotNil(t, l)
+	assert.Len(t, l.methods, 1)
+	assert.NotNil(t, l.callerLimit)
+	assert.Equal(t, "caller-id", l.callerKey)
+}
+
+func TestRateLimiter_admit_inFlight(t *testing.T) {
+	l := newRateLimiter(NewDefaultBaseConfig().WithMaxInFlightRequests(2))
+	ctx := context.Background()
+
+	release1, _, _ := l.admit(ctx, testFullMethod)
+	assert.NotNil(t, release1)
+	release2, _, _ := l.admit(ctx, testFullMethod)
+	assert.NotNil(t, release2)
+
+	release3, reason, retryAfter := l.admit(ctx, testFullMethod)
+	assert.Nil(t, release3)
+	assert.Equal(t, reasonInFlight, reason)
+	assert.NotZero(t, retryAfter)
+
+	release1()
+	release3, _, _ = l.admit(ctx, testFullMethod)
+	assert.NotNil(t, release3)
+}
+
+func TestRateLimiter_admit_method(t *testing.T) {
+	c := NewDefaultBaseConfig().
+		WithMaxInFlightRequests(10).
+		WithMethodRateLimit(testFullMethod, &RateLimit{Rate: 1, Burst: 2})
+	l := newRateLimiter(c)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		release, _, _ := l.admit(ctx, testFullMethod)
+		assert.NotNil(t, release)
+	}
+	release, reason, retryAfter := l.admit(ctx, testFullMethod)
+	assert.Nil(t, release)
+	assert.Equal(t, reasonMethod, reason)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// rejected requests don't count toward in-flight limit
+	assert.Equal(t, uint(2), l.inFlight)
+
+	// other methods aren't limited
+	release, _, _ = l.admit(ctx, "/test.PingPong/Other")
+	assert.NotNil(t, release)
+
+	now = now.Add(time.Second)
+	release, _, _ = l.admit(ctx, testFullMethod)
+	assert.NotNil(t, release)
+}
+
+func TestRateLimiter_admit_caller(t *testing.T) {
+	c := NewDefaultBaseConfig().WithCallerRateLimit(&RateLimit{Rate: 1, Burst: 1}, "caller-id")
+	l := newRateLimiter(c)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	ctx1 := metadata.NewIncomingContext(context.Background(), metadata.Pairs("caller-id", "c1"))
+	ctx2 := metadata.NewIncomingContext(context.Background(), metadata.Pairs("caller-id", "c2"))
+
+	release, _, _ := l.admit(ctx1, testFullMethod)
+	assert.NotNil(t, release)
+	release, reason, _ := l.admit(ctx1, testFullMethod)
+	assert.Nil(t, release)
+	assert.Equal(t, reasonCaller, reason)
+
+	release, _, _ = l.admit(ctx2, testFullMethod)
+	assert.NotNil(t, release)
+	assert.Len(t, l.callers, 2)
+}
+
+func TestRateLimiter_admit_methodAndCaller(t *testing.T) {
+	c := NewDefaultBaseConfig().
+		WithMethodRateLimit(testFullMethod, &RateLimit{Rate: 1, Burst: 2}).
+		WithCallerRateLimit(&RateLimit{Rate: 1, Burst: 1}, "caller-id")
+	l := newRateLimiter(c)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	ctx1 := metadata.NewIncomingContext(context.Background(), metadata.Pairs("caller-id", "c1"))
+	ctx2 := metadata.NewIncomingContext(context.Background(), metadata.Pairs("caller-id", "c2"))
+
+	release, _, _ := l.admit(ctx1, testFullMethod)
+	assert.NotNil(t, release)
+
+	// caller rejections don't use method tokens
+	for i := 0; i < 3; i++ {
+		release, reason, _ := l.admit(ctx1, testFullMethod)
+		assert.Nil(t, release)
+		assert.Equal(t, reasonCaller, reason)
+	}
+	release, _, _ = l.admit(ctx2, testFullMethod)
+	assert.NotNil(t, release)
+}
+
+func TestRateLimiter_admit_manyCallers(t *testing.T) {
+	c := NewDefaultBaseConfig().WithCallerRateLimit(&RateLimit{Rate: 1, Burst: 1}, "caller-id")
+	l := newRateLimiter(c)
+	l.maxCallers = 10
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	callerCtx := func(i int) context.Context {
+		md := metadata.Pairs("caller-id", fmt.Sprintf("c%d", i))
+		return metadata.NewIncomingContext(context.Background(), md)
+	}
+
+	for i := 0; i < 1000; i++ {
+		release, _, _ := l.admit(callerCtx(i), testFullMethod)
+		assert.NotNil(t, release)
+		assert.True(t, len(l.callers) <= l.maxCallers)
+	}
+	assert.Len(t, l.callers, l.maxCallers)
+	assert.Equal(t, l.maxCallers, l.callersLRU.Len())
+
+	// recent callers are still limited
+	release, reason, _ := l.admit(callerCtx(999), testFullMethod)
+	assert.Nil(t, release)
+	assert.Equal(t, reasonCaller, reason)
+
+	// using a bucket keeps it from being evicted
+	_, _, _ = l.admit(callerCtx(990), testFullMethod)
+	for i := 1000; i < 1009; i++ {
+		_, _, _ = l.admit(callerCtx(i), testFullMethod)
+	}
+	_, in := l.callers["c990"]
+	assert.True(t, in)
+	_, in = l.callers["c991"]
+	assert.False(t, in)
+}
+
+func TestRateLimiter_caller(t *testing.T) {
+	l := &rateLimiter{callerKey: "caller-id"}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("caller-id", "c1"))
+	assert.Equal(t, "c1", l.caller(ctx))
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	assert.Equal(t, "10.0.0.1", l.caller(ctx))
+
+	assert.Equal(t, unknownCaller, l.caller(context.Background()))
+}
+
+func TestRateLimiter_unaryInterceptor(t *testing.T) {
+	l := newRateLimiter(NewDefaultBaseConfig().WithMaxInFlightRequests(1))
+	info := &grpc.UnaryServerInfo{FullMethod: testFullMethod}
+	handled := make(chan struct{})
+	blocked := make(chan struct{})
+	done := make(chan struct{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		close(handled)
+		<-blocked
+		return &test.PingResponse{Pong: true}, nil
+	}
+	go func() {
+		rp, err := l.unaryInterceptor()(context.Background(), &test.PingRequest{}, info, handler)
+		assert.Nil(t, err)
+		assert.NotNil(t, rp)
+		close(done)
+	}()
+	<-handled
+
+	rp, err := l.unaryInterceptor()(context.Background(), &test.PingRequest{}, info, handler)
+	assert.Nil(t, rp)
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	close(blocked)
+	<-done
+}
+
+func TestTokenBucket_take(t *testing.T) {
+	now := time.Now()
+	tb := newTokenBucket(&RateLimit{Rate: 2, Burst: 2}, now)
+
+	for i := 0; i < 2; i++ {
+		_, ok := tb.take(now)
+		assert.True(t, ok)
+	}
+	wait, ok := tb.take(now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	now = now.Add(500 * time.Millisecond)
+	_, ok = tb.take(now)
+	assert.True(t, ok)
+
+	// refunds and refills never exceed burst
+	tb.refund()
+	assert.Equal(t, float64(1), tb.tokens)
+	now = now.Add(time.Minute)
+	_, ok = tb.take(now)
+	assert.True(t, ok)
+	tb.refund()
+	tb.refund()
+	assert.Equal(t, float64(2), tb.tokens)
+}
+
+func TestRetryAfterMetadata(t *testing.T) {
+	assert.Equal(t, []string{"1"}, retryAfterMetadata(0)[RetryAfterKey])
+	assert.Equal(t, []string{"1"}, retryAfterMetadata(100 * time.Millisecond)[RetryAfterKey])
+	assert.Equal(t, []string{"3"}, retryAfterMetadata(2500 * time.Millisecond)[RetryAfterKey])
+}
+
+func TestSplitMethodName(t *testing.T) {
+	service, method := splitMethodName(testFullMethod)
+	assert.Equal(t, "test.PingPong", service)
+	assert.Equal(t, "Ping", method)
+
+	service, method = splitMethodName("bad")
+	assert.Equal(t, unknownCaller, service)
+	assert.Equal(t, unknownCaller, method)
+}
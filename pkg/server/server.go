@@ -9,17 +9,22 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/drausin/libri/libri/common/errors"
 	"github.com/drausin/libri/libri/common/logging"
+	"github.com/elixirhealth/service-base/pkg/server/adminapi"
+	"github.com/elixirhealth/service-base/pkg/version"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -45,6 +50,21 @@ const (
 	Stopped
 )
 
+var stateNames = map[State]string{
+	Starting: "STARTING",
+	Started:  "STARTED",
+	Stopping: "STOPPING",
+	Stopped:  "STOPPED",
+}
+
+// String returns the name of the state.
+func (s State) String() string {
+	if name, in := stateNames[s]; in {
+		return name
+	}
+	return "UNKNOWN"
+}
+
 // BaseServer is the base server components.
 type BaseServer struct {
 	config  *BaseConfig
@@ -54,6 +74,19 @@ type BaseServer struct {
 	stopped chan struct{}
 	health  *health.Server
 	metrics *http.Server
+	limiter *rateLimiter
+
+	gatewayRegistrars []GatewayRegistrar
+	gateway           *http.Server
+	gatewayCancel     context.CancelFunc
+	muxLis            net.Listener
+	grpcWeb           *http.Server
+
+	buildInfo       version.BuildInfo
+	effectiveConfig zapcore.ObjectMarshaler
+	startTime       time.Time
+	healthStatuses  map[string]healthpb.HealthCheckResponse_ServingStatus
+	healthMu        sync.Mutex
 }
 
 // NewBaseServer creates a new BaseServer from the config.
@@ -73,24 +106,45 @@ func NewBaseServer(config *BaseConfig) *BaseServer {
 		stopped: make(chan struct{}),
 		health:  health.NewServer(),
 		metrics: metrics,
+		limiter: newRateLimiter(config),
 		Logger:  logging.NewDevLogger(config.LogLevel),
+
+		effectiveConfig: config,
+		healthStatuses:  make(map[string]healthpb.HealthCheckResponse_ServingStatus),
 	}
 }
 
 // Serve starts the server listening for requests.
 func (b *BaseServer) Serve(registerServer func(s *grpc.Server), onServing func()) error {
-	s := grpc.NewServer(
-		grpc.StreamInterceptor(grpc_prometheus.StreamServerInterceptor),
-		grpc.UnaryInterceptor(grpc_prometheus.UnaryServerInterceptor),
-		grpc.MaxConcurrentStreams(b.config.MaxConcurrentStreams),
-	)
+	if err := b.config.Validate(); err != nil {
+		b.Logger.Error("invalid config", zap.Error(err))
+		return err
+	}
+	s := grpc.NewServer(b.serverOptions()...)
 	registerServer(s)
 	reflection.Register(s)
 	healthpb.RegisterHealthServer(s, b.health)
+	adminapi.RegisterAdminServer(s, &adminServer{b: b})
 	if b.metrics != nil {
 		grpc_prometheus.Register(s)
 		grpc_prometheus.EnableHandlingTimeHistogram()
 	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", b.config.ServerPort))
+	if err != nil {
+		b.Logger.Error("failed to listen", zap.Error(err))
+		return err
+	}
+
+	// register the gateway only once listening, so its connection to the server succeeds
+	if b.gateway, err = b.newGateway(); err != nil {
+		b.Logger.Error("failed to register gateway", zap.Error(err))
+		errors.MaybePanic(lis.Close())
+		return err
+	}
+	b.startTime = time.Now()
+	lis = b.serveGateway(lis)
+	b.grpcWeb = b.newGRPCWeb(s)
+	b.serveGRPCWeb()
 
 	// handle Stop signal
 	go func() {
@@ -123,17 +177,12 @@ func (b *BaseServer) Serve(registerServer func(s *grpc.Server), onServing func()
 		)
 
 		// set top-level health status
-		b.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		b.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
 
 		close(b.started)
 		onServing()
 	}()
 
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", b.config.ServerPort))
-	if err != nil {
-		b.Logger.Error("failed to listen", zap.Error(err))
-		return err
-	}
 	if err = s.Serve(lis); err != nil {
 		if strings.Contains(err.Error(), "use of closed network connection") {
 			return nil
@@ -144,6 +193,54 @@ func (b *BaseServer) Serve(registerServer func(s *grpc.Server), onServing func()
 	return nil
 }
 
+func (b *BaseServer) serverOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.StreamInterceptor(b.streamInterceptor()),
+		grpc.UnaryInterceptor(b.unaryInterceptor()),
+		grpc.MaxConcurrentStreams(b.config.MaxConcurrentStreams),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  b.config.KeepaliveTime,
+			Timeout:               b.config.KeepaliveTimeout,
+			MaxConnectionIdle:     b.config.MaxConnectionIdle,
+			MaxConnectionAge:      b.config.MaxConnectionAge,
+			MaxConnectionAgeGrace: b.config.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             b.config.KeepaliveMinTime,
+			PermitWithoutStream: b.config.KeepalivePermitWithoutStream,
+		}),
+		grpc.MaxRecvMsgSize(b.config.maxRecvMsgSize()),
+		grpc.MaxSendMsgSize(b.config.maxSendMsgSize()),
+	}
+	if b.config.Compression == CompressionGzip {
+		opts = append(opts,
+			grpc.RPCCompressor(grpc.NewGZIPCompressor()),
+			grpc.RPCDecompressor(grpc.NewGZIPDecompressor()),
+		)
+	}
+	return opts
+}
+
+func (b *BaseServer) unaryInterceptor() grpc.UnaryServerInterceptor {
+	if b.limiter == nil {
+		return grpc_prometheus.UnaryServerInterceptor
+	}
+	return chainUnaryInterceptors(
+		grpc_prometheus.UnaryServerInterceptor,
+		b.limiter.unaryInterceptor(),
+	)
+}
+
+func (b *BaseServer) streamInterceptor() grpc.StreamServerInterceptor {
+	if b.limiter == nil {
+		return grpc_prometheus.StreamServerInterceptor
+	}
+	return chainStreamInterceptors(
+		grpc_prometheus.StreamServerInterceptor,
+		b.limiter.streamInterceptor(),
+	)
+}
+
 func maybeClose(ch chan struct{}) {
 	select {
 	case <-ch: // already closed
@@ -197,20 +294,36 @@ func (b *BaseServer) StopServer() {
 
 	if b.metrics != nil {
 		// end metrics server
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		if err := b.metrics.Shutdown(ctx); err != nil {
-			if err == context.DeadlineExceeded {
-				errors.MaybePanic(b.metrics.Close())
-			}
-		}
-		cancel()
+		shutdown(b.metrics)
+	}
+	if b.gateway != nil {
+		// end gateway server and its connections to the main server
+		shutdown(b.gateway)
+		b.gatewayCancel()
+	}
+	if b.grpcWeb != nil {
+		// end gRPC-Web server
+		shutdown(b.grpcWeb)
 	}
 
 	// wait for server to Stop
 	<-b.stopped
+	if b.muxLis != nil {
+		_ = b.muxLis.Close()
+	}
 	b.Logger.Info("stopped server")
 }
 
+func shutdown(s *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	if err := s.Shutdown(ctx); err != nil {
+		if err == context.DeadlineExceeded {
+			errors.MaybePanic(s.Close())
+		}
+	}
+	cancel()
+}
+
 // State returns the state of the server. The state is a finite state machine, that progresses from
 // Starting -> Started -> Stopping -> Stopped.
 func (b *BaseServer) State() State {
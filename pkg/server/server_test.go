@@ -15,6 +15,9 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestBaseServer_Serve_ok(t *testing.T) {
@@ -45,6 +48,28 @@ func TestBaseServer_Serve_ok(t *testing.T) {
 	srv1.StopServer()
 }
 
+func TestBaseServer_Serve_zeroConfig(t *testing.T) {
+	c := &BaseConfig{ServerPort: 10120}
+	srv1 := &pingPong{BaseServer: NewBaseServer(c)}
+	registerFunc := func(s *grpc.Server) { test.RegisterPingPongServer(s, srv1) }
+
+	up := make(chan *pingPong, 1)
+	go func() {
+		err := srv1.Serve(registerFunc, func() { up <- srv1 })
+		assert.Nil(t, err)
+	}()
+	<-up
+
+	addrStr := fmt.Sprintf("localhost:%d", c.ServerPort)
+	cc, err := grpc.Dial(addrStr, grpc.WithInsecure())
+	assert.Nil(t, err)
+	rp, err := test.NewPingPongClient(cc).Ping(context.Background(), &test.PingRequest{})
+	assert.Nil(t, err)
+	assert.True(t, rp.Pong)
+
+	srv1.StopServer()
+}
+
 func TestBaseServer_Serve_forcefulStop(t *testing.T) {
 	c := NewDefaultBaseConfig()
 	c.Profile = false
@@ -88,6 +113,24 @@ func TestBaseServer_Serve_err(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestBaseServer_Serve_invalidConfig(t *testing.T) {
+	c := NewDefaultBaseConfig().WithCompression("snappy")
+	srv := &pingPong{BaseServer: NewBaseServer(c)}
+	registerFunc := func(s *grpc.Server) { test.RegisterPingPongServer(s, srv) }
+
+	err := srv.Serve(registerFunc, func() {})
+	assert.NotNil(t, err)
+}
+
+func TestBaseServer_serverOptions(t *testing.T) {
+	c := NewDefaultBaseConfig()
+	b := NewBaseServer(c)
+	nOpts := len(b.serverOptions())
+
+	c.WithCompression(CompressionGzip)
+	assert.Equal(t, nOpts+2, len(b.serverOptions()))
+}
+
 func TestBaseServer_startAuxRoutines(t *testing.T) {
 	c := &BaseConfig{
 		ServerPort:           10100,
@@ -137,6 +180,42 @@ func TestBaseServer_startAuxRoutines_noMetrics(t *testing.T) {
 	assert.Nil(t, resp)
 }
 
+func TestBaseServer_Serve_rateLimited(t *testing.T) {
+	c := NewDefaultBaseConfig().
+		WithServerPort(10110).
+		WithMetricsPort(10111).
+		WithMethodRateLimit("/test.PingPong/Ping", &RateLimit{Rate: 0.1, Burst: 1})
+	c.Profile = false
+	srv1 := &pingPong{BaseServer: NewBaseServer(c)}
+	registerFunc := func(s *grpc.Server) { test.RegisterPingPongServer(s, srv1) }
+
+	up := make(chan *pingPong, 1)
+	go func() {
+		err := srv1.Serve(registerFunc, func() { up <- srv1 })
+		assert.Nil(t, err)
+	}()
+	<-up
+
+	addrStr := fmt.Sprintf("localhost:%d", c.ServerPort)
+	cc, err := grpc.Dial(addrStr, grpc.WithInsecure())
+	assert.Nil(t, err)
+	cl := test.NewPingPongClient(cc)
+
+	rp, err := cl.Ping(context.Background(), &test.PingRequest{})
+	assert.Nil(t, err)
+	assert.True(t, rp.Pong)
+
+	trailer := metadata.MD{}
+	rp, err = cl.Ping(context.Background(), &test.PingRequest{}, grpc.Trailer(&trailer))
+	assert.Nil(t, rp)
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, []string{"10"}, trailer[RetryAfterKey])
+
+	srv1.StopServer()
+}
+
 func TestBaseServer_State(t *testing.T) {
 	s := NewBaseServer(&BaseConfig{})
 	assert.Equal(t, Starting, s.State())
@@ -165,3 +244,12 @@ func (p *pingPong) Ping(context.Context, *test.PingRequest) (*test.PingResponse,
 	}
 	return &test.PingResponse{Pong: true}, nil
 }
+
+func (p *pingPong) Pings(rq *test.PingsRequest, stream test.PingPong_PingsServer) error {
+	for i := uint32(0); i < rq.Count; i++ {
+		if err := stream.Send(&test.PingResponse{Pong: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
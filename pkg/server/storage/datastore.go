@@ -2,19 +2,8 @@ package storage
 
 import (
 	"context"
-	"log"
-	"os"
-	"os/exec"
-	"syscall"
 
 	"cloud.google.com/go/datastore"
-	"github.com/drausin/libri/libri/common/errors"
-)
-
-const (
-	datastoreEmulatorHostEnv = "DATASTORE_EMULATOR_HOST"
-	datastoreEmulatorAddr    = "localhost:2002"
-	dummyDatastoreProject    = "dummy-datastore-test"
 )
 
 // DatastoreClient is a interface wrapper for a *datastore.Client to facilitate mocking in tests.
@@ -24,11 +13,11 @@ type DatastoreClient interface {
 	Get(ctx context.Context, key *datastore.Key, dest interface{}) error
 	GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error
 	Delete(ctx context.Context, keys []*datastore.Key) error
-	Count(ctx context.Context, q *datastore.Query) (int, error)
-	Run(ctx context.Context, q *datastore.Query) *datastore.Iterator
+	Count(ctx context.Context, q *DatastoreQuery) (int, error)
+	Run(ctx context.Context, q *DatastoreQuery) DatastoreIterator
 }
 
-// DatastoreClientImpl implements DatastoreClient.
+// DatastoreClientImpl implements DatastoreClient and DatastoreTxClient.
 type DatastoreClientImpl struct {
 	Inner *datastore.Client
 }
@@ -67,20 +56,110 @@ func (c *DatastoreClientImpl) Delete(ctx context.Context, keys []*datastore.Key)
 }
 
 // Count wraps datastore.Client.Count(...)
-func (c *DatastoreClientImpl) Count(ctx context.Context, q *datastore.Query) (int, error) {
-	return c.Inner.Count(ctx, q)
+func (c *DatastoreClientImpl) Count(ctx context.Context, q *DatastoreQuery) (int, error) {
+	return c.Inner.Count(ctx, q.datastoreQuery())
 }
 
 // Run wraps datastore.Client.Run(...)
-func (c *DatastoreClientImpl) Run(ctx context.Context, q *datastore.Query) *datastore.Iterator {
-	return c.Inner.Run(ctx, q)
+func (c *DatastoreClientImpl) Run(ctx context.Context, q *DatastoreQuery) DatastoreIterator {
+	return &DatastoreIteratorImpl{inner: c.Inner.Run(ctx, q.datastoreQuery())}
+}
+
+// DatastoreQuery describes a DataStore query. Unlike a *datastore.Query, its content is visible, so
+// DatastoreClient implementations can either translate it for the DataStore service (see
+// DatastoreClientImpl) or run it themselves (see FakeDatastoreClient).
+type DatastoreQuery struct {
+	// Kind is the kind of the entities to query, or empty for all kinds.
+	Kind string
+
+	// Namespace is the namespace of the entities to query.
+	Namespace string
+
+	// Ancestor, if not nil, limits the results to the entities that are or descend from it.
+	Ancestor *datastore.Key
+
+	// Filters are the filters the results must all satisfy.
+	Filters []*DatastoreFilter
+
+	// Orders are the sort orders of the results, which are finally sorted by key.
+	Orders []*DatastoreOrder
+
+	// KeysOnly indicates whether only the keys of the results are returned.
+	KeysOnly bool
+
+	// Limit is the maximum number of results, or 0 for no limit.
+	Limit int
+
+	// Offset is the number of results to skip.
+	Offset int
+
+	// Start, if not empty, is the cursor the results start after.
+	Start datastore.Cursor
+
+	// End, if not empty, is the cursor the results end at.
+	End datastore.Cursor
+}
+
+// DatastoreFilter is a filter on a property of the entities in a DatastoreQuery.
+type DatastoreFilter struct {
+	// Field is the name of the filtered property, e.g., "Age" or "__key__".
+	Field string
+
+	// Op is the filter operator, one of "<", "<=", "=", ">=", and ">".
+	Op string
+
+	// Value is the value the property is compared with.
+	Value interface{}
+}
+
+// DatastoreOrder is a sort order on a property of the entities in a DatastoreQuery.
+type DatastoreOrder struct {
+	// Field is the name of the sorted property.
+	Field string
+
+	// Descending indicates whether the order is descending (or ascending).
+	Descending bool
+}
+
+// datastoreQuery returns the *datastore.Query described by the query.
+func (q *DatastoreQuery) datastoreQuery() *datastore.Query {
+	dsQ := datastore.NewQuery(q.Kind).Namespace(q.Namespace)
+	if q.Ancestor != nil {
+		dsQ = dsQ.Ancestor(q.Ancestor)
+	}
+	for _, f := range q.Filters {
+		dsQ = dsQ.Filter(f.Field+" "+f.Op, f.Value)
+	}
+	for _, o := range q.Orders {
+		if o.Descending {
+			dsQ = dsQ.Order("-" + o.Field)
+		} else {
+			dsQ = dsQ.Order(o.Field)
+		}
+	}
+	if q.KeysOnly {
+		dsQ = dsQ.KeysOnly()
+	}
+	if q.Limit > 0 {
+		dsQ = dsQ.Limit(q.Limit)
+	}
+	if q.Offset != 0 {
+		dsQ = dsQ.Offset(q.Offset)
+	}
+	if q.Start.String() != "" {
+		dsQ = dsQ.Start(q.Start)
+	}
+	if q.End.String() != "" {
+		dsQ = dsQ.End(q.End)
+	}
+	return dsQ
 }
 
 // DatastoreIterator is an interface wrapper for a *datastore.Iterator to facilitate mocking in
 // tests.
 type DatastoreIterator interface {
-	Init(iter *datastore.Iterator)
 	Next(dst interface{}) (*datastore.Key, error)
+	Cursor() (datastore.Cursor, error)
 }
 
 // DatastoreIteratorImpl implements DatastoreIterator.
@@ -88,41 +167,12 @@ type DatastoreIteratorImpl struct {
 	inner *datastore.Iterator
 }
 
-// Init initializes the iterator with the given one.
-func (i *DatastoreIteratorImpl) Init(iter *datastore.Iterator) {
-	i.inner = iter
-}
-
 // Next wraps datastore.Iterator.Next(...)
 func (i *DatastoreIteratorImpl) Next(dst interface{}) (*datastore.Key, error) {
 	return i.inner.Next(dst)
 }
 
-// StartDatastoreEmulator starts the DataStore emulator.
-func StartDatastoreEmulator(dataDir string) *os.Process {
-	// nolint: gas
-	cmd := exec.Command("gcloud", "beta", "emulators", "datastore", "start",
-		"--no-store-on-disk",
-		"--host-port", datastoreEmulatorAddr,
-		"--project", dummyDatastoreProject,
-		"--data-dir", dataDir,
-	)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Start()
-	errors.MaybePanic(err)
-	err = os.Setenv(datastoreEmulatorHostEnv, datastoreEmulatorAddr)
-	errors.MaybePanic(err)
-	return cmd.Process
-}
-
-// StopDatastoreEmulator stops the DataStore emulator.
-func StopDatastoreEmulator(process *os.Process) {
-	pgid, err := syscall.Getpgid(process.Pid)
-	errors.MaybePanic(err)
-	err = syscall.Kill(-pgid, syscall.SIGKILL)
-	errors.MaybePanic(err)
-	log.Printf("stopped child processes under pid %d\n", pgid)
+// Cursor wraps datastore.Iterator.Cursor()
+func (i *DatastoreIteratorImpl) Cursor() (datastore.Cursor, error) {
+	return i.inner.Cursor()
 }
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/cenkalti/backoff"
+	cerrors "github.com/drausin/libri/libri/common/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultDatastoreEmulatorProject is the default project ID of a Datastore emulator.
+	DefaultDatastoreEmulatorProject = "dummy-datastore-test"
+
+	// DefaultDatastoreEmulatorConsistency is the default fraction of eventually consistent
+	// queries a Datastore emulator applies all writes to. The default of 1 makes all queries
+	// strongly consistent, which tests usually expect.
+	DefaultDatastoreEmulatorConsistency = 1.0
+
+	// DefaultDatastoreEmulatorStartTimeout is the default maximum amount of time to wait for a
+	// Datastore emulator to become ready.
+	DefaultDatastoreEmulatorStartTimeout = time.Minute
+
+	datastoreEmulatorHostEnv      = "DATASTORE_EMULATOR_HOST"
+	datastoreProjectIDEnv         = "DATASTORE_PROJECT_ID"
+	datastoreEmulatorHost         = "127.0.0.1"
+	datastoreEmulatorDirPrefix    = "datastore-emulator-"
+	datastoreEmulatorLogFile      = "emulator.log"
+	datastoreEmulatorStopTimeout  = 10 * time.Second
+	datastoreEmulatorHTTPTimeout  = 5 * time.Second
+	legacyDatastoreEmulatorPort   = 2002
+	datastoreEmulatorLogTailLen   = 2048
+	datastoreEmulatorGcloudBinary = "gcloud"
+)
+
+var (
+	// ErrGcloudNotFound indicates when the gcloud binary, which runs the Datastore emulator, isn't
+	// on the PATH.
+	ErrGcloudNotFound = errors.New("gcloud not found on the PATH; install the Google Cloud SDK " +
+		"and its cloud-datastore-emulator component to run the Datastore emulator")
+
+	// ErrDatastoreEmulatorExited indicates when a Datastore emulator exits before becoming ready.
+	ErrDatastoreEmulatorExited = errors.New("Datastore emulator exited before becoming ready")
+
+	// legacy emulators started by StartDatastoreEmulator, by PID
+	legacyEmulators   = make(map[int]*DatastoreEmulator)
+	legacyEmulatorsMu sync.Mutex
+)
+
+// DatastoreEmulatorParameters defines how a Datastore emulator is launched.
+type DatastoreEmulatorParameters struct {
+	// Port is the port to listen on. If zero, a free port is picked.
+	Port int
+
+	// Project is the project ID of the emulated Datastore.
+	Project string
+
+	// Consistency is the fraction of eventually consistent queries to which all writes are
+	// applied.
+	Consistency float64
+
+	// DataDir is the directory for the emulator's configuration files. If empty, a temporary
+	// directory is used. Entities are only kept in memory.
+	DataDir string
+
+	// LogPath is the file to which the emulator's output is written. If empty, the output is
+	// written to a file in the emulator's temporary directory.
+	LogPath string
+
+	// StartTimeout is the maximum amount of time to wait for the emulator to become ready.
+	StartTimeout time.Duration
+}
+
+// NewDefaultDatastoreEmulatorParameters returns a *DatastoreEmulatorParameters object with default
+// values.
+func NewDefaultDatastoreEmulatorParameters() *DatastoreEmulatorParameters {
+	return &DatastoreEmulatorParameters{
+		Project:      DefaultDatastoreEmulatorProject,
+		Consistency:  DefaultDatastoreEmulatorConsistency,
+		StartTimeout: DefaultDatastoreEmulatorStartTimeout,
+	}
+}
+
+// DatastoreEmulator is a running Datastore emulator.
+type DatastoreEmulator struct {
+	// Addr is the host:port address of the emulator.
+	Addr string
+
+	// Project is the project ID of the emulated Datastore.
+	Project string
+
+	// LogPath is the file to which the emulator's output is written.
+	LogPath string
+
+	dir      string
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	http     *http.Client
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// StartTestDatastoreEmulator launches a Datastore emulator with the default parameters for the
+// test, which is skipped if gcloud isn't installed. The emulator is stopped when the test
+// completes.
+func StartTestDatastoreEmulator(t testing.TB) *DatastoreEmulator {
+	t.Helper()
+	e, err := LaunchDatastoreEmulator(NewDefaultDatastoreEmulatorParameters())
+	if err == ErrGcloudNotFound {
+		t.Skip("skipping Datastore emulator test: " + err.Error())
+	} else if err != nil {
+		t.Fatal("Datastore emulator start error: " + err.Error())
+	}
+	t.Cleanup(func() {
+		if err := e.Stop(); err != nil {
+			t.Error("Datastore emulator stop error: " + err.Error())
+		}
+	})
+	return e
+}
+
+// LaunchDatastoreEmulator starts a Datastore emulator with gcloud, waiting until it's ready. Unlike
+// StartDatastoreEmulator, it doesn't change the process environment; use NewClient or Env to
+// connect to it.
+func LaunchDatastoreEmulator(params *DatastoreEmulatorParameters) (*DatastoreEmulator, error) {
+	gcloud, err := exec.LookPath(datastoreEmulatorGcloudBinary)
+	if err != nil {
+		return nil, ErrGcloudNotFound
+	}
+	dir, err := ioutil.TempDir("", datastoreEmulatorDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	e := &DatastoreEmulator{
+		Project: params.Project,
+		LogPath: params.LogPath,
+		dir:     dir,
+		http:    &http.Client{Timeout: datastoreEmulatorHTTPTimeout},
+	}
+	if e.LogPath == "" {
+		e.LogPath = filepath.Join(dir, datastoreEmulatorLogFile)
+	}
+	if err = e.start(gcloud, params); err != nil {
+		_ = e.Stop()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Env returns the environment variables (in "key=value" form) with which the datastore package
+// and other Google Cloud libraries connect to the emulator, e.g., for a subprocess.
+func (e *DatastoreEmulator) Env() []string {
+	return []string{
+		datastoreEmulatorHostEnv + "=" + e.Addr,
+		datastoreProjectIDEnv + "=" + e.Project,
+	}
+}
+
+// NewClient returns a new *datastore.Client connected to the emulator.
+func (e *DatastoreEmulator) NewClient(ctx context.Context) (*datastore.Client, error) {
+	conn, err := grpc.Dial(e.Addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	return datastore.NewClient(ctx, e.Project, option.WithGRPCConn(conn))
+}
+
+// Reset deletes all the entities in the emulator, e.g., between tests.
+func (e *DatastoreEmulator) Reset() error {
+	return e.post("/reset")
+}
+
+// Stop shuts the emulator down, first asking it to and then terminating and finally killing its
+// processes if they don't exit in time, and removes its temporary directory. It is safe to call
+// more than once.
+func (e *DatastoreEmulator) Stop() error {
+	e.stopOnce.Do(func() {
+		e.stopErr = e.stop()
+	})
+	return e.stopErr
+}
+
+func (e *DatastoreEmulator) start(gcloud string, params *DatastoreEmulatorParameters) error {
+	dataDir := params.DataDir
+	if dataDir == "" {
+		dataDir = filepath.Join(e.dir, "data")
+	}
+	port := params.Port
+	if port == 0 {
+		var err error
+		if port, err = freePort(datastoreEmulatorHost); err != nil {
+			return err
+		}
+	}
+	e.Addr = net.JoinHostPort(datastoreEmulatorHost, strconv.Itoa(port))
+	logFile, err := os.Create(e.LogPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = logFile.Close() }()
+	e.cmd = exec.Command(gcloud, "beta", "emulators", "datastore", "start", // nolint: gas
+		"--quiet",
+		"--no-store-on-disk",
+		"--consistency", strconv.FormatFloat(params.Consistency, 'f', -1, 64),
+		"--host-port", e.Addr,
+		"--project", e.Project,
+		"--data-dir", dataDir,
+	)
+	// gcloud runs the emulator in a child process, so signal them together as a group
+	e.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	e.cmd.Stdout = logFile
+	e.cmd.Stderr = logFile
+	if err = e.cmd.Start(); err != nil {
+		return err
+	}
+	e.exited = make(chan struct{})
+	go func() {
+		_ = e.cmd.Wait()
+		close(e.exited)
+	}()
+
+	if err = e.waitReady(params.StartTimeout); err != nil {
+		return fmt.Errorf("%s; log tail:\n%s", err, e.logTail())
+	}
+	return nil
+}
+
+// waitReady requests the emulator's root endpoint until it responds, exits, or the timeout
+// elapses.
+func (e *DatastoreEmulator) waitReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	op := func() error {
+		select {
+		case <-e.exited:
+			return backoff.Permanent(ErrDatastoreEmulatorExited)
+		default:
+		}
+		req, err := http.NewRequest(http.MethodGet, "http://"+e.Addr+"/", nil)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		rp, err := e.http.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		_ = rp.Body.Close()
+		if rp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected Datastore emulator status: %s", rp.Status)
+		}
+		return nil
+	}
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 100 * time.Millisecond
+	bo.MaxInterval = time.Second
+	bo.MaxElapsedTime = timeout
+	return backoff.Retry(op, backoff.WithContext(bo, ctx))
+}
+
+func (e *DatastoreEmulator) post(path string) error {
+	rp, err := e.http.Post("http://"+e.Addr+path, "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	_ = rp.Body.Close()
+	if rp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected Datastore emulator %s status: %s", path, rp.Status)
+	}
+	return nil
+}
+
+func (e *DatastoreEmulator) stop() error {
+	if e.cmd != nil && e.cmd.Process != nil {
+		_ = e.post("/shutdown")
+		signals := []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}
+		for i := 0; !e.waitExited(datastoreEmulatorStopTimeout) && i < len(signals); i++ {
+			_ = syscall.Kill(-e.cmd.Process.Pid, signals[i])
+		}
+	}
+	return os.RemoveAll(e.dir)
+}
+
+// waitExited returns whether the emulator's gcloud process exits within the timeout.
+func (e *DatastoreEmulator) waitExited(timeout time.Duration) bool {
+	select {
+	case <-e.exited:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (e *DatastoreEmulator) logTail() string {
+	buf, err := ioutil.ReadFile(e.LogPath)
+	if err != nil {
+		return err.Error()
+	}
+	if len(buf) > datastoreEmulatorLogTailLen {
+		buf = buf[len(buf)-datastoreEmulatorLogTailLen:]
+	}
+	return string(buf)
+}
+
+// StartDatastoreEmulator starts the DataStore emulator on port 2002 and sets the
+// DATASTORE_EMULATOR_HOST environment variable to it, panicking on error.
+//
+// Deprecated: use LaunchDatastoreEmulator or StartTestDatastoreEmulator, which pick a free port,
+// don't change the environment, and return errors.
+func StartDatastoreEmulator(dataDir string) *os.Process {
+	params := NewDefaultDatastoreEmulatorParameters()
+	params.Port = legacyDatastoreEmulatorPort
+	params.DataDir = dataDir
+	e, err := LaunchDatastoreEmulator(params)
+	cerrors.MaybePanic(err)
+	err = os.Setenv(datastoreEmulatorHostEnv, e.Addr)
+	cerrors.MaybePanic(err)
+	legacyEmulatorsMu.Lock()
+	legacyEmulators[e.cmd.Process.Pid] = e
+	legacyEmulatorsMu.Unlock()
+	return e.cmd.Process
+}
+
+// StopDatastoreEmulator stops the DataStore emulator started by StartDatastoreEmulator, panicking
+// on error.
+//
+// Deprecated: use DatastoreEmulator.Stop.
+func StopDatastoreEmulator(process *os.Process) {
+	legacyEmulatorsMu.Lock()
+	e, in := legacyEmulators[process.Pid]
+	delete(legacyEmulators, process.Pid)
+	legacyEmulatorsMu.Unlock()
+	if !in {
+		cerrors.MaybePanic(syscall.Kill(-process.Pid, syscall.SIGKILL))
+		return
+	}
+	cerrors.MaybePanic(e.Stop())
+}
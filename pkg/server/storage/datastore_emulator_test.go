@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaunchDatastoreEmulator_err(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datastore-emulator-bin")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	defer func(path string) { assert.Nil(t, os.Setenv("PATH", path)) }(os.Getenv("PATH"))
+	assert.Nil(t, os.Setenv("PATH", dir))
+
+	_, err = LaunchDatastoreEmulator(NewDefaultDatastoreEmulatorParameters())
+	assert.Equal(t, ErrGcloudNotFound, err)
+
+	started := false
+	t.Run("skipped", func(t *testing.T) {
+		StartTestDatastoreEmulator(t)
+		started = true
+	})
+	assert.False(t, started)
+
+	// gcloud exits without starting the emulator
+	script := "#!/bin/sh\necho 'cloud-datastore-emulator not installed' >&2\nexit 1\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "gcloud"), []byte(script), 0700)
+	assert.Nil(t, err)
+	params := NewDefaultDatastoreEmulatorParameters()
+	params.LogPath = filepath.Join(dir, "emulator.log")
+	_, err = LaunchDatastoreEmulator(params)
+	assert.Contains(t, err.Error(), ErrDatastoreEmulatorExited.Error())
+	assert.Contains(t, err.Error(), "cloud-datastore-emulator not installed")
+	_, err = os.Stat(params.LogPath)
+	assert.Nil(t, err)
+}
+
+func TestDatastoreEmulator_Reset(t *testing.T) {
+	paths := make([]string, 0)
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	e := &DatastoreEmulator{
+		Addr:    srv.Listener.Addr().String(),
+		Project: DefaultDatastoreEmulatorProject,
+		http:    srv.Client(),
+	}
+
+	assert.Nil(t, e.Reset())
+	status = http.StatusInternalServerError
+	assert.NotNil(t, e.Reset())
+	assert.Equal(t, []string{"/reset", "/reset"}, paths)
+
+	assert.Equal(t, []string{
+		"DATASTORE_EMULATOR_HOST=" + e.Addr,
+		"DATASTORE_PROJECT_ID=" + DefaultDatastoreEmulatorProject,
+	}, e.Env())
+}
+
+func TestDatastoreEmulator(t *testing.T) {
+	e := StartTestDatastoreEmulator(t)
+	ctx := context.Background()
+	client, err := e.NewClient(ctx)
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, client.Close()) }()
+
+	key := datastore.NameKey("test", "a", nil)
+	_, err = client.Put(ctx, key, &datastore.PropertyList{{Name: "B", Value: "c"}})
+	assert.Nil(t, err)
+	props := datastore.PropertyList{}
+	assert.Nil(t, client.Get(ctx, key, &props))
+
+	assert.Nil(t, e.Reset())
+	err = client.Get(ctx, key, &datastore.PropertyList{})
+	assert.Equal(t, datastore.ErrNoSuchEntity, err)
+
+	assert.Nil(t, e.Stop())
+	assert.Nil(t, e.Stop())
+}
@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	typeOfPropertyList      = reflect.TypeOf(datastore.PropertyList(nil))
+	typeOfPropertyLoadSaver = reflect.TypeOf((*datastore.PropertyLoadSaver)(nil)).Elem()
+	typeOfKey               = reflect.TypeOf((*datastore.Key)(nil))
+)
+
+// kinds of GetMulti destination and PutMulti source slices
+const (
+	fakeMultiArgInvalid = iota
+	fakeMultiArgPropertyLoadSaver
+	fakeMultiArgStruct
+	fakeMultiArgStructPtr
+	fakeMultiArgInterface
+)
+
+// FakeDatastoreClient is an in-memory DatastoreTxClient for unit tests. It stores entities as the
+// Datastore service does (e.g., with integers widened to int64 and times truncated to the
+// microsecond) and runs queries with the same semantics, including ancestors, namespaces,
+// filters, sort orders, limits, offsets, cursors, and keys-only queries. Its cursors encode their
+// positions, so they remain valid as entities change. Incomplete keys are completed with
+// sequential IDs. Transactions are optimistic: committing one fails with
+// datastore.ErrConcurrentTransaction if another write to an entity it read or wrote was made after
+// it began. It is safe for concurrent use.
+type FakeDatastoreClient struct {
+	entities    map[string]*fakeEntity
+	versions    map[string]uint64
+	lastID      int64
+	lastVersion uint64
+	mu          sync.RWMutex
+}
+
+// NewFakeDatastoreClient creates a new, empty *FakeDatastoreClient.
+func NewFakeDatastoreClient() *FakeDatastoreClient {
+	return &FakeDatastoreClient{
+		entities: make(map[string]*fakeEntity),
+		versions: make(map[string]uint64),
+	}
+}
+
+// Get loads the entity stored for the key into dst, which must be a struct pointer or implement
+// datastore.PropertyLoadSaver, returning datastore.ErrNoSuchEntity if there is no such entity.
+func (c *FakeDatastoreClient) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	if dst == nil {
+		return datastore.ErrInvalidEntityType
+	}
+	err := c.GetMulti(ctx, []*datastore.Key{key}, []interface{}{dst})
+	if me, ok := err.(datastore.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// GetMulti is a batch version of Get, returning a datastore.MultiError if getting any of the keys
+// fails.
+func (c *FakeDatastoreClient) GetMulti(
+	ctx context.Context, keys []*datastore.Key, dst interface{},
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	argType := checkFakeMultiArg(v)
+	if argType == fakeMultiArgInvalid {
+		return errors.New("datastore: dst has invalid type")
+	}
+	if len(keys) != v.Len() {
+		return errors.New("datastore: keys and dst slices have different length")
+	}
+	errs, hasErr := make(datastore.MultiError, len(keys)), false
+	for i, key := range keys {
+		if !validFakeKey(key) || key.Incomplete() {
+			errs[i], hasErr = datastore.ErrInvalidKey, true
+		}
+	}
+	if hasErr {
+		return errs
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i, key := range keys {
+		e, in := c.entities[fakeKeyString(key)]
+		if !in {
+			errs[i], hasErr = datastore.ErrNoSuchEntity, true
+			continue
+		}
+		elem := v.Index(i)
+		if argType == fakeMultiArgPropertyLoadSaver || argType == fakeMultiArgStruct {
+			elem = elem.Addr()
+		}
+		if argType == fakeMultiArgStructPtr && elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		if err := loadFakeEntity(elem.Interface(), e); err != nil {
+			errs[i], hasErr = err, true
+		}
+	}
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+// Put saves the entity src, which must be a struct pointer or implement
+// datastore.PropertyLoadSaver, with the key. If the key is incomplete, the returned key is
+// completed with a new ID.
+func (c *FakeDatastoreClient) Put(
+	ctx context.Context, key *datastore.Key, src interface{},
+) (*datastore.Key, error) {
+	keys, err := c.PutMulti(ctx, []*datastore.Key{key}, []interface{}{src})
+	if me, ok := err.(datastore.MultiError); ok {
+		return nil, me[0]
+	} else if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// PutMulti is a batch version of Put. Either all or none of the entities are saved.
+func (c *FakeDatastoreClient) PutMulti(
+	ctx context.Context, keys []*datastore.Key, src interface{},
+) ([]*datastore.Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	props, err := saveFakeEntities(keys, src)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	completed := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		completed[i] = key
+		if stored := c.put(key, props[i]); key.Incomplete() {
+			completed[i] = stored
+		}
+	}
+	return completed, nil
+}
+
+// Delete deletes the entities for the keys. Deleting keys without entities is not an error.
+func (c *FakeDatastoreClient) Delete(ctx context.Context, keys []*datastore.Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkFakeDeletes(keys); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.delete(key)
+	}
+	return nil
+}
+
+// Count returns the number of results for the query.
+func (c *FakeDatastoreClient) Count(ctx context.Context, q *DatastoreQuery) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	fq, err := newFakeQuery(q)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	results, _ := fq.run(c.entities)
+	return len(results), nil
+}
+
+// Run runs the query, returning an iterator over the results as of when it was run. As with
+// datastore.Client.Run, any error running the query is returned by the iterator.
+func (c *FakeDatastoreClient) Run(ctx context.Context, q *DatastoreQuery) DatastoreIterator {
+	if err := ctx.Err(); err != nil {
+		return &FakeDatastoreIterator{err: err}
+	}
+	fq, err := newFakeQuery(q)
+	if err != nil {
+		return &FakeDatastoreIterator{err: err}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	results, base := fq.run(c.entities)
+	return &FakeDatastoreIterator{
+		results:  results,
+		base:     base,
+		keysOnly: fq.keysOnly,
+	}
+}
+
+// put stores the entity with a copy of the given key, completing it if necessary, and returns a
+// copy of the stored key. It must be called with the lock held.
+func (c *FakeDatastoreClient) put(key *datastore.Key, props []datastore.Property) *datastore.Key {
+	stored := copyFakeKey(key)
+	if stored.Incomplete() {
+		c.allocateID(stored)
+	}
+	ks := fakeKeyString(stored)
+	c.entities[ks] = &fakeEntity{key: stored, props: props}
+	c.lastVersion++
+	c.versions[ks] = c.lastVersion
+	return copyFakeKey(stored)
+}
+
+// delete removes the entity for the given key, if any. It must be called with the lock held.
+func (c *FakeDatastoreClient) delete(key *datastore.Key) {
+	ks := fakeKeyString(key)
+	delete(c.entities, ks)
+	c.lastVersion++
+	c.versions[ks] = c.lastVersion
+}
+
+// allocateID completes the given incomplete key with the next unused ID.
+func (c *FakeDatastoreClient) allocateID(key *datastore.Key) {
+	for {
+		c.lastID++
+		key.ID = c.lastID
+		if _, in := c.entities[fakeKeyString(key)]; !in {
+			return
+		}
+	}
+}
+
+// FakeDatastoreIterator is a DatastoreIterator over the results of a FakeDatastoreClient query.
+type FakeDatastoreIterator struct {
+	results  []*fakeResult
+	next     int
+	base     *fakePosition
+	keysOnly bool
+	err      error
+}
+
+// Next returns the key of the next result, loading it into dst unless the query is keys-only or
+// dst is nil. When there are no more results, it returns iterator.Done.
+func (i *FakeDatastoreIterator) Next(dst interface{}) (*datastore.Key, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	if i.next == len(i.results) {
+		return nil, iterator.Done
+	}
+	e := i.results[i.next].entity
+	i.next++
+	var err error
+	if !i.keysOnly && dst != nil {
+		err = loadFakeEntity(dst, e)
+	}
+	return copyFakeKey(e.key), err
+}
+
+// Cursor returns a cursor for the iterator's current position, i.e., after the last result
+// returned by Next.
+func (i *FakeDatastoreIterator) Cursor() (datastore.Cursor, error) {
+	if i.err != nil {
+		return datastore.Cursor{}, i.err
+	}
+	pos := i.base
+	if i.next > 0 {
+		pos = i.results[i.next-1].pos
+	}
+	return encodeFakeCursor(pos)
+}
+
+// checkFakeMultiArg returns the kind of the given GetMulti destination or PutMulti source slice,
+// which must be a []S, []*S, []I, or []P for some struct type S, interface type I, or
+// non-interface non-pointer type P such that P or *P implements datastore.PropertyLoadSaver.
+func checkFakeMultiArg(v reflect.Value) int {
+	if v.Kind() != reflect.Slice || v.Type() == typeOfPropertyList {
+		return fakeMultiArgInvalid
+	}
+	elemType := v.Type().Elem()
+	if reflect.PtrTo(elemType).Implements(typeOfPropertyLoadSaver) {
+		return fakeMultiArgPropertyLoadSaver
+	}
+	switch elemType.Kind() {
+	case reflect.Struct:
+		return fakeMultiArgStruct
+	case reflect.Interface:
+		return fakeMultiArgInterface
+	case reflect.Ptr:
+		if elemType.Elem().Kind() == reflect.Struct {
+			return fakeMultiArgStructPtr
+		}
+	}
+	return fakeMultiArgInvalid
+}
+
+// saveFakeEntities returns the normalized properties of the entities in the given PutMulti
+// source slice, checking that they and their keys are valid.
+func saveFakeEntities(keys []*datastore.Key, src interface{}) ([][]datastore.Property, error) {
+	v := reflect.ValueOf(src)
+	argType := checkFakeMultiArg(v)
+	if argType == fakeMultiArgInvalid {
+		return nil, errors.New("datastore: src has invalid type")
+	}
+	if len(keys) != v.Len() {
+		return nil, errors.New("datastore: key and src slices have different length")
+	}
+	errs, hasErr := make(datastore.MultiError, len(keys)), false
+	for i, key := range keys {
+		if !validFakeKey(key) {
+			errs[i], hasErr = datastore.ErrInvalidKey, true
+		}
+	}
+	if hasErr {
+		return nil, errs
+	}
+	props := make([][]datastore.Property, len(keys))
+	for i := range keys {
+		elem := v.Index(i)
+		if argType == fakeMultiArgPropertyLoadSaver || argType == fakeMultiArgStruct {
+			elem = elem.Addr()
+		}
+		var err error
+		if props[i], err = saveFakeEntity(elem.Interface()); err != nil {
+			errs[i], hasErr = err, true
+		}
+	}
+	if hasErr {
+		return nil, errs
+	}
+	return props, nil
+}
+
+// checkFakeDeletes checks that the given keys to delete are valid and complete.
+func checkFakeDeletes(keys []*datastore.Key) error {
+	for _, key := range keys {
+		if !validFakeKey(key) {
+			return datastore.ErrInvalidKey
+		}
+		if key.Incomplete() {
+			return fmt.Errorf("datastore: can't delete the incomplete key: %v", key)
+		}
+	}
+	return nil
+}
+
+// saveFakeEntity returns the normalized properties of the given struct pointer or
+// datastore.PropertyLoadSaver.
+func saveFakeEntity(src interface{}) ([]datastore.Property, error) {
+	var props []datastore.Property
+	var err error
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		props, err = pls.Save()
+	} else {
+		props, err = datastore.SaveStruct(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normalizeFakeProps(props)
+}
+
+// loadFakeEntity loads a copy of the given entity into the given struct pointer or
+// datastore.PropertyLoadSaver, including its key if dst is a datastore.KeyLoader or a struct
+// with a __key__ field.
+func loadFakeEntity(dst interface{}, e *fakeEntity) error {
+	// normalizing the already normalized properties deep copies them
+	props, err := normalizeFakeProps(e.props)
+	if err != nil {
+		return err
+	}
+	if pls, ok := dst.(datastore.PropertyLoadSaver); ok {
+		if err = pls.Load(props); err != nil {
+			return err
+		}
+		if kl, ok := dst.(datastore.KeyLoader); ok {
+			return kl.LoadKey(copyFakeKey(e.key))
+		}
+		return nil
+	}
+	if err = datastore.LoadStruct(dst, props); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("datastore"), ",")[0]
+		if f := v.Field(i); name == datastoreKeyField && f.Type() == typeOfKey && f.CanSet() {
+			f.Set(reflect.ValueOf(copyFakeKey(e.key)))
+		}
+	}
+	return nil
+}
+
+// validFakeKey returns whether the given key is valid as the Datastore service requires, i.e.,
+// with a kind and at most one of an ID or name, and complete ancestors in the same namespace.
+func validFakeKey(k *datastore.Key) bool {
+	if k == nil {
+		return false
+	}
+	for ; k != nil; k = k.Parent {
+		if k.Kind == "" || (k.Name != "" && k.ID != 0) {
+			return false
+		}
+		if k.Parent != nil && (k.Parent.Incomplete() || k.Parent.Namespace != k.Namespace) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeKeyString returns a string uniquely identifying the given complete key.
+func fakeKeyString(k *datastore.Key) string {
+	b := &strings.Builder{}
+	b.WriteString(strconv.Quote(k.Namespace))
+	for _, elem := range fakeKeyPath(k) {
+		b.WriteString("/" + strconv.Quote(elem.Kind) + ",")
+		if elem.Name != "" {
+			b.WriteString(strconv.Quote(elem.Name))
+		} else {
+			b.WriteString(strconv.FormatInt(elem.ID, 10))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,647 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/datastore"
+)
+
+// filter operators
+const (
+	fakeLessThan = iota + 1
+	fakeLessEq
+	fakeEqual
+	fakeGreaterEq
+	fakeGreaterThan
+)
+
+const (
+	maxIndexedValueLen = 1500
+	fakeCursorPrefix   = "fake-cursor:"
+)
+
+var (
+	// ErrInvalidFakeQuery indicates when a FakeDatastoreClient is given a query the Datastore
+	// service would reject, i.e., with inequality filters on more than one property, with an
+	// inequality filter on a property other than the first sort order's, with a non-key filter
+	// value for the key property, or with a negative offset.
+	ErrInvalidFakeQuery = errors.New("fake datastore: invalid query")
+
+	// ErrInvalidFakeCursor indicates when a FakeDatastoreClient is given a query with a cursor it
+	// didn't create or created for a query with different sort orders.
+	ErrInvalidFakeCursor = errors.New("fake datastore: invalid cursor")
+
+	fakeOps = map[string]int{
+		"<":  fakeLessThan,
+		"<=": fakeLessEq,
+		"=":  fakeEqual,
+		">=": fakeGreaterEq,
+		">":  fakeGreaterThan,
+	}
+)
+
+// fakeQuery is a DatastoreQuery with its filter values normalized and its cursors decoded.
+type fakeQuery struct {
+	kind      string
+	namespace string
+	ancestor  *datastore.Key
+	filters   []*fakeFilter
+	orders    []*DatastoreOrder
+	keysOnly  bool
+	limit     int
+	offset    int
+	start     *fakePosition
+	end       *fakePosition
+}
+
+type fakeFilter struct {
+	field string
+	op    int
+	value interface{}
+}
+
+// fakePosition is the position of an entity in the results of a query, i.e., its sort order
+// values and key. A position with a nil key is before all results.
+type fakePosition struct {
+	values []interface{}
+	key    *datastore.Key
+}
+
+// fakeCursor is the content of a FakeDatastoreClient cursor, i.e., its encoded position.
+type fakeCursor struct {
+	Values []*fakeCursorValue `json:"values,omitempty"`
+	Key    string             `json:"key,omitempty"`
+}
+
+// fakeCursorValue is an encoded sort order value of a fakeCursor, whose type is given by its rank
+// (see fakeValueRank).
+type fakeCursorValue struct {
+	Rank   int      `json:"rank"`
+	Int    int64    `json:"int,omitempty"`
+	Bytes  []byte   `json:"bytes,omitempty"`
+	Floats []uint64 `json:"floats,omitempty"`
+}
+
+type fakeEntity struct {
+	key   *datastore.Key
+	props []datastore.Property
+}
+
+type fakeResult struct {
+	entity *fakeEntity
+	pos    *fakePosition
+}
+
+// newFakeQuery returns the fakeQuery for the given query.
+func newFakeQuery(q *DatastoreQuery) (*fakeQuery, error) {
+	fq := &fakeQuery{
+		kind:      q.Kind,
+		namespace: q.Namespace,
+		ancestor:  q.Ancestor,
+		orders:    q.Orders,
+		keysOnly:  q.KeysOnly,
+		limit:     q.Limit,
+		offset:    q.Offset,
+	}
+	for _, f := range q.Filters {
+		op, in := fakeOps[f.Op]
+		if !in {
+			return nil, fmt.Errorf("datastore: invalid operator %q in filter", f.Op)
+		}
+		value, err := normalizeFakeValue(f.Value, false)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: %v in filter", err)
+		}
+		fq.filters = append(fq.filters, &fakeFilter{field: f.Field, op: op, value: value})
+	}
+	inequalityField, err := fq.validate()
+	if err != nil {
+		return nil, err
+	}
+	if inequalityField != "" && len(fq.orders) == 0 {
+		// results are implicitly sorted by the inequality filter property first
+		fq.orders = []*DatastoreOrder{{Field: inequalityField}}
+	}
+	fq.start = &fakePosition{}
+	if q.Start.String() != "" {
+		if fq.start, err = fq.decodeCursor(q.Start); err != nil {
+			return nil, err
+		}
+	}
+	if q.End.String() != "" {
+		if fq.end, err = fq.decodeCursor(q.End); err != nil {
+			return nil, err
+		}
+	}
+	return fq, nil
+}
+
+// validate checks the query for the same restrictions the Datastore service has, returning the
+// property of its inequality filters (if any).
+func (fq *fakeQuery) validate() (string, error) {
+	inequalityField := ""
+	for _, f := range fq.filters {
+		if f.field == datastoreKeyField {
+			if _, isKey := f.value.(*datastore.Key); !isKey {
+				return "", ErrInvalidFakeQuery
+			}
+		}
+		if f.op == fakeEqual {
+			continue
+		}
+		if inequalityField != "" && inequalityField != f.field {
+			return "", ErrInvalidFakeQuery
+		}
+		inequalityField = f.field
+	}
+	if inequalityField != "" && len(fq.orders) > 0 && fq.orders[0].Field != inequalityField {
+		return "", ErrInvalidFakeQuery
+	}
+	if fq.offset < 0 {
+		return "", ErrInvalidFakeQuery
+	}
+	return inequalityField, nil
+}
+
+// run returns the query's results from the given entities. It also returns the position of the
+// last result skipped by its offset or else its start position.
+func (fq *fakeQuery) run(entities map[string]*fakeEntity) ([]*fakeResult, *fakePosition) {
+	start, end := fq.start, fq.end
+	results := make([]*fakeResult, 0)
+	for _, e := range entities {
+		if pos, matches := fq.match(e); matches {
+			results = append(results, &fakeResult{entity: e, pos: pos})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return fq.compare(results[i].pos, results[j].pos) < 0
+	})
+	i := 0
+	for i < len(results) && fq.compare(results[i].pos, start) <= 0 {
+		i++
+	}
+	results = results[i:]
+	if end != nil {
+		i = 0
+		for i < len(results) && fq.compare(results[i].pos, end) <= 0 {
+			i++
+		}
+		results = results[:i]
+	}
+	base := start
+	if offset := fq.offset; offset > 0 {
+		if offset > len(results) {
+			offset = len(results)
+		}
+		if offset > 0 {
+			base = results[offset-1].pos
+		}
+		results = results[offset:]
+	}
+	if fq.limit > 0 && fq.limit < len(results) {
+		results = results[:fq.limit]
+	}
+	return results, base
+}
+
+// match returns the position of the given entity and whether it matches the query, i.e., has the
+// query's kind, namespace, and ancestor, satisfies its filters, and has indexed values for the
+// properties of its sort orders.
+func (fq *fakeQuery) match(e *fakeEntity) (*fakePosition, bool) {
+	if (fq.kind != "" && e.key.Kind != fq.kind) || e.key.Namespace != fq.namespace {
+		return nil, false
+	}
+	if fq.ancestor != nil && !hasFakeAncestor(e.key, fq.ancestor) {
+		return nil, false
+	}
+	for _, f := range fq.filters {
+		if !f.match(e) {
+			return nil, false
+		}
+	}
+	pos := &fakePosition{values: make([]interface{}, len(fq.orders)), key: e.key}
+	for i, o := range fq.orders {
+		values := fakeIndexedValues(e, o.Field)
+		if len(values) == 0 {
+			return nil, false
+		}
+		// multi-valued properties sort by their smallest value ascending and largest descending
+		pos.values[i] = values[0]
+		for _, value := range values[1:] {
+			c := compareFakeValues(value, pos.values[i])
+			if (c < 0 && !o.Descending) || (c > 0 && o.Descending) {
+				pos.values[i] = value
+			}
+		}
+	}
+	return pos, true
+}
+
+// compare returns the order of the given positions in the query's results.
+func (fq *fakeQuery) compare(a, b *fakePosition) int {
+	if a.key == nil || b.key == nil {
+		return compareBool(a.key != nil, b.key != nil)
+	}
+	for i, o := range fq.orders {
+		c := compareFakeValues(a.values[i], b.values[i])
+		if o.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareFakeKeys(a.key, b.key)
+}
+
+// encodeFakeCursor returns a cursor for the given position.
+func encodeFakeCursor(pos *fakePosition) (datastore.Cursor, error) {
+	fc := &fakeCursor{Values: make([]*fakeCursorValue, len(pos.values))}
+	if pos.key != nil {
+		fc.Key = pos.key.Encode()
+	}
+	for i, value := range pos.values {
+		fcv := &fakeCursorValue{Rank: fakeValueRank(value)}
+		switch v := value.(type) {
+		case int64:
+			fcv.Int = v
+		case time.Time:
+			fcv.Int = v.Unix()*int64(time.Second/time.Microsecond) +
+				int64(v.Nanosecond())/int64(time.Microsecond)
+		case bool:
+			if v {
+				fcv.Int = 1
+			}
+		case []byte:
+			fcv.Bytes = v
+		case string:
+			fcv.Bytes = []byte(v)
+		case float64:
+			fcv.Floats = []uint64{math.Float64bits(v)}
+		case datastore.GeoPoint:
+			fcv.Floats = []uint64{math.Float64bits(v.Lat), math.Float64bits(v.Lng)}
+		case *datastore.Key:
+			fcv.Bytes = []byte(v.Encode())
+		}
+		fc.Values[i] = fcv
+	}
+	cc, err := json.Marshal(fc)
+	if err != nil {
+		return datastore.Cursor{}, err
+	}
+	cc = append([]byte(fakeCursorPrefix), cc...)
+	return datastore.DecodeCursor(base64.URLEncoding.EncodeToString(cc))
+}
+
+// decodeCursor returns the position of the given cursor, which must have been created for a
+// query with the same number of sort orders.
+func (fq *fakeQuery) decodeCursor(c datastore.Cursor) (*fakePosition, error) {
+	cc, err := base64.RawURLEncoding.DecodeString(c.String())
+	if err != nil || !bytes.HasPrefix(cc, []byte(fakeCursorPrefix)) {
+		return nil, ErrInvalidFakeCursor
+	}
+	fc := &fakeCursor{}
+	if err = json.Unmarshal(cc[len(fakeCursorPrefix):], fc); err != nil {
+		return nil, ErrInvalidFakeCursor
+	}
+	pos := &fakePosition{values: make([]interface{}, len(fc.Values))}
+	if fc.Key == "" {
+		return pos, nil
+	}
+	if pos.key, err = datastore.DecodeKey(fc.Key); err != nil {
+		return nil, ErrInvalidFakeCursor
+	}
+	if len(fc.Values) != len(fq.orders) {
+		return nil, ErrInvalidFakeCursor
+	}
+	for i, fcv := range fc.Values {
+		if pos.values[i], err = decodeFakeCursorValue(fcv); err != nil {
+			return nil, err
+		}
+	}
+	return pos, nil
+}
+
+func decodeFakeCursorValue(fcv *fakeCursorValue) (interface{}, error) {
+	switch fcv.Rank {
+	case 0:
+		return nil, nil
+	case 1:
+		return fcv.Int, nil
+	case 2:
+		perSec := int64(time.Second / time.Microsecond)
+		return time.Unix(fcv.Int/perSec, fcv.Int%perSec*int64(time.Microsecond)), nil
+	case 3:
+		return fcv.Int == 1, nil
+	case 4:
+		return fcv.Bytes, nil
+	case 5:
+		return string(fcv.Bytes), nil
+	case 6:
+		if len(fcv.Floats) == 1 {
+			return math.Float64frombits(fcv.Floats[0]), nil
+		}
+	case 7:
+		if len(fcv.Floats) == 2 {
+			lat, lng := math.Float64frombits(fcv.Floats[0]), math.Float64frombits(fcv.Floats[1])
+			return datastore.GeoPoint{Lat: lat, Lng: lng}, nil
+		}
+	case 8:
+		if key, err := datastore.DecodeKey(string(fcv.Bytes)); err == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidFakeCursor
+}
+
+// match returns whether any of the entity's indexed values for the filter property satisfy it.
+func (f *fakeFilter) match(e *fakeEntity) bool {
+	for _, value := range fakeIndexedValues(e, f.field) {
+		c := compareFakeValues(value, f.value)
+		switch {
+		case f.op == fakeLessThan && c < 0,
+			f.op == fakeLessEq && c <= 0,
+			f.op == fakeEqual && c == 0,
+			f.op == fakeGreaterEq && c >= 0,
+			f.op == fakeGreaterThan && c > 0:
+			return true
+		}
+	}
+	return false
+}
+
+// fakeIndexedValues returns the indexed values of the entity property with the given name, which
+// may be the key property or a dotted path to a property of an embedded entity. The elements of
+// array values are returned individually.
+func fakeIndexedValues(e *fakeEntity, name string) []interface{} {
+	if name == datastoreKeyField {
+		return []interface{}{e.key}
+	}
+	return fakePropValues(e.props, name, nil)
+}
+
+func fakePropValues(props []datastore.Property, name string, values []interface{}) []interface{} {
+	for _, p := range props {
+		if p.Name == name && !p.NoIndex {
+			values = appendFakeValues(values, p.Value)
+		} else if strings.HasPrefix(name, p.Name+".") {
+			values = fakeEntityValues(p.Value, strings.TrimPrefix(name, p.Name+"."), values)
+		}
+	}
+	return values
+}
+
+func appendFakeValues(values []interface{}, value interface{}) []interface{} {
+	if array, ok := value.([]interface{}); ok {
+		return append(values, array...)
+	}
+	return append(values, value)
+}
+
+func fakeEntityValues(value interface{}, name string, values []interface{}) []interface{} {
+	switch v := value.(type) {
+	case *datastore.Entity:
+		return fakePropValues(v.Properties, name, values)
+	case []interface{}:
+		for _, elem := range v {
+			values = fakeEntityValues(elem, name, values)
+		}
+	}
+	return values
+}
+
+// fakeValueRank returns the rank of the given value's type in the Datastore value ordering.
+func fakeValueRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case int64:
+		return 1
+	case time.Time:
+		return 2
+	case bool:
+		return 3
+	case []byte:
+		return 4
+	case string:
+		return 5
+	case float64:
+		return 6
+	case datastore.GeoPoint:
+		return 7
+	case *datastore.Key:
+		return 8
+	}
+	return 9
+}
+
+// compareFakeValues compares the given (normalized) values, ordering values of different types by
+// type first as the Datastore service does.
+func compareFakeValues(a, b interface{}) int {
+	if ra, rb := fakeValueRank(a), fakeValueRank(b); ra != rb {
+		return compareInt64(int64(ra), int64(rb))
+	}
+	switch av := a.(type) {
+	case int64:
+		return compareInt64(av, b.(int64))
+	case time.Time:
+		bv := b.(time.Time)
+		return compareBool(av.After(bv), bv.After(av))
+	case bool:
+		return compareBool(av, b.(bool))
+	case []byte:
+		return bytes.Compare(av, b.([]byte))
+	case string:
+		return strings.Compare(av, b.(string))
+	case float64:
+		return compareFloat64(av, b.(float64))
+	case datastore.GeoPoint:
+		bv := b.(datastore.GeoPoint)
+		if c := compareFloat64(av.Lat, bv.Lat); c != 0 {
+			return c
+		}
+		return compareFloat64(av.Lng, bv.Lng)
+	case *datastore.Key:
+		return compareFakeKeys(av, b.(*datastore.Key))
+	}
+	return 0
+}
+
+// compareFakeKeys compares the given keys by namespace and then by path, where IDs come before
+// names.
+func compareFakeKeys(a, b *datastore.Key) int {
+	if c := strings.Compare(a.Namespace, b.Namespace); c != 0 {
+		return c
+	}
+	pa, pb := fakeKeyPath(a), fakeKeyPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if c := strings.Compare(pa[i].Kind, pb[i].Kind); c != 0 {
+			return c
+		}
+		if c := compareBool(pa[i].Name != "", pb[i].Name != ""); c != 0 {
+			return c
+		}
+		if c := compareInt64(pa[i].ID, pb[i].ID); c != 0 {
+			return c
+		}
+		if c := strings.Compare(pa[i].Name, pb[i].Name); c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(pa)), int64(len(pb)))
+}
+
+// fakeKeyPath returns the keys from the root ancestor of the given key to the key itself.
+func fakeKeyPath(k *datastore.Key) []*datastore.Key {
+	var path []*datastore.Key
+	for ; k != nil; k = k.Parent {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	return path
+}
+
+// hasFakeAncestor returns whether the given key is or descends from the given ancestor.
+func hasFakeAncestor(k, ancestor *datastore.Key) bool {
+	for ; k != nil; k = k.Parent {
+		if k.Equal(ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		// NaN sorts before all other numbers
+		return compareBool(!math.IsNaN(a), !math.IsNaN(b))
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case !a && b:
+		return -1
+	case a && !b:
+		return 1
+	}
+	return 0
+}
+
+// normalizeFakeProps returns a deep copy of the given properties with their values converted to
+// the types the Datastore service returns, or an error if the service would reject them.
+func normalizeFakeProps(props []datastore.Property) ([]datastore.Property, error) {
+	normalized := make([]datastore.Property, 0, len(props))
+	names := make(map[string]struct{}, len(props))
+	for _, p := range props {
+		if p.Name == datastoreKeyField {
+			continue
+		}
+		value, err := normalizeFakeValue(p.Value, p.NoIndex)
+		if err != nil {
+			return nil, fmt.Errorf("datastore: %v for a Property with Name %q", err, p.Name)
+		}
+		if _, in := names[p.Name]; in {
+			return nil, fmt.Errorf("datastore: duplicate Property with Name %q", p.Name)
+		}
+		names[p.Name] = struct{}{}
+		p.Value = value
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
+
+// normalizeFakeValue returns a deep copy of the given property value converted to the type the
+// Datastore service returns, e.g., with integers widened to int64 and times truncated to the
+// microsecond.
+func normalizeFakeValue(value interface{}, noIndex bool) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, int64, bool, float64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case string:
+		if len(v) > maxIndexedValueLen && !noIndex {
+			return nil, errors.New("string property too long to index")
+		}
+		if !utf8.ValidString(v) {
+			return nil, fmt.Errorf("string is not valid utf8: %q", v)
+		}
+		return v, nil
+	case []byte:
+		if len(v) > maxIndexedValueLen && !noIndex {
+			return nil, errors.New("[]byte property too long to index")
+		}
+		return copyBytes(v), nil
+	case time.Time:
+		if v.Year() < 1 || v.Year() > 9999 {
+			return nil, errors.New("time value out of range")
+		}
+		return time.Unix(v.Unix(), int64(v.Nanosecond())).Truncate(time.Microsecond), nil
+	case datastore.GeoPoint:
+		if !v.Valid() {
+			return nil, errors.New("invalid GeoPoint value")
+		}
+		return v, nil
+	case *datastore.Key:
+		if v == nil {
+			return nil, nil
+		}
+		return copyFakeKey(v), nil
+	case *datastore.Entity:
+		props, err := normalizeFakeProps(v.Properties)
+		if err != nil {
+			return nil, err
+		}
+		return &datastore.Entity{Key: copyFakeKey(v.Key), Properties: props}, nil
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, elem := range v {
+			normalized, err := normalizeFakeValue(elem, noIndex)
+			if err != nil {
+				return nil, fmt.Errorf("%v at index %d", err, i)
+			}
+			array[i] = normalized
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("invalid Value type %T", value)
+}
+
+// copyFakeKey returns a deep copy of the given key.
+func copyFakeKey(k *datastore.Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	c := *k
+	c.Parent = copyFakeKey(k.Parent)
+	return &c
+}
@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+const fakeTestKind = "person"
+
+type fakeTestPerson struct {
+	Key     *datastore.Key `datastore:"__key__"`
+	Name    string
+	Age     int
+	Tags    []string
+	Bio     string `datastore:",noindex"`
+	Created time.Time
+}
+
+func TestFakeDatastoreClient_putGet(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	created := time.Date(2018, 1, 2, 3, 4, 5, 6789, time.UTC)
+	src := &fakeTestPerson{Name: "alice", Age: 30, Tags: []string{"a"}, Created: created}
+
+	key, err := c.Put(ctx, datastore.NameKey(fakeTestKind, "alice", nil), src)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", key.Name)
+	src.Tags[0] = "b"
+
+	dst := &fakeTestPerson{}
+	err = c.Get(ctx, datastore.NameKey(fakeTestKind, "alice", nil), dst)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(dst.Key))
+	assert.Equal(t, "alice", dst.Name)
+	assert.Equal(t, 30, dst.Age)
+	assert.Equal(t, []string{"a"}, dst.Tags) // stored copy not affected by later changes
+	assert.True(t, created.Truncate(time.Microsecond).Equal(dst.Created))
+
+	// incomplete keys are completed with new IDs
+	key1, err := c.Put(ctx, datastore.IncompleteKey(fakeTestKind, nil), &fakeTestPerson{})
+	assert.Nil(t, err)
+	key2, err := c.Put(ctx, datastore.IncompleteKey(fakeTestKind, nil), &fakeTestPerson{})
+	assert.Nil(t, err)
+	assert.False(t, key1.Incomplete())
+	assert.NotEqual(t, key1.ID, key2.ID)
+
+	props := datastore.PropertyList{}
+	err = c.Get(ctx, key1, &props)
+	assert.Nil(t, err)
+	for _, p := range props {
+		if p.Name == "Age" {
+			assert.Equal(t, int64(0), p.Value)
+		}
+	}
+
+	err = c.Get(ctx, datastore.NameKey(fakeTestKind, "bob", nil), dst)
+	assert.Equal(t, datastore.ErrNoSuchEntity, err)
+	err = c.Get(ctx, datastore.IncompleteKey(fakeTestKind, nil), dst)
+	assert.Equal(t, datastore.ErrInvalidKey, err)
+	err = c.Get(ctx, key, nil)
+	assert.Equal(t, datastore.ErrInvalidEntityType, err)
+}
+
+func TestFakeDatastoreClient_putErr(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	key := datastore.NameKey(fakeTestKind, "alice", nil)
+
+	_, err := c.Put(ctx, &datastore.Key{Name: "alice"}, &fakeTestPerson{})
+	assert.Equal(t, datastore.ErrInvalidKey, err)
+
+	_, err = c.Put(ctx, key, fakeTestPerson{})
+	assert.Equal(t, datastore.ErrInvalidEntityType, err)
+
+	_, err = c.Put(ctx, key, &datastore.PropertyList{{Name: "A", Value: uint(1)}})
+	assert.NotNil(t, err)
+
+	_, err = c.PutMulti(ctx, []*datastore.Key{key}, []*fakeTestPerson{})
+	assert.NotNil(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.Put(cancelled, key, &fakeTestPerson{})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestFakeDatastoreClient_getMultiDelete(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	keys := []*datastore.Key{
+		datastore.NameKey(fakeTestKind, "alice", nil),
+		datastore.NameKey(fakeTestKind, "bob", nil),
+	}
+	src := []fakeTestPerson{{Name: "alice"}, {Name: "bob"}}
+	_, err := c.PutMulti(ctx, keys, src)
+	assert.Nil(t, err)
+
+	dst := make([]*fakeTestPerson, 2)
+	err = c.GetMulti(ctx, keys, dst)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", dst[0].Name)
+	assert.Equal(t, "bob", dst[1].Name)
+
+	err = c.Delete(ctx, keys[:1])
+	assert.Nil(t, err)
+	err = c.Delete(ctx, keys[:1]) // already deleted
+	assert.Nil(t, err)
+	err = c.Delete(ctx, []*datastore.Key{datastore.IncompleteKey(fakeTestKind, nil)})
+	assert.NotNil(t, err)
+
+	props := make([]datastore.PropertyList, 2)
+	err = c.GetMulti(ctx, keys, props)
+	assert.Equal(t, datastore.MultiError{datastore.ErrNoSuchEntity, nil}, err)
+	assert.NotEmpty(t, props[1])
+
+	err = c.GetMulti(ctx, keys, make([]datastore.PropertyList, 1))
+	assert.NotNil(t, err)
+	err = c.GetMulti(ctx, keys, datastore.PropertyList{})
+	assert.NotNil(t, err)
+}
+
+func TestFakeDatastoreClient_query(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	family := datastore.NameKey("family", "smith", nil)
+	otherNS := datastore.NameKey(fakeTestKind, "zed", nil)
+	otherNS.Namespace = "other"
+	people := map[*datastore.Key]*fakeTestPerson{
+		datastore.NameKey(fakeTestKind, "alice", nil): {
+			Name: "alice", Age: 30, Tags: []string{"a", "c"}, Bio: "alice",
+		},
+		datastore.NameKey(fakeTestKind, "bob", family): {
+			Name: "bob", Age: 25, Tags: []string{"b"},
+		},
+		datastore.NameKey(fakeTestKind, "carol", family): {Name: "carol", Age: 35},
+		datastore.NameKey(fakeTestKind, "dave", nil): {
+			Name: "dave", Age: 30, Tags: []string{"d"},
+		},
+		datastore.NameKey("pet", "rex", family): {Name: "rex", Age: 3},
+		otherNS:                                 {Name: "zed", Age: 40},
+	}
+	for key, p := range people {
+		_, err := c.Put(ctx, key, p)
+		assert.Nil(t, err)
+	}
+
+	age := func(op string, value interface{}) *DatastoreFilter {
+		return &DatastoreFilter{Field: "Age", Op: op, Value: value}
+	}
+	cases := map[string]struct {
+		q        *DatastoreQuery
+		expected []string
+	}{
+		"all": {
+			q:        &DatastoreQuery{Kind: fakeTestKind},
+			expected: []string{"bob", "carol", "alice", "dave"},
+		},
+		"equal": {
+			q: &DatastoreQuery{
+				Kind:    fakeTestKind,
+				Filters: []*DatastoreFilter{age("=", 30)},
+			},
+			expected: []string{"alice", "dave"},
+		},
+		"inequality": {
+			q: &DatastoreQuery{
+				Kind:    fakeTestKind,
+				Filters: []*DatastoreFilter{age(">", 25), age("<=", 35)},
+			},
+			expected: []string{"alice", "dave", "carol"},
+		},
+		"multi-valued": {
+			q: &DatastoreQuery{
+				Kind:    fakeTestKind,
+				Filters: []*DatastoreFilter{{Field: "Tags", Op: "=", Value: "c"}},
+			},
+			expected: []string{"alice"},
+		},
+		"unindexed": {
+			q: &DatastoreQuery{
+				Kind:    fakeTestKind,
+				Filters: []*DatastoreFilter{{Field: "Bio", Op: "=", Value: "alice"}},
+			},
+			expected: []string{},
+		},
+		"key": {
+			q: &DatastoreQuery{
+				Kind: fakeTestKind,
+				Filters: []*DatastoreFilter{{
+					Field: datastoreKeyField,
+					Op:    ">",
+					Value: datastore.NameKey(fakeTestKind, "bob", nil),
+				}},
+			},
+			expected: []string{"dave"},
+		},
+		"order": {
+			q: &DatastoreQuery{
+				Kind:   fakeTestKind,
+				Orders: []*DatastoreOrder{{Field: "Age", Descending: true}, {Field: "Name"}},
+			},
+			expected: []string{"carol", "alice", "dave", "bob"},
+		},
+		"order multi-valued": {
+			// missing values are excluded and multi-valued sort by their smallest
+			q: &DatastoreQuery{
+				Kind:   fakeTestKind,
+				Orders: []*DatastoreOrder{{Field: "Tags"}},
+			},
+			expected: []string{"alice", "bob", "dave"},
+		},
+		"ancestor": {
+			q:        &DatastoreQuery{Kind: fakeTestKind, Ancestor: family},
+			expected: []string{"bob", "carol"},
+		},
+		"kindless ancestor": {
+			q:        &DatastoreQuery{Ancestor: family},
+			expected: []string{"bob", "carol", "rex"},
+		},
+		"namespace": {
+			q:        &DatastoreQuery{Kind: fakeTestKind, Namespace: "other"},
+			expected: []string{"zed"},
+		},
+		"limit offset": {
+			q: &DatastoreQuery{
+				Kind:   fakeTestKind,
+				Orders: []*DatastoreOrder{{Field: "Name"}},
+				Offset: 1,
+				Limit:  2,
+			},
+			expected: []string{"bob", "carol"},
+		},
+		"offset past end": {
+			q:        &DatastoreQuery{Kind: fakeTestKind, Offset: 10},
+			expected: []string{},
+		},
+	}
+	for desc, c2 := range cases {
+		assert.Equal(t, c2.expected, fakeQueryNames(t, c, c2.q), desc)
+		n, err := c.Count(ctx, c2.q)
+		assert.Nil(t, err, desc)
+		assert.Equal(t, len(c2.expected), n, desc)
+	}
+
+	// keys-only queries don't load the results
+	iter := c.Run(ctx, &DatastoreQuery{
+		Kind:     fakeTestKind,
+		Orders:   []*DatastoreOrder{{Field: "Name"}},
+		KeysOnly: true,
+		Limit:    1,
+	})
+	dst := &fakeTestPerson{}
+	key, err := iter.Next(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", key.Name)
+	assert.Equal(t, "", dst.Name)
+
+	name := &DatastoreFilter{Field: "Name", Op: ">", Value: "a"}
+	errFilters := map[string][]*DatastoreFilter{
+		"multiple inequality": {age(">", 25), name},
+		"non-key value":       {{Field: datastoreKeyField, Op: "=", Value: "alice"}},
+		"bad op":              {age("==", 25)},
+		"bad value":           {age("=", uint(25))},
+	}
+	errQs := map[string]*DatastoreQuery{
+		"inequality order": {
+			Kind:    fakeTestKind,
+			Filters: []*DatastoreFilter{age(">", 25)},
+			Orders:  []*DatastoreOrder{{Field: "Name"}},
+		},
+		"negative offset": {Kind: fakeTestKind, Offset: -1},
+	}
+	for desc, filters := range errFilters {
+		errQs[desc] = &DatastoreQuery{Kind: fakeTestKind, Filters: filters}
+	}
+	for desc, errQ := range errQs {
+		key, err = c.Run(ctx, errQ).Next(dst)
+		assert.NotNil(t, err, desc)
+		assert.Nil(t, key, desc)
+		_, err = c.Count(ctx, errQ)
+		assert.NotNil(t, err, desc)
+	}
+}
+
+func TestFakeDatastoreClient_cursors(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		p := &fakeTestPerson{Name: name, Age: 10 * i}
+		_, err := c.Put(ctx, datastore.NameKey(fakeTestKind, name, nil), p)
+		assert.Nil(t, err)
+	}
+	byAge := []*DatastoreOrder{{Field: "Age", Descending: true}}
+
+	// page through two at a time
+	pages := make([][]string, 0)
+	cursor := datastore.Cursor{}
+	for {
+		iter := c.Run(ctx, &DatastoreQuery{
+			Kind:   fakeTestKind,
+			Orders: byAge,
+			Start:  cursor,
+			Limit:  2,
+		})
+		page := make([]string, 0)
+		for {
+			key, err := iter.Next(nil)
+			if err == iterator.Done {
+				break
+			}
+			assert.Nil(t, err)
+			page = append(page, key.Name)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+		var err error
+		cursor, err = iter.Cursor()
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}, pages)
+
+	// cursors are positions, so they're unaffected by later changes to other entities
+	iter := c.Run(ctx, &DatastoreQuery{Kind: fakeTestKind, Orders: byAge, Offset: 1})
+	start, err := iter.Cursor()
+	assert.Nil(t, err)
+	_, err = iter.Next(nil)
+	assert.Nil(t, err)
+	_, err = iter.Next(nil)
+	assert.Nil(t, err)
+	end, err := iter.Cursor()
+	assert.Nil(t, err)
+	err = c.Delete(ctx, []*datastore.Key{datastore.NameKey(fakeTestKind, "d", nil)})
+	assert.Nil(t, err)
+	f := &fakeTestPerson{Name: "f", Age: 25}
+	_, err = c.Put(ctx, datastore.NameKey(fakeTestKind, "f", nil), f)
+	assert.Nil(t, err)
+	q := &DatastoreQuery{Kind: fakeTestKind, Orders: byAge, Start: start, End: end}
+	assert.Equal(t, []string{"f", "c"}, fakeQueryNames(t, c, q))
+
+	// cursors hold their positions, so other clients can use them
+	c2 := NewFakeDatastoreClient()
+	_, err = c2.Put(ctx, datastore.NameKey(fakeTestKind, "c", nil), &fakeTestPerson{Name: "c"})
+	assert.Nil(t, err)
+	q = &DatastoreQuery{Kind: fakeTestKind, Orders: byAge, Start: start}
+	assert.Equal(t, []string{"c"}, fakeQueryNames(t, c2, q))
+
+	// cursors are only valid for queries with the same sort orders
+	q = &DatastoreQuery{Kind: fakeTestKind, Orders: append(byAge, &DatastoreOrder{Field: "Name"})}
+	q.Start = start
+	_, err = c.Run(ctx, q).Next(nil)
+	assert.Equal(t, ErrInvalidFakeCursor, err)
+	notFake, err := datastore.DecodeCursor("bm90LWZha2U")
+	assert.Nil(t, err)
+	_, err = c.Run(ctx, &DatastoreQuery{Kind: fakeTestKind, Start: notFake}).Next(nil)
+	assert.Equal(t, ErrInvalidFakeCursor, err)
+}
+
+func TestFakeCursor_values(t *testing.T) {
+	created := time.Date(1, 2, 3, 4, 5, 6, 7000, time.UTC)
+	pos := &fakePosition{
+		values: []interface{}{
+			nil,
+			int64(-3),
+			created,
+			true,
+			[]byte{1, 2},
+			"a",
+			math.NaN(),
+			datastore.GeoPoint{Lat: 1.5, Lng: -2.5},
+			datastore.NameKey(fakeTestKind, "a", datastore.IDKey("family", 1, nil)),
+		},
+		key: datastore.NameKey(fakeTestKind, "b", nil),
+	}
+	fq := &fakeQuery{orders: make([]*DatastoreOrder, len(pos.values))}
+	for i := range fq.orders {
+		fq.orders[i] = &DatastoreOrder{}
+	}
+	cursor, err := encodeFakeCursor(pos)
+	assert.Nil(t, err)
+	decoded, err := fq.decodeCursor(cursor)
+	assert.Nil(t, err)
+	assert.Zero(t, fq.compare(pos, decoded))
+	assert.True(t, created.Equal(decoded.values[2].(time.Time)))
+}
+
+func fakeQueryNames(t *testing.T, c *FakeDatastoreClient, q *DatastoreQuery) []string {
+	names := make([]string, 0)
+	iter := c.Run(context.Background(), q)
+	for {
+		p := &fakeTestPerson{}
+		key, err := iter.Next(p)
+		if err == iterator.Done {
+			return names
+		}
+		assert.Nil(t, err)
+		assert.True(t, key.Equal(p.Key))
+		names = append(names, p.Name)
+	}
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+)
+
+// DefaultFakeDatastoreMaxAttempts is the default number of times FakeDatastoreClient's
+// RunInTransaction attempts a transaction, matching datastore.Client.RunInTransaction.
+const DefaultFakeDatastoreMaxAttempts = 3
+
+// fakeWrite is a put or delete buffered by a FakeDatastoreTransaction until it commits.
+type fakeWrite struct {
+	key     *datastore.Key
+	props   []datastore.Property // nil for deletes
+	pending *DatastorePendingKey // nil for deletes
+}
+
+// NewTransaction starts a new FakeDatastoreTransaction. The options are ignored.
+func (c *FakeDatastoreClient) NewTransaction(
+	ctx context.Context, opts ...datastore.TransactionOption,
+) (DatastoreTransaction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.newTransaction(ctx), nil
+}
+
+// RunInTransaction runs f within a new transaction and commits it, retrying up to the
+// datastore.MaxAttempts option (DefaultFakeDatastoreMaxAttempts by default) times if committing
+// fails with datastore.ErrConcurrentTransaction. If f returns an error or panics, the
+// transaction is rolled back.
+func (c *FakeDatastoreClient) RunInTransaction(
+	ctx context.Context,
+	f func(tx DatastoreTransaction) error,
+	opts ...datastore.TransactionOption,
+) error {
+	for i := 0; i < fakeMaxAttempts(opts); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := runFakeTx(c.newTransaction(ctx), f); err != datastore.ErrConcurrentTransaction {
+			return err
+		}
+	}
+	return datastore.ErrConcurrentTransaction
+}
+
+// Mutate applies the mutations atomically within a transaction, returning the (complete) key of
+// each. If any mutation fails, none are applied, and a datastore.MultiError with the failure is
+// returned.
+func (c *FakeDatastoreClient) Mutate(
+	ctx context.Context, muts ...*DatastoreMutation,
+) ([]*datastore.Key, error) {
+	return mutateDatastore(ctx, c, muts)
+}
+
+func (c *FakeDatastoreClient) newTransaction(ctx context.Context) *FakeDatastoreTransaction {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return &FakeDatastoreTransaction{
+		client:   c,
+		ctx:      ctx,
+		start:    c.lastVersion,
+		accessed: make(map[string]struct{}),
+	}
+}
+
+// FakeDatastoreTransaction is an in-memory DatastoreTransaction for unit tests. Its reads see
+// the entities as of when they are made, and its writes are buffered until it commits.
+type FakeDatastoreTransaction struct {
+	client   *FakeDatastoreClient
+	ctx      context.Context
+	start    uint64
+	accessed map[string]struct{}
+	writes   []*fakeWrite
+	done     bool
+	mu       sync.Mutex
+}
+
+// Get loads the entity stored for the key into dst, as FakeDatastoreClient.Get does.
+func (t *FakeDatastoreTransaction) Get(key *datastore.Key, dst interface{}) error {
+	if dst == nil {
+		return datastore.ErrInvalidEntityType
+	}
+	err := t.GetMulti([]*datastore.Key{key}, []interface{}{dst})
+	if me, ok := err.(datastore.MultiError); ok {
+		return me[0]
+	}
+	return err
+}
+
+// GetMulti is a batch version of Get.
+func (t *FakeDatastoreTransaction) GetMulti(keys []*datastore.Key, dst interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxnDone
+	}
+	for _, key := range keys {
+		if validFakeKey(key) && !key.Incomplete() {
+			t.accessed[fakeKeyString(key)] = struct{}{}
+		}
+	}
+	return t.client.GetMulti(t.ctx, keys, dst)
+}
+
+// Put buffers saving the entity src with the key until the transaction commits.
+func (t *FakeDatastoreTransaction) Put(
+	key *datastore.Key, src interface{},
+) (*DatastorePendingKey, error) {
+	pks, err := t.PutMulti([]*datastore.Key{key}, []interface{}{src})
+	if me, ok := err.(datastore.MultiError); ok {
+		return nil, me[0]
+	} else if err != nil {
+		return nil, err
+	}
+	return pks[0], nil
+}
+
+// PutMulti is a batch version of Put.
+func (t *FakeDatastoreTransaction) PutMulti(
+	keys []*datastore.Key, src interface{},
+) ([]*DatastorePendingKey, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	props, err := saveFakeEntities(keys, src)
+	if err != nil {
+		return nil, err
+	}
+	pks := make([]*DatastorePendingKey, len(keys))
+	for i, key := range keys {
+		pks[i] = &DatastorePendingKey{}
+		t.writes = append(t.writes, &fakeWrite{
+			key:     copyFakeKey(key),
+			props:   props[i],
+			pending: pks[i],
+		})
+	}
+	return pks, nil
+}
+
+// Delete buffers deleting the entities for the keys until the transaction commits.
+func (t *FakeDatastoreTransaction) Delete(keys []*datastore.Key) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := checkFakeDeletes(keys); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		t.writes = append(t.writes, &fakeWrite{key: copyFakeKey(key)})
+	}
+	return nil
+}
+
+// Mutate applies the mutations within the transaction, returning the pending key of each put.
+func (t *FakeDatastoreTransaction) Mutate(
+	muts ...*DatastoreMutation,
+) ([]*DatastorePendingKey, error) {
+	return mutateInTx(t, muts)
+}
+
+// Commit applies the transaction's writes, completing its pending keys. It fails with
+// datastore.ErrConcurrentTransaction, applying none of them, if an entity read or written by the
+// transaction has been written since it began.
+func (t *FakeDatastoreTransaction) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+
+	c := t.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ks := range t.accessed {
+		if c.versions[ks] > t.start {
+			return datastore.ErrConcurrentTransaction
+		}
+	}
+	for _, w := range t.writes {
+		if !w.key.Incomplete() && c.versions[fakeKeyString(w.key)] > t.start {
+			return datastore.ErrConcurrentTransaction
+		}
+	}
+	for _, w := range t.writes {
+		if w.pending == nil {
+			c.delete(w.key)
+			continue
+		}
+		w.pending.key = c.put(w.key, w.props)
+	}
+	return nil
+}
+
+// Rollback abandons the transaction's writes.
+func (t *FakeDatastoreTransaction) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+	return nil
+}
+
+// runFakeTx runs f within the transaction and commits it, rolling it back if f returns an error
+// or panics.
+func runFakeTx(tx *FakeDatastoreTransaction, f func(tx DatastoreTransaction) error) error {
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	if err := f(tx); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit()
+}
+
+// fakeMaxAttempts returns the number of attempts given by a datastore.MaxAttempts option, whose
+// type is unexported, or DefaultFakeDatastoreMaxAttempts if there is none.
+func fakeMaxAttempts(opts []datastore.TransactionOption) int {
+	attempts := DefaultFakeDatastoreMaxAttempts
+	maxAttemptsType := reflect.TypeOf(datastore.MaxAttempts(1))
+	for _, opt := range opts {
+		if v := reflect.ValueOf(opt); v.Type() == maxAttemptsType && v.Int() > 0 {
+			attempts = int(v.Int())
+		}
+	}
+	return attempts
+}
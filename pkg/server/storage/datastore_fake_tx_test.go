@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeDatastoreClient_RunInTransaction(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	key := datastore.NameKey(fakeTestKind, "alice", nil)
+	_, err := c.Put(ctx, key, &fakeTestPerson{Name: "alice", Age: 30})
+	assert.Nil(t, err)
+
+	// writes are applied on commit, completing pending keys
+	var pk *DatastorePendingKey
+	err = c.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		p := &fakeTestPerson{}
+		if err2 := tx.Get(key, p); err2 != nil {
+			return err2
+		}
+		p.Age++
+		if _, err2 := tx.Put(key, p); err2 != nil {
+			return err2
+		}
+		var err2 error
+		pk, err2 = tx.Put(datastore.IncompleteKey(fakeTestKind, nil), &fakeTestPerson{})
+		assert.Nil(t, pk.Key())
+		return err2
+	})
+	assert.Nil(t, err)
+	assert.False(t, pk.Key().Incomplete())
+	p := &fakeTestPerson{}
+	assert.Nil(t, c.Get(ctx, key, p))
+	assert.Equal(t, 31, p.Age)
+	assert.Nil(t, c.Get(ctx, pk.Key(), &fakeTestPerson{}))
+
+	// writes are discarded on error or panic
+	fErr := errors.New("some error")
+	err = c.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		assert.Nil(t, tx.Delete([]*datastore.Key{key}))
+		return fErr
+	})
+	assert.Equal(t, fErr, err)
+	assert.Panics(t, func() {
+		_ = c.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+			assert.Nil(t, tx.Delete([]*datastore.Key{key}))
+			panic("some panic")
+		})
+	})
+	assert.Nil(t, c.Get(ctx, key, p))
+
+	// conflicting transactions are retried up to the max attempts
+	attempts := 0
+	err = c.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		attempts++
+		assert.Nil(t, tx.Get(key, p))
+		_, err2 := c.Put(ctx, key, p) // concurrent write
+		assert.Nil(t, err2)
+		return nil
+	}, datastore.MaxAttempts(2))
+	assert.Equal(t, datastore.ErrConcurrentTransaction, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestFakeDatastoreTransaction(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	key1 := datastore.NameKey(fakeTestKind, "alice", nil)
+	key2 := datastore.NameKey(fakeTestKind, "bob", nil)
+
+	tx1, err := c.NewTransaction(ctx)
+	assert.Nil(t, err)
+	tx2, err := c.NewTransaction(ctx)
+	assert.Nil(t, err)
+
+	// reads don't see the transaction's own writes
+	_, err = tx1.Put(key1, &fakeTestPerson{Name: "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, datastore.ErrNoSuchEntity, tx1.Get(key1, &fakeTestPerson{}))
+
+	// blind writes to different entities don't conflict
+	_, err = tx2.Put(key2, &fakeTestPerson{Name: "bob"})
+	assert.Nil(t, err)
+	assert.Nil(t, tx2.Commit())
+	assert.Nil(t, tx1.Commit())
+	props := make([]datastore.PropertyList, 2)
+	assert.Nil(t, c.GetMulti(ctx, []*datastore.Key{key1, key2}, props))
+
+	// writes to entities written since the transaction began conflict
+	tx3, err := c.NewTransaction(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, tx3.Delete([]*datastore.Key{key1}))
+	_, err = c.Put(ctx, key1, &fakeTestPerson{Name: "alice2"})
+	assert.Nil(t, err)
+	assert.Equal(t, datastore.ErrConcurrentTransaction, tx3.Commit())
+	assert.Nil(t, c.Get(ctx, key1, &fakeTestPerson{}))
+
+	// finished transactions can't be used
+	assert.Equal(t, ErrTxnDone, tx3.Commit())
+	assert.Equal(t, ErrTxnDone, tx3.Rollback())
+	assert.Equal(t, ErrTxnDone, tx3.Get(key1, &fakeTestPerson{}))
+	_, err = tx3.Put(key1, &fakeTestPerson{})
+	assert.Equal(t, ErrTxnDone, err)
+
+	tx4, err := c.NewTransaction(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, tx4.Delete([]*datastore.Key{key1}))
+	assert.Nil(t, tx4.Rollback())
+	assert.Equal(t, ErrTxnDone, tx4.Delete([]*datastore.Key{key1}))
+	assert.Nil(t, c.Get(ctx, key1, &fakeTestPerson{}))
+}
+
+func TestFakeDatastoreClient_Mutate(t *testing.T) {
+	ctx := context.Background()
+	c := NewFakeDatastoreClient()
+	key1 := datastore.NameKey(fakeTestKind, "alice", nil)
+	key2 := datastore.NameKey(fakeTestKind, "bob", nil)
+
+	keys, err := c.Mutate(ctx,
+		NewDatastoreInsert(key1, &fakeTestPerson{Name: "alice"}),
+		NewDatastoreInsert(datastore.IncompleteKey(fakeTestKind, nil), &fakeTestPerson{}),
+		NewDatastoreUpsert(key2, &fakeTestPerson{Name: "bob"}),
+	)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 3)
+	assert.True(t, key1.Equal(keys[0]))
+	assert.False(t, keys[1].Incomplete())
+	assert.True(t, key2.Equal(keys[2]))
+
+	// failed mutations apply none of them
+	_, err = c.Mutate(ctx,
+		NewDatastoreDelete(key2),
+		NewDatastoreInsert(key1, &fakeTestPerson{Name: "alice2"}),
+		NewDatastoreUpdate(keys[1], &fakeTestPerson{Name: "carol"}),
+		NewDatastoreUpdate(datastore.NameKey(fakeTestKind, "dave", nil), &fakeTestPerson{}),
+	)
+	assert.Equal(t, datastore.MultiError{
+		nil, ErrDatastoreEntityExists, nil, datastore.ErrNoSuchEntity,
+	}, err)
+	p := &fakeTestPerson{}
+	assert.Nil(t, c.Get(ctx, key2, p))
+	assert.Nil(t, c.Get(ctx, keys[1], p))
+	assert.Equal(t, "", p.Name)
+
+	keys2, err := c.Mutate(ctx,
+		NewDatastoreDelete(key2),
+		NewDatastoreUpdate(keys[1], &fakeTestPerson{Name: "carol"}),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []*datastore.Key{key2, keys[1]}, keys2)
+	assert.Equal(t, datastore.ErrNoSuchEntity, c.Get(ctx, key2, p))
+	assert.Nil(t, c.Get(ctx, keys[1], p))
+	assert.Equal(t, "carol", p.Name)
+}
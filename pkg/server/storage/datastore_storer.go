@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+const datastoreKeyField = "__key__"
+
+type datastoreStorer struct {
+	client DatastoreClient
+	kind   string
+	p      *Paginator
+}
+
+// NewDatastoreStorer returns a Storer backed by the entities of the given kind in DataStore. Query
+// page tokens, which wrap DataStore cursors, are signed and verified by the Paginator.
+func NewDatastoreStorer(client DatastoreClient, kind string, p *Paginator) Storer {
+	return &datastoreStorer{client: client, kind: kind, p: p}
+}
+
+func (s *datastoreStorer) Put(ctx context.Context, r *Record) error {
+	normalized, err := normalizeRecord(r)
+	if err != nil {
+		return err
+	}
+	props := make(datastore.PropertyList, 0, len(normalized.Fields))
+	for name, value := range normalized.Fields {
+		props = append(props, datastore.Property{Name: name, Value: value})
+	}
+	_, err = s.client.Put(ctx, s.key(r.Key), &props)
+	return err
+}
+
+func (s *datastoreStorer) Get(ctx context.Context, key string) (*Record, error) {
+	props := datastore.PropertyList{}
+	if err := s.client.Get(ctx, s.key(key), &props); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return newDatastoreRecord(key, props), nil
+}
+
+func (s *datastoreStorer) GetMulti(ctx context.Context, keys []string) ([]*Record, error) {
+	dsKeys := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		dsKeys[i] = s.key(key)
+	}
+	props := make([]datastore.PropertyList, len(keys))
+	err := s.client.GetMulti(ctx, dsKeys, props)
+	errs, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		return nil, err
+	}
+	rs := make([]*Record, len(keys))
+	for i, key := range keys {
+		if isMultiErr && errs[i] == datastore.ErrNoSuchEntity {
+			continue
+		} else if isMultiErr && errs[i] != nil {
+			return nil, errs[i]
+		}
+		rs[i] = newDatastoreRecord(key, props[i])
+	}
+	return rs, nil
+}
+
+func (s *datastoreStorer) Delete(ctx context.Context, keys ...string) error {
+	dsKeys := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		dsKeys[i] = s.key(key)
+	}
+	return s.client.Delete(ctx, dsKeys)
+}
+
+func (s *datastoreStorer) Query(ctx context.Context, q *Query) (*QueryResult, error) {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	dsQ := &DatastoreQuery{Kind: s.kind}
+	for _, f := range q.Filters {
+		dsQ.Filters = append(dsQ.Filters, &DatastoreFilter{
+			Field: f.Field,
+			Op:    f.Op.String(),
+			Value: f.Value,
+		})
+	}
+	descending := q.Order != nil && q.Order.Descending
+	if q.Order != nil {
+		dsQ.Orders = append(dsQ.Orders,
+			&DatastoreOrder{Field: q.Order.Field, Descending: descending})
+	}
+	dsQ.Orders = append(dsQ.Orders,
+		&DatastoreOrder{Field: datastoreKeyField, Descending: descending})
+	scope := storerPageScope("datastore:"+s.kind, q)
+	if q.PageToken != "" {
+		pt, err2 := s.p.decode(q.PageToken, scope)
+		if err2 != nil {
+			return nil, err2
+		}
+		if dsQ.Start, err2 = datastore.DecodeCursor(pt.Cursor); err2 != nil {
+			return nil, ErrInvalidPageToken
+		}
+	}
+	if q.Limit > 0 {
+		// fetch one extra to determine whether there is a next page
+		dsQ.Limit = int(q.Limit) + 1
+	}
+
+	result := &QueryResult{Records: make([]*Record, 0)}
+	iter := s.client.Run(ctx, dsQ)
+	var lastCursor datastore.Cursor
+	for {
+		props := datastore.PropertyList{}
+		key, err := iter.Next(&props)
+		if err == iterator.Done {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if q.Limit > 0 && uint(len(result.Records)) == q.Limit {
+			// extra result exists, so there is a next page
+			next := &pageToken{Scope: scope, Cursor: lastCursor.String()}
+			if result.NextPageToken, err = s.p.encode(next); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		result.Records = append(result.Records, newDatastoreRecord(key.Name, props))
+		if q.Limit > 0 && uint(len(result.Records)) == q.Limit {
+			if lastCursor, err = iter.Cursor(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *datastoreStorer) key(key string) *datastore.Key {
+	return datastore.NameKey(s.kind, key, nil)
+}
+
+func newDatastoreRecord(key string, props datastore.PropertyList) *Record {
+	r := &Record{Key: key, Fields: make(map[string]interface{}, len(props))}
+	for _, prop := range props {
+		if value, err := normalizeValue(prop.Value); err == nil {
+			r.Fields[prop.Name] = value
+		}
+	}
+	return r
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/datastore"
+)
+
+// kinds of DatastoreMutations
+const (
+	datastoreInsert = iota + 1
+	datastoreUpdate
+	datastoreUpsert
+	datastoreDelete
+)
+
+// ErrDatastoreEntityExists indicates when inserting an entity with a key that already has one.
+var ErrDatastoreEntityExists = errors.New("datastore: entity already exists")
+
+// DatastoreTxClient is a DatastoreClient that also runs transactions.
+type DatastoreTxClient interface {
+	DatastoreClient
+	NewTransaction(
+		ctx context.Context, opts ...datastore.TransactionOption,
+	) (DatastoreTransaction, error)
+	RunInTransaction(
+		ctx context.Context,
+		f func(tx DatastoreTransaction) error,
+		opts ...datastore.TransactionOption,
+	) error
+	Mutate(ctx context.Context, muts ...*DatastoreMutation) ([]*datastore.Key, error)
+}
+
+// DatastoreTransaction is an interface wrapper for a *datastore.Transaction to facilitate mocking
+// in tests. Reads within a transaction don't see its own writes, which are applied when it
+// commits.
+type DatastoreTransaction interface {
+	Get(key *datastore.Key, dst interface{}) error
+	GetMulti(keys []*datastore.Key, dst interface{}) error
+	Put(key *datastore.Key, src interface{}) (*DatastorePendingKey, error)
+	PutMulti(keys []*datastore.Key, src interface{}) ([]*DatastorePendingKey, error)
+	Delete(keys []*datastore.Key) error
+	Mutate(muts ...*DatastoreMutation) ([]*DatastorePendingKey, error)
+	Commit() error
+	Rollback() error
+}
+
+// DatastorePendingKey is the key of an entity put within a transaction, which is complete once the
+// transaction commits.
+type DatastorePendingKey struct {
+	key     *datastore.Key
+	pending *datastore.PendingKey
+	commit  *datastore.Commit
+}
+
+// Key returns the complete key once the transaction has committed and nil before.
+func (k *DatastorePendingKey) Key() *datastore.Key {
+	if k.commit != nil {
+		return k.commit.Key(k.pending)
+	}
+	return k.key
+}
+
+// DatastoreMutation is an insert, update, upsert, or delete of an entity, applied atomically with
+// others via Mutate.
+type DatastoreMutation struct {
+	op  int
+	key *datastore.Key
+	src interface{}
+}
+
+// NewDatastoreInsert returns a mutation saving the entity src with the key, which may be
+// incomplete. Applying it fails with ErrDatastoreEntityExists if the key already has an entity.
+func NewDatastoreInsert(key *datastore.Key, src interface{}) *DatastoreMutation {
+	return &DatastoreMutation{op: datastoreInsert, key: key, src: src}
+}
+
+// NewDatastoreUpdate returns a mutation replacing the entity for the key with src. Applying it
+// fails with datastore.ErrNoSuchEntity if the key has no entity.
+func NewDatastoreUpdate(key *datastore.Key, src interface{}) *DatastoreMutation {
+	return &DatastoreMutation{op: datastoreUpdate, key: key, src: src}
+}
+
+// NewDatastoreUpsert returns a mutation saving the entity src with the key, as Put does.
+func NewDatastoreUpsert(key *datastore.Key, src interface{}) *DatastoreMutation {
+	return &DatastoreMutation{op: datastoreUpsert, key: key, src: src}
+}
+
+// NewDatastoreDelete returns a mutation deleting the entity for the key, if any.
+func NewDatastoreDelete(key *datastore.Key) *DatastoreMutation {
+	return &DatastoreMutation{op: datastoreDelete, key: key}
+}
+
+// NewTransaction wraps datastore.Client.NewTransaction(...)
+func (c *DatastoreClientImpl) NewTransaction(
+	ctx context.Context, opts ...datastore.TransactionOption,
+) (DatastoreTransaction, error) {
+	tx, err := c.Inner.NewTransaction(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &DatastoreTransactionImpl{Inner: tx}, nil
+}
+
+// RunInTransaction wraps datastore.Client.RunInTransaction(...), completing the pending keys of
+// the committed transaction.
+func (c *DatastoreClientImpl) RunInTransaction(
+	ctx context.Context,
+	f func(tx DatastoreTransaction) error,
+	opts ...datastore.TransactionOption,
+) error {
+	var last *DatastoreTransactionImpl
+	commit, err := c.Inner.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		last = &DatastoreTransactionImpl{Inner: tx}
+		return f(last)
+	}, opts...)
+	if err != nil {
+		return err
+	}
+	last.complete(commit)
+	return nil
+}
+
+// Mutate applies the mutations atomically within a transaction, returning the (complete) key of
+// each. If any mutation fails, none are applied, and a datastore.MultiError with the failure is
+// returned.
+func (c *DatastoreClientImpl) Mutate(
+	ctx context.Context, muts ...*DatastoreMutation,
+) ([]*datastore.Key, error) {
+	return mutateDatastore(ctx, c, muts)
+}
+
+// DatastoreTransactionImpl implements DatastoreTransaction.
+type DatastoreTransactionImpl struct {
+	Inner   *datastore.Transaction
+	pending []*DatastorePendingKey
+}
+
+// Get wraps datastore.Transaction.Get(...)
+func (t *DatastoreTransactionImpl) Get(key *datastore.Key, dst interface{}) error {
+	return t.Inner.Get(key, dst)
+}
+
+// GetMulti wraps datastore.Transaction.GetMulti(...)
+func (t *DatastoreTransactionImpl) GetMulti(keys []*datastore.Key, dst interface{}) error {
+	return t.Inner.GetMulti(keys, dst)
+}
+
+// Put wraps datastore.Transaction.Put(...)
+func (t *DatastoreTransactionImpl) Put(
+	key *datastore.Key, src interface{},
+) (*DatastorePendingKey, error) {
+	pending, err := t.Inner.Put(key, src)
+	if err != nil {
+		return nil, err
+	}
+	return t.addPending(pending), nil
+}
+
+// PutMulti wraps datastore.Transaction.PutMulti(...)
+func (t *DatastoreTransactionImpl) PutMulti(
+	keys []*datastore.Key, src interface{},
+) ([]*DatastorePendingKey, error) {
+	pending, err := t.Inner.PutMulti(keys, src)
+	if err != nil {
+		return nil, err
+	}
+	pks := make([]*DatastorePendingKey, len(pending))
+	for i, p := range pending {
+		pks[i] = t.addPending(p)
+	}
+	return pks, nil
+}
+
+// Delete wraps datastore.Transaction.DeleteMulti(...)
+func (t *DatastoreTransactionImpl) Delete(keys []*datastore.Key) error {
+	return t.Inner.DeleteMulti(keys)
+}
+
+// Mutate applies the mutations within the transaction, returning the pending key of each put.
+// Inserts and updates first get their keys to check whether they have entities.
+func (t *DatastoreTransactionImpl) Mutate(
+	muts ...*DatastoreMutation,
+) ([]*DatastorePendingKey, error) {
+	return mutateInTx(t, muts)
+}
+
+// Commit wraps datastore.Transaction.Commit(), completing the transaction's pending keys.
+func (t *DatastoreTransactionImpl) Commit() error {
+	commit, err := t.Inner.Commit()
+	if err != nil {
+		return err
+	}
+	t.complete(commit)
+	return nil
+}
+
+// Rollback wraps datastore.Transaction.Rollback()
+func (t *DatastoreTransactionImpl) Rollback() error {
+	return t.Inner.Rollback()
+}
+
+func (t *DatastoreTransactionImpl) addPending(pending *datastore.PendingKey) *DatastorePendingKey {
+	pk := &DatastorePendingKey{pending: pending}
+	t.pending = append(t.pending, pk)
+	return pk
+}
+
+func (t *DatastoreTransactionImpl) complete(commit *datastore.Commit) {
+	for _, pk := range t.pending {
+		pk.commit = commit
+	}
+}
+
+// mutateDatastore applies the mutations within a transaction run by the client.
+func mutateDatastore(
+	ctx context.Context, client DatastoreTxClient, muts []*DatastoreMutation,
+) ([]*datastore.Key, error) {
+	var pks []*DatastorePendingKey
+	err := client.RunInTransaction(ctx, func(tx DatastoreTransaction) error {
+		var err error
+		pks, err = tx.Mutate(muts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*datastore.Key, len(muts))
+	for i, m := range muts {
+		if pks[i] != nil {
+			keys[i] = pks[i].Key()
+		} else {
+			keys[i] = m.key
+		}
+	}
+	return keys, nil
+}
+
+// mutateInTx applies the mutations within the transaction, checking whether the keys of inserts
+// and updates have entities.
+func mutateInTx(
+	tx DatastoreTransaction, muts []*DatastoreMutation,
+) ([]*DatastorePendingKey, error) {
+	errs, hasErr := make(datastore.MultiError, len(muts)), false
+	checked, checkKeys := make([]int, 0, len(muts)), make([]*datastore.Key, 0, len(muts))
+	for i, m := range muts {
+		if m.op == datastoreUpdate || (m.op == datastoreInsert && !m.key.Incomplete()) {
+			checked = append(checked, i)
+			checkKeys = append(checkKeys, m.key)
+		}
+	}
+	if len(checkKeys) > 0 {
+		props := make([]datastore.PropertyList, len(checkKeys))
+		err := tx.GetMulti(checkKeys, props)
+		getErrs, isMultiErr := err.(datastore.MultiError)
+		if err != nil && !isMultiErr {
+			return nil, err
+		}
+		for j, i := range checked {
+			var getErr error
+			if isMultiErr {
+				getErr = getErrs[j]
+			}
+			switch {
+			case getErr != nil && getErr != datastore.ErrNoSuchEntity:
+				errs[i], hasErr = getErr, true
+			case muts[i].op == datastoreInsert && getErr == nil:
+				errs[i], hasErr = ErrDatastoreEntityExists, true
+			case muts[i].op == datastoreUpdate && getErr != nil:
+				errs[i], hasErr = datastore.ErrNoSuchEntity, true
+			}
+		}
+	}
+	if hasErr {
+		return nil, errs
+	}
+
+	pks := make([]*DatastorePendingKey, len(muts))
+	for i, m := range muts {
+		var err error
+		if m.op == datastoreDelete {
+			err = tx.Delete([]*datastore.Key{m.key})
+		} else {
+			pks[i], err = tx.Put(m.key, m.src)
+		}
+		if err != nil {
+			errs[i] = err
+			return nil, errs
+		}
+	}
+	return pks, nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"cloud.google.com/go/datastore"
+	"github.com/cenkalti/backoff"
+)
+
+var (
+	// ErrDatastoreVersionConflict indicates when saving a versioned entity whose version differs
+	// from that of the stored entity, i.e., when the stored entity has been saved since the
+	// versioned one was read.
+	ErrDatastoreVersionConflict = errors.New("datastore entity version conflicts with stored")
+
+	// ErrInvalidDatastoreVersioned indicates when a DatastoreVersioned is not a struct pointer.
+	ErrInvalidDatastoreVersioned = errors.New("datastore versioned entity must be a struct pointer")
+)
+
+// DatastoreVersioned is an entity with a version field for optimistic concurrency control. The
+// version is incremented each time the entity is saved via PutDatastoreVersioned or
+// UpdateDatastoreVersioned and is zero for entities never saved.
+type DatastoreVersioned interface {
+	// DatastoreVersion returns the version of the entity.
+	DatastoreVersion() int64
+
+	// SetDatastoreVersion sets the version of the entity.
+	SetDatastoreVersion(version int64)
+}
+
+// PutDatastoreVersioned saves the entity src with the key if its version equals that of the
+// stored entity (or zero if there is none), incrementing its version and returning the (complete)
+// key. It returns ErrDatastoreVersionConflict, leaving src's version unchanged, if the versions
+// differ. Transactions failing with datastore.ErrConcurrentTransaction are retried according to
+// the MaxRetries and RetryInterval options, which default to NewDefaultTxOptions() if nil; the
+// other options are ignored.
+func PutDatastoreVersioned(
+	ctx context.Context,
+	client DatastoreTxClient,
+	key *datastore.Key,
+	src DatastoreVersioned,
+	opts *TxOptions,
+) (*datastore.Key, error) {
+	if err := checkDatastoreVersioned(src); err != nil {
+		return nil, err
+	}
+	version := src.DatastoreVersion()
+	var pk *DatastorePendingKey
+	err := runDatastoreTx(ctx, client, opts, func(tx DatastoreTransaction) error {
+		stored := reflect.New(reflect.TypeOf(src).Elem()).Interface().(DatastoreVersioned)
+		if !key.Incomplete() {
+			if err := tx.Get(key, stored); err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+		}
+		if stored.DatastoreVersion() != version {
+			return ErrDatastoreVersionConflict
+		}
+		src.SetDatastoreVersion(version + 1)
+		var err error
+		pk, err = tx.Put(key, src)
+		return err
+	})
+	if err != nil {
+		src.SetDatastoreVersion(version)
+		return nil, err
+	}
+	return pk.Key(), nil
+}
+
+// UpdateDatastoreVersioned loads the entity stored for the key into dst, calls update to modify
+// it, and saves it with an incremented version, all within a transaction. The transaction and
+// update are retried when it fails with datastore.ErrConcurrentTransaction, as in
+// PutDatastoreVersioned, so update should only modify dst. If update returns an error, nothing is
+// saved, and the error is returned.
+func UpdateDatastoreVersioned(
+	ctx context.Context,
+	client DatastoreTxClient,
+	key *datastore.Key,
+	dst DatastoreVersioned,
+	update func() error,
+	opts *TxOptions,
+) error {
+	if err := checkDatastoreVersioned(dst); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst).Elem()
+	return runDatastoreTx(ctx, client, opts, func(tx DatastoreTransaction) error {
+		v.Set(reflect.Zero(v.Type()))
+		if err := tx.Get(key, dst); err != nil {
+			return err
+		}
+		if err := update(); err != nil {
+			return err
+		}
+		dst.SetDatastoreVersion(dst.DatastoreVersion() + 1)
+		_, err := tx.Put(key, dst)
+		return err
+	})
+}
+
+// runDatastoreTx runs f within a single-attempt transaction, retrying according to the options
+// when the transaction fails with datastore.ErrConcurrentTransaction.
+func runDatastoreTx(
+	ctx context.Context,
+	client DatastoreTxClient,
+	opts *TxOptions,
+	f func(tx DatastoreTransaction) error,
+) error {
+	if opts == nil {
+		opts = NewDefaultTxOptions()
+	}
+	op := func() error {
+		err := client.RunInTransaction(ctx, f, datastore.MaxAttempts(1))
+		if err != nil && err != datastore.ErrConcurrentTransaction {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	return backoff.Retry(op, newTxBackoff(ctx, opts))
+}
+
+func checkDatastoreVersioned(e DatastoreVersioned) error {
+	v := reflect.ValueOf(e)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrInvalidDatastoreVersioned
+	}
+	return nil
+}
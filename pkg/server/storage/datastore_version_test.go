@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+type versionedTestCounter struct {
+	Count   int
+	Version int64
+}
+
+func (c *versionedTestCounter) DatastoreVersion() int64 {
+	return c.Version
+}
+
+func (c *versionedTestCounter) SetDatastoreVersion(version int64) {
+	c.Version = version
+}
+
+func TestPutDatastoreVersioned(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeDatastoreClient()
+	key := datastore.NameKey("counter", "a", nil)
+
+	c1 := &versionedTestCounter{Count: 1}
+	stored, err := PutDatastoreVersioned(ctx, client, key, c1, nil)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(stored))
+	assert.Equal(t, int64(1), c1.Version)
+
+	// stale versions conflict
+	c2 := &versionedTestCounter{Count: 2}
+	_, err = PutDatastoreVersioned(ctx, client, key, c2, nil)
+	assert.Equal(t, ErrDatastoreVersionConflict, err)
+	assert.Equal(t, int64(0), c2.Version)
+
+	c1.Count = 3
+	_, err = PutDatastoreVersioned(ctx, client, key, c1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), c1.Version)
+	got := &versionedTestCounter{}
+	assert.Nil(t, client.Get(ctx, key, got))
+	assert.Equal(t, &versionedTestCounter{Count: 3, Version: 2}, got)
+
+	// incomplete keys are completed
+	stored, err = PutDatastoreVersioned(
+		ctx, client, datastore.IncompleteKey("counter", nil), &versionedTestCounter{}, nil,
+	)
+	assert.Nil(t, err)
+	assert.False(t, stored.Incomplete())
+
+	var nilCounter *versionedTestCounter
+	_, err = PutDatastoreVersioned(ctx, client, key, nilCounter, nil)
+	assert.Equal(t, ErrInvalidDatastoreVersioned, err)
+}
+
+func TestUpdateDatastoreVersioned(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeDatastoreClient()
+	key := datastore.NameKey("counter", "a", nil)
+	c := &versionedTestCounter{}
+	increment := func() error {
+		c.Count++
+		return nil
+	}
+
+	err := UpdateDatastoreVersioned(ctx, client, key, c, increment, nil)
+	assert.Equal(t, datastore.ErrNoSuchEntity, err)
+
+	_, err = PutDatastoreVersioned(ctx, client, key, &versionedTestCounter{}, nil)
+	assert.Nil(t, err)
+
+	// concurrent updates are retried until they don't conflict
+	n := 8
+	opts := &TxOptions{MaxRetries: 100, RetryInterval: time.Millisecond}
+	wg := new(sync.WaitGroup)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &versionedTestCounter{}
+			err := UpdateDatastoreVersioned(ctx, client, key, c, func() error {
+				c.Count++
+				return nil
+			}, opts)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	got := &versionedTestCounter{}
+	assert.Nil(t, client.Get(ctx, key, got))
+	assert.Equal(t, &versionedTestCounter{Count: n, Version: int64(n + 1)}, got)
+
+	// update errors aren't retried, and nothing is saved
+	updateErr, calls := errors.New("some update error"), 0
+	err = UpdateDatastoreVersioned(ctx, client, key, c, func() error {
+		calls++
+		return updateErr
+	}, nil)
+	assert.Equal(t, updateErr, err)
+	assert.Equal(t, 1, calls)
+	assert.Nil(t, client.Get(ctx, key, got))
+	assert.Equal(t, int64(n+1), got.Version)
+
+	// conflicts persisting past the max retries are returned
+	err = UpdateDatastoreVersioned(ctx, client, key, c, func() error {
+		_, err2 := client.Put(ctx, key, &versionedTestCounter{}) // concurrent write
+		assert.Nil(t, err2)
+		return nil
+	}, &TxOptions{MaxRetries: 1, RetryInterval: time.Millisecond})
+	assert.Equal(t, datastore.ErrConcurrentTransaction, err)
+}
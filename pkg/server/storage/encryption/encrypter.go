@@ -0,0 +1,182 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// modes of ciphertexts
+const (
+	randomized byte = iota + 1
+	deterministic
+)
+
+// headerLen is the length of the mode and data key ID header of ciphertexts.
+const headerLen = 1 + 4
+
+// dataKey is an unwrapped data key with the subkeys derived from it.
+type dataKey struct {
+	id        uint32
+	random    cipher.AEAD
+	determ    cipher.AEAD
+	determMAC []byte
+}
+
+// Encrypter encrypts and decrypts values with the data keys of a Keyring. Each ciphertext
+// consists of a header with its mode and data key ID followed by an AES-256-GCM nonce and sealed
+// plaintext. Randomized ciphertexts use random nonces. Deterministic ciphertexts use nonces
+// derived from an HMAC of the plaintext, so equal plaintexts encrypted with the same data key and
+// additional data have equal ciphertexts, which reveals which values are equal but allows
+// looking them up. Additional data (e.g., a field name) authenticated with each ciphertext
+// prevents it from being decrypted in another context.
+type Encrypter struct {
+	primary        uint32
+	keys           map[uint32]*dataKey
+	allowPlaintext bool
+}
+
+// NewEncrypter creates a new *Encrypter with the Keyring's data keys, unwrapped by the provider.
+func NewEncrypter(ctx context.Context, p KeyProvider, r *Keyring) (*Encrypter, error) {
+	e := &Encrypter{primary: r.Primary, keys: make(map[uint32]*dataKey, len(r.Keys))}
+	for _, wk := range r.Keys {
+		key, err := p.UnwrapKey(ctx, wk.Wrapped)
+		if err != nil {
+			return nil, err
+		}
+		if e.keys[wk.ID], err = newDataKey(wk.ID, key); err != nil {
+			return nil, err
+		}
+	}
+	if _, in := e.keys[e.primary]; !in {
+		return nil, ErrMissingPrimaryKey
+	}
+	return e, nil
+}
+
+// AllowPlaintext returns a copy of the Encrypter whose DecryptFields (and so Entity and
+// ScanStruct) leaves field values that aren't encrypted as is rather than failing. It is only
+// meant for reading values stored before their fields were encrypted while migrating them (see
+// ReencryptDatastore and ReencryptPostgres).
+func (e *Encrypter) AllowPlaintext() *Encrypter {
+	c := *e
+	c.allowPlaintext = true
+	return &c
+}
+
+// Encrypt encrypts the plaintext with the primary data key and a random nonce.
+func (e *Encrypter) Encrypt(plaintext, additional []byte) ([]byte, error) {
+	dk := e.keys[e.primary]
+	header := newHeader(randomized, dk.id)
+	return seal(dk.random, header, plaintext, append(header, additional...))
+}
+
+// EncryptDeterministic encrypts the plaintext with the primary data key and a nonce derived from
+// the plaintext and additional data.
+func (e *Encrypter) EncryptDeterministic(plaintext, additional []byte) []byte {
+	return encryptDeterministic(e.keys[e.primary], plaintext, additional)
+}
+
+// DeterministicCandidates returns the deterministic ciphertexts of the plaintext with each data
+// key, starting with the primary one. Looking up any of them finds the values equal to the
+// plaintext, including those not yet re-encrypted with the primary key.
+func (e *Encrypter) DeterministicCandidates(plaintext, additional []byte) [][]byte {
+	cts := make([][]byte, 0, len(e.keys))
+	cts = append(cts, e.EncryptDeterministic(plaintext, additional))
+	for id, dk := range e.keys {
+		if id != e.primary {
+			cts = append(cts, encryptDeterministic(dk, plaintext, additional))
+		}
+	}
+	return cts
+}
+
+// Decrypt decrypts the randomized or deterministic ciphertext with the data key that encrypted
+// it.
+func (e *Encrypter) Decrypt(ciphertext, additional []byte) ([]byte, error) {
+	mode, dk, err := e.parseHeader(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	aead := dk.random
+	if mode == deterministic {
+		aead = dk.determ
+	}
+	header := ciphertext[:headerLen]
+	additional = append(append(make([]byte, 0, headerLen+len(additional)), header...),
+		additional...)
+	return open(aead, ciphertext[headerLen:], additional)
+}
+
+// IsPrimary returns whether the ciphertext was encrypted with the primary data key.
+func (e *Encrypter) IsPrimary(ciphertext []byte) bool {
+	_, dk, err := e.parseHeader(ciphertext)
+	return err == nil && dk.id == e.primary
+}
+
+func (e *Encrypter) parseHeader(ciphertext []byte) (byte, *dataKey, error) {
+	if len(ciphertext) < headerLen {
+		return 0, nil, ErrInvalidCiphertext
+	}
+	mode := ciphertext[0]
+	if mode != randomized && mode != deterministic {
+		return 0, nil, ErrInvalidCiphertext
+	}
+	dk, in := e.keys[binary.BigEndian.Uint32(ciphertext[1:headerLen])]
+	if !in {
+		return 0, nil, ErrUnknownKey
+	}
+	return mode, dk, nil
+}
+
+func newDataKey(id uint32, key []byte) (*dataKey, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+	random, err := newAEAD(deriveKey(key, "randomized"))
+	if err != nil {
+		return nil, err
+	}
+	determ, err := newAEAD(deriveKey(key, "deterministic"))
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey{
+		id:        id,
+		random:    random,
+		determ:    determ,
+		determMAC: deriveKey(key, "deterministic-nonce"),
+	}, nil
+}
+
+func encryptDeterministic(dk *dataKey, plaintext, additional []byte) []byte {
+	header := newHeader(deterministic, dk.id)
+	mac := hmac.New(sha256.New, dk.determMAC)
+	_, _ = mac.Write(header) // never returns an error
+	lenBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(lenBuf, uint64(len(additional)))
+	_, _ = mac.Write(lenBuf)
+	_, _ = mac.Write(additional)
+	_, _ = mac.Write(plaintext)
+	nonce := mac.Sum(nil)[:dk.determ.NonceSize()]
+
+	out := make([]byte, 0, headerLen+len(nonce)+len(plaintext)+dk.determ.Overhead())
+	out = append(append(out, header...), nonce...)
+	return dk.determ.Seal(out, nonce, plaintext, append(header, additional...))
+}
+
+func newHeader(mode byte, id uint32) []byte {
+	header := make([]byte, headerLen)
+	header[0] = mode
+	binary.BigEndian.PutUint32(header[1:], id)
+	return header
+}
+
+// deriveKey derives a subkey for the given purpose from the key.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(purpose)) // never returns an error
+	return mac.Sum(nil)
+}
@@ -0,0 +1,96 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypter(t *testing.T) {
+	ctx := context.Background()
+	p := NewKMSKeyProvider(NewFakeKMSClient(), "phi")
+	r, err := NewKeyring(ctx, p)
+	assert.Nil(t, err)
+	e, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	plaintext, aad := []byte("123-45-6789"), []byte("ssn")
+
+	ct1, err := e.Encrypt(plaintext, aad)
+	assert.Nil(t, err)
+	ct2, err := e.Encrypt(plaintext, aad)
+	assert.Nil(t, err)
+	assert.NotEqual(t, ct1, ct2)
+	det1 := e.EncryptDeterministic(plaintext, aad)
+	assert.Equal(t, det1, e.EncryptDeterministic(plaintext, aad))
+	assert.NotEqual(t, det1, e.EncryptDeterministic(plaintext, []byte("other")))
+	assert.NotEqual(t, det1, e.EncryptDeterministic([]byte("987-65-4321"), aad))
+
+	for _, ct := range [][]byte{ct1, det1} {
+		decrypted, err2 := e.Decrypt(ct, aad)
+		assert.Nil(t, err2)
+		assert.Equal(t, plaintext, decrypted)
+		assert.True(t, e.IsPrimary(ct))
+
+		// ciphertexts are bound to their additional data and can't be modified
+		_, err2 = e.Decrypt(ct, []byte("other"))
+		assert.Equal(t, ErrInvalidCiphertext, err2)
+		for _, i := range []int{0, 1, headerLen, len(ct) - 1} {
+			tampered := append([]byte{}, ct...)
+			tampered[i] ^= 1
+			_, err2 = e.Decrypt(tampered, aad)
+			assert.NotNil(t, err2)
+		}
+	}
+	_, err = e.Decrypt(ct1[:headerLen-1], aad)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+
+	// rotated data keys encrypt new values and decrypt existing ones
+	assert.Nil(t, r.Rotate(ctx, p))
+	assert.Equal(t, uint32(2), r.Primary)
+	e2, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	assert.False(t, e2.IsPrimary(ct1))
+	decrypted, err := e2.Decrypt(ct1, aad)
+	assert.Nil(t, err)
+	assert.Equal(t, plaintext, decrypted)
+	det2 := e2.EncryptDeterministic(plaintext, aad)
+	assert.NotEqual(t, det1, det2)
+	assert.Equal(t, [][]byte{det2, det1}, e2.DeterministicCandidates(plaintext, aad))
+
+	// removed data keys no longer decrypt
+	assert.Equal(t, ErrRemovePrimaryKey, r.Remove(2))
+	assert.Equal(t, ErrUnknownKey, r.Remove(3))
+	assert.Nil(t, r.Remove(1))
+	e3, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	_, err = e3.Decrypt(ct1, aad)
+	assert.Equal(t, ErrUnknownKey, err)
+}
+
+func TestKeyring_Rewrap(t *testing.T) {
+	ctx := context.Background()
+	from := NewKMSKeyProvider(NewFakeKMSClient(), "phi")
+	to, err := NewKeyfileProvider("k1", map[string][]byte{"k1": newTestKey(t)})
+	assert.Nil(t, err)
+	r, err := NewKeyring(ctx, from)
+	assert.Nil(t, err)
+	e1, err := NewEncrypter(ctx, from, r)
+	assert.Nil(t, err)
+	ct, err := e1.Encrypt([]byte("value"), nil)
+	assert.Nil(t, err)
+
+	// rewrapping doesn't change the data keys
+	assert.Nil(t, r.Rewrap(ctx, from, to))
+	_, err = NewEncrypter(ctx, from, r)
+	assert.NotNil(t, err)
+	e2, err := NewEncrypter(ctx, to, r)
+	assert.Nil(t, err)
+	plaintext, err := e2.Decrypt(ct, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), plaintext)
+
+	r.Primary = 2
+	_, err = NewEncrypter(ctx, to, r)
+	assert.Equal(t, ErrMissingPrimaryKey, err)
+}
@@ -0,0 +1,432 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+)
+
+const (
+	encryptTag           = "encrypt"
+	encryptTagDeterm     = "deterministic"
+	encryptedValuePrefix = "enc:"
+)
+
+var (
+	// ErrInvalidFieldType indicates when an encrypted field is not a string, *string, or []byte.
+	ErrInvalidFieldType = errors.New("encrypted field must be a string, *string, or []byte")
+
+	// ErrInvalidEntityFieldType indicates when an encrypted field of a Datastore entity is a
+	// *string, which Datastore can't store.
+	ErrInvalidEntityFieldType = errors.New("encrypted entity field must be a string or []byte")
+
+	// ErrInvalidEncryptTag indicates when an encrypt tag has an unknown option.
+	ErrInvalidEncryptTag = errors.New("invalid encrypt tag")
+
+	// ErrUnencryptedField indicates when decrypting a field with an encrypt tag whose non-empty
+	// value isn't encrypted.
+	ErrUnencryptedField = errors.New("encrypted field has an unencrypted value")
+
+	fieldsCache = &fieldsMap{fields: make(map[reflect.Type][]*field)}
+
+	prefixBytes = []byte(encryptedValuePrefix)
+)
+
+// field is a struct field that is encrypted or an embedded struct whose fields may be.
+type field struct {
+	index         int
+	name          string
+	deterministic bool
+	embedded      bool
+}
+
+type fieldsMap struct {
+	fields map[reflect.Type][]*field
+	mu     sync.RWMutex
+}
+
+// EncryptFields encrypts in place the fields of the given struct pointer with `encrypt` tags,
+// including those of embedded structs. The tag value is the name authenticated with the field's
+// ciphertext (the field name if empty), optionally followed by ",deterministic" for fields that
+// need equality lookups (see LookupString). Fields may be strings, whose ciphertexts are
+// base64-encoded, *strings (except in Datastore entities, see Entity), or []bytes. Their
+// encrypted values are prefixed with "enc:". Empty and nil values are not encrypted.
+func (e *Encrypter) EncryptFields(v interface{}) error {
+	sv, err := structPtrValue(v)
+	if err != nil {
+		return err
+	}
+	return walkFields(sv, false, func(f *field, fv reflect.Value) error {
+		return e.encryptField(f, fv)
+	})
+}
+
+// DecryptFields decrypts in place the encrypted fields of the given struct pointer. It returns
+// ErrUnencryptedField if a non-empty field value isn't encrypted, unless the Encrypter allows
+// plaintext (see AllowPlaintext).
+func (e *Encrypter) DecryptFields(v interface{}) error {
+	sv, err := structPtrValue(v)
+	if err != nil {
+		return err
+	}
+	return walkFields(sv, false, func(f *field, fv reflect.Value) error {
+		if _, nonEmpty := getPlaintext(fv); !nonEmpty {
+			return nil
+		}
+		ct, encrypted := getEncrypted(fv)
+		if !encrypted && e.allowPlaintext {
+			return nil
+		} else if !encrypted {
+			return ErrUnencryptedField
+		}
+		plaintext, err := e.Decrypt(ct, []byte(f.name))
+		if err != nil {
+			return err
+		}
+		setPlaintext(fv, plaintext)
+		return nil
+	})
+}
+
+// ReencryptFields re-encrypts in place the encrypted fields of the given struct pointer that
+// weren't encrypted with the primary data key and encrypts those that aren't encrypted,
+// returning whether any fields changed.
+func (e *Encrypter) ReencryptFields(v interface{}) (bool, error) {
+	sv, err := structPtrValue(v)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	err = walkFields(sv, false, func(f *field, fv reflect.Value) error {
+		if _, nonEmpty := getPlaintext(fv); !nonEmpty {
+			return nil
+		}
+		ct, encrypted := getEncrypted(fv)
+		if encrypted && e.IsPrimary(ct) {
+			return nil
+		}
+		if encrypted {
+			plaintext, err := e.Decrypt(ct, []byte(f.name))
+			if err != nil {
+				return err
+			}
+			setPlaintext(fv, plaintext)
+		}
+		changed = true
+		return e.encryptField(f, fv)
+	})
+	return changed, err
+}
+
+// LookupString returns the encrypted values of a deterministic string field with the given name
+// (from its encrypt tag) equal to the plaintext, starting with the one encrypted with the
+// primary data key. Querying for fields equal to any of them finds those equal to the plaintext.
+func (e *Encrypter) LookupString(name, plaintext string) []string {
+	cts := e.DeterministicCandidates([]byte(plaintext), []byte(name))
+	values := make([]string, len(cts))
+	for i, ct := range cts {
+		values[i] = encryptedValuePrefix + base64.RawURLEncoding.EncodeToString(ct)
+	}
+	return values
+}
+
+// LookupBytes is the []byte field version of LookupString.
+func (e *Encrypter) LookupBytes(name string, plaintext []byte) [][]byte {
+	cts := e.DeterministicCandidates(plaintext, []byte(name))
+	values := make([][]byte, len(cts))
+	for i, ct := range cts {
+		values[i] = append(append(make([]byte, 0, len(prefixBytes)+len(ct)), prefixBytes...),
+			ct...)
+	}
+	return values
+}
+
+// Entity returns a datastore.PropertyLoadSaver for the given struct pointer that encrypts its
+// fields (without modifying it) when saved and decrypts them when loaded, e.g., for
+// DatastoreClient.Put and Get. Since Datastore can't store *string fields, Save and Load return
+// ErrInvalidEntityFieldType if the struct's encrypted fields include any.
+func (e *Encrypter) Entity(v interface{}) *Entity {
+	return &Entity{enc: e, v: v}
+}
+
+// InsertValues returns the column names and encrypted values of the given struct pointer (which
+// is not modified) for an insert or update statement, as storage.InsertValues does.
+func (e *Encrypter) InsertValues(v interface{}) ([]string, []interface{}, error) {
+	c, err := e.encryptedCopy(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return storage.InsertValues(c)
+}
+
+// ScanStruct scans the current row into the given struct pointer, as storage.ScanStruct does, and
+// decrypts its fields.
+func (e *Encrypter) ScanStruct(row storage.RowScanner, dest interface{}) error {
+	if err := storage.ScanStruct(row, dest); err != nil {
+		return err
+	}
+	return e.DecryptFields(dest)
+}
+
+// encryptedCopy returns a copy of the given struct pointer with encrypted fields.
+func (e *Encrypter) encryptedCopy(v interface{}) (interface{}, error) {
+	sv, err := structPtrValue(v)
+	if err != nil {
+		return nil, err
+	}
+	c := reflect.New(sv.Type())
+	c.Elem().Set(sv)
+	err = walkFields(c.Elem(), true, func(f *field, fv reflect.Value) error {
+		return e.encryptField(f, fv)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.Interface(), nil
+}
+
+func (e *Encrypter) encryptField(f *field, fv reflect.Value) error {
+	plaintext, nonEmpty := getPlaintext(fv)
+	if !nonEmpty {
+		return nil
+	}
+	var ct []byte
+	if f.deterministic {
+		ct = e.EncryptDeterministic(plaintext, []byte(f.name))
+	} else {
+		var err error
+		if ct, err = e.Encrypt(plaintext, []byte(f.name)); err != nil {
+			return err
+		}
+	}
+	setEncrypted(fv, ct)
+	return nil
+}
+
+// Entity is a datastore.PropertyLoadSaver encrypting the fields of a struct pointer.
+type Entity struct {
+	enc *Encrypter
+	v   interface{}
+}
+
+// Load loads the properties into the struct pointer and decrypts its fields.
+func (e *Entity) Load(props []datastore.Property) error {
+	sv, err := structPtrValue(e.v)
+	if err != nil {
+		return err
+	}
+	if err = checkEntityFields(sv.Type()); err != nil {
+		return err
+	}
+	if err = datastore.LoadStruct(e.v, props); err != nil {
+		return err
+	}
+	return e.enc.DecryptFields(e.v)
+}
+
+// Save returns the properties of a copy of the struct pointer with encrypted fields.
+func (e *Entity) Save() ([]datastore.Property, error) {
+	sv, err := structPtrValue(e.v)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkEntityFields(sv.Type()); err != nil {
+		return nil, err
+	}
+	c, err := e.enc.encryptedCopy(e.v)
+	if err != nil {
+		return nil, err
+	}
+	return datastore.SaveStruct(c)
+}
+
+// LoadKey loads the key into the struct pointer if it implements datastore.KeyLoader.
+func (e *Entity) LoadKey(k *datastore.Key) error {
+	if kl, ok := e.v.(datastore.KeyLoader); ok {
+		return kl.LoadKey(k)
+	}
+	return nil
+}
+
+// walkFields calls fn for each encrypted field of the struct value, including those of embedded
+// structs. If clone is true, embedded struct pointers are replaced with copies first.
+func walkFields(sv reflect.Value, clone bool, fn func(f *field, fv reflect.Value) error) error {
+	fs, err := getFields(sv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		fv := sv.Field(f.index)
+		if !f.embedded {
+			if err := fn(f, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			if clone {
+				c := reflect.New(fv.Type().Elem())
+				c.Elem().Set(fv.Elem())
+				fv.Set(c)
+			}
+			fv = fv.Elem()
+		}
+		if err := walkFields(fv, clone, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getFields(t reflect.Type) ([]*field, error) {
+	fieldsCache.mu.RLock()
+	fs, in := fieldsCache.fields[t]
+	fieldsCache.mu.RUnlock()
+	if in {
+		return fs, nil
+	}
+	fs = make([]*field, 0)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup(encryptTag)
+		if !tagged {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if sf.Anonymous && ft.Kind() == reflect.Struct && sf.PkgPath == "" {
+				fs = append(fs, &field{index: i, embedded: true})
+			}
+			continue
+		}
+		if !isEncryptableType(sf.Type) || sf.PkgPath != "" {
+			return nil, ErrInvalidFieldType
+		}
+		f := &field{index: i, name: sf.Name}
+		opts := strings.Split(tag, ",")
+		if opts[0] != "" {
+			f.name = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			if opt != encryptTagDeterm {
+				return nil, ErrInvalidEncryptTag
+			}
+			f.deterministic = true
+		}
+		fs = append(fs, f)
+	}
+	fieldsCache.mu.Lock()
+	fieldsCache.fields[t] = fs
+	fieldsCache.mu.Unlock()
+	return fs, nil
+}
+
+// checkEntityFields returns ErrInvalidEntityFieldType if the encrypted fields of the struct type,
+// including those of embedded structs, include a *string.
+func checkEntityFields(t reflect.Type) error {
+	fs, err := getFields(t)
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		ft := t.Field(f.index).Type
+		if !f.embedded && ft.Kind() == reflect.Ptr {
+			return ErrInvalidEntityFieldType
+		}
+		if !f.embedded {
+			continue
+		}
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if err := checkEntityFields(ft); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEncryptableType(t reflect.Type) bool {
+	switch {
+	case t.Kind() == reflect.String:
+		return true
+	case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.String:
+		return true
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return true
+	}
+	return false
+}
+
+// getPlaintext returns the field's value and whether it is non-empty.
+func getPlaintext(fv reflect.Value) ([]byte, bool) {
+	switch fv.Kind() {
+	case reflect.String:
+		return []byte(fv.String()), fv.Len() > 0
+	case reflect.Ptr:
+		if fv.IsNil() {
+			return nil, false
+		}
+		return []byte(fv.Elem().String()), fv.Elem().Len() > 0
+	default:
+		return fv.Bytes(), fv.Len() > 0
+	}
+}
+
+// setPlaintext sets the field's value, replacing rather than modifying any *string or []byte.
+func setPlaintext(fv reflect.Value, plaintext []byte) {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(string(plaintext))
+	case reflect.Ptr:
+		s := reflect.New(fv.Type().Elem())
+		s.Elem().SetString(string(plaintext))
+		fv.Set(s)
+	default:
+		fv.SetBytes(plaintext)
+	}
+}
+
+// getEncrypted returns the ciphertext of the field and whether its value is encrypted.
+func getEncrypted(fv reflect.Value) ([]byte, bool) {
+	value, nonEmpty := getPlaintext(fv)
+	if !nonEmpty || !bytes.HasPrefix(value, prefixBytes) {
+		return nil, false
+	}
+	value = value[len(prefixBytes):]
+	if fv.Kind() == reflect.Slice {
+		return value, true
+	}
+	ct, err := base64.RawURLEncoding.DecodeString(string(value))
+	if err != nil {
+		return nil, false
+	}
+	return ct, true
+}
+
+// setEncrypted sets the field's value to the encoded ciphertext.
+func setEncrypted(fv reflect.Value, ct []byte) {
+	if fv.Kind() == reflect.Slice {
+		value := append(append(make([]byte, 0, len(prefixBytes)+len(ct)), prefixBytes...), ct...)
+		fv.SetBytes(value)
+		return
+	}
+	setPlaintext(fv, []byte(encryptedValuePrefix+base64.RawURLEncoding.EncodeToString(ct)))
+}
+
+func structPtrValue(v interface{}) (reflect.Value, error) {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, storage.ErrNotStructPtr
+	}
+	return pv.Elem(), nil
+}
@@ -0,0 +1,244 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// PatientContact is exported since embedded struct pointers must have exported types.
+type PatientContact struct {
+	Email string `db:"email" encrypt:"email"`
+}
+
+type testPatient struct {
+	ID    string  `db:"id" datastore:"-"`
+	Name  string  `db:"name" encrypt:"name"`
+	SSN   string  `db:"ssn" encrypt:"ssn,deterministic"`
+	Notes *string `db:"notes" encrypt:""`
+	Photo []byte  `db:"photo" encrypt:"photo" datastore:",noindex"`
+	Age   int     `db:"age"`
+	*PatientContact
+}
+
+// testEntity is a testPatient without the *string field that Datastore entities can't have.
+type testEntity struct {
+	Name  string `encrypt:"name"`
+	SSN   string `encrypt:"ssn,deterministic"`
+	Photo []byte `encrypt:"photo" datastore:",noindex"`
+	Age   int
+	*PatientContact
+}
+
+func TestEncrypter_EncryptFields(t *testing.T) {
+	e := newTestEncrypter(t)
+	notes := "allergic to penicillin"
+	p := newTestPatient("1", notes)
+	assert.Nil(t, e.EncryptFields(p))
+	assert.Equal(t, "1", p.ID)
+	assert.Equal(t, 42, p.Age)
+	for _, value := range []string{p.Name, p.SSN, *p.Notes, string(p.Photo), p.Email} {
+		assert.True(t, strings.HasPrefix(value, encryptedValuePrefix), value)
+	}
+	assert.Equal(t, "allergic to penicillin", notes)
+	assert.Contains(t, e.LookupString("ssn", "123-45-6789"), p.SSN)
+
+	assert.Nil(t, e.DecryptFields(p))
+	assert.Equal(t, newTestPatient("1", notes), p)
+
+	// unencrypted values are rejected unless plaintext is allowed, and empty ones aren't encrypted
+	p2 := &testPatient{Name: "bob"}
+	assert.Equal(t, ErrUnencryptedField, e.DecryptFields(p2))
+	assert.Nil(t, e.AllowPlaintext().DecryptFields(p2))
+	assert.Equal(t, &testPatient{Name: "bob"}, p2)
+	assert.Equal(t, ErrUnencryptedField, e.DecryptFields(p2))
+	p2.Name = ""
+	assert.Nil(t, e.DecryptFields(p2))
+	assert.Nil(t, e.EncryptFields(p2))
+	assert.Equal(t, &testPatient{}, p2)
+
+	// values from another field can't be decrypted
+	p.Name, p.SSN = e.LookupString("ssn", "123-45-6789")[0], ""
+	assert.Equal(t, ErrInvalidCiphertext, e.DecryptFields(p))
+
+	type badType struct {
+		Age int `encrypt:"age"`
+	}
+	type badTag struct {
+		Name string `encrypt:"name,random"`
+	}
+	assert.Equal(t, ErrInvalidFieldType, e.EncryptFields(&badType{}))
+	assert.Equal(t, ErrInvalidEncryptTag, e.EncryptFields(&badTag{}))
+	assert.Equal(t, storage.ErrNotStructPtr, e.EncryptFields(testPatient{}))
+}
+
+func TestEncrypter_ReencryptFields(t *testing.T) {
+	ctx := context.Background()
+	p, r, e1 := newTestKeyring(t)
+	patient := newTestPatient("1", "notes")
+	assert.Nil(t, e1.EncryptFields(patient))
+	name := patient.Name
+
+	changed, err := e1.ReencryptFields(patient)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, name, patient.Name)
+
+	assert.Nil(t, r.Rotate(ctx, p))
+	e2, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(e2.LookupBytes("photo", []byte("photo"))))
+	changed, err = e2.ReencryptFields(patient)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.NotEqual(t, name, patient.Name)
+	assert.Equal(t, e2.LookupString("ssn", "123-45-6789")[0], patient.SSN)
+	assert.Nil(t, e2.DecryptFields(patient))
+	assert.Equal(t, newTestPatient("1", "notes"), patient)
+
+	// unencrypted values are encrypted
+	changed, err = e2.ReencryptFields(patient)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.True(t, strings.HasPrefix(patient.Name, encryptedValuePrefix))
+}
+
+func TestEncrypter_Entity(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEncrypter(t)
+	client := storage.NewFakeDatastoreClient()
+	key := datastore.NameKey("patient", "1", nil)
+	entity := newTestEntity()
+	_, err := client.Put(ctx, key, e.Entity(entity))
+	assert.Nil(t, err)
+	assert.Equal(t, newTestEntity(), entity)
+
+	stored := &testEntity{}
+	assert.Nil(t, client.Get(ctx, key, stored))
+	assert.NotEqual(t, entity.Name, stored.Name)
+
+	loaded := &testEntity{}
+	assert.Nil(t, client.Get(ctx, key, e.Entity(loaded)))
+	assert.Equal(t, entity, loaded)
+
+	// deterministic fields can be looked up
+	q := &storage.DatastoreQuery{
+		Kind: "patient",
+		Filters: []*storage.DatastoreFilter{
+			{Field: "SSN", Op: "=", Value: e.LookupString("ssn", "123-45-6789")[0]},
+		},
+	}
+	n, err := client.Count(ctx, q)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// *string fields can't be stored
+	_, err = client.Put(ctx, key, e.Entity(newTestPatient("", "notes")))
+	assert.Equal(t, ErrInvalidEntityFieldType, err)
+	assert.Equal(t, ErrInvalidEntityFieldType, client.Get(ctx, key, e.Entity(&testPatient{})))
+}
+
+func TestEncrypter_InsertValuesScanStruct(t *testing.T) {
+	e := newTestEncrypter(t)
+	patient := newTestPatient("1", "notes")
+	cols, values, err := e.InsertValues(patient)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id", "name", "ssn", "notes", "photo", "age", "email"}, cols)
+	assert.Equal(t, newTestPatient("1", "notes"), patient)
+	assert.Equal(t, "1", values[0])
+	assert.NotEqual(t, patient.Name, values[1])
+
+	loaded := &testPatient{}
+	assert.Nil(t, e.ScanStruct(&testRows{values: [][]interface{}{values}}, loaded))
+	assert.Equal(t, patient, loaded)
+}
+
+func newTestPatient(id, notes string) *testPatient {
+	return &testPatient{
+		ID:             id,
+		Name:           "alice",
+		SSN:            "123-45-6789",
+		Notes:          &notes,
+		Photo:          []byte("photo"),
+		Age:            42,
+		PatientContact: &PatientContact{Email: "alice@example.com"},
+	}
+}
+
+func newTestEntity() *testEntity {
+	return &testEntity{
+		Name:           "alice",
+		SSN:            "123-45-6789",
+		Photo:          []byte("photo"),
+		Age:            42,
+		PatientContact: &PatientContact{Email: "alice@example.com"},
+	}
+}
+
+func newTestEncrypter(t *testing.T) *Encrypter {
+	_, _, e := newTestKeyring(t)
+	return e
+}
+
+func newTestKeyring(t *testing.T) (KeyProvider, *Keyring, *Encrypter) {
+	ctx := context.Background()
+	p := NewKMSKeyProvider(NewFakeKMSClient(), "phi")
+	r, err := NewKeyring(ctx, p)
+	assert.Nil(t, err)
+	e, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	return p, r, e
+}
+
+// testRows are QueryRows with the given values, assigned to scan destinations as database/sql
+// does for the types used in the tests.
+type testRows struct {
+	values [][]interface{}
+	i      int
+}
+
+func (r *testRows) Next() bool {
+	r.i++
+	return r.i <= len(r.values)
+}
+
+func (r *testRows) Scan(dest ...interface{}) error {
+	row := r.values[0]
+	if r.i > 0 {
+		row = r.values[r.i-1]
+	}
+	for i, d := range dest {
+		switch v := row[i].(type) {
+		case string:
+			switch d := d.(type) {
+			case *string:
+				*d = v
+			case **string:
+				*d = &v
+			}
+		case *string:
+			*(d.(**string)) = v
+		case []byte:
+			*(d.(*[]byte)) = v
+		case int:
+			*(d.(*int)) = v
+		case nil:
+		default:
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Err() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrRemovePrimaryKey indicates when trying to remove the primary key from a Keyring.
+var ErrRemovePrimaryKey = errors.New("can't remove primary key")
+
+// Keyring is a set of data keys wrapped by a KeyProvider. It contains no plaintext keys, so it
+// may be stored alongside a service's configuration (e.g., as JSON). New field values are
+// encrypted with the primary key, and existing ones are decrypted with whichever key encrypted
+// them, so a data key may only be removed once all values encrypted with it have been
+// re-encrypted (see ReencryptDatastore and ReencryptPostgres).
+type Keyring struct {
+	// Primary is the ID of the data key encrypting new values.
+	Primary uint32 `json:"primary"`
+
+	// Keys are the wrapped data keys.
+	Keys []*WrappedKey `json:"keys"`
+}
+
+// WrappedKey is a data key wrapped by a KeyProvider.
+type WrappedKey struct {
+	// ID is the ID of the key within its Keyring.
+	ID uint32 `json:"id"`
+
+	// Wrapped is the wrapped key.
+	Wrapped []byte `json:"wrapped"`
+}
+
+// NewKeyring creates a new *Keyring with a new data key wrapped by the provider.
+func NewKeyring(ctx context.Context, p KeyProvider) (*Keyring, error) {
+	r := &Keyring{Keys: make([]*WrappedKey, 0, 1)}
+	if err := r.Rotate(ctx, p); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Rotate adds a new data key wrapped by the provider and makes it the primary key.
+func (r *Keyring) Rotate(ctx context.Context, p KeyProvider) error {
+	key, err := NewKey()
+	if err != nil {
+		return err
+	}
+	wrapped, err := p.WrapKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	id := uint32(1)
+	for _, wk := range r.Keys {
+		if wk.ID >= id {
+			id = wk.ID + 1
+		}
+	}
+	r.Keys = append(r.Keys, &WrappedKey{ID: id, Wrapped: wrapped})
+	r.Primary = id
+	return nil
+}
+
+// Rewrap unwraps the data keys with one provider and wraps them with another (or the same one),
+// e.g., after rotating the provider's key encryption keys. The data keys themselves and thus the
+// field values they encrypt are unchanged.
+func (r *Keyring) Rewrap(ctx context.Context, from, to KeyProvider) error {
+	rewrapped := make([]*WrappedKey, len(r.Keys))
+	for i, wk := range r.Keys {
+		key, err := from.UnwrapKey(ctx, wk.Wrapped)
+		if err != nil {
+			return err
+		}
+		wrapped, err := to.WrapKey(ctx, key)
+		if err != nil {
+			return err
+		}
+		rewrapped[i] = &WrappedKey{ID: wk.ID, Wrapped: wrapped}
+	}
+	r.Keys = rewrapped
+	return nil
+}
+
+// Remove removes the non-primary data key with the given ID.
+func (r *Keyring) Remove(id uint32) error {
+	if id == r.Primary {
+		return ErrRemovePrimaryKey
+	}
+	for i, wk := range r.Keys {
+		if wk.ID == id {
+			r.Keys = append(r.Keys[:i], r.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return ErrUnknownKey
+}
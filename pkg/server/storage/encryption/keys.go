@@ -0,0 +1,183 @@
+// Package encryption provides envelope encryption of the PHI fields of entities stored in Postgres
+// and Datastore. Field values are encrypted with data keys from a Keyring, which are themselves
+// encrypted (wrapped) by a KeyProvider's key encryption keys, e.g., from a local keyfile or a KMS.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// KeySize is the size in bytes of key encryption and data keys.
+const KeySize = 32
+
+var (
+	// ErrInvalidKeySize indicates when a key is not KeySize bytes.
+	ErrInvalidKeySize = errors.New("key must be 32 bytes")
+
+	// ErrUnknownKey indicates when a key encryption or data key is not known.
+	ErrUnknownKey = errors.New("unknown key")
+
+	// ErrMissingPrimaryKey indicates when the primary key is not one of the keys.
+	ErrMissingPrimaryKey = errors.New("primary key is missing")
+
+	// ErrInvalidCiphertext indicates when a ciphertext is malformed or fails authentication.
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+	// ErrInvalidKeyfile indicates when a keyfile is malformed.
+	ErrInvalidKeyfile = errors.New("invalid keyfile")
+)
+
+// KeyProvider wraps and unwraps data keys with key encryption keys it manages.
+type KeyProvider interface {
+	// WrapKey encrypts the data key with the provider's current key encryption key.
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key wrapped by WrapKey, possibly with a previous key encryption
+	// key.
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// NewKey returns a new random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// keyfile is the JSON representation of a keyfile.
+type keyfile struct {
+	// Primary is the ID of the key used to wrap new data keys.
+	Primary string `json:"primary"`
+
+	// Keys are the base64-encoded keys by ID.
+	Keys map[string]string `json:"keys"`
+}
+
+// KeyfileProvider is a KeyProvider with local key encryption keys, e.g., read from a keyfile.
+// Rotating its keys involves adding a new primary key, rewrapping the Keyring with it, and then
+// removing the previous key.
+type KeyfileProvider struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyfileProvider creates a new *KeyfileProvider with the given keys by ID, of which the
+// primary one wraps new data keys.
+func NewKeyfileProvider(primary string, keys map[string][]byte) (*KeyfileProvider, error) {
+	if _, in := keys[primary]; !in {
+		return nil, ErrMissingPrimaryKey
+	}
+	p := &KeyfileProvider{primary: primary, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, ErrInvalidKeyfile
+		}
+		var err error
+		if p.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// ReadKeyfileProvider creates a new *KeyfileProvider with the keys in the given JSON keyfile.
+func ReadKeyfileProvider(path string) (*KeyfileProvider, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kf := &keyfile{}
+	if err = json.Unmarshal(buf, kf); err != nil {
+		return nil, ErrInvalidKeyfile
+	}
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, ErrInvalidKeyfile
+		}
+	}
+	return NewKeyfileProvider(kf.Primary, keys)
+}
+
+// WriteKeyfile writes the given keys by ID, of which the primary one wraps new data keys, to a
+// JSON keyfile readable only by the current user.
+func WriteKeyfile(path string, primary string, keys map[string][]byte) error {
+	if _, in := keys[primary]; !in {
+		return ErrMissingPrimaryKey
+	}
+	kf := &keyfile{Primary: primary, Keys: make(map[string]string, len(keys))}
+	for id, key := range keys {
+		kf.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	buf, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0600)
+}
+
+// WrapKey encrypts the data key with the primary key, prefixing it with the primary key's ID.
+func (p *KeyfileProvider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	id := []byte(p.primary)
+	prefix := append([]byte{byte(len(id))}, id...)
+	return seal(p.keys[p.primary], prefix, key, prefix)
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey with the key whose ID prefixes it.
+func (p *KeyfileProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) == 0 || len(wrapped) < 1+int(wrapped[0]) {
+		return nil, ErrInvalidCiphertext
+	}
+	prefixLen := 1 + int(wrapped[0])
+	aead, in := p.keys[string(wrapped[1:prefixLen])]
+	if !in {
+		return nil, ErrUnknownKey
+	}
+	return open(aead, wrapped[prefixLen:], wrapped[:prefixLen])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, returning the prefix followed by the nonce and
+// sealed plaintext.
+func seal(aead cipher.AEAD, prefix, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(prefix)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(append(out, prefix...), nonce...)
+	return aead.Seal(out, nonce, plaintext, additional), nil
+}
+
+// open decrypts the nonce-prefixed sealed plaintext.
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce := sealed[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,110 @@
+package encryption
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyfileProvider(t *testing.T) {
+	ctx := context.Background()
+	key1, key2 := newTestKey(t), newTestKey(t)
+	p1, err := NewKeyfileProvider("k1", map[string][]byte{"k1": key1})
+	assert.Nil(t, err)
+	dataKey := newTestKey(t)
+	wrapped, err := p1.WrapKey(ctx, dataKey)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(wrapped), string(dataKey))
+
+	// rotated providers unwrap keys wrapped by their previous keys
+	dir, err := ioutil.TempDir("", "keyfile")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	path := filepath.Join(dir, "keys.json")
+	err = WriteKeyfile(path, "k2", map[string][]byte{"k1": key1, "k2": key2})
+	assert.Nil(t, err)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	p2, err := ReadKeyfileProvider(path)
+	assert.Nil(t, err)
+	unwrapped, err := p2.UnwrapKey(ctx, wrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	wrapped2, err := p2.WrapKey(ctx, dataKey)
+	assert.Nil(t, err)
+	_, err = p1.UnwrapKey(ctx, wrapped2)
+	assert.Equal(t, ErrUnknownKey, err)
+
+	wrapped2[len(wrapped2)-1] ^= 1
+	_, err = p2.UnwrapKey(ctx, wrapped2)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	_, err = p2.UnwrapKey(ctx, []byte{10, 'k'})
+	assert.Equal(t, ErrInvalidCiphertext, err)
+}
+
+func TestKeyfileProvider_err(t *testing.T) {
+	_, err := NewKeyfileProvider("k2", map[string][]byte{"k1": newTestKey(t)})
+	assert.Equal(t, ErrMissingPrimaryKey, err)
+	_, err = NewKeyfileProvider("k1", map[string][]byte{"k1": []byte("short")})
+	assert.Equal(t, ErrInvalidKeySize, err)
+	err = WriteKeyfile("keys.json", "k2", map[string][]byte{"k1": newTestKey(t)})
+	assert.Equal(t, ErrMissingPrimaryKey, err)
+
+	dir, err := ioutil.TempDir("", "keyfile")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	_, err = ReadKeyfileProvider(filepath.Join(dir, "missing.json"))
+	assert.True(t, os.IsNotExist(err))
+	for _, contents := range []string{"not json", `{"primary": "k1", "keys": {"k1": "!"}}`} {
+		path := filepath.Join(dir, "keys.json")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0600))
+		_, err = ReadKeyfileProvider(path)
+		assert.Equal(t, ErrInvalidKeyfile, err)
+	}
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	client := NewFakeKMSClient()
+	p := NewKMSKeyProvider(client, "phi")
+	dataKey := newTestKey(t)
+	wrapped, err := p.WrapKey(ctx, dataKey)
+	assert.Nil(t, err)
+
+	// previous key versions still decrypt after rotation
+	assert.Nil(t, client.Rotate("phi"))
+	wrapped2, err := p.WrapKey(ctx, dataKey)
+	assert.Nil(t, err)
+	for _, w := range [][]byte{wrapped, wrapped2} {
+		unwrapped, err2 := p.UnwrapKey(ctx, w)
+		assert.Nil(t, err2)
+		assert.Equal(t, dataKey, unwrapped)
+	}
+
+	// keys are bound to their names
+	_, err = NewKMSKeyProvider(client, "other").UnwrapKey(ctx, wrapped)
+	assert.Equal(t, ErrUnknownKey, err)
+	_, err = client.Encrypt(ctx, "other", dataKey)
+	assert.Nil(t, err)
+	_, err = NewKMSKeyProvider(client, "other").UnwrapKey(ctx, wrapped)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	_, err = p.UnwrapKey(ctx, []byte{1})
+	assert.Equal(t, ErrInvalidCiphertext, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = p.WrapKey(cancelled, dataKey)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func newTestKey(t *testing.T) []byte {
+	key, err := NewKey()
+	assert.Nil(t, err)
+	return key
+}
@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"sync"
+)
+
+// KMSClient is the interface of a key management service (e.g., Cloud KMS) encrypting and
+// decrypting small payloads with the named keys it holds. The KMS is responsible for rotating
+// the keys and decrypting payloads encrypted by their previous versions.
+type KMSClient interface {
+	// Encrypt encrypts the plaintext with the primary version of the named key.
+	Encrypt(ctx context.Context, keyName string, plaintext []byte) ([]byte, error)
+
+	// Decrypt decrypts the ciphertext with the version of the named key that encrypted it.
+	Decrypt(ctx context.Context, keyName string, ciphertext []byte) ([]byte, error)
+}
+
+// KMSKeyProvider is a KeyProvider wrapping data keys with a KMS key.
+type KMSKeyProvider struct {
+	// Client is the KMS client.
+	Client KMSClient
+
+	// KeyName is the name of the KMS key wrapping data keys.
+	KeyName string
+}
+
+// NewKMSKeyProvider creates a new *KMSKeyProvider wrapping data keys with the named key.
+func NewKMSKeyProvider(client KMSClient, keyName string) *KMSKeyProvider {
+	return &KMSKeyProvider{Client: client, KeyName: keyName}
+}
+
+// WrapKey encrypts the data key with the KMS key.
+func (p *KMSKeyProvider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	return p.Client.Encrypt(ctx, p.KeyName, key)
+}
+
+// UnwrapKey decrypts the data key with the KMS key.
+func (p *KMSKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return p.Client.Decrypt(ctx, p.KeyName, wrapped)
+}
+
+// FakeKMSClient is an in-memory KMSClient for unit tests and local development. Keys are created
+// when first used to encrypt.
+type FakeKMSClient struct {
+	keys map[string][]cipher.AEAD
+	mu   sync.Mutex
+}
+
+// NewFakeKMSClient creates a new *FakeKMSClient without any keys.
+func NewFakeKMSClient() *FakeKMSClient {
+	return &FakeKMSClient{keys: make(map[string][]cipher.AEAD)}
+}
+
+// Encrypt encrypts the plaintext with the latest version of the named key, creating the key if
+// it doesn't exist.
+func (c *FakeKMSClient) Encrypt(
+	ctx context.Context, keyName string, plaintext []byte,
+) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, in := c.keys[keyName]; !in {
+		if err := c.rotate(keyName); err != nil {
+			return nil, err
+		}
+	}
+	versions := c.keys[keyName]
+	version := make([]byte, 4)
+	binary.BigEndian.PutUint32(version, uint32(len(versions)-1))
+	return seal(versions[len(versions)-1], version, plaintext, []byte(keyName))
+}
+
+// Decrypt decrypts the ciphertext with the version of the named key that encrypted it.
+func (c *FakeKMSClient) Decrypt(
+	ctx context.Context, keyName string, ciphertext []byte,
+) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(ciphertext) < 4 {
+		return nil, ErrInvalidCiphertext
+	}
+	version := binary.BigEndian.Uint32(ciphertext[:4])
+	versions := c.keys[keyName]
+	if uint64(version) >= uint64(len(versions)) {
+		return nil, ErrUnknownKey
+	}
+	return open(versions[version], ciphertext[4:], []byte(keyName))
+}
+
+// Rotate adds a new version of the named key, creating the key if it doesn't exist. Subsequent
+// encryptions use the new version.
+func (c *FakeKMSClient) Rotate(keyName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rotate(keyName)
+}
+
+func (c *FakeKMSClient) rotate(keyName string) error {
+	key, err := NewKey()
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	c.keys[keyName] = append(c.keys[keyName], aead)
+	return nil
+}
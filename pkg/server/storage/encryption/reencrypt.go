@@ -0,0 +1,178 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/datastore"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"google.golang.org/api/iterator"
+)
+
+// DefaultReencryptBatchSize is the default number of rows ReencryptPostgres re-encrypts per
+// transaction.
+const DefaultReencryptBatchSize = 100
+
+// ErrMissingKeyColumn indicates when the row struct has no field for the key column.
+var ErrMissingKeyColumn = errors.New("row struct missing key column")
+
+// ReencryptDatastore re-encrypts the fields of the entities matching the query (see
+// ReencryptFields), each within its own transaction, returning the number of entities
+// re-encrypted. The entities are loaded into the struct pointers returned by newEntity. It may be
+// run while the entities are being read and written, and it is safe to re-run if it fails.
+func ReencryptDatastore(
+	ctx context.Context,
+	e *Encrypter,
+	client storage.DatastoreTxClient,
+	q *storage.DatastoreQuery,
+	newEntity func() interface{},
+) (int, error) {
+	keysOnly := *q
+	keysOnly.KeysOnly = true
+	iter := client.Run(ctx, &keysOnly)
+	n := 0
+	for {
+		key, err := iter.Next(nil)
+		if err == iterator.Done {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		changed := false
+		err = client.RunInTransaction(ctx, func(tx storage.DatastoreTransaction) error {
+			v := newEntity()
+			if err2 := tx.Get(key, v); err2 != nil {
+				return err2
+			}
+			var err2 error
+			if changed, err2 = e.ReencryptFields(v); err2 != nil || !changed {
+				return err2
+			}
+			_, err2 = tx.Put(key, v)
+			return err2
+		})
+		if err == datastore.ErrNoSuchEntity {
+			// deleted since the query ran
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if changed {
+			n++
+		}
+	}
+}
+
+// ReencryptPostgres re-encrypts the fields of the table's rows (see ReencryptFields) in batches
+// of the given size (or DefaultReencryptBatchSize if zero), each within its own transaction,
+// returning the number of rows re-encrypted. The rows are scanned into the struct pointers
+// returned by newRow, whose `db` tagged fields must include the key column uniquely identifying
+// rows. It may be run while the rows are being read and written, and it is safe to re-run if it
+// fails.
+func ReencryptPostgres(
+	ctx context.Context,
+	e *Encrypter,
+	q storage.Querier,
+	table string,
+	keyCol string,
+	newRow func() interface{},
+	batchSize uint64,
+) (int, error) {
+	if batchSize == 0 {
+		batchSize = DefaultReencryptBatchSize
+	}
+	cols, err := storage.Columns(newRow())
+	if err != nil {
+		return 0, err
+	}
+	keyIdx := -1
+	for i, col := range cols {
+		if col == keyCol {
+			keyIdx = i
+		}
+	}
+	if keyIdx == -1 {
+		return 0, ErrMissingKeyColumn
+	}
+	qb := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	n := 0
+	var after interface{}
+	for {
+		var batchN, batchLen int
+		var batchAfter interface{}
+		err = q.WithTx(ctx, nil, func(tx storage.Querier) error {
+			batchN, batchLen = 0, 0
+			sel := qb.Select(cols...).From(table).OrderBy(keyCol).Limit(batchSize).
+				Suffix("FOR UPDATE")
+			if after != nil {
+				sel = sel.Where(sq.Gt{keyCol: after})
+			}
+			batch, err2 := selectRows(ctx, tx, sel, newRow)
+			if err2 != nil {
+				return err2
+			}
+			batchLen = len(batch)
+			for _, v := range batch {
+				changed, err2 := e.ReencryptFields(v)
+				if err2 != nil {
+					return err2
+				}
+				_, values, err2 := storage.InsertValues(v)
+				if err2 != nil {
+					return err2
+				}
+				batchAfter = values[keyIdx]
+				if !changed {
+					continue
+				}
+				upd := qb.Update(table).Where(sq.Eq{keyCol: values[keyIdx]})
+				for i, col := range cols {
+					if i != keyIdx {
+						upd = upd.Set(col, values[i])
+					}
+				}
+				if _, err2 = tx.UpdateExecContext(ctx, upd); err2 != nil {
+					return err2
+				}
+				batchN++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += batchN
+		if uint64(batchLen) < batchSize {
+			return n, nil
+		}
+		after = batchAfter
+	}
+}
+
+// selectRows scans all of the rows selected by the query into new struct pointers.
+func selectRows(
+	ctx context.Context, q storage.Querier, sel sq.SelectBuilder, newRow func() interface{},
+) ([]interface{}, error) {
+	rows, err := q.SelectQueryContext(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	batch := make([]interface{}, 0)
+	for rows.Next() {
+		v := newRow()
+		if err := storage.ScanStruct(rows, v); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		batch = append(batch, v)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	return batch, rows.Close()
+}
@@ -0,0 +1,95 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReencryptDatastore(t *testing.T) {
+	ctx := context.Background()
+	p, r, e1 := newTestKeyring(t)
+	client := storage.NewFakeDatastoreClient()
+	for _, id := range []string{"1", "2", "3"} {
+		key := datastore.NameKey("patient", id, nil)
+		_, err := client.Put(ctx, key, e1.Entity(newTestEntity()))
+		assert.Nil(t, err)
+	}
+	_, err := client.Put(ctx, datastore.NameKey("patient", "4", nil), &testEntity{Age: 1})
+	assert.Nil(t, err)
+	q := &storage.DatastoreQuery{Kind: "patient"}
+	newEntity := func() interface{} { return &testEntity{} }
+
+	n, err := ReencryptDatastore(ctx, e1, client, q, newEntity)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	assert.Nil(t, r.Rotate(ctx, p))
+	e2, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	n, err = ReencryptDatastore(ctx, e2, client, q, newEntity)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	// once re-encrypted, the previous data key can be removed
+	assert.Nil(t, r.Remove(1))
+	e3, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	loaded := &testEntity{}
+	assert.Nil(t, client.Get(ctx, datastore.NameKey("patient", "2", nil), e3.Entity(loaded)))
+	assert.Equal(t, newTestEntity(), loaded)
+}
+
+func TestReencryptPostgres(t *testing.T) {
+	ctx := context.Background()
+	p, r, e1 := newTestKeyring(t)
+	rows := make([][]interface{}, 0)
+	for _, id := range []string{"1", "2", "3"} {
+		_, values, err := e1.InsertValues(newTestPatient(id, "notes"))
+		assert.Nil(t, err)
+		rows = append(rows, values)
+	}
+	assert.Nil(t, r.Rotate(ctx, p))
+	e2, err := NewEncrypter(ctx, p, r)
+	assert.Nil(t, err)
+	q := &storage.MockQuerier{SelectRows: &testRows{values: rows}}
+	newRow := func() interface{} { return &testPatient{} }
+
+	n, err := ReencryptPostgres(ctx, e2, q, "patient", "id", newRow, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{
+		storage.BeginEvent,
+		storage.SelectEvent,
+		storage.UpdateEvent,
+		storage.UpdateEvent,
+		storage.UpdateEvent,
+		storage.CommitEvent,
+	}, q.EventTypes())
+	assert.Equal(t, "SELECT id, name, ssn, notes, photo, age, email FROM patient "+
+		"ORDER BY id LIMIT 100 FOR UPDATE", q.Events[1].SQL)
+	assert.Equal(t, "UPDATE patient SET name = $1, ssn = $2, notes = $3, photo = $4, "+
+		"age = $5, email = $6 WHERE id = $7", q.Events[2].SQL)
+	assert.Equal(t, "1", q.Events[2].Args[6])
+	assert.Equal(t, e2.LookupString("ssn", "123-45-6789")[0], q.Events[2].Args[1])
+
+	// subsequent batches start after the last row of the previous one
+	q = &storage.MockQuerier{SelectRows: &testRows{values: rows[:2]}}
+	_, err = ReencryptPostgres(ctx, e2, q, "patient", "id", newRow, 2)
+	assert.Nil(t, err)
+	selects := make([]*storage.QuerierEvent, 0)
+	for _, event := range q.Events {
+		if event.Type == storage.SelectEvent {
+			selects = append(selects, event)
+		}
+	}
+	assert.Len(t, selects, 2)
+	assert.Contains(t, selects[1].SQL, "WHERE id > $1")
+	assert.Equal(t, []interface{}{"2"}, selects[1].Args)
+
+	_, err = ReencryptPostgres(ctx, e2, q, "patient", "key", newRow, 0)
+	assert.Equal(t, ErrMissingKeyColumn, err)
+}
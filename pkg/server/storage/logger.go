@@ -0,0 +1,21 @@
+package storage
+
+const (
+	logType      = "type"
+	logDBURL     = "db_url"
+	logQueryKind = "query_kind"
+	logQueryName = "query_name"
+	logDuration  = "duration"
+	logSQL       = "sql"
+	logNArgs     = "n_args"
+
+	logPostgres         = "postgres"
+	logMaxOpenConns     = "max_open_conns"
+	logMaxIdleConns     = "max_idle_conns"
+	logConnMaxLifetime  = "conn_max_lifetime"
+	logConnMaxIdleTime  = "conn_max_idle_time"
+	logStatementTimeout = "statement_timeout"
+	logApplicationName  = "application_name"
+	logSSLMode          = "ssl_mode"
+	logReadyTimeout     = "ready_timeout"
+)
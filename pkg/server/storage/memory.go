@@ -0,0 +1,567 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrNotFound indicates when a key is not found.
+	ErrNotFound = errors.New("not found")
+
+	// ErrTableNotFound indicates when a table does not exist.
+	ErrTableNotFound = errors.New("table not found")
+
+	// ErrTableExists indicates when creating a table that already exists.
+	ErrTableExists = errors.New("table already exists")
+
+	// ErrIndexNotFound indicates when a table index does not exist.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrTxnConflict indicates when a transaction could not be committed because another
+	// transaction committed a write to one of the same keys after it began.
+	ErrTxnConflict = errors.New("transaction conflicts with concurrent write")
+
+	// ErrTxnDone indicates when a transaction is used after it has been committed or rolled back.
+	ErrTxnDone = errors.New("transaction already committed or rolled back")
+
+	// ErrReadOnlyTxn indicates when writing within a read-only transaction.
+	ErrReadOnlyTxn = errors.New("cannot write in read-only transaction")
+)
+
+// MemoryIndex defines a secondary index on a MemoryDB table.
+type MemoryIndex struct {
+	// Name is the name of the index, unique within its table.
+	Name string
+
+	// Keys returns the index keys for the given row. A row may have zero or more index keys.
+	Keys func(key string, value []byte) []string
+}
+
+// MemoryDB is an ephemeral, concurrency-safe, in-memory store of tables of key/value rows. Rows
+// are ordered by key for range scans and may be looked up via secondary indexes. Each transaction
+// reads from a consistent snapshot as of when it began (i.e., snapshot isolation), and a
+// transaction's writes conflict with any other writes to the same keys committed after it began.
+type MemoryDB struct {
+	tables map[string]*memoryTable
+	lastTS uint64
+	active map[uint64]int
+	mu     sync.RWMutex
+}
+
+// NewMemoryDB creates a new, empty *MemoryDB.
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		tables: make(map[string]*memoryTable),
+		active: make(map[uint64]int),
+	}
+}
+
+// CreateTable creates a new table with the given secondary indexes.
+func (db *MemoryDB) CreateTable(name string, indexes ...*MemoryIndex) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, in := db.tables[name]; in {
+		return ErrTableExists
+	}
+	t := &memoryTable{
+		rows:    make(map[string][]*memoryVersion),
+		stale:   make(map[string]struct{}),
+		indexes: make(map[string]*memoryIndex),
+	}
+	for _, idx := range indexes {
+		t.indexes[idx.Name] = &memoryIndex{MemoryIndex: idx}
+	}
+	db.tables[name] = t
+	return nil
+}
+
+// Begin starts a new transaction reading from a snapshot of the current state. Writable
+// transactions must be finished via Commit or Rollback, and read-only transactions via Rollback.
+func (db *MemoryDB) Begin(writable bool) *MemoryTxn {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.active[db.lastTS]++
+	return &MemoryTxn{
+		db:       db,
+		readTS:   db.lastTS,
+		writable: writable,
+		writes:   make(map[string]map[string]*memoryVersion),
+	}
+}
+
+// View runs the given function within a read-only transaction.
+func (db *MemoryDB) View(fn func(tx *MemoryTxn) error) error {
+	tx := db.Begin(false)
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// Update runs the given function within a writable transaction, committing it if the function
+// returns no error and rolling it back otherwise. It returns ErrTxnConflict if the transaction
+// conflicts with another committed concurrently, in which case the caller may retry.
+func (db *MemoryDB) Update(fn func(tx *MemoryTxn) error) error {
+	tx := db.Begin(true)
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MemoryTxn is a transaction on a MemoryDB. It is not safe for concurrent use.
+type MemoryTxn struct {
+	db       *MemoryDB
+	readTS   uint64
+	writable bool
+	done     bool
+	writes   map[string]map[string]*memoryVersion
+}
+
+// Get returns the value for the given key in the table, or ErrNotFound if it doesn't exist.
+func (tx *MemoryTxn) Get(table, key string) ([]byte, error) {
+	if tx.done {
+		return nil, ErrTxnDone
+	}
+	tx.db.mu.RLock()
+	defer tx.db.mu.RUnlock()
+	t, in := tx.db.tables[table]
+	if !in {
+		return nil, ErrTableNotFound
+	}
+	v := tx.visible(table, t, key)
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	return copyBytes(v.value), nil
+}
+
+// Put sets the value for the given key in the table.
+func (tx *MemoryTxn) Put(table, key string, value []byte) error {
+	return tx.write(table, key, &memoryVersion{value: copyBytes(value)})
+}
+
+// Delete removes the given key from the table. Deleting a key that doesn't exist is a no-op.
+func (tx *MemoryTxn) Delete(table, key string) error {
+	return tx.write(table, key, &memoryVersion{deleted: true})
+}
+
+// Scan calls the given function on each row in the table with key in the range [start, end),
+// ordered by key, until it returns false. An empty end indicates no upper bound.
+func (tx *MemoryTxn) Scan(
+	table, start, end string, fn func(key string, value []byte) bool,
+) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.db.mu.RLock()
+	t, in := tx.db.tables[table]
+	if !in {
+		tx.db.mu.RUnlock()
+		return ErrTableNotFound
+	}
+	keys := mergeKeys(keysInRange(t.keys, start, end),
+		pendingKeysInRange(tx.writes[table], start, end))
+	rows := make([]*memoryRow, 0, len(keys))
+	for _, key := range keys {
+		if v := tx.visible(table, t, key); v != nil {
+			rows = append(rows, &memoryRow{key: key, value: copyBytes(v.value)})
+		}
+	}
+	tx.db.mu.RUnlock()
+
+	for _, row := range rows {
+		if !fn(row.key, row.value) {
+			break
+		}
+	}
+	return nil
+}
+
+// IndexScan calls the given function on each row in the table with an index key in the range
+// [start, end), ordered by index key and then row key, until it returns false. An empty end
+// indicates no upper bound.
+func (tx *MemoryTxn) IndexScan(
+	table, index, start, end string, fn func(key string, value []byte) bool,
+) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.db.mu.RLock()
+	t, in := tx.db.tables[table]
+	if !in {
+		tx.db.mu.RUnlock()
+		return ErrTableNotFound
+	}
+	idx, in := t.indexes[index]
+	if !in {
+		tx.db.mu.RUnlock()
+		return ErrIndexNotFound
+	}
+
+	// candidates may be stale, so confirm each against the row visible to this txn
+	candidates := idx.entriesInRange(start, end)
+	for key, v := range tx.writes[table] {
+		if !v.deleted {
+			for _, ikey := range idx.Keys(key, v.value) {
+				if inRange(ikey, start, end) {
+					candidates = append(candidates, memoryIndexEntry{ikey: ikey, key: key})
+				}
+			}
+		}
+	}
+	sortIndexEntries(candidates)
+	rows := make([]*memoryRow, 0, len(candidates))
+	for i, e := range candidates {
+		if i > 0 && e == candidates[i-1] {
+			continue
+		}
+		v := tx.visible(table, t, e.key)
+		if v != nil && contains(idx.Keys(e.key, v.value), e.ikey) {
+			rows = append(rows, &memoryRow{key: e.key, value: copyBytes(v.value)})
+		}
+	}
+	tx.db.mu.RUnlock()
+
+	for _, row := range rows {
+		if !fn(row.key, row.value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Commit atomically applies the transaction's writes. It returns ErrTxnConflict if another
+// transaction committed a write to one of the same keys after this transaction began, in which
+// case none of the writes are applied.
+func (tx *MemoryTxn) Commit() error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	if !tx.writable {
+		tx.Rollback()
+		return ErrReadOnlyTxn
+	}
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tx.finish()
+	for table, writes := range tx.writes {
+		t := db.tables[table]
+		for key := range writes {
+			if versions := t.rows[key]; len(versions) > 0 &&
+				versions[len(versions)-1].ts > tx.readTS {
+				return ErrTxnConflict
+			}
+		}
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	db.lastTS++
+	minActiveTS := db.minActiveTS()
+	for table, writes := range tx.writes {
+		t := db.tables[table]
+		for key, v := range writes {
+			v.ts = db.lastTS
+			t.put(key, v)
+			t.prune(key, minActiveTS)
+		}
+	}
+	return nil
+}
+
+// Rollback discards the transaction's writes. Rolling back a finished transaction is a no-op.
+func (tx *MemoryTxn) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.finish()
+}
+
+// finish marks the transaction as done, pruning the versions only its snapshot could see. The
+// caller must hold the DB write lock.
+func (tx *MemoryTxn) finish() {
+	tx.done = true
+	db := tx.db
+	db.active[tx.readTS]--
+	if db.active[tx.readTS] > 0 {
+		return
+	}
+	delete(db.active, tx.readTS)
+	minActiveTS := db.minActiveTS()
+	if tx.readTS >= minActiveTS {
+		// an older snapshot is still active, so nothing more can be pruned
+		return
+	}
+	for _, t := range db.tables {
+		for key := range t.stale {
+			t.prune(key, minActiveTS)
+		}
+	}
+}
+
+func (tx *MemoryTxn) write(table, key string, v *memoryVersion) error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	if !tx.writable {
+		return ErrReadOnlyTxn
+	}
+	tx.db.mu.RLock()
+	_, in := tx.db.tables[table]
+	tx.db.mu.RUnlock()
+	if !in {
+		return ErrTableNotFound
+	}
+	if _, in := tx.writes[table]; !in {
+		tx.writes[table] = make(map[string]*memoryVersion)
+	}
+	tx.writes[table][key] = v
+	return nil
+}
+
+// visible returns the (non-deleted) version of the row visible to the transaction or nil if none
+// exists. The caller must hold the DB read lock.
+func (tx *MemoryTxn) visible(table string, t *memoryTable, key string) *memoryVersion {
+	if v, in := tx.writes[table][key]; in {
+		if v.deleted {
+			return nil
+		}
+		return v
+	}
+	versions := t.rows[key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].ts <= tx.readTS {
+			if versions[i].deleted {
+				return nil
+			}
+			return versions[i]
+		}
+	}
+	return nil
+}
+
+// minActiveTS returns the snapshot timestamp of the oldest active transaction or the last commit
+// timestamp if there are no active transactions. The caller must hold the DB lock.
+func (db *MemoryDB) minActiveTS() uint64 {
+	minTS := db.lastTS
+	for ts := range db.active {
+		if ts < minTS {
+			minTS = ts
+		}
+	}
+	return minTS
+}
+
+type memoryVersion struct {
+	ts      uint64
+	value   []byte
+	deleted bool
+}
+
+type memoryRow struct {
+	key   string
+	value []byte
+}
+
+type memoryTable struct {
+	rows    map[string][]*memoryVersion
+	keys    []string
+	indexes map[string]*memoryIndex
+
+	// stale contains the keys of rows with old versions or tombstones still visible to active
+	// transactions, which are pruned once those transactions finish.
+	stale map[string]struct{}
+}
+
+func (t *memoryTable) put(key string, v *memoryVersion) {
+	if _, in := t.rows[key]; !in {
+		i := sort.SearchStrings(t.keys, key)
+		t.keys = append(t.keys, "")
+		copy(t.keys[i+1:], t.keys[i:])
+		t.keys[i] = key
+	}
+	t.rows[key] = append(t.rows[key], v)
+	if !v.deleted {
+		for _, idx := range t.indexes {
+			for _, ikey := range idx.Keys(key, v.value) {
+				idx.insert(memoryIndexEntry{ikey: ikey, key: key})
+			}
+		}
+	}
+}
+
+// prune removes versions of the row no longer visible to any active or future transaction along
+// with their stale index entries. Tombstones are removed once no transaction can see the versions
+// before them.
+func (t *memoryTable) prune(key string, minActiveTS uint64) {
+	versions := t.rows[key]
+	first := 0
+	for i, v := range versions {
+		if v.ts <= minActiveTS {
+			first = i
+		}
+	}
+	if first < len(versions) && versions[first].deleted && versions[first].ts <= minActiveTS {
+		// no version is visible to the oldest snapshot, which a missing version also indicates
+		first++
+	}
+	pruned, kept := versions[:first], versions[first:]
+	if len(kept) > 1 || (len(kept) == 1 && kept[0].deleted) {
+		t.stale[key] = struct{}{}
+	} else {
+		delete(t.stale, key)
+	}
+	if len(pruned) == 0 {
+		return
+	}
+	for _, idx := range t.indexes {
+		keptIKeys := make(map[string]struct{})
+		for _, v := range kept {
+			if !v.deleted {
+				for _, ikey := range idx.Keys(key, v.value) {
+					keptIKeys[ikey] = struct{}{}
+				}
+			}
+		}
+		for _, v := range pruned {
+			if v.deleted {
+				continue
+			}
+			for _, ikey := range idx.Keys(key, v.value) {
+				if _, in := keptIKeys[ikey]; !in {
+					idx.remove(memoryIndexEntry{ikey: ikey, key: key})
+				}
+			}
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.rows, key)
+		i := sort.SearchStrings(t.keys, key)
+		t.keys = append(t.keys[:i], t.keys[i+1:]...)
+		return
+	}
+	t.rows[key] = append([]*memoryVersion(nil), kept...)
+}
+
+type memoryIndexEntry struct {
+	ikey string
+	key  string
+}
+
+func (e memoryIndexEntry) less(other memoryIndexEntry) bool {
+	if e.ikey != other.ikey {
+		return e.ikey < other.ikey
+	}
+	return e.key < other.key
+}
+
+type memoryIndex struct {
+	*MemoryIndex
+	entries []memoryIndexEntry
+}
+
+func (idx *memoryIndex) search(e memoryIndexEntry) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		return !idx.entries[i].less(e)
+	})
+}
+
+func (idx *memoryIndex) insert(e memoryIndexEntry) {
+	i := idx.search(e)
+	if i < len(idx.entries) && idx.entries[i] == e {
+		return
+	}
+	idx.entries = append(idx.entries, memoryIndexEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = e
+}
+
+func (idx *memoryIndex) remove(e memoryIndexEntry) {
+	i := idx.search(e)
+	if i < len(idx.entries) && idx.entries[i] == e {
+		idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+	}
+}
+
+func (idx *memoryIndex) entriesInRange(start, end string) []memoryIndexEntry {
+	i := idx.search(memoryIndexEntry{ikey: start})
+	entries := make([]memoryIndexEntry, 0)
+	for ; i < len(idx.entries) && inRange(idx.entries[i].ikey, start, end); i++ {
+		entries = append(entries, idx.entries[i])
+	}
+	return entries
+}
+
+func sortIndexEntries(entries []memoryIndexEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].less(entries[j]) })
+}
+
+func keysInRange(sorted []string, start, end string) []string {
+	i := sort.SearchStrings(sorted, start)
+	j := len(sorted)
+	if end != "" {
+		j = sort.SearchStrings(sorted, end)
+	}
+	if j < i {
+		return nil
+	}
+	return sorted[i:j]
+}
+
+func pendingKeysInRange(writes map[string]*memoryVersion, start, end string) []string {
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		if inRange(key, start, end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mergeKeys merges two sorted key slices into a single sorted slice without duplicates.
+func mergeKeys(keys1, keys2 []string) []string {
+	merged := make([]string, 0, len(keys1)+len(keys2))
+	i, j := 0, 0
+	for i < len(keys1) || j < len(keys2) {
+		switch {
+		case j == len(keys2) || (i < len(keys1) && keys1[i] < keys2[j]):
+			merged = append(merged, keys1[i])
+			i++
+		case i == len(keys1) || keys2[j] < keys1[i]:
+			merged = append(merged, keys2[j])
+			j++
+		default:
+			merged = append(merged, keys1[i])
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+func inRange(key, start, end string) bool {
+	return key >= start && (end == "" || key < end)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func copyBytes(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sort"
+	"strings"
+)
+
+type memoryStorer struct {
+	db    *MemoryDB
+	table string
+	p     *Paginator
+}
+
+// NewMemoryStorer returns a Storer backed by the given table of the MemoryDB, creating the table if
+// it doesn't already exist. Query page tokens are signed and verified by the Paginator.
+func NewMemoryStorer(db *MemoryDB, table string, p *Paginator) (Storer, error) {
+	if err := db.CreateTable(table); err != nil && err != ErrTableExists {
+		return nil, err
+	}
+	return &memoryStorer{db: db, table: table, p: p}, nil
+}
+
+func (s *memoryStorer) Put(ctx context.Context, r *Record) error {
+	normalized, err := normalizeRecord(r)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(normalized.Fields); err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *MemoryTxn) error {
+		return tx.Put(s.table, r.Key, buf.Bytes())
+	})
+}
+
+func (s *memoryStorer) Get(ctx context.Context, key string) (*Record, error) {
+	rs, err := s.GetMulti(ctx, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if rs[0] == nil {
+		return nil, ErrNotFound
+	}
+	return rs[0], nil
+}
+
+func (s *memoryStorer) GetMulti(ctx context.Context, keys []string) ([]*Record, error) {
+	rs := make([]*Record, len(keys))
+	err := s.db.View(func(tx *MemoryTxn) error {
+		for i, key := range keys {
+			value, err := tx.Get(s.table, key)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if rs[i], err = decodeMemoryRecord(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (s *memoryStorer) Delete(ctx context.Context, keys ...string) error {
+	return s.update(ctx, func(tx *MemoryTxn) error {
+		for _, key := range keys {
+			if err := tx.Delete(s.table, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *memoryStorer) Query(ctx context.Context, q *Query) (*QueryResult, error) {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	scope := storerPageScope("memory:"+s.table, q)
+	var after *pageCursor
+	if q.PageToken != "" {
+		if after, err = s.p.decodePageCursor(q.PageToken, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	rs := make([]*Record, 0)
+	err = s.db.View(func(tx *MemoryTxn) error {
+		var decodeErr error
+		scanErr := tx.Scan(s.table, "", "", func(key string, value []byte) bool {
+			var r *Record
+			if r, decodeErr = decodeMemoryRecord(key, value); decodeErr != nil {
+				return false
+			}
+			if matchesQuery(r, q) && isAfter(r, q.Order, after) {
+				rs = append(rs, r)
+			}
+			return true
+		})
+		if scanErr != nil {
+			return scanErr
+		}
+		return decodeErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return compareRecords(rs[i], rs[j], q.Order) < 0
+	})
+
+	return newPagedResult(rs, q, s.p, scope)
+}
+
+// update runs the function within a writable transaction, retrying it when it conflicts with
+// another committed concurrently. Since the Storer's writes don't depend on what they read, the
+// last writer wins, as with the other backends.
+func (s *memoryStorer) update(ctx context.Context, fn func(tx *MemoryTxn) error) error {
+	for {
+		err := s.db.Update(fn)
+		if err != ErrTxnConflict {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeMemoryRecord(key string, value []byte) (*Record, error) {
+	r := &Record{Key: key}
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&r.Fields); err != nil {
+		return nil, err
+	}
+	if r.Fields == nil {
+		r.Fields = make(map[string]interface{})
+	}
+	return r, nil
+}
+
+// matchesQuery returns whether the record matches all of the query filters and has the query
+// order field.
+func matchesQuery(r *Record, q *Query) bool {
+	for _, f := range q.Filters {
+		value, in := r.Fields[f.Field]
+		if !in || !f.matches(value) {
+			return false
+		}
+	}
+	if q.Order != nil {
+		if _, in := r.Fields[q.Order.Field]; !in {
+			return false
+		}
+	}
+	return true
+}
+
+// compareRecords compares two records by the given order (or by key if nil).
+func compareRecords(r1, r2 *Record, o *Order) int {
+	cmp := 0
+	if o != nil {
+		cmp, _ = compareValues(r1.Fields[o.Field], r2.Fields[o.Field])
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(r1.Key, r2.Key)
+	}
+	if o != nil && o.Descending {
+		return -cmp
+	}
+	return cmp
+}
+
+// isAfter returns whether the record comes after the given page cursor in the given order.
+func isAfter(r *Record, o *Order, after *pageCursor) bool {
+	if after == nil {
+		return true
+	}
+	cursor := &Record{Key: after.Key}
+	if o != nil {
+		cursor.Fields = map[string]interface{}{o.Field: after.Value}
+	}
+	return compareRecords(r, cursor, o) > 0
+}
@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTable = "people"
+	testIndex = "by_city"
+)
+
+// byCity indexes values of the form "<name>,<city>" by city.
+var byCity = &MemoryIndex{
+	Name: testIndex,
+	Keys: func(key string, value []byte) []string {
+		parts := strings.Split(string(value), ",")
+		if len(parts) < 2 || parts[1] == "" {
+			return nil
+		}
+		return []string{parts[1]}
+	},
+}
+
+func newTestMemoryDB(t *testing.T) *MemoryDB {
+	db := NewMemoryDB()
+	err := db.CreateTable(testTable, byCity)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestMemoryDB_CreateTable(t *testing.T) {
+	db := newTestMemoryDB(t)
+	err := db.CreateTable(testTable)
+	assert.Equal(t, ErrTableExists, err)
+
+	err = db.Update(func(tx *MemoryTxn) error {
+		return tx.Put("missing", "k", []byte("v"))
+	})
+	assert.Equal(t, ErrTableNotFound, err)
+
+	err = db.View(func(tx *MemoryTxn) error {
+		_, err2 := tx.Get("missing", "k")
+		return err2
+	})
+	assert.Equal(t, ErrTableNotFound, err)
+}
+
+func TestMemoryTxn_PutGetDelete(t *testing.T) {
+	db := newTestMemoryDB(t)
+	value := []byte("alice,nyc")
+	err := db.Update(func(tx *MemoryTxn) error {
+		return tx.Put(testTable, "a", value)
+	})
+	assert.Nil(t, err)
+
+	// stored value is not affected by mutating the original
+	value[0] = 'X'
+	err = db.View(func(tx *MemoryTxn) error {
+		got, err2 := tx.Get(testTable, "a")
+		assert.Nil(t, err2)
+		assert.Equal(t, []byte("alice,nyc"), got)
+
+		_, err2 = tx.Get(testTable, "b")
+		assert.Equal(t, ErrNotFound, err2)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.Update(func(tx *MemoryTxn) error {
+		if err2 := tx.Delete(testTable, "a"); err2 != nil {
+			return err2
+		}
+		// reads within a txn see its own writes
+		_, err2 := tx.Get(testTable, "a")
+		assert.Equal(t, ErrNotFound, err2)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.View(func(tx *MemoryTxn) error {
+		_, err2 := tx.Get(testTable, "a")
+		return err2
+	})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemoryTxn_readOnly(t *testing.T) {
+	db := newTestMemoryDB(t)
+	err := db.View(func(tx *MemoryTxn) error {
+		return tx.Put(testTable, "a", []byte("alice,nyc"))
+	})
+	assert.Equal(t, ErrReadOnlyTxn, err)
+
+	tx := db.Begin(false)
+	assert.Equal(t, ErrReadOnlyTxn, tx.Commit())
+}
+
+func TestMemoryTxn_done(t *testing.T) {
+	db := newTestMemoryDB(t)
+	tx := db.Begin(true)
+	assert.Nil(t, tx.Commit())
+
+	assert.Equal(t, ErrTxnDone, tx.Commit())
+	assert.Equal(t, ErrTxnDone, tx.Put(testTable, "a", nil))
+	_, err := tx.Get(testTable, "a")
+	assert.Equal(t, ErrTxnDone, err)
+	tx.Rollback() // no-op
+
+	tx = db.Begin(true)
+	assert.Nil(t, tx.Put(testTable, "a", []byte("alice,nyc")))
+	tx.Rollback()
+	err = db.View(func(tx *MemoryTxn) error {
+		_, err2 := tx.Get(testTable, "a")
+		return err2
+	})
+	assert.Equal(t, ErrNotFound, err)
+	assert.Empty(t, db.active)
+}
+
+func TestMemoryTxn_Scan(t *testing.T) {
+	db := newTestMemoryDB(t)
+	err := db.Update(func(tx *MemoryTxn) error {
+		for _, key := range []string{"d", "b", "a", "e", "c"} {
+			if err2 := tx.Put(testTable, key, []byte(key)); err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	cases := map[string]struct {
+		start, end string
+		limit      int
+		expected   []string
+	}{
+		"all":       {expected: []string{"a", "b", "c", "d", "e"}},
+		"bounded":   {start: "b", end: "d", expected: []string{"b", "c"}},
+		"unbounded": {start: "c", expected: []string{"c", "d", "e"}},
+		"limited":   {limit: 2, expected: []string{"a", "b"}},
+		"empty":     {start: "x", expected: []string{}},
+	}
+	for desc, c := range cases {
+		keys := []string{}
+		err = db.View(func(tx *MemoryTxn) error {
+			return tx.Scan(testTable, c.start, c.end, func(key string, value []byte) bool {
+				assert.Equal(t, key, string(value))
+				keys = append(keys, key)
+				return c.limit == 0 || len(keys) < c.limit
+			})
+		})
+		assert.Nil(t, err, desc)
+		assert.Equal(t, c.expected, keys, desc)
+	}
+
+	// scans within a txn merge its own writes
+	keys := []string{}
+	err = db.Update(func(tx *MemoryTxn) error {
+		assert.Nil(t, tx.Delete(testTable, "b"))
+		assert.Nil(t, tx.Put(testTable, "bb", []byte("bb")))
+		return tx.Scan(testTable, "", "d", func(key string, value []byte) bool {
+			keys = append(keys, key)
+			return true
+		})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "bb", "c"}, keys)
+}
+
+func TestMemoryTxn_IndexScan(t *testing.T) {
+	db := newTestMemoryDB(t)
+	rows := map[string]string{
+		"a": "alice,nyc",
+		"b": "bob,sf",
+		"c": "carol,nyc",
+		"d": "dave,la",
+		"e": "eve,",
+	}
+	err := db.Update(func(tx *MemoryTxn) error {
+		for key, value := range rows {
+			if err2 := tx.Put(testTable, key, []byte(value)); err2 != nil {
+				return err2
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+
+	scan := func(tx *MemoryTxn, start, end string) []string {
+		keys := []string{}
+		err2 := tx.IndexScan(testTable, testIndex, start, end,
+			func(key string, value []byte) bool {
+				keys = append(keys, key)
+				return true
+			})
+		assert.Nil(t, err2)
+		return keys
+	}
+	err = db.View(func(tx *MemoryTxn) error {
+		assert.Equal(t, []string{"d", "a", "c", "b"}, scan(tx, "", ""))
+		assert.Equal(t, []string{"a", "c"}, scan(tx, "nyc", "nyc\x00"))
+		assert.Equal(t, []string{"d", "a", "c"}, scan(tx, "a", "s"))
+		return tx.IndexScan(testTable, "missing", "", "", nil)
+	})
+	assert.Equal(t, ErrIndexNotFound, err)
+
+	// moving a row to a different index key removes it from the old one
+	err = db.Update(func(tx *MemoryTxn) error {
+		if err2 := tx.Put(testTable, "a", []byte("alice,sf")); err2 != nil {
+			return err2
+		}
+		assert.Equal(t, []string{"c"}, scan(tx, "nyc", "nyc\x00"))
+		assert.Equal(t, []string{"a", "b"}, scan(tx, "sf", "sf\x00"))
+		return nil
+	})
+	assert.Nil(t, err)
+	err = db.View(func(tx *MemoryTxn) error {
+		assert.Equal(t, []string{"c"}, scan(tx, "nyc", "nyc\x00"))
+		assert.Equal(t, []string{"a", "b"}, scan(tx, "sf", "sf\x00"))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// stale index entries are pruned once no txn can see them
+	idx := db.tables[testTable].indexes[testIndex]
+	assert.Equal(t, 4, len(idx.entries))
+}
+
+func TestMemoryTxn_snapshotIsolation(t *testing.T) {
+	db := newTestMemoryDB(t)
+	err := db.Update(func(tx *MemoryTxn) error {
+		return tx.Put(testTable, "a", []byte("alice,nyc"))
+	})
+	assert.Nil(t, err)
+
+	snapshot := db.Begin(false)
+	defer snapshot.Rollback()
+
+	err = db.Update(func(tx *MemoryTxn) error {
+		assert.Nil(t, tx.Put(testTable, "a", []byte("alice,sf")))
+		assert.Nil(t, tx.Put(testTable, "b", []byte("bob,sf")))
+		return nil
+	})
+	assert.Nil(t, err)
+	err = db.Update(func(tx *MemoryTxn) error {
+		return tx.Delete(testTable, "a")
+	})
+	assert.Nil(t, err)
+
+	// snapshot still sees the state as of when it began
+	value, err := snapshot.Get(testTable, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice,nyc"), value)
+	_, err = snapshot.Get(testTable, "b")
+	assert.Equal(t, ErrNotFound, err)
+	keys := []string{}
+	err = snapshot.IndexScan(testTable, testIndex, "", "", func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+
+	// old versions, tombstones, and index entries are pruned once the snapshot finishes
+	tbl := db.tables[testTable]
+	assert.Len(t, tbl.rows["a"], 3)
+	assert.Len(t, tbl.stale, 1)
+	snapshot.Rollback()
+	_, in := tbl.rows["a"]
+	assert.False(t, in)
+	assert.Equal(t, []string{"b"}, tbl.keys)
+	assert.Equal(t, []memoryIndexEntry{{ikey: "sf", key: "b"}},
+		tbl.indexes[testIndex].entries)
+	assert.Len(t, tbl.stale, 0)
+
+	// as are overwritten versions of rows never written again
+	snapshot = db.Begin(false)
+	for _, value := range []string{"bob,la", "bob,nyc"} {
+		err = db.Update(func(tx *MemoryTxn) error {
+			return tx.Put(testTable, "b", []byte(value))
+		})
+		assert.Nil(t, err)
+	}
+	assert.Len(t, tbl.rows["b"], 3)
+	snapshot.Rollback()
+	assert.Len(t, tbl.rows["b"], 1)
+	assert.Equal(t, []memoryIndexEntry{{ikey: "nyc", key: "b"}},
+		tbl.indexes[testIndex].entries)
+	assert.Len(t, tbl.stale, 0)
+}
+
+func TestMemoryTxn_Commit_conflict(t *testing.T) {
+	db := newTestMemoryDB(t)
+	tx1, tx2 := db.Begin(true), db.Begin(true)
+	assert.Nil(t, tx1.Put(testTable, "a", []byte("alice,nyc")))
+	assert.Nil(t, tx2.Put(testTable, "a", []byte("alice,sf")))
+	assert.Nil(t, tx2.Put(testTable, "b", []byte("bob,sf")))
+
+	assert.Nil(t, tx1.Commit())
+	assert.Equal(t, ErrTxnConflict, tx2.Commit())
+
+	// none of the conflicting txn's writes are applied
+	err := db.View(func(tx *MemoryTxn) error {
+		value, err2 := tx.Get(testTable, "a")
+		assert.Nil(t, err2)
+		assert.Equal(t, []byte("alice,nyc"), value)
+		_, err2 = tx.Get(testTable, "b")
+		assert.Equal(t, ErrNotFound, err2)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, db.active)
+}
+
+func TestMemoryDB_Update_concurrent(t *testing.T) {
+	db := newTestMemoryDB(t)
+	nWorkers, nIncrements := 8, 25
+	increment := func(tx *MemoryTxn) error {
+		value, err := tx.Get(testTable, "counter")
+		count := 0
+		if err == nil {
+			_, err = fmt.Sscanf(string(value), "%d", &count)
+		}
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		return tx.Put(testTable, "counter", []byte(fmt.Sprintf("%d", count+1)))
+	}
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < nIncrements; j++ {
+				err := ErrTxnConflict
+				for err == ErrTxnConflict {
+					err = db.Update(increment)
+				}
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := db.View(func(tx *MemoryTxn) error {
+		value, err2 := tx.Get(testTable, "counter")
+		assert.Nil(t, err2)
+		assert.Equal(t, fmt.Sprintf("%d", nWorkers*nIncrements), string(value))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.tables[testTable].rows["counter"]))
+}
@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mattes/migrate/source"
+)
+
+// migration lint rules
+const (
+	// LintRuleMissingDown flags an up migration without a down migration.
+	LintRuleMissingDown = "missing-down"
+
+	// LintRuleMissingUp flags a down migration without an up migration.
+	LintRuleMissingUp = "missing-up"
+
+	// LintRuleVersionGap flags a gap in sequential version numbering.
+	LintRuleVersionGap = "version-gap"
+
+	// LintRuleEmpty flags a migration without any statements.
+	LintRuleEmpty = "empty"
+
+	// LintRuleIndexNotConcurrent flags an index build on an existing table without
+	// CONCURRENTLY, which blocks writes to the table for the duration of the build.
+	LintRuleIndexNotConcurrent = "index-not-concurrent"
+
+	// LintRuleDropColumn flags an up migration dropping a column, which breaks replicas still
+	// running the previous version.
+	LintRuleDropColumn = "drop-column"
+
+	// LintRuleDropTable flags an up migration dropping a table.
+	LintRuleDropTable = "drop-table"
+
+	// LintRuleNotNullWithoutDefault flags adding a NOT NULL column without a default to an
+	// existing table, which fails if the table has any rows.
+	LintRuleNotNullWithoutDefault = "not-null-without-default"
+
+	// LintRuleSetNotNull flags setting NOT NULL on an existing column, which scans the whole
+	// table while holding an exclusive lock.
+	LintRuleSetNotNull = "set-not-null"
+
+	// LintRuleAlterColumnType flags changing a column type, which may rewrite the whole table
+	// while holding an exclusive lock.
+	LintRuleAlterColumnType = "alter-column-type"
+
+	// LintRuleRename flags renaming a table or column, which breaks replicas still running the
+	// previous version.
+	LintRuleRename = "rename"
+
+	// LintRuleConstraintNotValid flags adding a foreign key or check constraint to an existing
+	// table without NOT VALID, which validates all rows while holding a lock.
+	LintRuleConstraintNotValid = "constraint-not-valid"
+
+	// timestamp versions (e.g., 20180101120000) aren't expected to be sequential
+	minTimestampVersion = 10000000
+)
+
+var (
+	lintIgnoreRegex   = regexp.MustCompile(`--\s*lint:ignore\s+([\w\-, ]+)`)
+	lineCommentRegex  = regexp.MustCompile(`--[^\n]*`)
+	blockCommentRegex = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	whitespaceRegex   = regexp.MustCompile(`\s+`)
+	createTableRegex  = regexp.MustCompile(`^CREATE TABLE (IF NOT EXISTS )?([\w."]+)`)
+	alterTableRegex   = regexp.MustCompile(`^ALTER TABLE (IF EXISTS )?(ONLY )?([\w."]+) `)
+	createIndexRegex  = regexp.MustCompile(
+		`^CREATE (UNIQUE )?INDEX (.* )?ON (ONLY )?([\w."]+)`)
+	addColumnRegex      = regexp.MustCompile(`^ADD (COLUMN )?(IF NOT EXISTS )?[\w"]+ `)
+	addConstraintRegex  = regexp.MustCompile(`^ADD (CONSTRAINT [\w"]+ )?(FOREIGN KEY|CHECK)\b`)
+	dropColumnRegex     = regexp.MustCompile(`^DROP (COLUMN )?(IF EXISTS )?[\w"]+`)
+	setNotNullRegex     = regexp.MustCompile(`\bALTER (COLUMN )?[\w"]+ SET NOT NULL\b`)
+	alterTypeRegex      = regexp.MustCompile(`\bALTER (COLUMN )?[\w"]+ (SET DATA )?TYPE\b`)
+	renameRegex         = regexp.MustCompile(`\bRENAME (COLUMN |TO |[\w"]+ TO )`)
+	dropConstraintRegex = regexp.MustCompile(`^DROP CONSTRAINT\b`)
+)
+
+// LintFinding is a potential problem with a migration.
+type LintFinding struct {
+	// Version is the migration version.
+	Version uint `json:"version"`
+
+	// Identifier is the migration name.
+	Identifier string `json:"identifier,omitempty"`
+
+	// Direction is the direction (up or down) of the migration file, if the finding applies to
+	// a single file.
+	Direction string `json:"direction,omitempty"`
+
+	// Rule is the name of the lint rule.
+	Rule string `json:"rule"`
+
+	// Message describes the problem.
+	Message string `json:"message"`
+
+	// Statement is the (normalized) offending statement, if any.
+	Statement string `json:"statement,omitempty"`
+}
+
+// lintMigrations returns the findings for the migrations in the given source.
+func lintMigrations(d source.Driver) ([]*LintFinding, error) {
+	findings := make([]*LintFinding, 0)
+	version, err := d.First()
+	var prev uint
+	for err == nil {
+		if prev != 0 && version < minTimestampVersion && version != prev+1 {
+			findings = append(findings, &LintFinding{
+				Version: version,
+				Rule:    LintRuleVersionGap,
+				Message: fmt.Sprintf("version %d follows version %d", version, prev),
+			})
+		}
+		vFindings, err2 := lintVersion(d, version)
+		if err2 != nil {
+			return nil, err2
+		}
+		findings = append(findings, vFindings...)
+		prev = version
+		version, err = d.Next(version)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return findings, nil
+}
+
+func lintVersion(d source.Driver, version uint) ([]*LintFinding, error) {
+	upSQL, upID, err := readMigrationSQL(d.ReadUp, version)
+	if err != nil {
+		return nil, err
+	}
+	downSQL, downID, err := readMigrationSQL(d.ReadDown, version)
+	if err != nil {
+		return nil, err
+	}
+	if upSQL == nil {
+		return []*LintFinding{{
+			Version:    version,
+			Identifier: downID,
+			Rule:       LintRuleMissingUp,
+			Message:    "down migration has no matching up migration",
+		}}, nil
+	}
+	findings := lintSQL(version, upID, source.Up, *upSQL)
+	if downSQL == nil {
+		return append(findings, &LintFinding{
+			Version:    version,
+			Identifier: upID,
+			Rule:       LintRuleMissingDown,
+			Message:    "up migration has no matching down migration",
+		}), nil
+	}
+	return append(findings, lintSQL(version, downID, source.Down, *downSQL)...), nil
+}
+
+// readMigrationSQL returns the SQL and identifier of the migration read by the given function or
+// nil SQL if there is no such migration.
+func readMigrationSQL(
+	read func(version uint) (io.ReadCloser, string, error), version uint,
+) (*string, string, error) {
+	r, identifier, err := read(version)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = r.Close() }()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	sql := string(buf)
+	return &sql, identifier, nil
+}
+
+// lintSQL returns the findings for the statements of a single migration file. Statements are
+// split on semicolons, so those within string literals or function bodies may cause spurious
+// findings, which can be suppressed with a "-- lint:ignore rule[,rule...]" comment anywhere in
+// the file.
+func lintSQL(
+	version uint, identifier string, direction source.Direction, sql string,
+) []*LintFinding {
+	ignored := make(map[string]struct{})
+	for _, match := range lintIgnoreRegex.FindAllStringSubmatch(sql, -1) {
+		for _, rule := range strings.Split(match[1], ",") {
+			ignored[strings.TrimSpace(rule)] = struct{}{}
+		}
+	}
+	findings := make([]*LintFinding, 0)
+	add := func(rule, msg, stmt string) {
+		if _, in := ignored[rule]; in {
+			return
+		}
+		findings = append(findings, &LintFinding{
+			Version:    version,
+			Identifier: identifier,
+			Direction:  string(direction),
+			Rule:       rule,
+			Message:    msg,
+			Statement:  stmt,
+		})
+	}
+
+	stmts := splitStatements(sql)
+	if len(stmts) == 0 {
+		add(LintRuleEmpty, "migration has no statements", "")
+	}
+	created := make(map[string]struct{}) // tables created in this migration
+	for _, stmt := range stmts {
+		upper := strings.ToUpper(stmt)
+		if m := createTableRegex.FindStringSubmatch(upper); m != nil {
+			created[m[2]] = struct{}{}
+			continue
+		}
+		if m := createIndexRegex.FindStringSubmatch(upper); m != nil {
+			_, isNew := created[m[4]]
+			if !isNew && !strings.Contains(upper, " CONCURRENTLY ") {
+				add(LintRuleIndexNotConcurrent,
+					"index build blocks writes to existing table; use CREATE INDEX "+
+						"CONCURRENTLY in its own migration", stmt)
+			}
+			continue
+		}
+		if direction == source.Up && strings.HasPrefix(upper, "DROP TABLE ") {
+			add(LintRuleDropTable, "dropping a table loses its data and breaks replicas "+
+				"still using it", stmt)
+			continue
+		}
+		m := alterTableRegex.FindStringSubmatch(upper)
+		if m == nil {
+			continue
+		}
+		if _, isNew := created[m[3]]; isNew {
+			continue
+		}
+		lintAlterTable(direction, stmt, upper[len(m[0]):], add)
+	}
+	return findings
+}
+
+// lintAlterTable adds findings for the actions of an ALTER TABLE statement on an existing table.
+func lintAlterTable(
+	direction source.Direction, stmt, actions string, add func(rule, msg, stmt string),
+) {
+	for _, action := range splitAlterActions(actions) {
+		lintAlterAction(direction, stmt, action, add)
+	}
+}
+
+// lintAlterAction adds findings for a single action of an ALTER TABLE statement.
+func lintAlterAction(
+	direction source.Direction, stmt, action string, add func(rule, msg, stmt string),
+) {
+	if direction == source.Up && dropColumnRegex.MatchString(action) &&
+		!dropConstraintRegex.MatchString(action) {
+		add(LintRuleDropColumn, "dropping a column breaks replicas still using it; stop "+
+			"using it in a prior release", stmt)
+	}
+	if setNotNullRegex.MatchString(action) {
+		add(LintRuleSetNotNull, "setting NOT NULL scans the whole table under an exclusive "+
+			"lock", stmt)
+	}
+	if alterTypeRegex.MatchString(action) {
+		add(LintRuleAlterColumnType, "changing a column type may rewrite the whole table "+
+			"under an exclusive lock", stmt)
+	}
+	if renameRegex.MatchString(action) {
+		add(LintRuleRename, "renaming breaks replicas still using the old name", stmt)
+	}
+	if addConstraintRegex.MatchString(action) && !strings.Contains(action, "NOT VALID") {
+		add(LintRuleConstraintNotValid, "adding a constraint validates all rows under a "+
+			"lock; add it NOT VALID and VALIDATE it separately", stmt)
+	} else if addColumnRegex.MatchString(action) &&
+		strings.Contains(action, "NOT NULL") && !strings.Contains(action, "DEFAULT") {
+		add(LintRuleNotNullWithoutDefault, "adding a NOT NULL column without a default "+
+			"fails on tables with rows", stmt)
+	}
+}
+
+// splitStatements returns the non-empty statements of the SQL with comments removed and
+// whitespace collapsed.
+func splitStatements(sql string) []string {
+	sql = blockCommentRegex.ReplaceAllString(sql, " ")
+	sql = lineCommentRegex.ReplaceAllString(sql, " ")
+	stmts := make([]string, 0)
+	for _, stmt := range strings.Split(sql, ";") {
+		stmt = strings.TrimSpace(whitespaceRegex.ReplaceAllString(stmt, " "))
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// splitAlterActions returns the comma-separated actions of the given ALTER TABLE actions,
+// ignoring commas within parentheses and quotes.
+func splitAlterActions(actions string) []string {
+	split := make([]string, 0)
+	depth, quoted, start := 0, false, 0
+	for i, c := range actions {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			split = append(split, strings.TrimSpace(actions[start:i]))
+			start = i + 1
+		}
+	}
+	return append(split, strings.TrimSpace(actions[start:]))
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/mattes/migrate/source"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintMigrations(t *testing.T) {
+	d, err := newFSSource(newMapFS(migratorTestMigrations))
+	assert.Nil(t, err)
+	findings, err := lintMigrations(d)
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+
+	d, err = newFSSource(newMapFS(map[string]string{
+		"1_create-a.up.sql":           "CREATE TABLE a (id INT);",
+		"1_create-a.down.sql":         "DROP TABLE a;",
+		"2_only-up.up.sql":            "CREATE TABLE b (id INT);",
+		"4_only-down.down.sql":        "DROP TABLE b;",
+		"20180101_timestamp.up.sql":   "CREATE TABLE c (id INT);",
+		"20180101_timestamp.down.sql": "DROP TABLE c;",
+		"20180102_timestamp.up.sql":   "CREATE TABLE d (id INT);",
+		"20180102_timestamp.down.sql": "DROP TABLE d;",
+	}))
+	assert.Nil(t, err)
+	findings, err = lintMigrations(d)
+	assert.Nil(t, err)
+	rules := make([]string, len(findings))
+	for i, f := range findings {
+		rules[i] = f.Rule
+	}
+	assert.Equal(t, []string{LintRuleMissingDown, LintRuleVersionGap, LintRuleMissingUp}, rules)
+	assert.Equal(t, uint(2), findings[0].Version)
+	assert.Equal(t, "only-up", findings[0].Identifier)
+	assert.Equal(t, uint(4), findings[1].Version)
+	assert.Equal(t, "only-down", findings[2].Identifier)
+}
+
+func TestLintSQL_ok(t *testing.T) {
+	cases := map[string]string{
+		"new table": `
+			CREATE TABLE IF NOT EXISTS a.b (
+				id INT NOT NULL,
+				c_id INT
+			);
+			CREATE INDEX b_id ON a.b (id);
+			ALTER TABLE a.b ADD COLUMN d INT NOT NULL;
+			ALTER TABLE a.b ADD CONSTRAINT b_c FOREIGN KEY (c_id) REFERENCES a.c (id);
+		`,
+		"concurrent index": "CREATE INDEX CONCURRENTLY b_id ON a.b (id);",
+		"nullable column":  "ALTER TABLE a.b ADD COLUMN d INT;",
+		"default column":   "ALTER TABLE a.b ADD COLUMN d INT NOT NULL DEFAULT 0;",
+		"not valid": "ALTER TABLE a.b ADD CONSTRAINT b_check CHECK (id > 0) NOT VALID;" +
+			"ALTER TABLE a.b VALIDATE CONSTRAINT b_check;",
+		"drop constraint": "ALTER TABLE a.b DROP CONSTRAINT b_check;",
+		"drop default":    "ALTER TABLE a.b ALTER COLUMN c DROP DEFAULT;",
+		"drop not null":   "ALTER TABLE a.b ALTER COLUMN c DROP NOT NULL;",
+		"quoted comma": "ALTER TABLE a.b ADD COLUMN c TEXT NOT NULL DEFAULT 'x, DROP d', " +
+			"ADD CONSTRAINT b_check CHECK (c IN ('a', 'b')) NOT VALID;",
+		"comments": `
+			-- ALTER TABLE a.b DROP COLUMN d;
+			/* DROP TABLE a.b; */
+			SELECT 1;
+		`,
+		"ignored": `
+			-- lint:ignore drop-column, rename
+			ALTER TABLE a.b DROP COLUMN d;
+			ALTER TABLE a.b RENAME COLUMN e TO f;
+		`,
+	}
+	for name, sql := range cases {
+		assert.Empty(t, lintSQL(1, "test", source.Up, sql), name)
+	}
+
+	// dropping is expected in down migrations
+	sql := "ALTER TABLE a.b DROP COLUMN d; DROP TABLE a.c;"
+	assert.Empty(t, lintSQL(1, "test", source.Down, sql))
+}
+
+func TestLintSQL_findings(t *testing.T) {
+	cases := map[string]struct {
+		sql      string
+		expected []string
+	}{
+		"empty": {
+			sql:      "  -- nothing to see here\n",
+			expected: []string{LintRuleEmpty},
+		},
+		"index": {
+			sql:      "create unique index b_id on a.b (id);",
+			expected: []string{LintRuleIndexNotConcurrent},
+		},
+		"drop column": {
+			sql:      "ALTER TABLE a.b DROP d;",
+			expected: []string{LintRuleDropColumn},
+		},
+		"drop constraint and column": {
+			sql:      "ALTER TABLE a.b DROP CONSTRAINT x, DROP COLUMN y;",
+			expected: []string{LintRuleDropColumn},
+		},
+		"drop table": {
+			sql:      "DROP TABLE a.b;",
+			expected: []string{LintRuleDropTable},
+		},
+		"not null without default": {
+			sql:      "ALTER TABLE a.b ADD COLUMN c INT DEFAULT 0, ADD COLUMN d INT NOT NULL;",
+			expected: []string{LintRuleNotNullWithoutDefault},
+		},
+		"set not null": {
+			sql:      "ALTER TABLE a.b ALTER COLUMN d SET NOT NULL;",
+			expected: []string{LintRuleSetNotNull},
+		},
+		"alter type": {
+			sql:      "ALTER TABLE a.b ALTER COLUMN d TYPE BIGINT;",
+			expected: []string{LintRuleAlterColumnType},
+		},
+		"rename": {
+			sql:      "ALTER TABLE a.b RENAME TO c;",
+			expected: []string{LintRuleRename},
+		},
+		"constraint": {
+			sql: "ALTER TABLE a.b ADD CONSTRAINT b_c FOREIGN KEY (c_id) " +
+				"REFERENCES a.c (id);",
+			expected: []string{LintRuleConstraintNotValid},
+		},
+		"multiple": {
+			sql: `
+				-- lint:ignore rename
+				ALTER TABLE a.b
+					ALTER COLUMN d SET NOT NULL,
+					ALTER COLUMN e TYPE TEXT;
+				ALTER TABLE a.b RENAME COLUMN f TO g;
+				CREATE INDEX b_d ON a.b (d);
+			`,
+			expected: []string{
+				LintRuleSetNotNull,
+				LintRuleAlterColumnType,
+				LintRuleIndexNotConcurrent,
+			},
+		},
+	}
+	for name, c := range cases {
+		findings := lintSQL(2, "test", source.Up, c.sql)
+		rules := make([]string, len(findings))
+		for i, f := range findings {
+			rules[i] = f.Rule
+			assert.Equal(t, uint(2), f.Version, name)
+			assert.Equal(t, "test", f.Identifier, name)
+			assert.Equal(t, "up", f.Direction, name)
+			assert.NotEmpty(t, f.Message, name)
+		}
+		assert.Equal(t, c.expected, rules, name)
+	}
+
+	findings := lintSQL(1, "test", source.Up, "ALTER TABLE a.b\n  DROP COLUMN d;")
+	assert.Equal(t, "ALTER TABLE a.b DROP COLUMN d", findings[0].Statement)
+}
@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/mattes/migrate"
+	_ "github.com/mattes/migrate/database/postgres" // loads "postgres" driver for migrate
+	"github.com/mattes/migrate/source"
+	"github.com/mattes/migrate/source/go-bindata"
+)
+
+// ErrInvalidForceVersion indicates when forcing a migration version less than -1.
+var ErrInvalidForceVersion = errors.New("forced migration version must be >= -1")
+
+// Migrator handles Postgres DB migrations. It is a thin wrapper around *Migrate in mattes/migrate
+// package. Migrations are applied one at a time while holding a Postgres advisory lock, so
+// replicas migrating the same DB at once wait for each other rather than fail, and each is
+// recorded in a history table.
+type Migrator interface {
+	// Up migrates the DB up to the latest state.
+	Up() error
+
+	// Down migrates the DB all the way to the empty state.
+	Down() error
+
+	// Version returns the current migration version and whether the DB is dirty, i.e., the
+	// migration to that version failed partway. The version is 0 when no migrations have been
+	// applied.
+	Version() (version uint, dirty bool, err error)
+
+	// Goto migrates the DB up or down to the given version.
+	Goto(version uint) error
+
+	// Steps applies the next n up migrations if n > 0 or the previous -n down migrations if
+	// n < 0.
+	Steps(n int) error
+
+	// Force sets the migration version without running any migrations and clears the dirty
+	// flag. It is used to recover from a failed migration after manually fixing the DB. A
+	// version of -1 indicates that no migrations have been applied.
+	Force(version int) error
+
+	// Status returns the status of each migration in the source, ordered by version.
+	Status() ([]*MigrationStatus, error)
+
+	// PlanSteps returns the migrations, in order, that Steps(n) would apply without applying
+	// them. Fewer than |n| migrations are returned when fewer are available.
+	PlanSteps(n int) ([]*PlannedMigration, error)
+
+	// PlanGoto returns the migrations, in order, that Goto(version) would apply without
+	// applying them.
+	PlanGoto(version uint) ([]*PlannedMigration, error)
+
+	// Lint returns the potential problems with the migrations in the source, e.g., risky
+	// operations, missing down migrations, and gaps in version numbering. It doesn't connect to
+	// the DB.
+	Lint() ([]*LintFinding, error)
+
+	// History returns the records of the applied (and failed) migrations, oldest first.
+	History() ([]*MigrationRecord, error)
+}
+
+// PlannedMigration is a migration that would be applied.
+type PlannedMigration struct {
+	// Version is the migration version.
+	Version uint
+
+	// Identifier is the migration name.
+	Identifier string
+
+	// Up indicates whether this is an up (or down) migration.
+	Up bool
+
+	// SQL is the migration SQL.
+	SQL string
+}
+
+// MigrationStatus is the status of a single migration.
+type MigrationStatus struct {
+	// Version is the migration version.
+	Version uint
+
+	// Identifier is the migration name, e.g., "create-test-table" for the
+	// 001_create-test-table.up.sql asset.
+	Identifier string
+
+	// Applied indicates whether the migration has been applied to the DB.
+	Applied bool
+
+	// Dirty indicates whether the migration failed partway when applied.
+	Dirty bool
+}
+
+type sourceMigrator struct {
+	dbURL      string
+	sourceName string
+	newSource  func() (source.Driver, error)
+	params     *MigratorParameters
+	logger     migrate.Logger
+}
+
+// NewBindataMigrator creates a new Migrator from the given go-bindata asset source and using the
+// given logger and the default parameters.
+func NewBindataMigrator(dbURL string, as *bindata.AssetSource, logger migrate.Logger) Migrator {
+	return NewBindataMigratorWithParams(dbURL, nil, as, logger)
+}
+
+// NewBindataMigratorWithParams creates a new Migrator from the given go-bindata asset source and
+// using the given parameters (or the defaults if nil) and logger.
+func NewBindataMigratorWithParams(
+	dbURL string, params *MigratorParameters, as *bindata.AssetSource, logger migrate.Logger,
+) Migrator {
+	return &sourceMigrator{
+		dbURL:      dbURL,
+		sourceName: "go-bindata",
+		newSource: func() (source.Driver, error) {
+			return bindata.WithInstance(as)
+		},
+		params: orDefaultMigratorParameters(params),
+		logger: logger,
+	}
+}
+
+func orDefaultMigratorParameters(params *MigratorParameters) *MigratorParameters {
+	if params == nil {
+		return NewDefaultMigratorParameters()
+	}
+	return params
+}
+
+// Up migrates the DB up to the latest state.
+func (sm *sourceMigrator) Up() error {
+	return sm.withLock(func(lm *lockedMigrator) error {
+		return lm.up()
+	})
+}
+
+// Down migrates the DB down to the empty state.
+func (sm *sourceMigrator) Down() error {
+	return sm.withLock(func(lm *lockedMigrator) error {
+		return lm.gotoVersion(0)
+	})
+}
+
+// Version returns the current migration version and whether the DB is dirty.
+func (sm *sourceMigrator) Version() (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := sm.withInner(func(m *migrate.Migrate) error {
+		var err error
+		version, dirty, err = innerVersion(m)
+		return err
+	})
+	return version, dirty, err
+}
+
+// Goto migrates the DB up or down to the given version.
+func (sm *sourceMigrator) Goto(version uint) error {
+	return sm.withLock(func(lm *lockedMigrator) error {
+		return lm.gotoVersion(version)
+	})
+}
+
+// Steps applies the next n up migrations or the previous -n down migrations.
+func (sm *sourceMigrator) Steps(n int) error {
+	return sm.withLock(func(lm *lockedMigrator) error {
+		return lm.steps(n)
+	})
+}
+
+// Force sets the migration version and clears the dirty flag.
+func (sm *sourceMigrator) Force(version int) error {
+	if version < -1 {
+		return ErrInvalidForceVersion
+	}
+	return sm.withLock(func(lm *lockedMigrator) error {
+		return lm.force(version)
+	})
+}
+
+// Status returns the status of each migration in the source.
+func (sm *sourceMigrator) Status() ([]*MigrationStatus, error) {
+	d, err := sm.newSource()
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := sourceMigrations(d)
+	if err != nil {
+		return nil, err
+	}
+	err = sm.withInner(func(m *migrate.Migrate) error {
+		version, dirty, err2 := innerVersion(m)
+		if err2 != nil {
+			return err2
+		}
+		setApplied(statuses, version, dirty)
+		return nil
+	})
+	return statuses, err
+}
+
+// PlanSteps returns the migrations that Steps(n) would apply.
+func (sm *sourceMigrator) PlanSteps(n int) ([]*PlannedMigration, error) {
+	statuses, err := sm.Status()
+	if err != nil {
+		return nil, err
+	}
+	d, err := sm.newSource()
+	if err != nil {
+		return nil, err
+	}
+	return planSteps(d, statuses, n)
+}
+
+// PlanGoto returns the migrations that Goto(version) would apply.
+func (sm *sourceMigrator) PlanGoto(version uint) ([]*PlannedMigration, error) {
+	statuses, err := sm.Status()
+	if err != nil {
+		return nil, err
+	}
+	n, err := gotoSteps(statuses, version)
+	if err != nil {
+		return nil, err
+	}
+	d, err := sm.newSource()
+	if err != nil {
+		return nil, err
+	}
+	return planSteps(d, statuses, n)
+}
+
+// Lint returns the potential problems with the migrations in the source.
+func (sm *sourceMigrator) Lint() ([]*LintFinding, error) {
+	d, err := sm.newSource()
+	if err != nil {
+		return nil, err
+	}
+	return lintMigrations(d)
+}
+
+func (sm *sourceMigrator) newInner() (*migrate.Migrate, error) {
+	d, err := sm.newSource()
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.NewWithSourceInstance(sm.sourceName, d, sm.dbURL)
+	if err != nil {
+		return nil, err
+	}
+	m.Log = sm.logger
+	return m, nil
+}
+
+// withInner runs the given function with a new *migrate.Migrate, closing it afterwards.
+func (sm *sourceMigrator) withInner(fn func(m *migrate.Migrate) error) error {
+	m, err := sm.newInner()
+	if err != nil {
+		return err
+	}
+	if err = fn(m); err != nil {
+		_, _ = m.Close()
+		return err
+	}
+	err1, err2 := m.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// innerVersion returns the current version and dirty flag, with a zero version when no
+// migrations have been applied.
+func innerVersion(m *migrate.Migrate) (uint, bool, error) {
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// sourceMigrations returns the (unapplied) statuses of the migrations in the given source,
+// ordered by version.
+func sourceMigrations(d source.Driver) ([]*MigrationStatus, error) {
+	statuses := make([]*MigrationStatus, 0)
+	version, err := d.First()
+	for err == nil {
+		r, identifier, err2 := d.ReadUp(version)
+		if err2 != nil {
+			return nil, err2
+		}
+		if err2 = r.Close(); err2 != nil {
+			return nil, err2
+		}
+		statuses = append(statuses, &MigrationStatus{Version: version, Identifier: identifier})
+		version, err = d.Next(version)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// setApplied marks the migrations up to and including the given current version as applied.
+func setApplied(statuses []*MigrationStatus, current uint, dirty bool) {
+	for _, s := range statuses {
+		s.Applied = s.Version <= current
+		s.Dirty = dirty && s.Version == current
+	}
+}
+
+// planSteps returns the migrations from the given source that would be applied by n steps from
+// the current state given by the statuses.
+func planSteps(
+	d source.Driver, statuses []*MigrationStatus, n int,
+) ([]*PlannedMigration, error) {
+	nApplied := 0
+	for _, s := range statuses {
+		if s.Applied {
+			nApplied++
+		}
+	}
+	planned := make([]*PlannedMigration, 0)
+	for i := 0; i < n && nApplied+i < len(statuses); i++ {
+		pm, err := readPlanned(d, statuses[nApplied+i].Version, true)
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, pm)
+	}
+	for i := 0; i < -n && nApplied-i > 0; i++ {
+		pm, err := readPlanned(d, statuses[nApplied-i-1].Version, false)
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, pm)
+	}
+	return planned, nil
+}
+
+// gotoSteps returns the number of steps from the current state given by the statuses to the given
+// version (0 for none), or ErrMigrationVersionNotFound if the version isn't among the statuses.
+func gotoSteps(statuses []*MigrationStatus, version uint) (int, error) {
+	target, nApplied := -1, 0
+	if version == 0 {
+		target = 0
+	}
+	for i, s := range statuses {
+		if s.Version == version {
+			target = i + 1
+		}
+		if s.Applied {
+			nApplied++
+		}
+	}
+	if target == -1 {
+		return 0, ErrMigrationVersionNotFound
+	}
+	return target - nApplied, nil
+}
+
+func readPlanned(d source.Driver, version uint, up bool) (*PlannedMigration, error) {
+	read := d.ReadDown
+	if up {
+		read = d.ReadUp
+	}
+	r, identifier, err := read(version)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	sql, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &PlannedMigration{
+		Version:    version,
+		Identifier: identifier,
+		Up:         up,
+		SQL:        string(sql),
+	}, nil
+}
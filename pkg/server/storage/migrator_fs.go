@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/source"
+)
+
+var (
+	// ErrDuplicateMigration indicates when a migration source has more than one up or down file
+	// for the same version.
+	ErrDuplicateMigration = errors.New("duplicate migration version and direction")
+
+	errFSSourceOpen = errors.New("fs migration source only supports instances")
+)
+
+// NewFSMigrator creates a new Migrator from the migration files (e.g., 001_create-table.up.sql)
+// in the root directory of the given file system and using the given parameters (or the defaults
+// if nil) and logger. Other files are ignored. For migrations embedded via a //go:embed migrations
+// directive, use fs.Sub(embedded, "migrations") as the file system.
+func NewFSMigrator(
+	dbURL string, params *MigratorParameters, fsys fs.FS, logger migrate.Logger,
+) Migrator {
+	return &sourceMigrator{
+		dbURL:      dbURL,
+		sourceName: "fs",
+		newSource: func() (source.Driver, error) {
+			return newFSSource(fsys)
+		},
+		params: orDefaultMigratorParameters(params),
+		logger: logger,
+	}
+}
+
+// NewDirMigrator creates a new Migrator from the migration files in the given directory and using
+// the given parameters (or the defaults if nil) and logger.
+func NewDirMigrator(
+	dbURL string, params *MigratorParameters, dir string, logger migrate.Logger,
+) Migrator {
+	return NewFSMigrator(dbURL, params, os.DirFS(dir), logger)
+}
+
+// fsSource is a source.Driver reading migrations from the root directory of an fs.FS.
+type fsSource struct {
+	fsys       fs.FS
+	migrations *source.Migrations
+}
+
+func newFSSource(fsys fs.FS) (source.Driver, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	ms := source.NewMigrations()
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m, err := source.Parse(e.Name())
+		if err != nil {
+			continue // not a migration file
+		}
+		if !ms.Append(m) {
+			return nil, ErrDuplicateMigration
+		}
+	}
+	return &fsSource{fsys: fsys, migrations: ms}, nil
+}
+
+func (s *fsSource) Open(url string) (source.Driver, error) {
+	return nil, errFSSourceOpen
+}
+
+func (s *fsSource) Close() error {
+	return nil
+}
+
+func (s *fsSource) First() (uint, error) {
+	v, ok := s.migrations.First()
+	if !ok {
+		return 0, notExistErr("first")
+	}
+	return v, nil
+}
+
+func (s *fsSource) Prev(version uint) (uint, error) {
+	v, ok := s.migrations.Prev(version)
+	if !ok {
+		return 0, notExistErr(fmt.Sprintf("prev for version %d", version))
+	}
+	return v, nil
+}
+
+func (s *fsSource) Next(version uint) (uint, error) {
+	v, ok := s.migrations.Next(version)
+	if !ok {
+		return 0, notExistErr(fmt.Sprintf("next for version %d", version))
+	}
+	return v, nil
+}
+
+func (s *fsSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if m, ok := s.migrations.Up(version); ok {
+		return s.read(m)
+	}
+	return nil, "", notExistErr(fmt.Sprintf("read up version %d", version))
+}
+
+func (s *fsSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if m, ok := s.migrations.Down(version); ok {
+		return s.read(m)
+	}
+	return nil, "", notExistErr(fmt.Sprintf("read down version %d", version))
+}
+
+func (s *fsSource) read(m *source.Migration) (io.ReadCloser, string, error) {
+	f, err := s.fsys.Open(m.Raw)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, m.Identifier, nil
+}
+
+// notExistErr returns an error satisfying os.IsNotExist, which migrate expects when there is no
+// such migration.
+func notExistErr(op string) error {
+	return &os.PathError{Op: op, Path: ".", Err: os.ErrNotExist}
+}
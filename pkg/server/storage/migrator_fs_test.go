@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMapFS returns an in-memory file system with the given file contents.
+func newMapFS(files map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return fsys
+}
+
+// writeTestMigrationsDir writes the test migrations to a new temporary directory.
+func writeTestMigrationsDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	assert.Nil(t, err)
+	for name, content := range migratorTestMigrations {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		assert.Nil(t, err)
+	}
+	return dir
+}
+
+func TestFSSource(t *testing.T) {
+	files := map[string]string{
+		"README.md":                   "not a migration",
+		"seeds/1_seed-data.up.sql":    "not a root migration",
+		"tools/2_tool.down.sql":       "not a root migration",
+		"1_create-schema.up.sql":      migratorTestMigrations["1_create-schema.up.sql"],
+		"1_create-schema.down.sql":    migratorTestMigrations["1_create-schema.down.sql"],
+		"002_create-a.up.sql":         migratorTestMigrations["2_create-a.up.sql"],
+		"002_create-a.down.sql":       migratorTestMigrations["2_create-a.down.sql"],
+		"10_only-up.up.sql":           "SELECT 1;",
+		"20180101_timestamp.up.sql":   "SELECT 2;",
+		"20180101_timestamp.down.sql": "SELECT 3;",
+	}
+	d, err := newFSSource(newMapFS(files))
+	assert.Nil(t, err)
+	statuses, err := sourceMigrations(d)
+	assert.Nil(t, err)
+	assert.Equal(t, []*MigrationStatus{
+		{Version: 1, Identifier: "create-schema"},
+		{Version: 2, Identifier: "create-a"},
+		{Version: 10, Identifier: "only-up"},
+		{Version: 20180101, Identifier: "timestamp"},
+	}, statuses)
+
+	planned, err := planSteps(d, statuses, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, files["002_create-a.up.sql"], planned[1].SQL)
+
+	version, err := d.Prev(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), version)
+	_, err = d.Prev(1)
+	assert.True(t, os.IsNotExist(err))
+	_, _, err = d.ReadDown(10)
+	assert.True(t, os.IsNotExist(err))
+	_, _, err = d.ReadUp(3)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = d.Open("fs://")
+	assert.NotNil(t, err)
+	assert.Nil(t, d.Close())
+}
+
+func TestFSSource_empty(t *testing.T) {
+	d, err := newFSSource(newMapFS(map[string]string{}))
+	assert.Nil(t, err)
+	_, err = d.First()
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFSSource_err(t *testing.T) {
+	d, err := newFSSource(newMapFS(map[string]string{
+		"1_create-a.up.sql":   "CREATE TABLE a (id INT);",
+		"001_create-b.up.sql": "CREATE TABLE b (id INT);",
+	}))
+	assert.Equal(t, ErrDuplicateMigration, err)
+	assert.Nil(t, d)
+
+	// missing dir
+	m := NewDirMigrator("postgres://localhost:1/db", nil, "/not/a/dir", &LogLogger{})
+	_, err = m.Status()
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDirMigrator_source(t *testing.T) {
+	dir := writeTestMigrationsDir(t)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	d, err := newFSSource(os.DirFS(dir))
+	assert.Nil(t, err)
+	statuses, err := sourceMigrations(d)
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 3)
+}
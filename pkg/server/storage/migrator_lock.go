@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/mattes/migrate"
+	"github.com/mattes/migrate/source"
+)
+
+const (
+	// DefaultMigrationLockTimeout is the default maximum amount of time to wait for the migration
+	// lock, e.g., while another replica migrates the DB.
+	DefaultMigrationLockTimeout = 5 * time.Minute
+
+	// DefaultMigrationHistoryTable is the default table recording each applied migration.
+	DefaultMigrationHistoryTable = "migration_history"
+
+	// MigrationDirectionForce is the direction of a migration history record for a forced
+	// version.
+	MigrationDirectionForce = "force"
+
+	migrationLockPollInterval = time.Second
+	migrationLockLogInterval  = 10 * time.Second
+	migrationLockKeyPrefix    = "service-base/migrate:"
+	unknownAppliedBy          = "unknown"
+
+	createHistoryTableSQL = `CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	version BIGINT NOT NULL,
+	identifier TEXT NOT NULL,
+	direction TEXT NOT NULL,
+	applied_by TEXT NOT NULL,
+	started_at TIMESTAMPTZ NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	error TEXT NOT NULL
+)`
+)
+
+var (
+	// ErrMigrationLockTimeout indicates when the migration lock isn't acquired within the lock
+	// timeout.
+	ErrMigrationLockTimeout = errors.New("timed out waiting for migration lock")
+
+	// ErrNegativeMigrationLockTimeout indicates when the migration lock timeout is negative.
+	ErrNegativeMigrationLockTimeout = errors.New("migration lock timeout must be non-negative")
+
+	// ErrInvalidMigrationHistoryTable indicates when the migration history table isn't a
+	// (optionally schema-qualified) unquoted identifier.
+	ErrInvalidMigrationHistoryTable = errors.New("invalid migration history table name")
+
+	// ErrMigrationVersionNotFound indicates when a goto version isn't among the migrations.
+	ErrMigrationVersionNotFound = errors.New("migration version not found")
+
+	historyTableRegex = regexp.MustCompile(`^[A-Za-z_]\w*(\.[A-Za-z_]\w*)?$`)
+)
+
+// MigratorParameters defines how a Migrator coordinates with other Migrators (e.g., those of other
+// replicas) and records the migrations it applies.
+type MigratorParameters struct {
+	// LockTimeout is the maximum amount of time to wait for the migration lock, including while
+	// the DB is unavailable. Zero means the lock is only tried once.
+	LockTimeout time.Duration
+
+	// HistoryTable is the table recording each applied migration, created if it doesn't exist.
+	// Its schema (if given) must already exist.
+	HistoryTable string
+
+	// AppliedBy identifies who applied the migrations in the history table. If empty, the
+	// hostname (e.g., the Kubernetes pod name) is used.
+	AppliedBy string
+}
+
+// NewDefaultMigratorParameters returns a *MigratorParameters object with default values.
+func NewDefaultMigratorParameters() *MigratorParameters {
+	return &MigratorParameters{
+		LockTimeout:  DefaultMigrationLockTimeout,
+		HistoryTable: DefaultMigrationHistoryTable,
+	}
+}
+
+// Validate checks that the lock timeout is non-negative and that the history table is a valid
+// table name.
+func (p *MigratorParameters) Validate() error {
+	if p.LockTimeout < 0 {
+		return ErrNegativeMigrationLockTimeout
+	}
+	if !historyTableRegex.MatchString(p.HistoryTable) {
+		return ErrInvalidMigrationHistoryTable
+	}
+	return nil
+}
+
+// MigrationRecord is a row of the migration history table.
+type MigrationRecord struct {
+	// Version is the migration version, or the forced version for a forced record.
+	Version int64 `db:"version"`
+
+	// Identifier is the migration name, if known.
+	Identifier string `db:"identifier"`
+
+	// Direction is "up", "down", or MigrationDirectionForce.
+	Direction string `db:"direction"`
+
+	// AppliedBy identifies who applied the migration.
+	AppliedBy string `db:"applied_by"`
+
+	// StartedAt is when the migration started.
+	StartedAt time.Time `db:"started_at"`
+
+	// DurationMS is how long the migration took in milliseconds.
+	DurationMS int64 `db:"duration_ms"`
+
+	// Error is the error message if the migration failed, else empty.
+	Error string `db:"error"`
+}
+
+// lockedMigrator applies migrations one at a time while holding the migration lock, recording
+// each in the history table.
+type lockedMigrator struct {
+	m         *migrate.Migrate
+	db        *sql.DB
+	statuses  []*MigrationStatus
+	params    *MigratorParameters
+	appliedBy string
+	logger    migrate.Logger
+}
+
+// withLock opens the DB, waits for the migration lock, and runs the given function with a
+// lockedMigrator before releasing the lock.
+func (sm *sourceMigrator) withLock(fn func(lm *lockedMigrator) error) error {
+	if err := sm.params.Validate(); err != nil {
+		return err
+	}
+	db, err := openMigrationDB(sm.dbURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+	key := migrationLockKey(sm.params.HistoryTable)
+	conn, err := acquireMigrationLock(db, key, sm.params.LockTimeout, sm.logger)
+	if err != nil {
+		return err
+	}
+	defer releaseMigrationLock(conn, key)
+
+	if _, err = db.Exec(fmt.Sprintf(createHistoryTableSQL, sm.params.HistoryTable)); err != nil {
+		return err
+	}
+	d, err := sm.newSource()
+	if err != nil {
+		return err
+	}
+	statuses, err := sourceMigrations(d)
+	if err != nil {
+		return err
+	}
+	return sm.withInner(func(m *migrate.Migrate) error {
+		version, dirty, err2 := innerVersion(m)
+		if err2 != nil {
+			return err2
+		}
+		setApplied(statuses, version, dirty)
+		return fn(&lockedMigrator{
+			m:         m,
+			db:        db,
+			statuses:  statuses,
+			params:    sm.params,
+			appliedBy: appliedBy(sm.params),
+			logger:    sm.logger,
+		})
+	})
+}
+
+// History returns the records of the migration history table, oldest first.
+func (sm *sourceMigrator) History() ([]*MigrationRecord, error) {
+	if err := sm.params.Validate(); err != nil {
+		return nil, err
+	}
+	db, err := openMigrationDB(sm.dbURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	records := make([]*MigrationRecord, 0)
+	var exists bool
+	err = db.QueryRow("SELECT to_regclass($1) IS NOT NULL", sm.params.HistoryTable).
+		Scan(&exists)
+	if err != nil || !exists {
+		return records, err
+	}
+	cols, err := Columns(&MigrationRecord{})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY id",
+		strings.Join(cols, ", "), sm.params.HistoryTable))
+	if err != nil {
+		return nil, err
+	}
+	if err = ScanAll(rows, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// steps applies the next n up migrations if n > 0 or the previous -n down migrations if n < 0,
+// returning a migrate.ErrShortLimit after applying those available if there are fewer than |n|.
+func (lm *lockedMigrator) steps(n int) error {
+	nApplied := 0
+	for _, s := range lm.statuses {
+		if s.Dirty {
+			// needs manual recovery via Force
+			return migrate.ErrDirty{Version: int(s.Version)}
+		}
+		if s.Applied {
+			nApplied++
+		}
+	}
+	for ; n > 0; n-- {
+		if nApplied == len(lm.statuses) {
+			return migrate.ErrShortLimit{Short: uint(n)}
+		}
+		if err := lm.step(lm.statuses[nApplied], source.Up); err != nil {
+			return err
+		}
+		nApplied++
+	}
+	for ; n < 0; n++ {
+		if nApplied == 0 {
+			return migrate.ErrShortLimit{Short: uint(-n)}
+		}
+		if err := lm.step(lm.statuses[nApplied-1], source.Down); err != nil {
+			return err
+		}
+		nApplied--
+	}
+	return nil
+}
+
+// up applies all pending migrations.
+func (lm *lockedMigrator) up() error {
+	nPending := 0
+	for _, s := range lm.statuses {
+		if !s.Applied {
+			nPending++
+		}
+	}
+	return lm.steps(nPending)
+}
+
+// gotoVersion applies the migrations up or down to the given version (0 for none).
+func (lm *lockedMigrator) gotoVersion(version uint) error {
+	n, err := gotoSteps(lm.statuses, version)
+	if err != nil {
+		return err
+	}
+	return lm.steps(n)
+}
+
+// force sets the migration version and clears the dirty flag.
+func (lm *lockedMigrator) force(version int) error {
+	identifier := ""
+	for _, s := range lm.statuses {
+		if int(s.Version) == version {
+			identifier = s.Identifier
+		}
+	}
+	lm.logger.Printf("forcing migration version %d", version)
+	start := time.Now()
+	err := lm.m.Force(version)
+	err2 := lm.record(int64(version), identifier, MigrationDirectionForce, start, err)
+	if err != nil {
+		return err
+	}
+	return err2
+}
+
+// step applies the up or down migration with the given status.
+func (lm *lockedMigrator) step(s *MigrationStatus, direction source.Direction) error {
+	n := 1
+	if direction == source.Down {
+		n = -1
+	}
+	lm.logger.Printf("applying %s migration %d_%s", direction, s.Version, s.Identifier)
+	start := time.Now()
+	err := lm.m.Steps(n)
+	err2 := lm.record(int64(s.Version), s.Identifier, string(direction), start, err)
+	if err != nil {
+		return err
+	}
+	lm.logger.Printf("applied %s migration %d_%s in %s", direction, s.Version, s.Identifier,
+		time.Since(start))
+	return err2
+}
+
+// record inserts a migration history record for the migration started at the given time and
+// ending now with the given error (if any).
+func (lm *lockedMigrator) record(
+	version int64, identifier, direction string, start time.Time, migrateErr error,
+) error {
+	r := &MigrationRecord{
+		Version:    version,
+		Identifier: identifier,
+		Direction:  direction,
+		AppliedBy:  lm.appliedBy,
+		StartedAt:  start,
+		DurationMS: int64(time.Since(start) / time.Millisecond),
+	}
+	if migrateErr != nil {
+		r.Error = migrateErr.Error()
+	}
+	cols, values, err := InsertValues(r)
+	if err != nil {
+		return err
+	}
+	_, err = sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(lm.db).
+		Insert(lm.params.HistoryTable).
+		Columns(cols...).
+		Values(values...).
+		Exec()
+	return err
+}
+
+// openMigrationDB opens the DB at the given URL without the migrate-specific (x-) parameters.
+func openMigrationDB(dbURL string) (*sql.DB, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("postgres", migrate.FilterCustomQuery(u).String())
+}
+
+// migrationLockKey returns the advisory lock key for the migrations recorded in the given history
+// table. It differs from the key migrate itself uses while applying each migration.
+func migrationLockKey(historyTable string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte(migrationLockKeyPrefix + historyTable)))
+}
+
+// acquireMigrationLock polls for the session-level advisory lock with the given key until it is
+// acquired or the timeout elapses, returning the connection holding it. Errors (e.g., while the
+// DB is starting up) are retried until the timeout.
+func acquireMigrationLock(
+	db *sql.DB, key int64, timeout time.Duration, logger migrate.Logger,
+) (*sql.Conn, error) {
+	start := time.Now()
+	logEvery := int(migrationLockLogInterval / migrationLockPollInterval)
+	for attempt := 0; ; attempt++ {
+		conn, err := tryMigrationLock(db, key)
+		if conn != nil {
+			return conn, nil
+		}
+		elapsed := time.Since(start)
+		if elapsed >= timeout {
+			if err == nil {
+				err = ErrMigrationLockTimeout
+			}
+			return nil, err
+		}
+		if attempt%logEvery == 0 {
+			if err != nil {
+				logger.Printf("waiting for migration lock after error (%s elapsed): %s",
+					elapsed, err)
+			} else {
+				logger.Printf("waiting for migration lock held by another migrator "+
+					"(%s elapsed)", elapsed)
+			}
+		}
+		wait := migrationLockPollInterval
+		if remaining := timeout - elapsed; remaining < wait {
+			wait = remaining
+		}
+		time.Sleep(wait)
+	}
+}
+
+// tryMigrationLock returns the connection holding the advisory lock with the given key if it was
+// acquired or nil if another session holds it.
+func tryMigrationLock(db *sql.DB, key int64) (*sql.Conn, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	if err != nil || !locked {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// releaseMigrationLock releases the advisory lock with the given key held by the connection. If
+// the unlock fails, the lock is released when the DB is closed.
+func releaseMigrationLock(conn *sql.Conn, key int64) {
+	_, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+	_ = conn.Close()
+}
+
+func appliedBy(p *MigratorParameters) string {
+	if p.AppliedBy != "" {
+		return p.AppliedBy
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return unknownAppliedBy
+	}
+	return hostname
+}
@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/mattes/migrate/source/go-bindata"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	migratorTestMigrationsTable = "migrator_test_migrations"
+	migratorTestHistoryTable    = "migrator_test_history"
+)
+
+var migratorTestMigrations = map[string]string{
+	"1_create-schema.up.sql":   "CREATE SCHEMA migrator_test;",
+	"1_create-schema.down.sql": "DROP SCHEMA migrator_test;",
+	"2_create-a.up.sql":        "CREATE TABLE migrator_test.a (id INT PRIMARY KEY);",
+	"2_create-a.down.sql":      "DROP TABLE migrator_test.a;",
+	"3_create-b.up.sql":        "CREATE TABLE migrator_test.b (id INT PRIMARY KEY);",
+	"3_create-b.down.sql":      "DROP TABLE migrator_test.b;",
+}
+
+// newMapAssetSource returns a go-bindata asset source with the given asset contents.
+func newMapAssetSource(assets map[string]string) *bindata.AssetSource {
+	names := make([]string, 0, len(assets))
+	for name := range assets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return bindata.Resource(names, func(name string) ([]byte, error) {
+		content, in := assets[name]
+		if !in {
+			return nil, os.ErrNotExist
+		}
+		return []byte(content), nil
+	})
+}
+
+func TestSourceMigrations(t *testing.T) {
+	d, err := bindata.WithInstance(newMapAssetSource(migratorTestMigrations))
+	assert.Nil(t, err)
+	statuses, err := sourceMigrations(d)
+	assert.Nil(t, err)
+	assert.Equal(t, []*MigrationStatus{
+		{Version: 1, Identifier: "create-schema"},
+		{Version: 2, Identifier: "create-a"},
+		{Version: 3, Identifier: "create-b"},
+	}, statuses)
+
+	d, err = bindata.WithInstance(newMapAssetSource(map[string]string{}))
+	assert.Nil(t, err)
+	statuses, err = sourceMigrations(d)
+	assert.Nil(t, err)
+	assert.Empty(t, statuses)
+}
+
+func TestSetApplied(t *testing.T) {
+	statuses := []*MigrationStatus{{Version: 1}, {Version: 2}, {Version: 3}}
+	setApplied(statuses, 2, true)
+	assert.Equal(t, []*MigrationStatus{
+		{Version: 1, Applied: true},
+		{Version: 2, Applied: true, Dirty: true},
+		{Version: 3},
+	}, statuses)
+
+	setApplied(statuses, 0, false)
+	for _, s := range statuses {
+		assert.False(t, s.Applied)
+		assert.False(t, s.Dirty)
+	}
+}
+
+func TestPlanSteps(t *testing.T) {
+	d, err := bindata.WithInstance(newMapAssetSource(migratorTestMigrations))
+	assert.Nil(t, err)
+	statuses, err := sourceMigrations(d)
+	assert.Nil(t, err)
+	setApplied(statuses, 1, false)
+
+	planned, err := planSteps(d, statuses, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []*PlannedMigration{
+		{Version: 2, Identifier: "create-a", Up: true,
+			SQL: migratorTestMigrations["2_create-a.up.sql"]},
+		{Version: 3, Identifier: "create-b", Up: true,
+			SQL: migratorTestMigrations["3_create-b.up.sql"]},
+	}, planned)
+
+	planned, err = planSteps(d, statuses, -5)
+	assert.Nil(t, err)
+	assert.Equal(t, []*PlannedMigration{
+		{Version: 1, Identifier: "create-schema", Up: false,
+			SQL: migratorTestMigrations["1_create-schema.down.sql"]},
+	}, planned)
+
+	setApplied(statuses, 3, false)
+	planned, err = planSteps(d, statuses, -2)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{3, 2}, []uint{planned[0].Version, planned[1].Version})
+
+	planned, err = planSteps(d, statuses, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, planned)
+}
+
+func TestGotoSteps(t *testing.T) {
+	statuses := []*MigrationStatus{{Version: 1}, {Version: 2}, {Version: 5}}
+	setApplied(statuses, 2, false)
+	cases := map[uint]int{0: -2, 1: -1, 2: 0, 5: 1}
+	for version, expected := range cases {
+		n, err := gotoSteps(statuses, version)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n, version)
+	}
+
+	_, err := gotoSteps(statuses, 3)
+	assert.Equal(t, ErrMigrationVersionNotFound, err)
+}
+
+func TestBindataMigrator_Force_err(t *testing.T) {
+	m := NewBindataMigrator("postgres://localhost:1/db", newMapAssetSource(nil), &LogLogger{})
+	assert.Equal(t, ErrInvalidForceVersion, m.Force(-2))
+}
+
+func TestBindataMigrator_lockErr(t *testing.T) {
+	as := newMapAssetSource(migratorTestMigrations)
+	params := &MigratorParameters{HistoryTable: "bad-table"}
+	m := NewBindataMigratorWithParams("postgres://localhost:1/db", params, as, &LogLogger{})
+	assert.Equal(t, ErrInvalidMigrationHistoryTable, m.Up())
+	_, err := m.History()
+	assert.Equal(t, ErrInvalidMigrationHistoryTable, err)
+
+	// DB unavailable
+	params = &MigratorParameters{HistoryTable: migratorTestHistoryTable}
+	m = NewBindataMigratorWithParams("postgres://localhost:1/db?sslmode=disable", params, as,
+		&LogLogger{})
+	assert.NotNil(t, m.Up())
+}
+
+func TestMigratorParameters_Validate(t *testing.T) {
+	assert.Nil(t, NewDefaultMigratorParameters().Validate())
+	assert.Nil(t, (&MigratorParameters{HistoryTable: "a_1.migration_history"}).Validate())
+
+	cases := map[*MigratorParameters]error{
+		{LockTimeout: -1, HistoryTable: "a"}: ErrNegativeMigrationLockTimeout,
+		{}:                                   ErrInvalidMigrationHistoryTable,
+		{HistoryTable: "a; DROP TABLE b"}:    ErrInvalidMigrationHistoryTable,
+		{HistoryTable: "a.b.c"}:              ErrInvalidMigrationHistoryTable,
+		{HistoryTable: "1a"}:                 ErrInvalidMigrationHistoryTable,
+	}
+	for p, expected := range cases {
+		assert.Equal(t, expected, p.Validate())
+	}
+}
+
+func TestMigrationLockKey(t *testing.T) {
+	assert.Equal(t, migrationLockKey("a"), migrationLockKey("a"))
+	assert.NotEqual(t, migrationLockKey("a"), migrationLockKey("b"))
+	assert.True(t, migrationLockKey("a") >= 0)
+}
+
+func TestPostgresMigrator(t *testing.T) {
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
+	db, err := sql.Open("postgres", dbURL)
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, db.Close()) }()
+	dir := writeTestMigrationsDir(t)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+
+	dbURL += "&x-migrations-table=" + migratorTestMigrationsTable
+	logger := &LogLogger{}
+	params := &MigratorParameters{HistoryTable: migratorTestHistoryTable, AppliedBy: "test"}
+	ms := map[string]Migrator{
+		"bindata": NewBindataMigratorWithParams(dbURL, params,
+			newMapAssetSource(migratorTestMigrations), logger),
+		"fs":  NewFSMigrator(dbURL, params, newMapFS(migratorTestMigrations), logger),
+		"dir": NewDirMigrator(dbURL, params, dir, logger),
+	}
+	for name, m := range ms {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				_, err2 := db.Exec("DROP TABLE " + migratorTestHistoryTable)
+				assert.Nil(t, err2)
+			}()
+			testMigrator(t, db, m)
+		})
+	}
+}
+
+func TestPostgresMigrator_concurrent(t *testing.T) {
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
+	db, err := sql.Open("postgres", dbURL)
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, db.Close()) }()
+
+	dbURL += "&x-migrations-table=" + migratorTestMigrationsTable
+	nReplicas := 4
+	ms := make([]Migrator, nReplicas)
+	for i := range ms {
+		params := &MigratorParameters{
+			LockTimeout:  DefaultMigrationLockTimeout,
+			HistoryTable: migratorTestHistoryTable,
+			AppliedBy:    fmt.Sprintf("replica-%d", i),
+		}
+		ms[i] = NewFSMigrator(dbURL, params, newMapFS(migratorTestMigrations), &LogLogger{})
+	}
+
+	// hold the lock so that all replicas wait for it
+	key := migrationLockKey(migratorTestHistoryTable)
+	conn, err := tryMigrationLock(db, key)
+	assert.Nil(t, err)
+	assert.NotNil(t, conn)
+	timeoutParams := &MigratorParameters{HistoryTable: migratorTestHistoryTable}
+	m := NewFSMigrator(dbURL, timeoutParams, newMapFS(migratorTestMigrations), &LogLogger{})
+	assert.Equal(t, ErrMigrationLockTimeout, m.Up())
+
+	var wg sync.WaitGroup
+	errs := make([]error, nReplicas)
+	for i, m := range ms {
+		wg.Add(1)
+		go func(i int, m Migrator) {
+			defer wg.Done()
+			errs[i] = m.Up()
+		}(i, m)
+	}
+	releaseMigrationLock(conn, key)
+	wg.Wait()
+	for _, err := range errs {
+		assert.Nil(t, err)
+	}
+
+	// each migration applied exactly once, by the same replica
+	records, err := ms[0].History()
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+	for i, r := range records {
+		assert.Equal(t, int64(i+1), r.Version)
+		assert.Equal(t, "up", r.Direction)
+		assert.Equal(t, records[0].AppliedBy, r.AppliedBy)
+		assert.Empty(t, r.Error)
+	}
+	assert.Nil(t, ms[0].Down())
+}
+
+func testMigrator(t *testing.T, db *sql.DB, m Migrator) {
+	checkVersion := func(expected uint, expectedDirty bool) {
+		version, dirty, err2 := m.Version()
+		assert.Nil(t, err2)
+		assert.Equal(t, expected, version)
+		assert.Equal(t, expectedDirty, dirty)
+	}
+	tableExists := func(table string) bool {
+		var exists bool
+		err2 := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists)
+		assert.Nil(t, err2)
+		return exists
+	}
+
+	checkVersion(0, false)
+	records, err := m.History()
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+	statuses, err := m.Status()
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 3)
+	for _, s := range statuses {
+		assert.False(t, s.Applied)
+	}
+
+	planned, err := m.PlanSteps(2)
+	assert.Nil(t, err)
+	assert.Len(t, planned, 2)
+	checkVersion(0, false)
+
+	assert.Nil(t, m.Steps(2))
+	checkVersion(2, false)
+	assert.True(t, tableExists("migrator_test.a"))
+	assert.False(t, tableExists("migrator_test.b"))
+
+	assert.Nil(t, m.Goto(3))
+	checkVersion(3, false)
+	assert.True(t, tableExists("migrator_test.b"))
+	assert.Nil(t, m.Goto(3)) // no change
+
+	assert.Nil(t, m.Steps(-1))
+	checkVersion(2, false)
+	assert.False(t, tableExists("migrator_test.b"))
+	statuses, err = m.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, true, false},
+		[]bool{statuses[0].Applied, statuses[1].Applied, statuses[2].Applied})
+
+	// simulate a failed migration to version 3 and recovering from it
+	_, err = db.Exec("UPDATE " + migratorTestMigrationsTable + " SET version = 3, dirty = TRUE")
+	assert.Nil(t, err)
+	checkVersion(3, true)
+	statuses, err = m.Status()
+	assert.Nil(t, err)
+	assert.True(t, statuses[2].Dirty)
+	assert.NotNil(t, m.Up())
+	assert.Nil(t, m.Force(2))
+	checkVersion(2, false)
+
+	assert.Nil(t, m.Down())
+	checkVersion(0, false)
+	assert.False(t, tableExists("migrator_test.a"))
+
+	records, err = m.History()
+	assert.Nil(t, err)
+	type versionDirection struct {
+		version   int64
+		direction string
+	}
+	expected := []versionDirection{
+		{1, "up"}, {2, "up"}, {3, "up"}, {3, "down"}, {2, MigrationDirectionForce},
+		{2, "down"}, {1, "down"},
+	}
+	actual := make([]versionDirection, len(records))
+	for i, r := range records {
+		actual[i] = versionDirection{r.Version, r.Direction}
+		assert.Equal(t, "test", r.AppliedBy)
+		assert.Empty(t, r.Error)
+		assert.False(t, r.StartedAt.IsZero())
+	}
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "create-a", records[1].Identifier)
+}
@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// DefaultPageSize is the default number of results in a page when none is requested.
+	DefaultPageSize = 100
+
+	// DefaultMaxPageSize is the default maximum number of results in a page.
+	DefaultMaxPageSize = 1000
+
+	// DefaultPageTokenTTL is the default amount of time page tokens are valid for.
+	DefaultPageTokenTTL = 24 * time.Hour
+
+	// MinPageTokenKeyLen is the minimum length of the key used to sign page tokens.
+	MinPageTokenKeyLen = 32
+)
+
+var (
+	// ErrPageTokenKeyTooShort indicates when the page token signing key is shorter than
+	// MinPageTokenKeyLen.
+	ErrPageTokenKeyTooShort = fmt.Errorf("page token key must have at least %d bytes",
+		MinPageTokenKeyLen)
+
+	// ErrInvalidPageSize indicates when the default page size is zero or greater than the
+	// maximum page size.
+	ErrInvalidPageSize = errors.New("default page size must be positive and at most the max")
+
+	// ErrNegativePageTokenTTL indicates when the page token TTL is negative.
+	ErrNegativePageTokenTTL = errors.New("page token TTL must be non-negative")
+
+	// ErrExpiredPageToken indicates when a page token was issued longer than the TTL ago.
+	ErrExpiredPageToken = errors.New("page token expired")
+
+	// ErrPageTokenMismatch indicates when a page token was issued for a different query.
+	ErrPageTokenMismatch = errors.New("page token issued for a different query")
+
+	// ErrPageNotDone indicates when getting the next page token before iterating through a
+	// page.
+	ErrPageNotDone = errors.New("page iteration not done")
+
+	// ErrEmptyKeyset indicates when a Keyset has no columns.
+	ErrEmptyKeyset = errors.New("keyset must have at least one column")
+)
+
+// PaginationParameters defines the signing key and limits of page tokens issued by a Paginator.
+type PaginationParameters struct {
+	// Key is the secret key used to sign page tokens, which must have at least
+	// MinPageTokenKeyLen bytes. Every instance of a service must use the same key.
+	Key []byte
+
+	// DefaultPageSize is the number of results in a page when none is requested.
+	DefaultPageSize uint
+
+	// MaxPageSize is the maximum number of results in a page. Larger requested sizes are
+	// reduced to it.
+	MaxPageSize uint
+
+	// TokenTTL is the amount of time page tokens are valid for. Zero means tokens never expire.
+	TokenTTL time.Duration
+}
+
+// NewDefaultPaginationParameters returns a *PaginationParameters object with default values and
+// no key.
+func NewDefaultPaginationParameters() *PaginationParameters {
+	return &PaginationParameters{
+		DefaultPageSize: DefaultPageSize,
+		MaxPageSize:     DefaultMaxPageSize,
+		TokenTTL:        DefaultPageTokenTTL,
+	}
+}
+
+// Validate checks that the key is long enough, the default page size is positive and at most
+// the max, and the TTL is non-negative.
+func (p *PaginationParameters) Validate() error {
+	if len(p.Key) < MinPageTokenKeyLen {
+		return ErrPageTokenKeyTooShort
+	}
+	if p.DefaultPageSize == 0 || p.DefaultPageSize > p.MaxPageSize {
+		return ErrInvalidPageSize
+	}
+	if p.TokenTTL < 0 {
+		return ErrNegativePageTokenTTL
+	}
+	return nil
+}
+
+// Paginator issues and verifies opaque, signed page tokens for paging through Postgres (via
+// keyset pagination) and Datastore (via cursors) query results. Tokens are rejected with
+// ErrInvalidPageToken if they are malformed or have been tampered with, ErrExpiredPageToken if
+// they were issued longer than the TTL ago, and ErrPageTokenMismatch if they were issued for a
+// different query.
+type Paginator struct {
+	params *PaginationParameters
+	now    func() time.Time
+}
+
+// NewPaginator creates a new *Paginator with the given parameters.
+func NewPaginator(params *PaginationParameters) (*Paginator, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return &Paginator{params: params, now: time.Now}, nil
+}
+
+// PageSize returns the page size for the requested size, which is the default if zero and at
+// most the max.
+func (p *Paginator) PageSize(requested uint) uint {
+	if requested == 0 {
+		return p.params.DefaultPageSize
+	}
+	if requested > p.params.MaxPageSize {
+		return p.params.MaxPageSize
+	}
+	return requested
+}
+
+// Keyset defines the columns a Postgres query is ordered and paged by. The last column (or the
+// columns together) must uniquely identify each row, e.g., by ending with the primary key.
+type Keyset struct {
+	// Cols are the keyset columns, in order.
+	Cols []string
+
+	// Descending indicates whether the results are ordered descending by the columns rather
+	// than ascending.
+	Descending bool
+}
+
+// SQLPage is a page of a Postgres query's results.
+type SQLPage struct {
+	// Select is the query for the page, ordered by the keyset columns, starting after the
+	// previous page, and limited to one more than the page size to indicate whether there is a
+	// next page.
+	Select sq.SelectBuilder
+
+	// Size is the number of results in the page.
+	Size uint
+
+	p     *Paginator
+	scope []byte
+}
+
+// SQLPage returns the page of the given query's results with the requested size (see PageSize)
+// starting after the position encoded in the page token, or at the beginning if the token is
+// empty. The query must not already be ordered or limited. The token must have been issued for
+// the same query, including its arguments, and keyset.
+func (p *Paginator) SQLPage(
+	b sq.SelectBuilder, ks *Keyset, size uint, token string,
+) (*SQLPage, error) {
+	if len(ks.Cols) == 0 {
+		return nil, ErrEmptyKeyset
+	}
+	query, args, err := b.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	scope := pageScope("sql", query, fmt.Sprintf("%#v", args), strings.Join(ks.Cols, ","),
+		fmt.Sprint(ks.Descending))
+	size = p.PageSize(size)
+	direction, cmp := "ASC", ">"
+	if ks.Descending {
+		direction, cmp = "DESC", "<"
+	}
+	orderBys := make([]string, len(ks.Cols))
+	for i, col := range ks.Cols {
+		orderBys[i] = col + " " + direction
+	}
+	b = b.OrderBy(orderBys...).Limit(uint64(size) + 1)
+	if token != "" {
+		pt, err := p.decode(token, scope)
+		if err != nil {
+			return nil, err
+		}
+		if len(pt.Values) != len(ks.Cols) {
+			return nil, ErrInvalidPageToken
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ks.Cols)), ", ")
+		b = b.Where(fmt.Sprintf("(%s) %s (%s)", strings.Join(ks.Cols, ", "), cmp,
+			placeholders), pt.Values...)
+	}
+	return &SQLPage{Select: b, Size: size, p: p, scope: scope}, nil
+}
+
+// NextToken returns the token for the next page given the number of rows the page's query
+// returned and the keyset column values of the last row in the page (i.e., the row at index
+// Size-1). It returns an empty token if there is no next page.
+func (pg *SQLPage) NextToken(nRows int, lastValues ...interface{}) (string, error) {
+	if uint(nRows) <= pg.Size {
+		return "", nil
+	}
+	return pg.p.encode(&pageToken{Scope: pg.scope, Values: lastValues})
+}
+
+// pageToken is the position of the next page of a query's results, encoded in a page token.
+type pageToken struct {
+	// Scope is a digest of the query the token was issued for.
+	Scope []byte
+
+	// Issued is when the token was issued, in Unix seconds.
+	Issued int64
+
+	// Values are the keyset column values of the last row of the previous page.
+	Values []interface{}
+
+	// Cursor is the Datastore cursor after the last result of the previous page.
+	Cursor string
+}
+
+// encode returns the token for the given position, signed with the key.
+func (p *Paginator) encode(pt *pageToken) (string, error) {
+	pt.Issued = p.now().Unix()
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(pt); err != nil {
+		return "", err
+	}
+	payload := buf.Bytes()
+	return base64.RawURLEncoding.EncodeToString(append(payload, p.sign(payload)...)), nil
+}
+
+// decode verifies the token's signature, age, and scope, returning its position.
+func (p *Paginator) decode(token string, scope []byte) (*pageToken, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) < sha256.Size {
+		return nil, ErrInvalidPageToken
+	}
+	payload, sig := buf[:len(buf)-sha256.Size], buf[len(buf)-sha256.Size:]
+	if !hmac.Equal(sig, p.sign(payload)) {
+		return nil, ErrInvalidPageToken
+	}
+	pt := &pageToken{}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(pt); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	issued := time.Unix(pt.Issued, 0)
+	if p.params.TokenTTL > 0 && p.now().Sub(issued) > p.params.TokenTTL {
+		return nil, ErrExpiredPageToken
+	}
+	if !hmac.Equal(pt.Scope, scope) {
+		return nil, ErrPageTokenMismatch
+	}
+	return pt, nil
+}
+
+func (p *Paginator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.params.Key)
+	_, _ = mac.Write(payload) // never returns an error
+	return mac.Sum(nil)
+}
+
+// pageScope returns a digest of the given query parts.
+func pageScope(parts ...string) []byte {
+	h := sha256.New()
+	for _, part := range parts {
+		_, _ = fmt.Fprintf(h, "%d:%s;", len(part), part) // never returns an error
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// DatastorePage is a page of a Datastore query's results.
+type DatastorePage struct {
+	// Query is the query for the page, starting after the previous page and limited to one
+	// more than the page size to indicate whether there is a next page.
+	Query *DatastoreQuery
+
+	// Size is the number of results in the page.
+	Size uint
+
+	p     *Paginator
+	scope []byte
+}
+
+// DatastorePage returns the page of the given query's results with the requested size (see
+// PageSize) starting after the cursor encoded in the page token, or at the beginning if the token
+// is empty. The page's query is a copy of the given one with its limit and start cursor replaced.
+// The scope identifies the query (e.g., its kind, filters, and orders), and the token must have
+// been issued for the same scope.
+func (p *Paginator) DatastorePage(
+	q *DatastoreQuery, scope string, size uint, token string,
+) (*DatastorePage, error) {
+	scopeDigest := pageScope("datastore", scope)
+	size = p.PageSize(size)
+	pq := *q
+	pq.Limit, pq.Start = int(size)+1, datastore.Cursor{}
+	if token != "" {
+		pt, err := p.decode(token, scopeDigest)
+		if err != nil {
+			return nil, err
+		}
+		if pq.Start, err = datastore.DecodeCursor(pt.Cursor); err != nil {
+			return nil, ErrInvalidPageToken
+		}
+	}
+	return &DatastorePage{Query: &pq, Size: size, p: p, scope: scopeDigest}, nil
+}
+
+// Run runs the page's query with the client, returning an iterator over the page's results.
+func (pg *DatastorePage) Run(ctx context.Context, client DatastoreClient) *DatastorePageIterator {
+	return &DatastorePageIterator{page: pg, inner: client.Run(ctx, pg.Query)}
+}
+
+// DatastorePageIterator is an iterator over a page of a Datastore query's results, after which
+// it issues the token for the next page.
+type DatastorePageIterator struct {
+	page  *DatastorePage
+	inner DatastoreIterator
+	n     uint
+	next  *datastore.Cursor
+	done  bool
+}
+
+// Next loads the next result in the page into dst, returning its key, or iterator.Done when there
+// are no more results in the page.
+func (it *DatastorePageIterator) Next(dst interface{}) (*datastore.Key, error) {
+	if it.done {
+		return nil, iterator.Done
+	}
+	if it.n == it.page.Size {
+		// check whether there is a next page, remembering the cursor before its first result
+		it.done = true
+		cursor, err := it.inner.Cursor()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := it.inner.Next(nil); err == iterator.Done {
+			return nil, iterator.Done
+		} else if err != nil {
+			return nil, err
+		}
+		it.next = &cursor
+		return nil, iterator.Done
+	}
+	key, err := it.inner.Next(dst)
+	if err == iterator.Done {
+		it.done = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	it.n++
+	return key, nil
+}
+
+// NextToken returns the token for the next page, which is empty if there is none. It must be
+// called after Next returns iterator.Done.
+func (it *DatastorePageIterator) NextToken() (string, error) {
+	if !it.done {
+		return "", ErrPageNotDone
+	}
+	if it.next == nil {
+		return "", nil
+	}
+	return it.page.p.encode(&pageToken{Scope: it.page.scope, Cursor: it.next.String()})
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+var testPageTokenKey = bytes.Repeat([]byte{1}, MinPageTokenKeyLen)
+
+func TestPaginationParameters_Validate(t *testing.T) {
+	p := NewDefaultPaginationParameters()
+	assert.Equal(t, ErrPageTokenKeyTooShort, p.Validate())
+
+	p.Key = testPageTokenKey
+	assert.Nil(t, p.Validate())
+
+	cases := map[error]func(p *PaginationParameters){
+		ErrInvalidPageSize:      func(p *PaginationParameters) { p.DefaultPageSize = 0 },
+		ErrNegativePageTokenTTL: func(p *PaginationParameters) { p.TokenTTL = -1 },
+	}
+	for expected, modify := range cases {
+		p := NewDefaultPaginationParameters()
+		p.Key = testPageTokenKey
+		modify(p)
+		assert.Equal(t, expected, p.Validate())
+	}
+	p.MaxPageSize = p.DefaultPageSize - 1
+	assert.Equal(t, ErrInvalidPageSize, p.Validate())
+
+	_, err := NewPaginator(NewDefaultPaginationParameters())
+	assert.Equal(t, ErrPageTokenKeyTooShort, err)
+}
+
+func TestPaginator_PageSize(t *testing.T) {
+	p := newTestPaginator(t)
+	assert.Equal(t, uint(DefaultPageSize), p.PageSize(0))
+	assert.Equal(t, uint(10), p.PageSize(10))
+	assert.Equal(t, uint(DefaultMaxPageSize), p.PageSize(DefaultMaxPageSize+1))
+}
+
+func TestPaginator_SQLPage(t *testing.T) {
+	p := newTestPaginator(t)
+	b := sq.Select("id", "created").From("people").Where(sq.Eq{"name": "alice"}).
+		PlaceholderFormat(sq.Dollar)
+	ks := &Keyset{Cols: []string{"created", "id"}, Descending: true}
+
+	page, err := p.SQLPage(b, ks, 2, "")
+	assert.Nil(t, err)
+	query, args, err := page.Select.ToSql()
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT id, created FROM people WHERE name = $1 "+
+		"ORDER BY created DESC, id DESC LIMIT 3", query)
+	assert.Equal(t, []interface{}{"alice"}, args)
+
+	// no next page if the query returned at most the page size
+	token, err := page.NextToken(2, "2018-01-02", int64(2))
+	assert.Nil(t, err)
+	assert.Empty(t, token)
+
+	created := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	token, err = page.NextToken(3, created, int64(2))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+
+	page, err = p.SQLPage(b, ks, 2, token)
+	assert.Nil(t, err)
+	query, args, err = page.Select.ToSql()
+	assert.Nil(t, err)
+	assert.Equal(t, "SELECT id, created FROM people WHERE name = $1 "+
+		"AND (created, id) < ($2, $3) ORDER BY created DESC, id DESC LIMIT 3", query)
+	assert.Equal(t, []interface{}{"alice", created, int64(2)}, args)
+
+	// tokens are only valid for the same query and keyset
+	other := sq.Select("id", "created").From("people").Where(sq.Eq{"name": "bob"}).
+		PlaceholderFormat(sq.Dollar)
+	_, err = p.SQLPage(other, ks, 2, token)
+	assert.Equal(t, ErrPageTokenMismatch, err)
+	_, err = p.SQLPage(b, &Keyset{Cols: []string{"created", "id"}}, 2, token)
+	assert.Equal(t, ErrPageTokenMismatch, err)
+
+	_, err = p.SQLPage(b, &Keyset{}, 2, "")
+	assert.Equal(t, ErrEmptyKeyset, err)
+}
+
+func TestPaginator_decode(t *testing.T) {
+	p := newTestPaginator(t)
+	scope := pageScope("test")
+	token, err := p.encode(&pageToken{Scope: scope, Values: []interface{}{"a"}})
+	assert.Nil(t, err)
+	pt, err := p.decode(token, scope)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"a"}, pt.Values)
+
+	// tampered tokens are invalid
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	assert.Nil(t, err)
+	buf[len(buf)/2] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(buf)
+	for _, invalid := range []string{tampered, "not base64!", "c2hvcnQ", token[:len(token)-2]} {
+		_, err = p.decode(invalid, scope)
+		assert.Equal(t, ErrInvalidPageToken, err, invalid)
+	}
+
+	// tokens signed with another key are invalid
+	params := NewDefaultPaginationParameters()
+	params.Key = bytes.Repeat([]byte{2}, MinPageTokenKeyLen)
+	p2, err := NewPaginator(params)
+	assert.Nil(t, err)
+	_, err = p2.decode(token, scope)
+	assert.Equal(t, ErrInvalidPageToken, err)
+
+	// expired tokens are stale
+	p.now = func() time.Time { return time.Now().Add(DefaultPageTokenTTL + time.Minute) }
+	_, err = p.decode(token, scope)
+	assert.Equal(t, ErrExpiredPageToken, err)
+	p.params.TokenTTL = 0
+	_, err = p.decode(token, scope)
+	assert.Nil(t, err)
+}
+
+func TestPaginator_DatastorePage(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPaginator(t)
+	c := NewFakeDatastoreClient()
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		person := &fakeTestPerson{Name: name, Age: 10 * i}
+		_, err := c.Put(ctx, datastore.NameKey(fakeTestKind, name, nil), person)
+		assert.Nil(t, err)
+	}
+	q := &DatastoreQuery{
+		Kind:   fakeTestKind,
+		Orders: []*DatastoreOrder{{Field: "Age", Descending: true}},
+	}
+	scope := "people by age"
+
+	pages, token := make([][]string, 0), ""
+	for {
+		page, err := p.DatastorePage(q, scope, 2, token)
+		assert.Nil(t, err)
+		iter := page.Run(ctx, c)
+		_, err = iter.NextToken()
+		assert.Equal(t, ErrPageNotDone, err)
+		names := make([]string, 0)
+		for {
+			person := &fakeTestPerson{}
+			_, err = iter.Next(person)
+			if err == iterator.Done {
+				break
+			}
+			assert.Nil(t, err)
+			names = append(names, person.Name)
+		}
+		pages = append(pages, names)
+		token, err = iter.NextToken()
+		assert.Nil(t, err)
+		if token == "" {
+			break
+		}
+		_, err = p.DatastorePage(q, "other scope", 2, token)
+		assert.Equal(t, ErrPageTokenMismatch, err)
+	}
+	assert.Equal(t, [][]string{{"e", "d"}, {"c", "b"}, {"a"}}, pages)
+
+	// no next page when the last page is full
+	page, err := p.DatastorePage(q, scope, 5, "")
+	assert.Nil(t, err)
+	iter := page.Run(ctx, c)
+	n := 0
+	for ; ; n++ {
+		if _, err = iter.Next(nil); err == iterator.Done {
+			break
+		}
+	}
+	assert.Equal(t, 5, n)
+	token, err = iter.NextToken()
+	assert.Nil(t, err)
+	assert.Empty(t, token)
+}
+
+func newTestPaginator(t *testing.T) *Paginator {
+	params := NewDefaultPaginationParameters()
+	params.Key = testPageTokenKey
+	p, err := NewPaginator(params)
+	assert.Nil(t, err)
+	return p
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"errors"
+
+	cerrors "github.com/drausin/libri/libri/common/errors"
+	"github.com/elixirhealth/service-base/pkg/util"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// ErrMissingDBURL indicates when Postgres storage is missing its DB URL.
+	ErrMissingDBURL = errors.New("Postgres storage requires a DB URL")
+
+	// ErrUnexpectedDBURL indicates when a DB URL is given for non-Postgres storage.
+	ErrUnexpectedDBURL = errors.New("DB URL only applies to Postgres storage")
+)
+
+// Parameters defines the storage backend type and connection parameters common to all services.
+type Parameters struct {
+	// Type is the storage backend type.
+	Type Type
+
+	// DBURL is the URL of the Postgres DB when the Type is Postgres.
+	DBURL string
+
+	// Postgres defines the connection pool and session parameters used by OpenPostgres. If nil,
+	// the defaults are used.
+	Postgres *PostgresParameters
+}
+
+// Validate checks that the type is valid, that a DB URL is given if and only if the type is
+// Postgres, and that the Postgres parameters (if any) are valid.
+func (p *Parameters) Validate() error {
+	if p.Type < Unspecified || p.Type > Postgres {
+		return ErrInvalidType
+	}
+	if p.Type == Postgres && p.DBURL == "" {
+		return ErrMissingDBURL
+	}
+	if p.Type != Postgres && p.DBURL != "" {
+		return ErrUnexpectedDBURL
+	}
+	if p.Postgres != nil {
+		return p.Postgres.Validate()
+	}
+	return nil
+}
+
+// MarshalLogObject writes the parameters to the given object encoder.
+func (p *Parameters) MarshalLogObject(oe zapcore.ObjectEncoder) error {
+	oe.AddString(logType, p.Type.String())
+	if p.DBURL != "" {
+		oe.AddString(logDBURL, util.RedactPasswords(p.DBURL))
+	}
+	if p.Postgres != nil {
+		cerrors.MaybePanic(oe.AddObject(logPostgres, p.Postgres)) // should never happen
+	}
+	return nil
+}
@@ -5,32 +5,22 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os/exec"
 	"strings"
 	"testing"
-	"time"
 
 	sq "github.com/Masterminds/squirrel"
-	"github.com/cenkalti/backoff"
-	"github.com/drausin/libri/libri/common/errors"
 	_ "github.com/lib/pq" // loads "postgres" driver for database/sql
-	"github.com/mattes/migrate"
-	_ "github.com/mattes/migrate/database/postgres" // loads "postgres" driver for migrate
 	"github.com/mattes/migrate/source/go-bindata"
 	"go.uber.org/zap"
 )
 
 const (
 	migrationPrefix = "[migration] "
-
-	// these vars assume the particular installation settings and paths found in the Docker
-	// gcr.io/elixir-core-prod/service-base-build image
-	postgresTestServerDir = "/var/lib/postgresql/10/tests"
-	postgresTestServerLog = "/var/log/postgresql/tests.log"
-	postgresDBName        = "postgres"
+	postgresDBName  = "postgres"
 )
 
-// ColDest is a mapping from a column name to a sql.Scan destination type.
+// ColDest is a mapping from a column name to a sql.Scan destination type. StructColDests derives
+// them from a struct's `db` field tags.
 type ColDest struct {
 	Col  string
 	Dest interface{}
@@ -64,15 +54,35 @@ type Querier interface {
 	InsertExecContext(ctx context.Context, b sq.InsertBuilder) (sql.Result, error)
 	UpdateExecContext(ctx context.Context, b sq.UpdateBuilder) (sql.Result, error)
 	DeleteExecContext(ctx context.Context, b sq.DeleteBuilder) (sql.Result, error)
+
+	// WithTx runs the given function within a transaction, committing it if the function
+	// returns no error and rolling it back otherwise. The function's statements must be run via
+	// the given tx Querier. The whole transaction is retried on serialization failures and
+	// deadlocks, so the function should have no side effects outside the transaction. Calling
+	// WithTx on a tx Querier runs the function within the existing transaction.
+	WithTx(ctx context.Context, opts *TxOptions, fn func(tx Querier) error) error
+
+	// BulkLoadContext inserts the rows of the bulk load in batches within a transaction (or the
+	// existing one of a tx Querier), using COPY FROM STDIN when possible and multi-row INSERTs
+	// otherwise (see BulkMethod).
+	BulkLoadContext(ctx context.Context, l *BulkLoad) (*BulkLoadResult, error)
 }
 
-type querierImpl struct{}
+type querierImpl struct {
+	db *sql.DB
+}
 
-// NewQuerier returns a new Querier.
+// NewQuerier returns a new Querier. Since it has no DB to begin transactions on, its WithTx and
+// BulkLoadContext methods return ErrNoTxDB.
 func NewQuerier() Querier {
 	return &querierImpl{}
 }
 
+// NewQuerierWithDB returns a new Querier able to run transactions on the given DB.
+func NewQuerierWithDB(db *sql.DB) Querier {
+	return &querierImpl{db: db}
+}
+
 func (q *querierImpl) SelectQueryContext(
 	ctx context.Context, b sq.SelectBuilder,
 ) (QueryRows, error) {
@@ -103,72 +113,19 @@ func (q *querierImpl) DeleteExecContext(
 	return b.ExecContext(ctx)
 }
 
-// Migrator handles Postgres DB migrations. It is a thin wrapper around *Migrate in mattes/migrate
-// package.
-type Migrator interface {
-	// Up migrates the DB up to the latest state.
-	Up() error
-
-	// Down migrates the DB all the way to the empty state.
-	Down() error
-}
-
-type bindataMigrator struct {
-	dbURL  string
-	as     *bindata.AssetSource
-	logger migrate.Logger
-}
-
-// NewBindataMigrator creates a new Migrator from the given go-bindata asset source and using the
-// given logger.
-func NewBindataMigrator(dbURL string, as *bindata.AssetSource, logger migrate.Logger) Migrator {
-	return &bindataMigrator{
-		dbURL:  dbURL,
-		as:     as,
-		logger: logger,
+func (q *querierImpl) WithTx(
+	ctx context.Context, opts *TxOptions, fn func(tx Querier) error,
+) error {
+	if q.db == nil {
+		return ErrNoTxDB
 	}
-}
-
-// Up migrates the DB up to the latest state.
-func (bm *bindataMigrator) Up() error {
-	m := bm.newInner()
-	op := func() error {
-		err := m.Up()
-		if err == migrate.ErrNoChange {
-			return nil
+	return runTx(ctx, opts, fn, func(sqlOpts *sql.TxOptions) (txHandle, error) {
+		tx, err := q.db.BeginTx(ctx, sqlOpts)
+		if err != nil {
+			return nil, err
 		}
-		return err
-	}
-	if err := backoff.Retry(op, newShortExpBackoff()); err != nil {
-		return err
-	}
-	err1, err2 := m.Close()
-	if err1 != nil {
-		return err1
-	}
-	return err2
-}
-
-// Down migrates the DB down to the empty state.
-func (bm *bindataMigrator) Down() error {
-	m := bm.newInner()
-	if err := m.Down(); err != nil {
-		return err
-	}
-	err1, err2 := m.Close()
-	if err1 != nil {
-		return err1
-	}
-	return err2
-}
-
-func (bm *bindataMigrator) newInner() *migrate.Migrate {
-	d, err := bindata.WithInstance(bm.as)
-	errors.MaybePanic(err) // should never happen
-	m, err := migrate.NewWithSourceInstance("go-bindata", d, bm.dbURL)
-	errors.MaybePanic(err) // should never happen
-	m.Log = bm.logger
-	return m
+		return &txQuerier{tx: tx}, nil
+	})
 }
 
 // LogLogger implements migrate.Logger via log.Printf
@@ -199,7 +156,8 @@ func (zl *ZapLogger) Verbose() bool {
 	return false
 }
 
-// SetUpTestPostgres migrates the DB with the given URL and asset source.
+// SetUpTestPostgres migrates the DB with the given URL and asset source. Since tests using it share
+// the DB, they can't run in parallel; NewTestPostgresDB gives each test its own DB instead.
 func SetUpTestPostgres(t *testing.T, dbURL string, as *bindata.AssetSource) func() {
 	logger := &LogLogger{}
 	m := NewBindataMigrator(dbURL, as, logger)
@@ -213,58 +171,3 @@ func SetUpTestPostgres(t *testing.T, dbURL string, as *bindata.AssetSource) func
 	}
 	return tearDown
 }
-
-// StartTestPostgres starts a Postgres server for tests to use. It assumes that pg_ctl is available
-// in the PATH and that the postgresTestServerDir and postgresTestServerLog are valid paths. This
-// generally will only be the case when running inside of a
-// gcr.io/elixir-core-prod/service-base-build Docker container.
-// nolint: gas
-func StartTestPostgres() (dbURL string, cleanup func() error, err error) {
-	dbURL = fmt.Sprintf("postgres://localhost:5432/%s?sslmode=disable", postgresDBName)
-	cleanup = func() error {
-		stopCmd := exec.Command("pg_ctl",
-			"-D", postgresTestServerDir,
-			"-l", postgresTestServerLog,
-			"stop")
-		return stopCmd.Run()
-	}
-	if err = dbReady(dbURL, false); err == nil {
-		// DB already running (perhaps from previous test, so don't try to start again
-		return dbURL, cleanup, nil
-	}
-	startCmd := exec.Command("pg_ctl",
-		"-D", postgresTestServerDir,
-		"-l", postgresTestServerLog,
-		"start")
-	if err = startCmd.Run(); err != nil {
-		noCleanup := func() error { return nil }
-		return "", noCleanup, err
-	}
-
-	// wait for DB to become available
-	err = dbReady(dbURL, true)
-	return dbURL, cleanup, err
-}
-
-func dbReady(dbURL string, retry bool) error {
-	op := func() error {
-		var err error
-		db, err := sql.Open("postgres", dbURL)
-		if err != nil {
-			return err
-		}
-		return db.Ping()
-	}
-
-	if err := op(); err == nil || !retry {
-		return err
-	}
-	return backoff.Retry(op, newShortExpBackoff())
-}
-
-func newShortExpBackoff() backoff.BackOff {
-	bo := backoff.NewExponentialBackOff()
-	bo.MaxInterval = time.Second * 1
-	bo.MaxElapsedTime = 5 * time.Second
-	return bo
-}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultBulkBatchSize is the default number of rows per batch of a bulk load.
+	DefaultBulkBatchSize = 1000
+
+	// maxStmtParams is the maximum number of parameters of a Postgres statement, which limits
+	// the number of rows per multi-row INSERT.
+	maxStmtParams = 65535
+)
+
+var (
+	// ErrEmptyBulkLoad indicates when a bulk load is missing its table or columns.
+	ErrEmptyBulkLoad = errors.New("bulk load must have a table and columns")
+
+	// ErrBulkRowLength indicates when a bulk load row doesn't have a value for each column.
+	ErrBulkRowLength = errors.New("bulk load row must have a value for each column")
+
+	// ErrBulkCopyConflict indicates when a bulk load using COPY has an ON CONFLICT clause,
+	// which COPY doesn't support.
+	ErrBulkCopyConflict = errors.New("COPY doesn't support ON CONFLICT clauses")
+
+	// ErrBulkCopyUnsupported indicates when a bulk load using COPY is run by a Querier that
+	// can't run COPY statements.
+	ErrBulkCopyUnsupported = errors.New("querier doesn't support COPY")
+)
+
+// BulkMethod is the statement used to insert the rows of a bulk load.
+type BulkMethod int
+
+const (
+	// BulkAuto uses COPY unless the bulk load has an ON CONFLICT clause or the Querier can't run
+	// COPY statements, in which case it uses multi-row INSERTs.
+	BulkAuto BulkMethod = iota
+
+	// BulkCopy uses COPY FROM STDIN, which is the fastest.
+	BulkCopy
+
+	// BulkInsert uses multi-row INSERTs.
+	BulkInsert
+)
+
+// String returns a string representation of the method.
+func (m BulkMethod) String() string {
+	switch m {
+	case BulkCopy:
+		return "copy"
+	case BulkInsert:
+		return "insert"
+	default:
+		return "auto"
+	}
+}
+
+// BulkLoad defines rows to insert into a table in batches via Querier.BulkLoadContext.
+type BulkLoad struct {
+	// Table is the (optionally schema-qualified) table to insert the rows into.
+	Table string
+
+	// Columns are the columns of the rows' values.
+	Columns []string
+
+	// Rows are the values of the rows to insert, each with a value per column.
+	Rows [][]interface{}
+
+	// OnConflict is an optional ON CONFLICT clause (e.g., "ON CONFLICT (id) DO NOTHING") of
+	// multi-row INSERTs.
+	OnConflict string
+
+	// Method is the statement used to insert the rows.
+	Method BulkMethod
+
+	// BatchSize is the maximum number of rows per batch. If zero, DefaultBulkBatchSize is used.
+	// The batches of multi-row INSERTs are also limited by the maximum number of statement
+	// parameters.
+	BatchSize uint
+
+	// OnBatch, if not nil, is called after each batch is inserted, e.g., to report progress.
+	// Since transactions may be retried, it may be called again for the same rows.
+	OnBatch func(b *BulkBatch)
+}
+
+// BulkBatch describes a batch of a bulk load after it is inserted.
+type BulkBatch struct {
+	// Method is the statement used to insert the batch.
+	Method BulkMethod
+
+	// Rows is the number of rows in the batch.
+	Rows int
+
+	// Inserted is the number of rows inserted, which may be fewer than the number of rows if
+	// an ON CONFLICT clause skipped some.
+	Inserted int64
+
+	// Duration is how long inserting the batch took.
+	Duration time.Duration
+}
+
+// BulkLoadResult describes a completed bulk load.
+type BulkLoadResult struct {
+	// Method is the statement used to insert the rows.
+	Method BulkMethod
+
+	// Batches is the number of batches.
+	Batches int
+
+	// Inserted is the number of rows inserted.
+	Inserted int64
+}
+
+// copyInFunc inserts the given rows of a bulk load via a COPY statement, returning the number of
+// rows inserted.
+type copyInFunc func(ctx context.Context, l *BulkLoad, rows [][]interface{}) (int64, error)
+
+// runBulkLoad inserts the rows of the bulk load in batches via the given COPY function (which is
+// nil if COPY isn't supported) or insert function.
+func runBulkLoad(
+	ctx context.Context,
+	l *BulkLoad,
+	copyIn copyInFunc,
+	insert func(ctx context.Context, b sq.InsertBuilder) (sql.Result, error),
+) (*BulkLoadResult, error) {
+	if l.Table == "" || len(l.Columns) == 0 {
+		return nil, ErrEmptyBulkLoad
+	}
+	for _, row := range l.Rows {
+		if len(row) != len(l.Columns) {
+			return nil, ErrBulkRowLength
+		}
+	}
+	method := l.Method
+	if method == BulkAuto {
+		method = BulkCopy
+		if l.OnConflict != "" || copyIn == nil {
+			method = BulkInsert
+		}
+	}
+	if method == BulkCopy && l.OnConflict != "" {
+		return nil, ErrBulkCopyConflict
+	}
+	if method == BulkCopy && copyIn == nil {
+		return nil, ErrBulkCopyUnsupported
+	}
+	batchSize := int(l.BatchSize)
+	if batchSize == 0 {
+		batchSize = DefaultBulkBatchSize
+	}
+	if maxRows := maxStmtParams / len(l.Columns); method == BulkInsert && batchSize > maxRows {
+		batchSize = maxRows
+	}
+
+	result := &BulkLoadResult{Method: method}
+	qb := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	for start := 0; start < len(l.Rows); start += batchSize {
+		end := start + batchSize
+		if end > len(l.Rows) {
+			end = len(l.Rows)
+		}
+		batchStart := time.Now()
+		var inserted int64
+		var err error
+		if method == BulkCopy {
+			inserted, err = copyIn(ctx, l, l.Rows[start:end])
+		} else {
+			inserted, err = insertBatch(ctx, qb, l, l.Rows[start:end], insert)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Batches++
+		result.Inserted += inserted
+		if l.OnBatch != nil {
+			l.OnBatch(&BulkBatch{
+				Method:   method,
+				Rows:     end - start,
+				Inserted: inserted,
+				Duration: time.Since(batchStart),
+			})
+		}
+	}
+	return result, nil
+}
+
+func insertBatch(
+	ctx context.Context,
+	qb sq.StatementBuilderType,
+	l *BulkLoad,
+	rows [][]interface{},
+	insert func(ctx context.Context, b sq.InsertBuilder) (sql.Result, error),
+) (int64, error) {
+	b := qb.Insert(l.Table).Columns(l.Columns...)
+	for _, row := range rows {
+		b = b.Values(row...)
+	}
+	if l.OnConflict != "" {
+		b = b.Suffix(l.OnConflict)
+	}
+	result, err := insert(ctx, b)
+	if err != nil {
+		return 0, err
+	}
+	if result == nil {
+		// e.g., from a MockQuerier without an InsertResult
+		return int64(len(rows)), nil
+	}
+	return result.RowsAffected()
+}
+
+// copyInSQL returns the COPY FROM STDIN statement for the (optionally schema-qualified) table and
+// columns.
+func copyInSQL(table string, cols []string) string {
+	if i := strings.Index(table, "."); i >= 0 {
+		return pq.CopyInSchema(table[:i], table[i+1:], cols...)
+	}
+	return pq.CopyIn(table, cols...)
+}
+
+func (q *querierImpl) BulkLoadContext(ctx context.Context, l *BulkLoad) (*BulkLoadResult, error) {
+	if q.db == nil {
+		return nil, ErrNoTxDB
+	}
+	var result *BulkLoadResult
+	err := q.WithTx(ctx, nil, func(tx Querier) error {
+		var err error
+		result, err = tx.BulkLoadContext(ctx, l)
+		return err
+	})
+	return result, err
+}
+
+func (q *txQuerier) BulkLoadContext(ctx context.Context, l *BulkLoad) (*BulkLoadResult, error) {
+	return runBulkLoad(ctx, l, q.copyIn, q.InsertExecContext)
+}
+
+func (q *txQuerier) copyIn(ctx context.Context, l *BulkLoad, rows [][]interface{}) (int64, error) {
+	stmt, err := q.tx.PrepareContext(ctx, copyInSQL(l.Table, l.Columns))
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			_ = stmt.Close()
+			return 0, err
+		}
+	}
+	// flush the buffered rows
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return 0, err
+	}
+	return int64(len(rows)), stmt.Close()
+}
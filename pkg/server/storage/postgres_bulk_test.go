@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+var testBulkCols = []string{"id", "field_1", "field_2"}
+
+func TestMockQuerier_BulkLoadContext_copy(t *testing.T) {
+	q := &MockQuerier{}
+	batches := make([]*BulkBatch, 0)
+	l := &BulkLoad{
+		Table:     "test.test",
+		Columns:   testBulkCols,
+		Rows:      newTestBulkRows(5),
+		BatchSize: 2,
+		OnBatch:   func(b *BulkBatch) { batches = append(batches, b) },
+	}
+	result, err := q.BulkLoadContext(context.Background(), l)
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkLoadResult{Method: BulkCopy, Batches: 3, Inserted: 5}, result)
+	assert.Equal(t, []string{BeginEvent, CopyEvent, CopyEvent, CopyEvent, CommitEvent},
+		q.EventTypes())
+	assert.Equal(t, `COPY "test"."test" ("id", "field_1", "field_2") FROM STDIN`, q.Events[1].SQL)
+	assert.Equal(t, []interface{}{"id4", "row-4", 4}, q.Events[3].Args)
+	assert.Len(t, batches, 3)
+	assert.Equal(t, BulkCopy, batches[2].Method)
+	assert.Equal(t, 1, batches[2].Rows)
+	assert.Equal(t, int64(1), batches[2].Inserted)
+
+	// errors roll back the transaction
+	q = &MockQuerier{CopyErr: errors.New("some copy error")}
+	_, err = q.BulkLoadContext(context.Background(), l)
+	assert.Equal(t, q.CopyErr, err)
+	assert.Equal(t, []string{BeginEvent, CopyEvent, RollbackEvent}, q.EventTypes())
+}
+
+func TestMockQuerier_BulkLoadContext_insert(t *testing.T) {
+	q := &MockQuerier{InsertResult: driver.RowsAffected(1)}
+	l := &BulkLoad{
+		Table:      "test.test",
+		Columns:    testBulkCols,
+		Rows:       newTestBulkRows(3),
+		OnConflict: "ON CONFLICT (id) DO NOTHING",
+		BatchSize:  2,
+	}
+	result, err := q.BulkLoadContext(context.Background(), l)
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkLoadResult{Method: BulkInsert, Batches: 2, Inserted: 2}, result)
+	assert.Equal(t, []string{BeginEvent, InsertEvent, InsertEvent, CommitEvent}, q.EventTypes())
+	assert.Equal(t, "INSERT INTO test.test (id,field_1,field_2) VALUES ($1,$2,$3),($4,$5,$6) "+
+		"ON CONFLICT (id) DO NOTHING", q.Events[1].SQL)
+
+	// multi-row INSERTs are limited by the maximum number of statement parameters
+	q = &MockQuerier{}
+	l = &BulkLoad{
+		Table:     "test.test",
+		Columns:   testBulkCols,
+		Rows:      newTestBulkRows(maxStmtParams/3 + 1),
+		Method:    BulkInsert,
+		BatchSize: maxStmtParams,
+	}
+	result, err = q.BulkLoadContext(context.Background(), l)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Batches)
+	assert.Len(t, q.Events[1].Args, maxStmtParams)
+}
+
+func TestBulkLoad_err(t *testing.T) {
+	ctx := context.Background()
+	cases := map[error]*BulkLoad{
+		ErrEmptyBulkLoad: {Columns: testBulkCols},
+		ErrBulkRowLength: {
+			Table:   "test.test",
+			Columns: testBulkCols,
+			Rows:    [][]interface{}{{"id1"}},
+		},
+		ErrBulkCopyConflict: {
+			Table:      "test.test",
+			Columns:    testBulkCols,
+			OnConflict: "ON CONFLICT DO NOTHING",
+			Method:     BulkCopy,
+		},
+	}
+	for expected, l := range cases {
+		_, err := (&MockQuerier{}).BulkLoadContext(ctx, l)
+		assert.Equal(t, expected, err)
+	}
+
+	l := &BulkLoad{Table: "test.test", Columns: testBulkCols, Method: BulkCopy}
+	_, err := runBulkLoad(ctx, l, nil, NewQuerier().InsertExecContext)
+	assert.Equal(t, ErrBulkCopyUnsupported, err)
+	_, err = NewQuerier().BulkLoadContext(ctx, l)
+	assert.Equal(t, ErrNoTxDB, err)
+
+	assert.Equal(t, "auto", BulkAuto.String())
+	assert.Equal(t, "copy", BulkCopy.String())
+	assert.Equal(t, "insert", BulkInsert.String())
+}
+
+func TestPostgresBulkLoad(t *testing.T) {
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
+	db, err := sql.Open("postgres", dbURL)
+	assert.Nil(t, err)
+	if err != nil {
+		return
+	}
+	defer func() { assert.Nil(t, db.Close()) }()
+	ctx := context.Background()
+	q := NewQuerierWithDB(db)
+	count := func() int {
+		var n int
+		row := db.QueryRow("SELECT COUNT(*) FROM test.test")
+		assert.Nil(t, row.Scan(&n))
+		return n
+	}
+
+	l := &BulkLoad{Table: "test.test", Columns: testBulkCols, Rows: newTestBulkRows(2500)}
+	result, err := q.BulkLoadContext(ctx, l)
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkLoadResult{Method: BulkCopy, Batches: 3, Inserted: 2500}, result)
+	assert.Equal(t, 2500, count())
+
+	// conflicts fail the whole load
+	l.Rows = newTestBulkRows(2600)
+	_, err = q.BulkLoadContext(ctx, l)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2500, count())
+
+	l.OnConflict = "ON CONFLICT (id) DO NOTHING"
+	result, err = q.BulkLoadContext(ctx, l)
+	assert.Nil(t, err)
+	assert.Equal(t, &BulkLoadResult{Method: BulkInsert, Batches: 3, Inserted: 100}, result)
+	assert.Equal(t, 2600, count())
+
+	// within an existing transaction
+	l = &BulkLoad{Table: "test.test", Columns: testBulkCols, Rows: [][]interface{}{
+		{"extra", "row-extra", 0},
+	}}
+	err = q.WithTx(ctx, nil, func(tx Querier) error {
+		_, err2 := tx.BulkLoadContext(ctx, l)
+		return err2
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2601, count())
+}
+
+func BenchmarkPostgresBulkLoad(b *testing.B) {
+	nRows := 10000
+	benchmarks := map[string]func(ctx context.Context, q Querier, rows [][]interface{}) error{
+		"copy": func(ctx context.Context, q Querier, rows [][]interface{}) error {
+			l := &BulkLoad{Table: "test.test", Columns: testBulkCols, Rows: rows}
+			_, err := q.BulkLoadContext(ctx, l)
+			return err
+		},
+		"multi-row insert": func(ctx context.Context, q Querier, rows [][]interface{}) error {
+			l := &BulkLoad{
+				Table:   "test.test",
+				Columns: testBulkCols,
+				Rows:    rows,
+				Method:  BulkInsert,
+			}
+			_, err := q.BulkLoadContext(ctx, l)
+			return err
+		},
+		"single-row inserts": func(ctx context.Context, q Querier, rows [][]interface{}) error {
+			return q.WithTx(ctx, nil, func(tx Querier) error {
+				qb := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+				for _, row := range rows {
+					insert := qb.Insert("test.test").Columns(testBulkCols...).Values(row...)
+					if _, err := tx.InsertExecContext(ctx, insert); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	}
+	for name, load := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			dbURL := setUpPostgresTest(b)
+			db, err := sql.Open("postgres", dbURL)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() { _ = db.Close() }()
+			ctx := context.Background()
+			q := NewQuerierWithDB(db)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if _, err = db.Exec("TRUNCATE test.test"); err != nil {
+					b.Fatal(err)
+				}
+				rows := newTestBulkRows(nRows)
+				b.StartTimer()
+				if err = load(ctx, q, rows); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func newTestBulkRows(n int) [][]interface{} {
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{fmt.Sprintf("id%d", i), fmt.Sprintf("row-%d", i), i}
+	}
+	return rows
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+const (
+	// TestPostgresBinDirEnv is the environment variable giving the directory of the Postgres
+	// binaries (e.g., /usr/lib/postgresql/10/bin) for StartTestPostgres.
+	TestPostgresBinDirEnv = "TEST_POSTGRES_BIN_DIR"
+
+	// DefaultTestPostgresStartTimeout is the default maximum amount of time to wait for a test
+	// Postgres server to become ready.
+	DefaultTestPostgresStartTimeout = 30 * time.Second
+
+	testPostgresDirPrefix   = "test-postgres-"
+	testPostgresOwnerFile   = "owner.pid"
+	testPostgresLogFile     = "postgres.log"
+	testPostgresUser        = "postgres"
+	testPostgresHost        = "127.0.0.1"
+	testPostgresStopTimeout = 10 * time.Second
+	testPostgresLogTailLen  = 2048
+)
+
+var (
+	// ErrPostgresBinNotFound indicates when the initdb and postgres binaries aren't in the
+	// configured bin directory, on the PATH, or in a common install directory.
+	ErrPostgresBinNotFound = errors.New("Postgres binaries (initdb, postgres) not found")
+
+	// ErrTestPostgresExited indicates when a test Postgres server exits before becoming ready.
+	ErrTestPostgresExited = errors.New("test Postgres server exited before becoming ready")
+
+	// common install dirs of Postgres packages, whose binaries often aren't on the PATH
+	postgresBinDirGlobs = []string{
+		"/usr/lib/postgresql/*/bin", // Debian and Ubuntu
+		"/usr/pgsql-*/bin",          // RHEL and CentOS
+		"/usr/local/pgsql/bin",      // built from source
+	}
+	postgresBins = []string{"initdb", "postgres"}
+)
+
+// TestPostgresParameters defines how a throwaway test Postgres server is launched.
+type TestPostgresParameters struct {
+	// BinDir is the directory of the initdb and postgres binaries. If empty, they are looked
+	// for on the PATH and then in common install directories (using the latest version).
+	BinDir string
+
+	// Port is the port to listen on. If zero, a free port is picked.
+	Port int
+
+	// TempDir is the directory in which to create the server's temporary directory. If empty,
+	// the default temporary directory is used.
+	TempDir string
+
+	// StartTimeout is the maximum amount of time to wait for the server to become ready.
+	StartTimeout time.Duration
+}
+
+// NewDefaultTestPostgresParameters returns a *TestPostgresParameters object with default values
+// and the bin dir from the TestPostgresBinDirEnv environment variable (if set).
+func NewDefaultTestPostgresParameters() *TestPostgresParameters {
+	return &TestPostgresParameters{
+		BinDir:       os.Getenv(TestPostgresBinDirEnv),
+		StartTimeout: DefaultTestPostgresStartTimeout,
+	}
+}
+
+// TestPostgres is a throwaway Postgres server for tests, whose data directory is in a temporary
+// directory removed when it is stopped.
+type TestPostgres struct {
+	// DBURL is the URL of the server's postgres DB.
+	DBURL string
+
+	dir      string
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// StartTestPostgres starts a throwaway Postgres server for tests to use with the default
+// parameters, returning its DB URL and a function to stop it and remove its data.
+func StartTestPostgres() (dbURL string, cleanup func() error, err error) {
+	tp, err := LaunchTestPostgres(NewDefaultTestPostgresParameters())
+	if err != nil {
+		noCleanup := func() error { return nil }
+		return "", noCleanup, err
+	}
+	return tp.DBURL, tp.Stop, nil
+}
+
+// LaunchTestPostgres initializes a new data directory in a temporary directory and starts a
+// Postgres server on it, waiting until it's ready. Durability is turned off for speed. On Linux,
+// the server is killed if the test process dies (e.g., after a test panic) without stopping it,
+// and temporary directories left behind by such processes are removed on later launches.
+// Postgres refuses to run as root, so tests using it must run as a regular user.
+func LaunchTestPostgres(params *TestPostgresParameters) (*TestPostgres, error) {
+	binDir, err := findPostgresBinDir(params.BinDir)
+	if err != nil {
+		return nil, err
+	}
+	removeStaleTestPostgresDirs(params.TempDir)
+	dir, err := ioutil.TempDir(params.TempDir, testPostgresDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	tp := &TestPostgres{dir: dir}
+	if err = tp.start(binDir, params); err != nil {
+		_ = tp.Stop()
+		return nil, err
+	}
+	return tp, nil
+}
+
+// Stop shuts the server down and removes its temporary directory. It is safe to call more than
+// once.
+func (tp *TestPostgres) Stop() error {
+	tp.stopOnce.Do(func() {
+		tp.stopErr = tp.stop()
+	})
+	return tp.stopErr
+}
+
+func (tp *TestPostgres) start(binDir string, params *TestPostgresParameters) error {
+	ownerPID := []byte(strconv.Itoa(os.Getpid()))
+	err := ioutil.WriteFile(filepath.Join(tp.dir, testPostgresOwnerFile), ownerPID, 0600)
+	if err != nil {
+		return err
+	}
+	dataDir := filepath.Join(tp.dir, "data")
+	initCmd := exec.Command(filepath.Join(binDir, "initdb"), // nolint: gas
+		"-D", dataDir,
+		"-U", testPostgresUser,
+		"-A", "trust",
+		"-E", "UTF8",
+		"-N", // don't wait for the files to be synced to disk
+	)
+	if out, err2 := initCmd.CombinedOutput(); err2 != nil {
+		return fmt.Errorf("initdb failed: %s: %s", err2, strings.TrimSpace(string(out)))
+	}
+
+	port := params.Port
+	if port == 0 {
+		if port, err = freePort(testPostgresHost); err != nil {
+			return err
+		}
+	}
+	logFile, err := os.Create(filepath.Join(tp.dir, testPostgresLogFile))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = logFile.Close() }()
+	tp.cmd = exec.Command(filepath.Join(binDir, "postgres"), // nolint: gas
+		"-D", dataDir,
+		"-p", strconv.Itoa(port),
+		"-h", testPostgresHost,
+		"-k", tp.dir, // socket dir, since the default may not be writable
+		"-c", "fsync=off",
+		"-c", "synchronous_commit=off",
+		"-c", "full_page_writes=off",
+	)
+	tp.cmd.Stdout = logFile
+	tp.cmd.Stderr = logFile
+	setPdeathsig(tp.cmd)
+	if err = tp.cmd.Start(); err != nil {
+		return err
+	}
+	tp.exited = make(chan struct{})
+	go func() {
+		_ = tp.cmd.Wait()
+		close(tp.exited)
+	}()
+
+	tp.DBURL = fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=disable", testPostgresUser,
+		testPostgresHost, port, postgresDBName)
+	if err = tp.waitReady(params.StartTimeout); err != nil {
+		return fmt.Errorf("%s; log tail:\n%s", err, tp.logTail())
+	}
+	return nil
+}
+
+// waitReady pings the server until it responds, exits, or the timeout elapses.
+func (tp *TestPostgres) waitReady(timeout time.Duration) error {
+	db, err := sql.Open("postgres", tp.DBURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	op := func() error {
+		select {
+		case <-tp.exited:
+			return backoff.Permanent(ErrTestPostgresExited)
+		default:
+			return db.PingContext(ctx)
+		}
+	}
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 50 * time.Millisecond
+	bo.MaxInterval = time.Second
+	bo.MaxElapsedTime = timeout
+	return backoff.Retry(op, backoff.WithContext(bo, ctx))
+}
+
+func (tp *TestPostgres) stop() error {
+	if tp.cmd != nil && tp.cmd.Process != nil {
+		// fast shutdown, which disconnects open sessions rather than waiting for them
+		_ = tp.cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-tp.exited:
+		case <-time.After(testPostgresStopTimeout):
+			_ = tp.cmd.Process.Kill()
+			<-tp.exited
+		}
+	}
+	return os.RemoveAll(tp.dir)
+}
+
+func (tp *TestPostgres) logTail() string {
+	buf, err := ioutil.ReadFile(filepath.Join(tp.dir, testPostgresLogFile))
+	if err != nil {
+		return err.Error()
+	}
+	if len(buf) > testPostgresLogTailLen {
+		buf = buf[len(buf)-testPostgresLogTailLen:]
+	}
+	return string(buf)
+}
+
+// findPostgresBinDir returns the given bin dir if it has the Postgres binaries or else the
+// directory with them on the PATH or in a common install directory.
+func findPostgresBinDir(binDir string) (string, error) {
+	if binDir != "" {
+		if !hasPostgresBins(binDir) {
+			return "", ErrPostgresBinNotFound
+		}
+		return binDir, nil
+	}
+	if path, err := exec.LookPath(postgresBins[0]); err == nil {
+		if dir := filepath.Dir(path); hasPostgresBins(dir) {
+			return dir, nil
+		}
+	}
+	for _, pattern := range postgresBinDirGlobs {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		// prefer the latest version, though versions aren't zero-padded
+		sort.Slice(dirs, func(i, j int) bool {
+			if len(dirs[i]) != len(dirs[j]) {
+				return len(dirs[i]) > len(dirs[j])
+			}
+			return dirs[i] > dirs[j]
+		})
+		for _, dir := range dirs {
+			if hasPostgresBins(dir) {
+				return dir, nil
+			}
+		}
+	}
+	return "", ErrPostgresBinNotFound
+}
+
+func hasPostgresBins(dir string) bool {
+	for _, bin := range postgresBins {
+		info, err := os.Stat(filepath.Join(dir, bin))
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// removeStaleTestPostgresDirs removes the test Postgres temporary directories in the given
+// directory (or the default temporary directory) whose owner processes no longer exist.
+func removeStaleTestPostgresDirs(tempDir string) {
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	dirs, err := filepath.Glob(filepath.Join(tempDir, testPostgresDirPrefix+"*"))
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, testPostgresOwnerFile))
+		if err != nil {
+			continue // not yet written by an owner that's still starting up
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+		if err != nil || processExists(pid) {
+			continue
+		}
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// freePort returns a TCP port on the given host that's free at the time of calling.
+func freePort(host string) (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	return port, l.Close()
+}
+
+// processExists returns whether a process with the given PID exists.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
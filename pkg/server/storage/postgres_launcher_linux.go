@@ -0,0 +1,15 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setPdeathsig has the kernel send SIGQUIT (immediate shutdown) to the command's process when the
+// process that started it dies.
+func setPdeathsig(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGQUIT}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+import "os/exec"
+
+// setPdeathsig is a no-op, since only Linux supports a parent death signal.
+func setPdeathsig(cmd *exec.Cmd) {}
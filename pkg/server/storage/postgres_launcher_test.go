@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindPostgresBinDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postgres-bin")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+
+	_, err = findPostgresBinDir(dir)
+	assert.Equal(t, ErrPostgresBinNotFound, err)
+
+	// not executable
+	for _, bin := range postgresBins {
+		err = ioutil.WriteFile(filepath.Join(dir, bin), []byte("#!/bin/sh\n"), 0600)
+		assert.Nil(t, err)
+	}
+	_, err = findPostgresBinDir(dir)
+	assert.Equal(t, ErrPostgresBinNotFound, err)
+
+	for _, bin := range postgresBins {
+		assert.Nil(t, os.Chmod(filepath.Join(dir, bin), 0700))
+	}
+	found, err := findPostgresBinDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, dir, found)
+
+	// on the PATH
+	defer func(path string) { assert.Nil(t, os.Setenv("PATH", path)) }(os.Getenv("PATH"))
+	assert.Nil(t, os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH")))
+	found, err = findPostgresBinDir("")
+	assert.Nil(t, err)
+	assert.Equal(t, dir, found)
+}
+
+func TestRemoveStaleTestPostgresDirs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "postgres-temp")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(tempDir)) }()
+
+	// PID of a process that has exited
+	cmd := exec.Command("true")
+	assert.Nil(t, cmd.Run())
+	deadPID := cmd.Process.Pid
+
+	owners := map[string]string{
+		"stale":    strconv.Itoa(deadPID),
+		"live":     strconv.Itoa(os.Getpid()),
+		"starting": "",
+		"invalid":  "not a pid",
+	}
+	for name, owner := range owners {
+		dir := filepath.Join(tempDir, testPostgresDirPrefix+name)
+		assert.Nil(t, os.Mkdir(dir, 0700))
+		if owner != "" {
+			err = ioutil.WriteFile(filepath.Join(dir, testPostgresOwnerFile), []byte(owner),
+				0600)
+			assert.Nil(t, err)
+		}
+	}
+	other := filepath.Join(tempDir, "other")
+	assert.Nil(t, os.Mkdir(other, 0700))
+
+	removeStaleTestPostgresDirs(tempDir)
+	for name := range owners {
+		_, err = os.Stat(filepath.Join(tempDir, testPostgresDirPrefix+name))
+		assert.Equal(t, name == "stale", os.IsNotExist(err), name)
+	}
+	_, err = os.Stat(other)
+	assert.Nil(t, err)
+}
+
+func TestProcessExists(t *testing.T) {
+	assert.True(t, processExists(os.Getpid()))
+	cmd := exec.Command("true")
+	assert.Nil(t, cmd.Run())
+	assert.False(t, processExists(cmd.Process.Pid))
+}
+
+func TestFreePort(t *testing.T) {
+	port, err := freePort(testPostgresHost)
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", net.JoinHostPort(testPostgresHost, strconv.Itoa(port)))
+	assert.Nil(t, err)
+	assert.Nil(t, l.Close())
+}
+
+func TestLaunchTestPostgres_err(t *testing.T) {
+	params := NewDefaultTestPostgresParameters()
+	params.BinDir = "/not/a/dir"
+	tp, err := LaunchTestPostgres(params)
+	assert.Equal(t, ErrPostgresBinNotFound, err)
+	assert.Nil(t, tp)
+
+	// initdb fails
+	dir, err := ioutil.TempDir("", "postgres-bin")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, os.RemoveAll(dir)) }()
+	for _, bin := range postgresBins {
+		err = ioutil.WriteFile(filepath.Join(dir, bin), []byte("#!/bin/sh\nexit 1\n"), 0700)
+		assert.Nil(t, err)
+	}
+	params.BinDir = dir
+	params.TempDir = dir
+	tp, err = LaunchTestPostgres(params)
+	assert.NotNil(t, err)
+	assert.Nil(t, tp)
+	tempDirs, err := filepath.Glob(filepath.Join(dir, testPostgresDirPrefix+"*"))
+	assert.Nil(t, err)
+	assert.Empty(t, tempDirs)
+
+	// postgres exits before becoming ready
+	err = ioutil.WriteFile(filepath.Join(dir, "initdb"), []byte("#!/bin/sh\n"), 0700)
+	assert.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "postgres"),
+		[]byte("#!/bin/sh\necho 'FATAL: no good'\nexit 1\n"), 0700)
+	assert.Nil(t, err)
+	tp, err = LaunchTestPostgres(params)
+	assert.Nil(t, tp)
+	assert.Contains(t, err.Error(), ErrTestPostgresExited.Error())
+	assert.Contains(t, err.Error(), "FATAL: no good")
+	tempDirs, err = filepath.Glob(filepath.Join(dir, testPostgresDirPrefix+"*"))
+	assert.Nil(t, err)
+	assert.Empty(t, tempDirs)
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultMaxOpenConns is the default maximum number of open connections to the DB.
+	DefaultMaxOpenConns = 16
+
+	// DefaultMaxIdleConns is the default maximum number of idle connections in the pool.
+	DefaultMaxIdleConns = 4
+
+	// DefaultConnMaxLifetime is the default maximum amount of time a connection may be reused.
+	DefaultConnMaxLifetime = 30 * time.Minute
+
+	// DefaultConnMaxIdleTime is the default maximum amount of time a connection may be idle.
+	DefaultConnMaxIdleTime = 5 * time.Minute
+
+	// DefaultStatementTimeout is the default maximum duration of each statement, after which
+	// Postgres aborts it.
+	DefaultStatementTimeout = 30 * time.Second
+
+	// DefaultReadyTimeout is the default maximum amount of time to wait for the DB to become
+	// ready when opening it.
+	DefaultReadyTimeout = 30 * time.Second
+
+	sslModeParam          = "sslmode"
+	applicationNameParam  = "application_name"
+	statementTimeoutParam = "statement_timeout"
+	dbStatsLabel          = "db"
+)
+
+var (
+	// ErrInvalidDBURL indicates when a DB URL cannot be parsed as a postgres:// URL.
+	ErrInvalidDBURL = errors.New("invalid Postgres DB URL")
+
+	// ErrInvalidSSLMode indicates when the SSL mode is not one supported by the Postgres driver.
+	ErrInvalidSSLMode = errors.New("invalid SSL mode")
+
+	// ErrNegativePoolParameter indicates when a connection pool limit or timeout is negative.
+	ErrNegativePoolParameter = errors.New("Postgres pool parameters must be non-negative")
+
+	sslModes = map[string]struct{}{
+		"disable":     {},
+		"require":     {},
+		"verify-ca":   {},
+		"verify-full": {},
+	}
+)
+
+// PostgresParameters defines the connection pool and session parameters of a Postgres DB.
+type PostgresParameters struct {
+	// MaxOpenConns is the maximum number of open connections to the DB. Zero means unlimited.
+	MaxOpenConns int
+
+	// MaxIdleConns is the maximum number of idle connections in the pool. Zero means no idle
+	// connections are kept.
+	MaxIdleConns int
+
+	// ConnMaxLifetime is the maximum amount of time a connection may be reused. Zero means
+	// connections are reused forever.
+	ConnMaxLifetime time.Duration
+
+	// ConnMaxIdleTime is the maximum amount of time a connection may be idle. Zero means
+	// connections are not closed due to idle time.
+	ConnMaxIdleTime time.Duration
+
+	// StatementTimeout is the maximum duration of each statement, after which Postgres aborts
+	// it. Zero means no timeout.
+	StatementTimeout time.Duration
+
+	// ApplicationName is the application name reported to Postgres (e.g., in pg_stat_activity)
+	// and used to label the pool metrics. If empty, the DB name is used for the metrics.
+	ApplicationName string
+
+	// SSLMode is the SSL mode (disable, require, verify-ca, or verify-full). If empty, the
+	// DB URL's sslmode (if any) is used.
+	SSLMode string
+
+	// ReadyTimeout is the maximum amount of time to wait for the DB to become ready when
+	// opening it.
+	ReadyTimeout time.Duration
+}
+
+// NewDefaultPostgresParameters returns a *PostgresParameters object with default values.
+func NewDefaultPostgresParameters() *PostgresParameters {
+	return &PostgresParameters{
+		MaxOpenConns:     DefaultMaxOpenConns,
+		MaxIdleConns:     DefaultMaxIdleConns,
+		ConnMaxLifetime:  DefaultConnMaxLifetime,
+		ConnMaxIdleTime:  DefaultConnMaxIdleTime,
+		StatementTimeout: DefaultStatementTimeout,
+		ReadyTimeout:     DefaultReadyTimeout,
+	}
+}
+
+// Validate checks that the pool limits and timeouts are non-negative and that the SSL mode (if
+// given) is valid.
+func (p *PostgresParameters) Validate() error {
+	if p.MaxOpenConns < 0 || p.MaxIdleConns < 0 || p.ConnMaxLifetime < 0 ||
+		p.ConnMaxIdleTime < 0 || p.StatementTimeout < 0 || p.ReadyTimeout < 0 {
+		return ErrNegativePoolParameter
+	}
+	if _, in := sslModes[p.SSLMode]; p.SSLMode != "" && !in {
+		return ErrInvalidSSLMode
+	}
+	return nil
+}
+
+// MarshalLogObject writes the parameters to the given object encoder.
+func (p *PostgresParameters) MarshalLogObject(oe zapcore.ObjectEncoder) error {
+	oe.AddInt(logMaxOpenConns, p.MaxOpenConns)
+	oe.AddInt(logMaxIdleConns, p.MaxIdleConns)
+	oe.AddDuration(logConnMaxLifetime, p.ConnMaxLifetime)
+	oe.AddDuration(logConnMaxIdleTime, p.ConnMaxIdleTime)
+	oe.AddDuration(logStatementTimeout, p.StatementTimeout)
+	oe.AddString(logApplicationName, p.ApplicationName)
+	oe.AddString(logSSLMode, p.SSLMode)
+	oe.AddDuration(logReadyTimeout, p.ReadyTimeout)
+	return nil
+}
+
+// PostgresDB is a *sql.DB whose pool stats are exported as Prometheus metrics until it is closed.
+type PostgresDB struct {
+	*sql.DB
+	stats prometheus.Collector
+}
+
+// OpenPostgres opens the Postgres DB at the URL of the given parameters, configured with their
+// Postgres pool and session parameters (or the defaults if they have none), and waits for it to
+// become ready. Its pool stats are registered as metrics labeled by the application (or DB) name,
+// so only one DB per name may be open at a time.
+func OpenPostgres(ctx context.Context, params *Parameters) (*PostgresDB, error) {
+	if params.DBURL == "" {
+		return nil, ErrMissingDBURL
+	}
+	pp := params.Postgres
+	if pp == nil {
+		pp = NewDefaultPostgresParameters()
+	}
+	if err := pp.Validate(); err != nil {
+		return nil, err
+	}
+	dbURL, dbName, err := postgresURL(params.DBURL, pp)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pp.MaxOpenConns)
+	db.SetMaxIdleConns(pp.MaxIdleConns)
+	db.SetConnMaxLifetime(pp.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pp.ConnMaxIdleTime)
+
+	if err = waitPostgresReady(ctx, db, pp.ReadyTimeout); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	name := pp.ApplicationName
+	if name == "" {
+		name = dbName
+	}
+	stats := newDBStatsCollector(db, name)
+	if err = prometheus.Register(stats); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &PostgresDB{DB: db, stats: stats}, nil
+}
+
+// Close unregisters the pool stats metrics and closes the DB.
+func (db *PostgresDB) Close() error {
+	prometheus.Unregister(db.stats)
+	return db.DB.Close()
+}
+
+// postgresURL returns the given DB URL with the session parameters added along with the DB name.
+func postgresURL(dbURL string, p *PostgresParameters) (string, string, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", "", ErrInvalidDBURL
+	}
+	q := u.Query()
+	if p.SSLMode != "" {
+		q.Set(sslModeParam, p.SSLMode)
+	}
+	if p.ApplicationName != "" {
+		q.Set(applicationNameParam, p.ApplicationName)
+	}
+	if p.StatementTimeout > 0 {
+		// the driver passes unrecognized params to Postgres as run-time params
+		ms := int64(p.StatementTimeout / time.Millisecond)
+		q.Set(statementTimeoutParam, strconv.FormatInt(ms, 10))
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), path.Base(u.Path), nil
+}
+
+// waitPostgresReady pings the DB with exponential backoff until it responds, the timeout elapses,
+// or the context is done.
+func waitPostgresReady(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	op := func() error {
+		return db.PingContext(ctx)
+	}
+	if err := op(); err == nil || timeout == 0 {
+		return err
+	}
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = time.Second
+	bo.MaxElapsedTime = timeout
+	return backoff.Retry(op, backoff.WithContext(bo, ctx))
+}
+
+// dbStatsCollector exports the sql.DBStats of a DB as Prometheus metrics.
+type dbStatsCollector struct {
+	db                *sql.DB
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func newDBStatsCollector(db *sql.DB, name string) *dbStatsCollector {
+	labels := prometheus.Labels{dbStatsLabel: name}
+	newDesc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("postgres_pool_"+metric, help, nil, labels)
+	}
+	return &dbStatsCollector{
+		db: db,
+		maxOpen: newDesc("max_open_connections",
+			"Maximum number of open connections to the DB."),
+		open: newDesc("open_connections",
+			"Number of established connections, both in use and idle."),
+		inUse: newDesc("in_use_connections",
+			"Number of connections currently in use."),
+		idle: newDesc("idle_connections",
+			"Number of idle connections."),
+		waitCount: newDesc("wait_count_total",
+			"Total number of connections waited for."),
+		waitDuration: newDesc("wait_duration_seconds_total",
+			"Total time blocked waiting for a new connection."),
+		maxIdleClosed: newDesc("max_idle_closed_total",
+			"Total number of connections closed due to the max idle connections."),
+		maxIdleTimeClosed: newDesc("max_idle_time_closed_total",
+			"Total number of connections closed due to the max connection idle time."),
+		maxLifetimeClosed: newDesc("max_lifetime_closed_total",
+			"Total number of connections closed due to the max connection lifetime."),
+	}
+}
+
+// Describe sends the descriptors of the DB stats metrics to the given channel.
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxIdleTimeClosed
+	ch <- c.maxLifetimeClosed
+}
+
+// Collect sends the current DB stats metrics to the given channel.
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.Stats()
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+	gauge(c.maxOpen, float64(s.MaxOpenConnections))
+	gauge(c.open, float64(s.OpenConnections))
+	gauge(c.inUse, float64(s.InUse))
+	gauge(c.idle, float64(s.Idle))
+	counter(c.waitCount, float64(s.WaitCount))
+	counter(c.waitDuration, s.WaitDuration.Seconds())
+	counter(c.maxIdleClosed, float64(s.MaxIdleClosed))
+	counter(c.maxIdleTimeClosed, float64(s.MaxIdleTimeClosed))
+	counter(c.maxLifetimeClosed, float64(s.MaxLifetimeClosed))
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestPostgresParameters_Validate(t *testing.T) {
+	p := NewDefaultPostgresParameters()
+	assert.Nil(t, p.Validate())
+	p.SSLMode = "verify-full"
+	assert.Nil(t, p.Validate())
+	assert.Nil(t, (&PostgresParameters{}).Validate())
+
+	cases := map[error]*PostgresParameters{
+		ErrNegativePoolParameter: {MaxOpenConns: -1},
+		ErrInvalidSSLMode:        {SSLMode: "sometimes"},
+	}
+	for expected, p := range cases {
+		assert.Equal(t, expected, p.Validate())
+	}
+
+	params := &Parameters{
+		Type:     Postgres,
+		DBURL:    "postgres://localhost:5432/db",
+		Postgres: &PostgresParameters{ConnMaxIdleTime: -time.Second},
+	}
+	assert.Equal(t, ErrNegativePoolParameter, params.Validate())
+}
+
+func TestPostgresParameters_MarshalLogObject(t *testing.T) {
+	oe := zapcore.NewMapObjectEncoder()
+	p := &Parameters{
+		Type:     Postgres,
+		DBURL:    "postgres://localhost:5432/db",
+		Postgres: NewDefaultPostgresParameters(),
+	}
+	err := p.MarshalLogObject(oe)
+	assert.Nil(t, err)
+	pp := oe.Fields[logPostgres].(map[string]interface{})
+	assert.Equal(t, DefaultMaxOpenConns, pp[logMaxOpenConns])
+	assert.Equal(t, DefaultStatementTimeout, pp[logStatementTimeout])
+}
+
+func TestPostgresURL(t *testing.T) {
+	p := &PostgresParameters{
+		StatementTimeout: 5 * time.Second,
+		ApplicationName:  "some-service",
+		SSLMode:          "require",
+	}
+	dbURL, dbName, err := postgresURL("postgres://user@localhost:5432/db?sslmode=disable", p)
+	assert.Nil(t, err)
+	assert.Equal(t, "db", dbName)
+	u, err := url.Parse(dbURL)
+	assert.Nil(t, err)
+	assert.Equal(t, "require", u.Query().Get(sslModeParam))
+	assert.Equal(t, "some-service", u.Query().Get(applicationNameParam))
+	assert.Equal(t, "5000", u.Query().Get(statementTimeoutParam))
+
+	// existing params kept when not overridden
+	dbURL, _, err = postgresURL("postgres://localhost:5432/db?sslmode=disable",
+		&PostgresParameters{})
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://localhost:5432/db?sslmode=disable", dbURL)
+
+	for _, dbURL := range []string{"mysql://localhost/db", "%gh&%ij"} {
+		_, _, err = postgresURL(dbURL, p)
+		assert.Equal(t, ErrInvalidDBURL, err)
+	}
+}
+
+func TestOpenPostgres_err(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenPostgres(ctx, &Parameters{Type: Postgres})
+	assert.Equal(t, ErrMissingDBURL, err)
+	assert.Nil(t, db)
+
+	db, err = OpenPostgres(ctx, &Parameters{
+		Type:     Postgres,
+		DBURL:    "postgres://localhost:5432/db",
+		Postgres: &PostgresParameters{SSLMode: "sometimes"},
+	})
+	assert.Equal(t, ErrInvalidSSLMode, err)
+	assert.Nil(t, db)
+
+	// nothing listening on port 1
+	pp := NewDefaultPostgresParameters()
+	pp.ReadyTimeout = 100 * time.Millisecond
+	db, err = OpenPostgres(ctx, &Parameters{
+		Type:     Postgres,
+		DBURL:    "postgres://localhost:1/db?sslmode=disable",
+		Postgres: pp,
+	})
+	assert.NotNil(t, err)
+	assert.Nil(t, db)
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	// sql.Open doesn't connect, so stats are available without a server
+	db, err := sql.Open("postgres", "postgres://localhost:1/db?sslmode=disable")
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, db.Close()) }()
+	db.SetMaxOpenConns(3)
+
+	c := newDBStatsCollector(db, "TestDBStatsCollector")
+	reg := prometheus.NewRegistry()
+	assert.Nil(t, reg.Register(c))
+	mfs, err := reg.Gather()
+	assert.Nil(t, err)
+	assert.Len(t, mfs, 9)
+
+	values := make(map[string]*dto.Metric)
+	for _, mf := range mfs {
+		values[mf.GetName()] = mf.Metric[0]
+		assert.Equal(t, dbStatsLabel, mf.Metric[0].Label[0].GetName())
+		assert.Equal(t, "TestDBStatsCollector", mf.Metric[0].Label[0].GetValue())
+	}
+	assert.Equal(t, 3.0, values["postgres_pool_max_open_connections"].Gauge.GetValue())
+	assert.Equal(t, 0.0, values["postgres_pool_open_connections"].Gauge.GetValue())
+	assert.Equal(t, 0.0, values["postgres_pool_wait_count_total"].Counter.GetValue())
+}
+
+func TestPostgresOpen(t *testing.T) {
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
+
+	pp := NewDefaultPostgresParameters()
+	pp.ApplicationName = "TestPostgresOpen"
+	pp.StatementTimeout = time.Second
+	params := &Parameters{Type: Postgres, DBURL: dbURL, Postgres: pp}
+	db, err := OpenPostgres(context.Background(), params)
+	assert.Nil(t, err)
+
+	var appName, timeout string
+	assert.Nil(t, db.QueryRow("SHOW application_name").Scan(&appName))
+	assert.Equal(t, "TestPostgresOpen", appName)
+	assert.Nil(t, db.QueryRow("SHOW statement_timeout").Scan(&timeout))
+	assert.Equal(t, "1s", timeout)
+	assert.Equal(t, DefaultMaxOpenConns, db.Stats().MaxOpenConnections)
+
+	// stats metrics registered while open, so can't open another with the same name
+	db2, err := OpenPostgres(context.Background(), params)
+	assert.NotNil(t, err)
+	assert.Nil(t, db2)
+
+	assert.Nil(t, db.Close())
+	db2, err = OpenPostgres(context.Background(), params)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Close())
+}
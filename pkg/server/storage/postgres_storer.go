@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ErrUnknownField indicates when a record field or query field has no corresponding Postgres
+// column.
+var ErrUnknownField = errors.New("unknown field")
+
+// PostgresTable describes the Postgres table backing a Storer, which has a string key column
+// (usually the primary key) and a column for each record field.
+type PostgresTable struct {
+	// Name is the (usually schema-qualified) table name.
+	Name string
+
+	// KeyCol is the record key column.
+	KeyCol string
+
+	// FieldCols are the record field columns, named the same as the fields.
+	FieldCols []string
+}
+
+type postgresStorer struct {
+	qb      sq.StatementBuilderType
+	q       Querier
+	table   *PostgresTable
+	colsIdx map[string]struct{}
+	p       *Paginator
+}
+
+// NewPostgresStorer returns a Storer backed by the given table in the given Postgres DB. Query
+// page tokens are signed and verified by the Paginator.
+func NewPostgresStorer(db *sql.DB, q Querier, table *PostgresTable, p *Paginator) Storer {
+	colsIdx := make(map[string]struct{}, len(table.FieldCols))
+	for _, col := range table.FieldCols {
+		colsIdx[col] = struct{}{}
+	}
+	return &postgresStorer{
+		qb:      sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(db),
+		q:       q,
+		table:   table,
+		colsIdx: colsIdx,
+		p:       p,
+	}
+}
+
+func (s *postgresStorer) Put(ctx context.Context, r *Record) error {
+	normalized, err := normalizeRecord(r)
+	if err != nil {
+		return err
+	}
+	for field := range normalized.Fields {
+		if _, in := s.colsIdx[field]; !in {
+			return ErrUnknownField
+		}
+	}
+	values := make([]interface{}, len(s.table.FieldCols)+1)
+	values[0] = r.Key
+	sets := make([]string, len(s.table.FieldCols))
+	for i, col := range s.table.FieldCols {
+		values[i+1] = normalized.Fields[col]
+		sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+	}
+	onConflict := fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", s.table.KeyCol)
+	if len(sets) > 0 {
+		onConflict = fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", s.table.KeyCol,
+			strings.Join(sets, ", "))
+	}
+	q := s.qb.Insert(s.table.Name).
+		Columns(s.cols()...).
+		Values(values...).
+		Suffix(onConflict)
+	_, err = s.q.InsertExecContext(ctx, q)
+	return err
+}
+
+func (s *postgresStorer) Get(ctx context.Context, key string) (*Record, error) {
+	rs, err := s.GetMulti(ctx, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if rs[0] == nil {
+		return nil, ErrNotFound
+	}
+	return rs[0], nil
+}
+
+func (s *postgresStorer) GetMulti(ctx context.Context, keys []string) ([]*Record, error) {
+	rs := make([]*Record, len(keys))
+	if len(keys) == 0 {
+		return rs, nil
+	}
+	q := s.qb.Select(s.cols()...).
+		From(s.table.Name).
+		Where(sq.Eq{s.table.KeyCol: keys})
+	found, err := s.selectRecords(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*Record, len(found))
+	for _, r := range found {
+		byKey[r.Key] = r
+	}
+	for i, key := range keys {
+		rs[i] = byKey[key]
+	}
+	return rs, nil
+}
+
+func (s *postgresStorer) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	q := s.qb.Delete(s.table.Name).Where(sq.Eq{s.table.KeyCol: keys})
+	_, err := s.q.DeleteExecContext(ctx, q)
+	return err
+}
+
+func (s *postgresStorer) Query(ctx context.Context, q *Query) (*QueryResult, error) {
+	q, err := normalizeQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	sel := s.qb.Select(s.cols()...).From(s.table.Name)
+	for _, f := range q.Filters {
+		if _, in := s.colsIdx[f.Field]; !in {
+			return nil, ErrUnknownField
+		}
+		sel = sel.Where(postgresFilter(f))
+	}
+	direction, cmp := "ASC", ">"
+	if q.Order != nil && q.Order.Descending {
+		direction, cmp = "DESC", "<"
+	}
+	if q.Order != nil {
+		if _, in := s.colsIdx[q.Order.Field]; !in {
+			return nil, ErrUnknownField
+		}
+		sel = sel.Where(q.Order.Field+" IS NOT NULL").
+			OrderBy(q.Order.Field+" "+direction, s.table.KeyCol+" "+direction)
+	} else {
+		sel = sel.OrderBy(s.table.KeyCol + " " + direction)
+	}
+	scope := storerPageScope("postgres:"+s.table.Name, q)
+	if q.PageToken != "" {
+		after, err2 := s.p.decodePageCursor(q.PageToken, scope)
+		if err2 != nil {
+			return nil, err2
+		}
+		if q.Order != nil {
+			sel = sel.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", q.Order.Field,
+				s.table.KeyCol, cmp), after.Value, after.Key)
+		} else {
+			sel = sel.Where(fmt.Sprintf("%s %s ?", s.table.KeyCol, cmp), after.Key)
+		}
+	}
+	if q.Limit > 0 {
+		// fetch one extra to determine whether there is a next page
+		sel = sel.Limit(uint64(q.Limit) + 1)
+	}
+
+	rs, err := s.selectRecords(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	return newPagedResult(rs, q, s.p, scope)
+}
+
+func (s *postgresStorer) cols() []string {
+	return append([]string{s.table.KeyCol}, s.table.FieldCols...)
+}
+
+func (s *postgresStorer) selectRecords(
+	ctx context.Context, q sq.SelectBuilder,
+) ([]*Record, error) {
+	rows, err := s.q.SelectQueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]*Record, 0)
+	for rows.Next() {
+		key := ""
+		values := make([]interface{}, len(s.table.FieldCols))
+		dests := make([]interface{}, len(s.table.FieldCols)+1)
+		dests[0] = &key
+		for i := range values {
+			dests[i+1] = &values[i]
+		}
+		if err := rows.Scan(dests...); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		r := &Record{Key: key, Fields: make(map[string]interface{})}
+		for i, col := range s.table.FieldCols {
+			if values[i] == nil {
+				continue
+			}
+			if r.Fields[col], err = normalizeValue(values[i]); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+		}
+		rs = append(rs, r)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	return rs, rows.Close()
+}
+
+func postgresFilter(f *Filter) sq.Sqlizer {
+	// use expression rather than sq.Eq, etc. so []byte values aren't treated as lists
+	return sq.Expr(fmt.Sprintf("%s %s ?", f.Field, f.Op.String()), f.Value)
+}
@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"os"
 	"testing"
 
+	sq "github.com/Masterminds/squirrel"
 	"github.com/elixirhealth/service-base/pkg/server/storage/test"
 	_ "github.com/lib/pq"
 	_ "github.com/mattes/migrate/database/postgres"
@@ -14,7 +16,7 @@ import (
 )
 
 var (
-	setUpPostgresTest func(t *testing.T) (dbURL string, tearDown func())
+	setUpPostgresTest func(t testing.TB) (dbURL string)
 )
 
 func TestMain(m *testing.M) {
@@ -26,9 +28,8 @@ func TestMain(m *testing.M) {
 		log.Fatal("test postgres start error: " + err.Error())
 	}
 	as := bindata.Resource(test.AssetNames(), test.Asset)
-	setUpPostgresTest = func(t *testing.T) (string, func()) {
-		tearDown := SetUpTestPostgres(t, dbURL, as)
-		return dbURL, tearDown
+	setUpPostgresTest = func(t testing.TB) string {
+		return NewTestPostgresDB(t, dbURL, as)
 	}
 
 	code := m.Run()
@@ -41,15 +42,25 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func TestPostgresStartNoOp(t *testing.T) {
-	// check that running this again when DB is already running is fine
-	_, _, err := StartTestPostgres()
+func TestPostgresLaunchTestPostgres(t *testing.T) {
+	// check that another server can run alongside the one from TestMain
+	tp, err := LaunchTestPostgres(NewDefaultTestPostgresParameters())
 	assert.Nil(t, err)
+	db, err := sql.Open("postgres", tp.DBURL)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Ping())
+
+	assert.Nil(t, tp.Stop())
+	assert.Nil(t, tp.Stop()) // no-op
+	assert.NotNil(t, db.Ping())
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(tp.dir)
+	assert.True(t, os.IsNotExist(err))
 }
 
 func TestPostgresInsert1(t *testing.T) {
-	dbURL, tearDown := setUpPostgresTest(t)
-	defer tearDown()
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
 
 	db, err := sql.Open("postgres", dbURL)
 	assert.Nil(t, err)
@@ -75,8 +86,8 @@ func TestPostgresInsert1(t *testing.T) {
 }
 
 func TestPostgresInsert2(t *testing.T) {
-	dbURL, tearDown := setUpPostgresTest(t)
-	defer tearDown()
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
 
 	db, err := sql.Open("postgres", dbURL)
 	assert.Nil(t, err)
@@ -103,3 +114,92 @@ func TestPostgresInsert2(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 2, count)
 }
+
+func TestPostgresWithTx(t *testing.T) {
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
+
+	db, err := sql.Open("postgres", dbURL)
+	assert.Nil(t, err)
+	if err != nil {
+		return
+	}
+	ctx := context.Background()
+	q := NewQuerierWithDB(db)
+	qb := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(db)
+	insert := func(tx Querier, id string) error {
+		_, err2 := tx.InsertExecContext(ctx, qb.Insert("test.test").
+			Columns("id", "field_1", "field_2").
+			Values(id, "row", 1))
+		return err2
+	}
+	count := func(tx Querier) int {
+		var n int
+		row := tx.SelectQueryRowContext(ctx, qb.Select("COUNT(*)").From("test.test"))
+		assert.Nil(t, row.Scan(&n))
+		return n
+	}
+
+	// committed tx
+	opts := NewDefaultTxOptions()
+	opts.Isolation = sql.LevelSerializable
+	err = q.WithTx(ctx, opts, func(tx Querier) error {
+		if err2 := insert(tx, "id1"); err2 != nil {
+			return err2
+		}
+		if err2 := insert(tx, "id2"); err2 != nil {
+			return err2
+		}
+		assert.Equal(t, 2, count(tx))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count(q))
+
+	// rolled back tx
+	err = q.WithTx(ctx, nil, func(tx Querier) error {
+		if err2 := insert(tx, "id3"); err2 != nil {
+			return err2
+		}
+		return insert(tx, "id1") // duplicate key
+	})
+	assert.NotNil(t, err)
+	assert.False(t, IsRetryableTxErr(err))
+	assert.Equal(t, 2, count(q))
+}
+
+func TestPostgresScanAll(t *testing.T) {
+	t.Parallel()
+	dbURL := setUpPostgresTest(t)
+
+	db, err := sql.Open("postgres", dbURL)
+	assert.Nil(t, err)
+	if err != nil {
+		return
+	}
+	type row struct {
+		ID     string  `db:"id"`
+		Field1 *string `db:"field_1"`
+		Field2 *int64  `db:"field_2"`
+	}
+	ctx := context.Background()
+	q := NewQuerierWithDB(db)
+	qb := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(db)
+	field1, field2 := "row-1", int64(1)
+	for _, r := range []*row{{ID: "id1", Field1: &field1, Field2: &field2}, {ID: "id2"}} {
+		cols, values, err2 := InsertValues(r)
+		assert.Nil(t, err2)
+		_, err2 = q.InsertExecContext(ctx, qb.Insert("test.test").Columns(cols...).
+			Values(values...))
+		assert.Nil(t, err2)
+	}
+
+	cols, err := Columns(&row{})
+	assert.Nil(t, err)
+	rows, err := q.SelectQueryContext(ctx, qb.Select(cols...).From("test.test").OrderBy("id"))
+	assert.Nil(t, err)
+	var rs []*row
+	err = ScanAll(rows, &rs)
+	assert.Nil(t, err)
+	assert.Equal(t, []*row{{ID: "id1", Field1: &field1, Field2: &field2}, {ID: "id2"}}, rs)
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/lib/pq"
+	"github.com/mattes/migrate/source"
+	"github.com/mattes/migrate/source/go-bindata"
+)
+
+const (
+	testTemplatePrefix   = "test_template_"
+	testDBPrefix         = "test_"
+	maxTestDBNameBaseLen = 40
+	testDBSuffixBytes    = 4
+	migrationsHashLen    = 16
+
+	// Postgres error code when a DB is being accessed by other sessions, e.g., while a closed
+	// session's backend exits
+	objectInUseCode = "55006"
+)
+
+var (
+	testTemplates   = make(map[string]*testTemplate)
+	testTemplatesMu sync.Mutex
+	testDBNameRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+)
+
+type testTemplate struct {
+	once sync.Once
+	err  error
+}
+
+// NewTestPostgresDB creates a new DB for the test on the Postgres server with the given URL and
+// returns its URL. The new DB is cloned from a template DB migrated up with the migrations in the
+// given go-bindata asset source, which is created once per distinct set of migrations (even
+// across test processes), so each clone is cheap. The new DB is dropped when the test completes,
+// and tests with separate DBs can run in parallel.
+func NewTestPostgresDB(t testing.TB, dbURL string, as *bindata.AssetSource) string {
+	t.Helper()
+	return newTestPostgresDB(t, dbURL, "go-bindata", func() (source.Driver, error) {
+		return bindata.WithInstance(as)
+	})
+}
+
+// NewTestPostgresDBFS is like NewTestPostgresDB but with the migration files in the root directory
+// of the given file system.
+func NewTestPostgresDBFS(t testing.TB, dbURL string, fsys fs.FS) string {
+	t.Helper()
+	return newTestPostgresDB(t, dbURL, "fs", func() (source.Driver, error) {
+		return newFSSource(fsys)
+	})
+}
+
+func newTestPostgresDB(
+	t testing.TB, dbURL, sourceName string, newSource func() (source.Driver, error),
+) string {
+	t.Helper()
+	admin, err := openMigrationDB(dbURL)
+	if err != nil {
+		t.Fatal("test postgres open error: " + err.Error())
+	}
+	defer func() { _ = admin.Close() }()
+	template, err := ensureTestTemplate(admin, dbURL, sourceName, newSource)
+	if err != nil {
+		t.Fatal("test postgres template error: " + err.Error())
+	}
+	name, err := newTestDBName(t.Name())
+	if err != nil {
+		t.Fatal("test postgres name error: " + err.Error())
+	}
+	testURL, err := withDBName(dbURL, name)
+	if err != nil {
+		t.Fatal("test postgres URL error: " + err.Error())
+	}
+	q := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", pq.QuoteIdentifier(name),
+		pq.QuoteIdentifier(template))
+	if err = execRetryInUse(admin, q); err != nil {
+		t.Fatal("test postgres create error: " + err.Error())
+	}
+	t.Cleanup(func() {
+		if err := dropTestDB(dbURL, name); err != nil {
+			t.Error("test postgres drop error: " + err.Error())
+		}
+	})
+	return testURL
+}
+
+// ensureTestTemplate returns the name of the template DB for the migrations in the given source,
+// creating and migrating it if it doesn't exist yet.
+func ensureTestTemplate(
+	admin *sql.DB, dbURL, sourceName string, newSource func() (source.Driver, error),
+) (string, error) {
+	d, err := newSource()
+	if err != nil {
+		return "", err
+	}
+	hash, err := migrationsHash(d)
+	if err != nil {
+		return "", err
+	}
+	name := testTemplatePrefix + hash
+	testTemplatesMu.Lock()
+	tt, in := testTemplates[name]
+	if !in {
+		tt = &testTemplate{}
+		testTemplates[name] = tt
+	}
+	testTemplatesMu.Unlock()
+	tt.once.Do(func() {
+		tt.err = createTestTemplate(admin, dbURL, name, sourceName, newSource)
+	})
+	return name, tt.err
+}
+
+// createTestTemplate creates and migrates the template DB with the given name if it doesn't exist.
+// The template is migrated under a temporary name and then renamed, so a template interrupted
+// partway is never used.
+func createTestTemplate(
+	admin *sql.DB,
+	dbURL, name, sourceName string,
+	newSource func() (source.Driver, error),
+) error {
+	// other test processes (e.g., for other packages) may be creating the same template
+	logger := &LogLogger{}
+	key := migrationLockKey(name)
+	conn, err := acquireMigrationLock(admin, key, DefaultMigrationLockTimeout, logger)
+	if err != nil {
+		return err
+	}
+	defer releaseMigrationLock(conn, key)
+
+	var exists bool
+	err = admin.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).
+		Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	tmpName := name + "_tmp"
+	if err = dropDB(admin, tmpName); err != nil {
+		return err
+	}
+	if _, err = admin.Exec("CREATE DATABASE " + pq.QuoteIdentifier(tmpName)); err != nil {
+		return err
+	}
+	tmpURL, err := withDBName(dbURL, tmpName)
+	if err != nil {
+		return err
+	}
+	m := &sourceMigrator{
+		dbURL:      tmpURL,
+		sourceName: sourceName,
+		newSource:  newSource,
+		params:     NewDefaultMigratorParameters(),
+		logger:     logger,
+	}
+	if err = m.Up(); err != nil {
+		return err
+	}
+	return execRetryInUse(admin, fmt.Sprintf("ALTER DATABASE %s RENAME TO %s",
+		pq.QuoteIdentifier(tmpName), pq.QuoteIdentifier(name)))
+}
+
+// migrationsHash returns a hash of the names and SQL of the migrations in the given source.
+func migrationsHash(d source.Driver) (string, error) {
+	h := sha256.New()
+	version, err := d.First()
+	for err == nil {
+		reads := []func(uint) (io.ReadCloser, string, error){d.ReadUp, d.ReadDown}
+		for _, read := range reads {
+			sql, identifier, err2 := readMigrationSQL(read, version)
+			if err2 != nil {
+				return "", err2
+			}
+			if sql == nil {
+				continue
+			}
+			if _, err2 = fmt.Fprintf(h, "%d_%s\n%s\n", version, identifier, *sql); err2 != nil {
+				return "", err2
+			}
+		}
+		version, err = d.Next(version)
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:migrationsHashLen], nil
+}
+
+// newTestDBName returns a new random DB name derived from the given test name and short enough
+// for a Postgres identifier.
+func newTestDBName(testName string) (string, error) {
+	base := testDBNameRegex.ReplaceAllString(strings.ToLower(testName), "_")
+	if len(base) > maxTestDBNameBaseLen {
+		base = base[:maxTestDBNameBaseLen]
+	}
+	suffix := make([]byte, testDBSuffixBytes)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return testDBPrefix + strings.Trim(base, "_") + "_" + hex.EncodeToString(suffix), nil
+}
+
+// withDBName returns the given DB URL with its DB name replaced.
+func withDBName(dbURL, name string) (string, error) {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/" + name
+	return u.String(), nil
+}
+
+// dropTestDB terminates any remaining sessions to the DB with the given name on the server with
+// the given URL and drops it.
+func dropTestDB(dbURL, name string) error {
+	admin, err := openMigrationDB(dbURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = admin.Close() }()
+	return dropDB(admin, name)
+}
+
+func dropDB(admin *sql.DB, name string) error {
+	q := "SELECT pg_terminate_backend(pid) FROM pg_stat_activity " +
+		"WHERE datname = $1 AND pid <> pg_backend_pid()"
+	if _, err := admin.Exec(q, name); err != nil {
+		return err
+	}
+	return execRetryInUse(admin, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name))
+}
+
+// execRetryInUse executes the given statement, retrying while the DB it operates on is still
+// being accessed by other (e.g., just closed) sessions.
+func execRetryInUse(db *sql.DB, query string) error {
+	op := func() error {
+		_, err := db.Exec(query)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == objectInUseCode {
+			return err
+		}
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		return nil
+	}
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 10 * time.Millisecond
+	bo.MaxElapsedTime = 10 * time.Second
+	return backoff.Retry(op, bo)
+}
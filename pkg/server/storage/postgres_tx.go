@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/cenkalti/backoff"
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultTxMaxRetries is the default maximum number of times a transaction is retried after
+	// serialization failures or deadlocks.
+	DefaultTxMaxRetries = 3
+
+	// DefaultTxRetryInterval is the default initial interval between transaction retries, which
+	// grows exponentially with each retry.
+	DefaultTxRetryInterval = 10 * time.Millisecond
+
+	// SQLSTATE codes of retryable transaction errors
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+)
+
+// ErrNoTxDB indicates when trying to run a transaction with a Querier that has no DB.
+var ErrNoTxDB = errors.New("querier has no DB to run transactions on")
+
+// TxOptions defines the options of a transaction run via Querier.WithTx.
+type TxOptions struct {
+	// Isolation is the transaction isolation level. If zero, the DB's default level is used.
+	Isolation sql.IsolationLevel
+
+	// ReadOnly indicates whether the transaction is read-only.
+	ReadOnly bool
+
+	// MaxRetries is the maximum number of times the transaction is retried after serialization
+	// failures or deadlocks.
+	MaxRetries uint
+
+	// RetryInterval is the initial interval between retries, which grows exponentially with each
+	// retry.
+	RetryInterval time.Duration
+}
+
+// NewDefaultTxOptions returns a *TxOptions with default values.
+func NewDefaultTxOptions() *TxOptions {
+	return &TxOptions{
+		MaxRetries:    DefaultTxMaxRetries,
+		RetryInterval: DefaultTxRetryInterval,
+	}
+}
+
+// IsRetryableTxErr returns whether the given error is a Postgres serialization failure or
+// deadlock, after which the whole transaction may be retried.
+func IsRetryableTxErr(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	return pqErr.Code == serializationFailureCode || pqErr.Code == deadlockDetectedCode
+}
+
+// txHandle is a Querier running statements within a transaction that can be committed or rolled
+// back.
+type txHandle interface {
+	Querier
+	Commit() error
+	Rollback() error
+}
+
+// runTx runs the given function within transactions started by the given begin function, retrying
+// according to the options when the transaction fails with a retryable error.
+func runTx(
+	ctx context.Context,
+	opts *TxOptions,
+	fn func(tx Querier) error,
+	begin func(sqlOpts *sql.TxOptions) (txHandle, error),
+) error {
+	if opts == nil {
+		opts = NewDefaultTxOptions()
+	}
+	sqlOpts := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+	op := func() error {
+		tx, err := begin(sqlOpts)
+		if err == nil {
+			err = runInTx(tx, fn)
+		}
+		if err != nil && !IsRetryableTxErr(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	return backoff.Retry(op, newTxBackoff(ctx, opts))
+}
+
+// runInTx runs the given function within the given transaction, committing it if the function
+// returns no error and rolling it back otherwise (including when the function panics).
+func runInTx(tx txHandle, fn func(tx Querier) error) error {
+	committed := false
+	defer func() {
+		if !committed {
+			// nothing to do if rollback fails since error (or panic) already propagating
+			_ = tx.Rollback()
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit()
+}
+
+func newTxBackoff(ctx context.Context, opts *TxOptions) backoff.BackOff {
+	if opts.MaxRetries == 0 {
+		return backoff.WithContext(&backoff.StopBackOff{}, ctx)
+	}
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = opts.RetryInterval
+	bo.MaxElapsedTime = 0
+	return backoff.WithContext(backoff.WithMaxTries(bo, uint64(opts.MaxRetries)), ctx)
+}
+
+// txQuerier is a Querier running statements within a *sql.Tx.
+type txQuerier struct {
+	tx *sql.Tx
+}
+
+func (q *txQuerier) SelectQueryContext(
+	ctx context.Context, b sq.SelectBuilder,
+) (QueryRows, error) {
+	return b.RunWith(q.tx).QueryContext(ctx)
+}
+
+func (q *txQuerier) SelectQueryRowContext(
+	ctx context.Context, b sq.SelectBuilder,
+) sq.RowScanner {
+	return b.RunWith(q.tx).QueryRowContext(ctx)
+}
+
+func (q *txQuerier) InsertExecContext(
+	ctx context.Context, b sq.InsertBuilder,
+) (sql.Result, error) {
+	return b.RunWith(q.tx).ExecContext(ctx)
+}
+
+func (q *txQuerier) UpdateExecContext(
+	ctx context.Context, b sq.UpdateBuilder,
+) (sql.Result, error) {
+	return b.RunWith(q.tx).ExecContext(ctx)
+}
+
+func (q *txQuerier) DeleteExecContext(
+	ctx context.Context, b sq.DeleteBuilder,
+) (sql.Result, error) {
+	return b.RunWith(q.tx).ExecContext(ctx)
+}
+
+func (q *txQuerier) WithTx(ctx context.Context, _ *TxOptions, fn func(tx Querier) error) error {
+	return fn(q)
+}
+
+func (q *txQuerier) Commit() error {
+	return q.tx.Commit()
+}
+
+func (q *txQuerier) Rollback() error {
+	return q.tx.Rollback()
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errSerialization = &pq.Error{Code: serializationFailureCode}
+	errDeadlock      = &pq.Error{Code: deadlockDetectedCode}
+	testInsert       = sq.Insert("test.test").Columns("id").Values("id1")
+)
+
+func TestIsRetryableTxErr(t *testing.T) {
+	assert.True(t, IsRetryableTxErr(errSerialization))
+	assert.True(t, IsRetryableTxErr(errDeadlock))
+	assert.False(t, IsRetryableTxErr(&pq.Error{Code: "23505"})) // unique violation
+	assert.False(t, IsRetryableTxErr(errors.New("some error")))
+	assert.False(t, IsRetryableTxErr(nil))
+}
+
+func TestQuerierImpl_WithTx_noDB(t *testing.T) {
+	err := NewQuerier().WithTx(context.Background(), nil, func(tx Querier) error {
+		return nil
+	})
+	assert.Equal(t, ErrNoTxDB, err)
+}
+
+func TestMockQuerier_WithTx_ok(t *testing.T) {
+	q := &MockQuerier{}
+	opts := &TxOptions{Isolation: sql.LevelSerializable}
+	err := q.WithTx(context.Background(), opts, func(tx Querier) error {
+		_, err := tx.InsertExecContext(context.Background(), testInsert)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{BeginEvent, InsertEvent, CommitEvent}, q.EventTypes())
+	assert.Equal(t, opts, q.Events[0].TxOptions)
+	assert.True(t, q.Events[1].InTx)
+	assert.Equal(t, "INSERT INTO test.test (id) VALUES (?)", q.Events[1].SQL)
+	assert.Equal(t, []interface{}{"id1"}, q.Events[1].Args)
+
+	// statements outside of tx aren't marked as in one
+	_, err = q.InsertExecContext(context.Background(), testInsert)
+	assert.Nil(t, err)
+	assert.False(t, q.Events[3].InTx)
+}
+
+func TestMockQuerier_WithTx_rollback(t *testing.T) {
+	q := &MockQuerier{}
+	fnErr := errors.New("some fn error")
+	err := q.WithTx(context.Background(), nil, func(tx Querier) error {
+		if _, err := tx.InsertExecContext(context.Background(), testInsert); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	assert.Equal(t, fnErr, err)
+	assert.Equal(t, []string{BeginEvent, InsertEvent, RollbackEvent}, q.EventTypes())
+
+	// statement errors also roll back
+	q = &MockQuerier{InsertErr: errors.New("some insert error")}
+	err = q.WithTx(context.Background(), nil, func(tx Querier) error {
+		_, err := tx.InsertExecContext(context.Background(), testInsert)
+		return err
+	})
+	assert.Equal(t, q.InsertErr, err)
+	assert.Equal(t, []string{BeginEvent, InsertEvent, RollbackEvent}, q.EventTypes())
+}
+
+func TestMockQuerier_WithTx_panic(t *testing.T) {
+	q := &MockQuerier{}
+	assert.Panics(t, func() {
+		_ = q.WithTx(context.Background(), nil, func(tx Querier) error {
+			panic("some panic")
+		})
+	})
+	assert.Equal(t, []string{BeginEvent, RollbackEvent}, q.EventTypes())
+}
+
+func TestMockQuerier_WithTx_retry(t *testing.T) {
+	q := &MockQuerier{CommitErrs: []error{errSerialization, errDeadlock}}
+	nCalls := 0
+	err := q.WithTx(context.Background(), nil, func(tx Querier) error {
+		nCalls++
+		_, err := tx.InsertExecContext(context.Background(), testInsert)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, nCalls)
+	attempt := []string{BeginEvent, InsertEvent, CommitEvent}
+	expected := append(append(append([]string{}, attempt...), attempt...), attempt...)
+	assert.Equal(t, expected, q.EventTypes())
+
+	// retryable statement error rolls back and retries
+	q = &MockQuerier{}
+	nCalls = 0
+	err = q.WithTx(context.Background(), nil, func(tx Querier) error {
+		nCalls++
+		if nCalls == 1 {
+			return errDeadlock
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{BeginEvent, RollbackEvent, BeginEvent, CommitEvent},
+		q.EventTypes())
+}
+
+func TestMockQuerier_WithTx_retryErr(t *testing.T) {
+	// exhausts retries
+	q := &MockQuerier{
+		CommitErrs: []error{errSerialization, errSerialization, errSerialization},
+	}
+	opts := NewDefaultTxOptions()
+	opts.MaxRetries = 2
+	nCalls := 0
+	err := q.WithTx(context.Background(), opts, func(tx Querier) error {
+		nCalls++
+		return nil
+	})
+	assert.Equal(t, errSerialization, err)
+	assert.Equal(t, 3, nCalls)
+
+	// no retries
+	q = &MockQuerier{CommitErrs: []error{errSerialization}}
+	opts.MaxRetries = 0
+	nCalls = 0
+	err = q.WithTx(context.Background(), opts, func(tx Querier) error {
+		nCalls++
+		return nil
+	})
+	assert.Equal(t, errSerialization, err)
+	assert.Equal(t, 1, nCalls)
+
+	// non-retryable begin error
+	q = &MockQuerier{BeginErrs: []error{errors.New("some begin error")}}
+	err = q.WithTx(context.Background(), nil, func(tx Querier) error {
+		assert.Fail(t, "should not be called")
+		return nil
+	})
+	assert.Equal(t, q.BeginErrs[0], err)
+	assert.Equal(t, []string{BeginEvent}, q.EventTypes())
+
+	// canceled context stops retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q = &MockQuerier{CommitErrs: []error{errSerialization, errSerialization}}
+	err = q.WithTx(ctx, nil, func(tx Querier) error { return nil })
+	assert.Equal(t, errSerialization, err)
+}
+
+func TestMockQuerier_WithTx_nested(t *testing.T) {
+	q := &MockQuerier{}
+	err := q.WithTx(context.Background(), nil, func(tx Querier) error {
+		return tx.WithTx(context.Background(), nil, func(tx2 Querier) error {
+			_, err := tx2.InsertExecContext(context.Background(), testInsert)
+			return err
+		})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{BeginEvent, InsertEvent, CommitEvent}, q.EventTypes())
+}
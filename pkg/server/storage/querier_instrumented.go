@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultSlowQueryThreshold is the default duration above which queries are logged as slow.
+	DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+	unnamedQuery    = "unnamed"
+	unknownSQLSTATE = "unknown"
+	queryKindSelect = "select"
+	queryKindInsert = "insert"
+	queryKindUpdate = "update"
+	queryKindDelete = "delete"
+	queryKindTx     = "transaction"
+	queryKindBulk   = "bulk_load"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "postgres_query_duration_seconds",
+			Help:    "Duration of Postgres queries and transactions.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"kind", "query"},
+	)
+	queryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "postgres_query_errors_total",
+			Help: "Total number of Postgres query and transaction errors.",
+		},
+		[]string{"kind", "query", "sqlstate_class"},
+	)
+	bulkBatchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "postgres_bulk_load_batch_duration_seconds",
+			Help:    "Duration of Postgres bulk load batches.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"method", "query"},
+	)
+	bulkRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "postgres_bulk_load_rows_total",
+			Help: "Total number of rows inserted by Postgres bulk loads.",
+		},
+		[]string{"method", "query"},
+	)
+)
+
+func init() {
+	// registered in the default registry exposed by the BaseServer metrics endpoint
+	prometheus.MustRegister(queryDuration, queryErrors, bulkBatchDuration, bulkRows)
+}
+
+type queryNameKey struct{}
+
+// WithQueryName returns a context with the given query name, which labels the metrics (and slow
+// query logs) of queries run with it via an instrumented Querier.
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+func queryName(ctx context.Context) string {
+	if name, ok := ctx.Value(queryNameKey{}).(string); ok && name != "" {
+		return name
+	}
+	return unnamedQuery
+}
+
+type instrumentedQuerier struct {
+	inner         Querier
+	logger        *zap.Logger
+	slowThreshold time.Duration
+}
+
+// NewInstrumentedQuerier returns a Querier wrapping the given one that records Prometheus
+// duration histograms (labeled by statement kind and the query name from WithQueryName) and error
+// counters (labeled by SQLSTATE class). Queries taking longer than the given threshold are logged
+// with their SQL but not their parameters. A zero threshold disables slow query logging.
+func NewInstrumentedQuerier(
+	inner Querier, logger *zap.Logger, slowThreshold time.Duration,
+) Querier {
+	return &instrumentedQuerier{
+		inner:         inner,
+		logger:        logger,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (q *instrumentedQuerier) SelectQueryContext(
+	ctx context.Context, b sq.SelectBuilder,
+) (QueryRows, error) {
+	start := time.Now()
+	rows, err := q.inner.SelectQueryContext(ctx, b)
+	q.observe(ctx, queryKindSelect, b, start, err)
+	return rows, err
+}
+
+func (q *instrumentedQuerier) SelectQueryRowContext(
+	ctx context.Context, b sq.SelectBuilder,
+) sq.RowScanner {
+	// query errors are only returned by Scan, so observe then
+	return &instrumentedRow{
+		inner: q.inner.SelectQueryRowContext(ctx, b),
+		observe: func(start time.Time, err error) {
+			if err == sql.ErrNoRows {
+				err = nil
+			}
+			q.observe(ctx, queryKindSelect, b, start, err)
+		},
+		start: time.Now(),
+	}
+}
+
+func (q *instrumentedQuerier) InsertExecContext(
+	ctx context.Context, b sq.InsertBuilder,
+) (sql.Result, error) {
+	start := time.Now()
+	result, err := q.inner.InsertExecContext(ctx, b)
+	q.observe(ctx, queryKindInsert, b, start, err)
+	return result, err
+}
+
+func (q *instrumentedQuerier) UpdateExecContext(
+	ctx context.Context, b sq.UpdateBuilder,
+) (sql.Result, error) {
+	start := time.Now()
+	result, err := q.inner.UpdateExecContext(ctx, b)
+	q.observe(ctx, queryKindUpdate, b, start, err)
+	return result, err
+}
+
+func (q *instrumentedQuerier) DeleteExecContext(
+	ctx context.Context, b sq.DeleteBuilder,
+) (sql.Result, error) {
+	start := time.Now()
+	result, err := q.inner.DeleteExecContext(ctx, b)
+	q.observe(ctx, queryKindDelete, b, start, err)
+	return result, err
+}
+
+func (q *instrumentedQuerier) WithTx(
+	ctx context.Context, opts *TxOptions, fn func(tx Querier) error,
+) error {
+	start := time.Now()
+	err := q.inner.WithTx(ctx, opts, func(tx Querier) error {
+		return fn(&instrumentedQuerier{
+			inner:         tx,
+			logger:        q.logger,
+			slowThreshold: q.slowThreshold,
+		})
+	})
+	q.observe(ctx, queryKindTx, nil, start, err)
+	return err
+}
+
+func (q *instrumentedQuerier) BulkLoadContext(
+	ctx context.Context, l *BulkLoad,
+) (*BulkLoadResult, error) {
+	name := queryName(ctx)
+	instrumented := *l
+	instrumented.OnBatch = func(b *BulkBatch) {
+		method := b.Method.String()
+		bulkBatchDuration.WithLabelValues(method, name).Observe(b.Duration.Seconds())
+		bulkRows.WithLabelValues(method, name).Add(float64(b.Inserted))
+		if l.OnBatch != nil {
+			l.OnBatch(b)
+		}
+	}
+	start := time.Now()
+	result, err := q.inner.BulkLoadContext(ctx, &instrumented)
+	q.observe(ctx, queryKindBulk, nil, start, err)
+	return result, err
+}
+
+// observe records the metrics of a query (or transaction or bulk load if b is nil) started at
+// the given time and logs it if slow.
+func (q *instrumentedQuerier) observe(
+	ctx context.Context, kind string, b sq.Sqlizer, start time.Time, err error,
+) {
+	elapsed := time.Since(start)
+	name := queryName(ctx)
+	queryDuration.WithLabelValues(kind, name).Observe(elapsed.Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(kind, name, sqlStateClass(err)).Inc()
+	}
+	if q.slowThreshold == 0 || elapsed < q.slowThreshold {
+		return
+	}
+	fields := []zapcore.Field{
+		zap.String(logQueryKind, kind),
+		zap.String(logQueryName, name),
+		zap.Duration(logDuration, elapsed),
+	}
+	if b != nil {
+		// only log placeholder SQL, never the args, since they may be sensitive
+		if stmt, args, sqlErr := b.ToSql(); sqlErr == nil {
+			fields = append(fields, zap.String(logSQL, stmt), zap.Int(logNArgs, len(args)))
+		}
+	}
+	q.logger.Warn("slow query", fields...)
+}
+
+// sqlStateClass returns the SQLSTATE class (i.e., the first two characters of the code) of a
+// Postgres error or "unknown" for other errors.
+func sqlStateClass(err error) string {
+	pqErr, ok := err.(*pq.Error)
+	if !ok || pqErr.Code == "" {
+		return unknownSQLSTATE
+	}
+	return string(pqErr.Code.Class())
+}
+
+type instrumentedRow struct {
+	inner   sq.RowScanner
+	observe func(start time.Time, err error)
+	start   time.Time
+}
+
+func (r *instrumentedRow) Scan(dest ...interface{}) error {
+	err := r.inner.Scan(dest...)
+	r.observe(r.start, err)
+	return err
+}
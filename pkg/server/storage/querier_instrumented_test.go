@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestWithQueryName(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, unnamedQuery, queryName(ctx))
+	assert.Equal(t, "get_user", queryName(WithQueryName(ctx, "get_user")))
+	assert.Equal(t, unnamedQuery, queryName(WithQueryName(ctx, "")))
+}
+
+func TestInstrumentedQuerier_metrics(t *testing.T) {
+	inner := &MockQuerier{
+		SelectRow: &fixedRow{},
+		UpdateErr: &pq.Error{Code: "23505"}, // unique violation
+		DeleteErr: errors.New("some delete error"),
+	}
+	q := NewInstrumentedQuerier(inner, zap.NewNop(), 0)
+	ctx := WithQueryName(context.Background(), "TestInstrumentedQuerier_metrics")
+	selectB := sq.Select("id").From("test.test")
+
+	_, err := q.SelectQueryContext(ctx, selectB)
+	assert.Nil(t, err)
+	err = q.SelectQueryRowContext(ctx, selectB).Scan()
+	assert.Nil(t, err)
+	_, err = q.InsertExecContext(ctx, testInsert)
+	assert.Nil(t, err)
+	_, err = q.UpdateExecContext(ctx, sq.Update("test.test").Set("field_1", "a"))
+	assert.NotNil(t, err)
+	_, err = q.DeleteExecContext(ctx, sq.Delete("test.test"))
+	assert.NotNil(t, err)
+
+	name := queryName(ctx)
+	assert.Equal(t, uint64(2), histogramCount(t, queryKindSelect, name))
+	assert.Equal(t, uint64(1), histogramCount(t, queryKindInsert, name))
+	assert.Equal(t, uint64(1), histogramCount(t, queryKindUpdate, name))
+	assert.Equal(t, uint64(1), histogramCount(t, queryKindDelete, name))
+	assert.Equal(t, 0.0, counterValue(t, queryKindSelect, name, unknownSQLSTATE))
+	assert.Equal(t, 1.0, counterValue(t, queryKindUpdate, name, "23"))
+	assert.Equal(t, 1.0, counterValue(t, queryKindDelete, name, unknownSQLSTATE))
+
+	// no rows isn't a query error
+	inner.SelectRow = &fixedRow{err: sql.ErrNoRows}
+	err = q.SelectQueryRowContext(ctx, selectB).Scan()
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.Equal(t, uint64(3), histogramCount(t, queryKindSelect, name))
+	assert.Equal(t, 0.0, counterValue(t, queryKindSelect, name, unknownSQLSTATE))
+}
+
+func TestInstrumentedQuerier_WithTx(t *testing.T) {
+	inner := &MockQuerier{CommitErrs: []error{&pq.Error{Code: deadlockDetectedCode}}}
+	q := NewInstrumentedQuerier(inner, zap.NewNop(), 0)
+	ctx := WithQueryName(context.Background(), "TestInstrumentedQuerier_WithTx")
+	opts := NewDefaultTxOptions()
+	opts.MaxRetries = 0
+
+	err := q.WithTx(ctx, opts, func(tx Querier) error {
+		_, err2 := tx.InsertExecContext(ctx, testInsert)
+		return err2
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{BeginEvent, InsertEvent, CommitEvent}, inner.EventTypes())
+
+	// statements within the tx are also instrumented
+	name := queryName(ctx)
+	assert.Equal(t, uint64(1), histogramCount(t, queryKindInsert, name))
+	assert.Equal(t, uint64(1), histogramCount(t, queryKindTx, name))
+	assert.Equal(t, 1.0, counterValue(t, queryKindTx, name, "40"))
+}
+
+func TestInstrumentedQuerier_slowQuery(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(buf),
+		zapcore.DebugLevel,
+	))
+	inner := &slowQuerier{MockQuerier: &MockQuerier{}, delay: 10 * time.Millisecond}
+	ctx := WithQueryName(context.Background(), "TestInstrumentedQuerier_slowQuery")
+	insert := sq.Insert("test.test").Columns("id", "field_1").Values("id1", "secret value")
+
+	// fast enough
+	q := NewInstrumentedQuerier(inner, logger, time.Second)
+	_, err := q.InsertExecContext(ctx, insert)
+	assert.Nil(t, err)
+	assert.Empty(t, buf.String())
+
+	// disabled
+	q = NewInstrumentedQuerier(inner, logger, 0)
+	_, err = q.InsertExecContext(ctx, insert)
+	assert.Nil(t, err)
+	assert.Empty(t, buf.String())
+
+	// slow
+	q = NewInstrumentedQuerier(inner, logger, time.Millisecond)
+	_, err = q.InsertExecContext(ctx, insert)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(buf.String(), "secret value"))
+	logged := make(map[string]interface{})
+	err = json.Unmarshal(buf.Bytes(), &logged)
+	assert.Nil(t, err)
+	assert.Equal(t, "slow query", logged["msg"])
+	assert.Equal(t, queryKindInsert, logged[logQueryKind])
+	assert.Equal(t, queryName(ctx), logged[logQueryName])
+	assert.Equal(t, "INSERT INTO test.test (id,field_1) VALUES (?,?)", logged[logSQL])
+	assert.Equal(t, 2.0, logged[logNArgs])
+	assert.NotEmpty(t, logged[logDuration])
+}
+
+func TestSQLStateClass(t *testing.T) {
+	assert.Equal(t, "40", sqlStateClass(&pq.Error{Code: serializationFailureCode}))
+	assert.Equal(t, "23", sqlStateClass(&pq.Error{Code: "23505"}))
+	assert.Equal(t, unknownSQLSTATE, sqlStateClass(&pq.Error{}))
+	assert.Equal(t, unknownSQLSTATE, sqlStateClass(errors.New("some error")))
+}
+
+type fixedRow struct {
+	err error
+}
+
+func (r *fixedRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+type slowQuerier struct {
+	*MockQuerier
+	delay time.Duration
+}
+
+func (q *slowQuerier) InsertExecContext(
+	ctx context.Context, b sq.InsertBuilder,
+) (sql.Result, error) {
+	time.Sleep(q.delay)
+	return q.MockQuerier.InsertExecContext(ctx, b)
+}
+
+func histogramCount(t *testing.T, kind, name string) uint64 {
+	m := &dto.Metric{}
+	err := queryDuration.WithLabelValues(kind, name).(prometheus.Metric).Write(m)
+	assert.Nil(t, err)
+	return m.Histogram.GetSampleCount()
+}
+
+func counterValue(t *testing.T, kind, name, class string) float64 {
+	m := &dto.Metric{}
+	err := queryErrors.WithLabelValues(kind, name, class).Write(m)
+	assert.Nil(t, err)
+	return m.Counter.GetValue()
+}
+
+func TestInstrumentedQuerier_BulkLoadContext(t *testing.T) {
+	inner := &MockQuerier{}
+	q := NewInstrumentedQuerier(inner, zap.NewNop(), 0)
+	ctx := WithQueryName(context.Background(), "TestInstrumentedQuerier_BulkLoadContext")
+	nBatches := 0
+	l := &BulkLoad{
+		Table:     "test.test",
+		Columns:   []string{"id"},
+		Rows:      [][]interface{}{{"id1"}, {"id2"}, {"id3"}},
+		BatchSize: 2,
+		OnBatch:   func(b *BulkBatch) { nBatches++ },
+	}
+	_, err := q.BulkLoadContext(ctx, l)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, nBatches)
+
+	name := queryName(ctx)
+	assert.Equal(t, uint64(1), histogramCount(t, queryKindBulk, name))
+	m := &dto.Metric{}
+	err = bulkBatchDuration.WithLabelValues("copy", name).(prometheus.Metric).Write(m)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), m.Histogram.GetSampleCount())
+	err = bulkRows.WithLabelValues("copy", name).Write(m)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, m.Counter.GetValue())
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// types of QuerierEvents recorded by a MockQuerier
+const (
+	// BeginEvent indicates the beginning of a transaction.
+	BeginEvent = "BEGIN"
+
+	// CommitEvent indicates the commit of a transaction.
+	CommitEvent = "COMMIT"
+
+	// RollbackEvent indicates the rollback of a transaction.
+	RollbackEvent = "ROLLBACK"
+
+	// SelectEvent indicates a SELECT statement.
+	SelectEvent = "SELECT"
+
+	// InsertEvent indicates an INSERT statement.
+	InsertEvent = "INSERT"
+
+	// UpdateEvent indicates an UPDATE statement.
+	UpdateEvent = "UPDATE"
+
+	// DeleteEvent indicates a DELETE statement.
+	DeleteEvent = "DELETE"
+
+	// CopyEvent indicates a COPY statement, whose Args are the values of its rows.
+	CopyEvent = "COPY"
+)
+
+// QuerierEvent is a statement or transaction boundary recorded by a MockQuerier.
+type QuerierEvent struct {
+	// Type is the event type.
+	Type string
+
+	// InTx indicates whether the event occurred within a transaction.
+	InTx bool
+
+	// SQL is the SQL of a statement event.
+	SQL string
+
+	// Args are the SQL arguments of a statement event.
+	Args []interface{}
+
+	// TxOptions are the options of a BeginEvent.
+	TxOptions *TxOptions
+}
+
+// MockQuerier is a Querier for unit tests that returns fixed results and records the statements it
+// runs along with the boundaries of the transactions it runs them in.
+type MockQuerier struct {
+	SelectRows   QueryRows
+	SelectRow    sq.RowScanner
+	SelectErr    error
+	InsertResult sql.Result
+	InsertErr    error
+	UpdateResult sql.Result
+	UpdateErr    error
+	DeleteResult sql.Result
+	DeleteErr    error
+	CopyErr      error
+
+	// BeginErrs are returned by successive transaction begins, after which begins succeed.
+	BeginErrs []error
+
+	// CommitErrs are returned by successive transaction commits, after which commits succeed.
+	// Retryable errors (e.g., a *pq.Error with a serialization failure code) cause WithTx to
+	// retry the transaction.
+	CommitErrs []error
+
+	// Events are the recorded statements and transaction boundaries.
+	Events []*QuerierEvent
+
+	mu       sync.Mutex
+	nBegins  int
+	nCommits int
+}
+
+// SelectQueryContext records the SELECT statement and returns the SelectRows and SelectErr.
+func (m *MockQuerier) SelectQueryContext(
+	ctx context.Context, b sq.SelectBuilder,
+) (QueryRows, error) {
+	m.recordStmt(SelectEvent, false, b)
+	return m.SelectRows, m.SelectErr
+}
+
+// SelectQueryRowContext records the SELECT statement and returns the SelectRow.
+func (m *MockQuerier) SelectQueryRowContext(
+	ctx context.Context, b sq.SelectBuilder,
+) sq.RowScanner {
+	m.recordStmt(SelectEvent, false, b)
+	return m.SelectRow
+}
+
+// InsertExecContext records the INSERT statement and returns the InsertResult and InsertErr.
+func (m *MockQuerier) InsertExecContext(
+	ctx context.Context, b sq.InsertBuilder,
+) (sql.Result, error) {
+	m.recordStmt(InsertEvent, false, b)
+	return m.InsertResult, m.InsertErr
+}
+
+// UpdateExecContext records the UPDATE statement and returns the UpdateResult and UpdateErr.
+func (m *MockQuerier) UpdateExecContext(
+	ctx context.Context, b sq.UpdateBuilder,
+) (sql.Result, error) {
+	m.recordStmt(UpdateEvent, false, b)
+	return m.UpdateResult, m.UpdateErr
+}
+
+// DeleteExecContext records the DELETE statement and returns the DeleteResult and DeleteErr.
+func (m *MockQuerier) DeleteExecContext(
+	ctx context.Context, b sq.DeleteBuilder,
+) (sql.Result, error) {
+	m.recordStmt(DeleteEvent, false, b)
+	return m.DeleteResult, m.DeleteErr
+}
+
+// WithTx runs the given function within a mock transaction, with the same commit, rollback, and
+// retry behavior as a real Querier.
+func (m *MockQuerier) WithTx(
+	ctx context.Context, opts *TxOptions, fn func(tx Querier) error,
+) error {
+	return runTx(ctx, opts, fn, func(_ *sql.TxOptions) (txHandle, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		e := &QuerierEvent{Type: BeginEvent, InTx: true, TxOptions: opts}
+		m.Events = append(m.Events, e)
+		m.nBegins++
+		if m.nBegins <= len(m.BeginErrs) && m.BeginErrs[m.nBegins-1] != nil {
+			return nil, m.BeginErrs[m.nBegins-1]
+		}
+		return &mockTx{m: m}, nil
+	})
+}
+
+// BulkLoadContext runs the bulk load within a mock transaction, recording its COPY or INSERT
+// statements.
+func (m *MockQuerier) BulkLoadContext(
+	ctx context.Context, l *BulkLoad,
+) (*BulkLoadResult, error) {
+	var result *BulkLoadResult
+	err := m.WithTx(ctx, nil, func(tx Querier) error {
+		var err error
+		result, err = tx.BulkLoadContext(ctx, l)
+		return err
+	})
+	return result, err
+}
+
+// EventTypes returns the types of the recorded events.
+func (m *MockQuerier) EventTypes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := make([]string, len(m.Events))
+	for i, e := range m.Events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func (m *MockQuerier) recordStmt(eventType string, inTx bool, b sq.Sqlizer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &QuerierEvent{Type: eventType, InTx: inTx}
+	e.SQL, e.Args, _ = b.ToSql()
+	m.Events = append(m.Events, e)
+}
+
+// mockTx is a mock transaction of a MockQuerier.
+type mockTx struct {
+	m *MockQuerier
+}
+
+func (t *mockTx) SelectQueryContext(
+	ctx context.Context, b sq.SelectBuilder,
+) (QueryRows, error) {
+	t.m.recordStmt(SelectEvent, true, b)
+	return t.m.SelectRows, t.m.SelectErr
+}
+
+func (t *mockTx) SelectQueryRowContext(
+	ctx context.Context, b sq.SelectBuilder,
+) sq.RowScanner {
+	t.m.recordStmt(SelectEvent, true, b)
+	return t.m.SelectRow
+}
+
+func (t *mockTx) InsertExecContext(
+	ctx context.Context, b sq.InsertBuilder,
+) (sql.Result, error) {
+	t.m.recordStmt(InsertEvent, true, b)
+	return t.m.InsertResult, t.m.InsertErr
+}
+
+func (t *mockTx) UpdateExecContext(
+	ctx context.Context, b sq.UpdateBuilder,
+) (sql.Result, error) {
+	t.m.recordStmt(UpdateEvent, true, b)
+	return t.m.UpdateResult, t.m.UpdateErr
+}
+
+func (t *mockTx) DeleteExecContext(
+	ctx context.Context, b sq.DeleteBuilder,
+) (sql.Result, error) {
+	t.m.recordStmt(DeleteEvent, true, b)
+	return t.m.DeleteResult, t.m.DeleteErr
+}
+
+func (t *mockTx) WithTx(ctx context.Context, _ *TxOptions, fn func(tx Querier) error) error {
+	return fn(t)
+}
+
+func (t *mockTx) Commit() error {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	t.m.Events = append(t.m.Events, &QuerierEvent{Type: CommitEvent, InTx: true})
+	t.m.nCommits++
+	if t.m.nCommits <= len(t.m.CommitErrs) {
+		return t.m.CommitErrs[t.m.nCommits-1]
+	}
+	return nil
+}
+
+func (t *mockTx) Rollback() error {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	t.m.Events = append(t.m.Events, &QuerierEvent{Type: RollbackEvent, InTx: true})
+	return nil
+}
+
+func (t *mockTx) BulkLoadContext(ctx context.Context, l *BulkLoad) (*BulkLoadResult, error) {
+	return runBulkLoad(ctx, l, t.copyIn, t.InsertExecContext)
+}
+
+func (t *mockTx) copyIn(ctx context.Context, l *BulkLoad, rows [][]interface{}) (int64, error) {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	e := &QuerierEvent{Type: CopyEvent, InTx: true, SQL: copyInSQL(l.Table, l.Columns)}
+	for _, row := range rows {
+		e.Args = append(e.Args, row...)
+	}
+	t.m.Events = append(t.m.Events, e)
+	if t.m.CopyErr != nil {
+		return 0, t.m.CopyErr
+	}
+	return int64(len(rows)), nil
+}
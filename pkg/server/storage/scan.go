@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	dbTag     = "db"
+	dbTagSkip = "-"
+)
+
+var (
+	// ErrNotStructPtr indicates when a value is not a pointer to a struct.
+	ErrNotStructPtr = errors.New("value must be a non-nil pointer to a struct")
+
+	// ErrNotSlicePtr indicates when a value is not a pointer to a slice of structs or struct
+	// pointers.
+	ErrNotSlicePtr = errors.New("value must be a non-nil pointer to a slice of structs or " +
+		"struct pointers")
+
+	// ErrDuplicateColumn indicates when two struct fields have the same db tag column name.
+	ErrDuplicateColumn = errors.New("duplicate db column")
+
+	// ErrUnexportedColumn indicates when an unexported struct field has a db tag or when an
+	// embedded struct pointer has an unexported type.
+	ErrUnexportedColumn = errors.New("db column field must be exported")
+
+	structColsCache = &structColsMap{cols: make(map[reflect.Type][]*structCol)}
+)
+
+// RowScanner scans the columns of the current row into the given destinations. Both QueryRows and
+// squirrel.RowScanner implement it.
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Columns returns the column names of the given struct (or pointer to one) from the `db` tags of
+// its fields, including those of embedded structs (or pointers to exported ones). Fields without a
+// `db` tag (or with a "-" tag) are ignored. Nullable columns should use pointer fields (e.g.,
+// *string) or sql.Null* types.
+func Columns(v interface{}) ([]string, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ErrNotStructPtr
+	}
+	scs, err := getStructCols(t)
+	if err != nil {
+		return nil, err
+	}
+	cols := make([]string, len(scs))
+	for i, sc := range scs {
+		cols[i] = sc.name
+	}
+	return cols, nil
+}
+
+// ScanDests returns the scan destinations of the given struct pointer's `db` tagged fields in the
+// same order as Columns, allocating any nil embedded struct pointers.
+func ScanDests(v interface{}) ([]interface{}, error) {
+	sv, err := structPtrValue(v)
+	if err != nil {
+		return nil, err
+	}
+	scs, err := getStructCols(sv.Type())
+	if err != nil {
+		return nil, err
+	}
+	dests := make([]interface{}, len(scs))
+	for i, sc := range scs {
+		dests[i] = allocFieldByIndex(sv, sc.index).Addr().Interface()
+	}
+	return dests, nil
+}
+
+// StructColDests returns the ColDests for the given struct pointer's `db` tagged fields.
+func StructColDests(v interface{}) ([]*ColDest, error) {
+	cols, err := Columns(v)
+	if err != nil {
+		return nil, err
+	}
+	dests, err := ScanDests(v)
+	if err != nil {
+		return nil, err
+	}
+	cds := make([]*ColDest, len(cols))
+	for i, col := range cols {
+		cds[i] = &ColDest{Col: col, Dest: dests[i]}
+	}
+	return cds, nil
+}
+
+// InsertValues returns the column names and values of the given struct (or pointer to one) for an
+// insert statement, e.g., via squirrel.InsertBuilder.Columns(cols...).Values(values...). The
+// fields of nil embedded struct pointers have nil values.
+func InsertValues(v interface{}) ([]string, []interface{}, error) {
+	sv := reflect.ValueOf(v)
+	if sv.Kind() == reflect.Ptr && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Struct {
+		return nil, nil, ErrNotStructPtr
+	}
+	scs, err := getStructCols(sv.Type())
+	if err != nil {
+		return nil, nil, err
+	}
+	cols := make([]string, len(scs))
+	values := make([]interface{}, len(scs))
+	for i, sc := range scs {
+		cols[i] = sc.name
+		if fv, ok := fieldByIndex(sv, sc.index); ok {
+			values[i] = fv.Interface()
+		}
+	}
+	return cols, values, nil
+}
+
+// ScanStruct scans the current row, whose columns must be those given by Columns, into the given
+// struct pointer.
+func ScanStruct(row RowScanner, dest interface{}) error {
+	dests, err := ScanDests(dest)
+	if err != nil {
+		return err
+	}
+	return row.Scan(dests...)
+}
+
+// ScanAll scans all of the rows, whose columns must be those given by Columns for the slice's
+// element struct type, into the given pointer to a slice of structs or struct pointers and then
+// closes the rows.
+func ScanAll(rows QueryRows, dest interface{}) error {
+	sv := reflect.ValueOf(dest)
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Slice {
+		return ErrNotSlicePtr
+	}
+	slice := sv.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	structType := elemType
+	if isPtr {
+		structType = elemType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return ErrNotSlicePtr
+	}
+
+	for rows.Next() {
+		elem := reflect.New(structType)
+		if err := ScanStruct(rows, elem.Interface()); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if isPtr {
+			slice = reflect.Append(slice, elem)
+		} else {
+			slice = reflect.Append(slice, elem.Elem())
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	sv.Elem().Set(slice)
+	return rows.Close()
+}
+
+// structCol is a struct field mapped to a DB column.
+type structCol struct {
+	name  string
+	index []int
+}
+
+type structColsMap struct {
+	cols map[reflect.Type][]*structCol
+	mu   sync.RWMutex
+}
+
+func getStructCols(t reflect.Type) ([]*structCol, error) {
+	structColsCache.mu.RLock()
+	scs, in := structColsCache.cols[t]
+	structColsCache.mu.RUnlock()
+	if in {
+		return scs, nil
+	}
+	scs = make([]*structCol, 0, t.NumField())
+	if err := collectStructCols(t, nil, &scs, make(map[string]struct{})); err != nil {
+		return nil, err
+	}
+	structColsCache.mu.Lock()
+	structColsCache.cols[t] = scs
+	structColsCache.mu.Unlock()
+	return scs, nil
+}
+
+func collectStructCols(
+	t reflect.Type, index []int, scs *[]*structCol, seen map[string]struct{},
+) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fIndex := append(append([]int{}, index...), i)
+		name := strings.Split(f.Tag.Get(dbTag), ",")[0]
+		if name == dbTagSkip {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				if ft.Kind() == reflect.Struct && f.PkgPath != "" {
+					// can't allocate unexported embedded struct pointers when scanning
+					return ErrUnexportedColumn
+				}
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := collectStructCols(ft, fIndex, scs, seen); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if name == "" {
+			continue
+		}
+		if f.PkgPath != "" {
+			return ErrUnexportedColumn
+		}
+		if _, in := seen[name]; in {
+			return ErrDuplicateColumn
+		}
+		seen[name] = struct{}{}
+		*scs = append(*scs, &structCol{name: name, index: fIndex})
+	}
+	return nil
+}
+
+func structPtrValue(v interface{}) (reflect.Value, error) {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, ErrNotStructPtr
+	}
+	return pv.Elem(), nil
+}
+
+// allocFieldByIndex returns the nested field with the given index, allocating any nil embedded
+// struct pointers along the way.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldByIndex returns the nested field with the given index or false if it is within a nil
+// embedded struct pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRowBase struct {
+	ID string `db:"id"`
+}
+
+// TestRowExtra is exported since unexported embedded struct pointers can't be allocated.
+type TestRowExtra struct {
+	Note sql.NullString `db:"note"`
+}
+
+type testRow struct {
+	testRowBase
+	*TestRowExtra
+	Field1   *string `db:"field_1"`
+	Field2   int64   `db:"field_2,omitempty"`
+	Ignored  string  `db:"-"`
+	Untagged string
+}
+
+func TestColumns(t *testing.T) {
+	expected := []string{"id", "note", "field_1", "field_2"}
+	cols, err := Columns(&testRow{})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, cols)
+
+	cols, err = Columns(testRow{})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, cols)
+}
+
+func TestColumns_err(t *testing.T) {
+	cases := []struct {
+		v        interface{}
+		expected error
+	}{
+		{v: nil, expected: ErrNotStructPtr},
+		{v: "some string", expected: ErrNotStructPtr},
+		{v: &struct {
+			A string `db:"a"`
+			B string `db:"a"`
+		}{}, expected: ErrDuplicateColumn},
+		{v: &struct {
+			testRowBase
+			ID2 string `db:"id"`
+		}{}, expected: ErrDuplicateColumn},
+		{v: &struct {
+			*testRowBase
+		}{}, expected: ErrUnexportedColumn},
+		{v: &struct {
+			a string `db:"a"` // nolint: megacheck
+		}{}, expected: ErrUnexportedColumn},
+	}
+	for i, c := range cases {
+		cols, err := Columns(c.v)
+		assert.Equal(t, c.expected, err, "case %d", i)
+		assert.Nil(t, cols, "case %d", i)
+	}
+}
+
+func TestScanDests(t *testing.T) {
+	r := &testRow{}
+	dests, err := ScanDests(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{&r.ID, &r.Note, &r.Field1, &r.Field2}, dests)
+	assert.NotNil(t, r.TestRowExtra) // allocated
+
+	dests, err = ScanDests(testRow{})
+	assert.Equal(t, ErrNotStructPtr, err)
+	assert.Nil(t, dests)
+
+	dests, err = ScanDests((*testRow)(nil))
+	assert.Equal(t, ErrNotStructPtr, err)
+	assert.Nil(t, dests)
+}
+
+func TestStructColDests(t *testing.T) {
+	r := &testRow{}
+	cds, err := StructColDests(r)
+	assert.Nil(t, err)
+	cols, dests := SplitColDests(0, cds)
+	assert.Equal(t, []string{"id", "note", "field_1", "field_2"}, cols)
+	assert.Equal(t, []interface{}{&r.ID, &r.Note, &r.Field1, &r.Field2}, dests)
+}
+
+func TestInsertValues(t *testing.T) {
+	field1 := "value 1"
+	r := &testRow{
+		testRowBase: testRowBase{ID: "id1"},
+		Field1:      &field1,
+		Field2:      2,
+	}
+	cols, values, err := InsertValues(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id", "note", "field_1", "field_2"}, cols)
+	assert.Equal(t, []interface{}{"id1", nil, &field1, int64(2)}, values)
+
+	r.TestRowExtra = &TestRowExtra{Note: sql.NullString{String: "note", Valid: true}}
+	r.Field1 = nil
+	_, values, err = InsertValues(*r)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"id1", r.Note, (*string)(nil), int64(2)}, values)
+
+	stmt, args, err := sq.Insert("test.test").Columns(cols...).Values(values...).ToSql()
+	assert.Nil(t, err)
+	assert.Equal(t, "INSERT INTO test.test (id,note,field_1,field_2) VALUES (?,?,?,?)", stmt)
+	assert.Len(t, args, 4)
+
+	cols, values, err = InsertValues(1)
+	assert.Equal(t, ErrNotStructPtr, err)
+	assert.Nil(t, cols)
+	assert.Nil(t, values)
+}
+
+func TestScanStruct(t *testing.T) {
+	field1 := "value 1"
+	rows := &fixedRows{rows: [][]interface{}{
+		{"id1", sql.NullString{String: "note", Valid: true}, &field1, int64(1)},
+	}}
+	rows.Next()
+	r := &testRow{}
+	err := ScanStruct(rows, r)
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", r.ID)
+	assert.Equal(t, "note", r.Note.String)
+	assert.Equal(t, &field1, r.Field1)
+	assert.Equal(t, int64(1), r.Field2)
+
+	err = ScanStruct(rows, testRow{})
+	assert.Equal(t, ErrNotStructPtr, err)
+}
+
+func TestScanAll(t *testing.T) {
+	field1 := "value 1"
+	newRows := func() *fixedRows {
+		return &fixedRows{rows: [][]interface{}{
+			{"id1", sql.NullString{}, &field1, int64(1)},
+			{"id2", sql.NullString{}, nil, int64(2)},
+		}}
+	}
+
+	// slice of structs
+	rows := newRows()
+	rs := make([]testRow, 0)
+	err := ScanAll(rows, &rs)
+	assert.Nil(t, err)
+	assert.True(t, rows.closed)
+	assert.Len(t, rs, 2)
+	assert.Equal(t, "id1", rs[0].ID)
+	assert.Equal(t, &field1, rs[0].Field1)
+	assert.Equal(t, "id2", rs[1].ID)
+	assert.Nil(t, rs[1].Field1)
+	assert.Equal(t, int64(2), rs[1].Field2)
+
+	// slice of struct pointers
+	rows = newRows()
+	var rPtrs []*testRow
+	err = ScanAll(rows, &rPtrs)
+	assert.Nil(t, err)
+	assert.Len(t, rPtrs, 2)
+	assert.Equal(t, "id2", rPtrs[1].ID)
+
+	// no rows
+	rows = &fixedRows{}
+	rPtrs = nil
+	err = ScanAll(rows, &rPtrs)
+	assert.Nil(t, err)
+	assert.True(t, rows.closed)
+	assert.Empty(t, rPtrs)
+}
+
+func TestScanAll_err(t *testing.T) {
+	var rs []testRow
+	var ints []int
+	for _, dest := range []interface{}{nil, rs, &ints, "some string"} {
+		rows := &fixedRows{}
+		assert.Equal(t, ErrNotSlicePtr, ScanAll(rows, dest))
+	}
+
+	// scan error
+	rows := &fixedRows{rows: [][]interface{}{{"id1"}}}
+	err := ScanAll(rows, &rs)
+	assert.NotNil(t, err)
+	assert.True(t, rows.closed)
+	assert.Nil(t, rs)
+
+	// rows error
+	rows = &fixedRows{err: errors.New("some rows error")}
+	err = ScanAll(rows, &rs)
+	assert.Equal(t, rows.err, err)
+	assert.True(t, rows.closed)
+}
+
+// fixedRows are QueryRows that scan fixed values.
+type fixedRows struct {
+	rows   [][]interface{}
+	cur    int
+	err    error
+	closed bool
+}
+
+func (r *fixedRows) Scan(dest ...interface{}) error {
+	row := r.rows[r.cur-1]
+	if len(row) != len(dest) {
+		return errors.New("mismatched number of scan destinations")
+	}
+	for i, v := range row {
+		dv := reflect.ValueOf(dest[i]).Elem()
+		if v == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			continue
+		}
+		dv.Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (r *fixedRows) Next() bool {
+	if r.cur >= len(r.rows) {
+		return false
+	}
+	r.cur++
+	return true
+}
+
+func (r *fixedRows) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *fixedRows) Err() error {
+	return r.err
+}
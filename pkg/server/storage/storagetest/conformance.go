@@ -0,0 +1,451 @@
+// Package storagetest contains a conformance test suite defining the semantics of a
+// storage.Storer.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// fields of the records used in the conformance tests
+const (
+	// NameField is a string field.
+	NameField = "name"
+
+	// AgeField is an int64 field.
+	AgeField = "age"
+
+	// ScoreField is a float64 field.
+	ScoreField = "score"
+
+	// ActiveField is a bool field.
+	ActiveField = "active"
+
+	// CreatedField is a time.Time field.
+	CreatedField = "created"
+
+	// DataField is a []byte field.
+	DataField = "data"
+)
+
+// postgresKeyCol is the key column of the Postgres conformance table.
+const postgresKeyCol = "id"
+
+// CreatePostgresTableFmt is the format (with the table name as its sole argument) of the statement
+// creating a Postgres table with the columns for the conformance test record fields. Key and
+// string columns use the "C" collation so they are ordered bytewise like the other backends.
+const CreatePostgresTableFmt = `CREATE TABLE %s (
+  id VARCHAR COLLATE "C" PRIMARY KEY,
+  name VARCHAR COLLATE "C",
+  age BIGINT,
+  score DOUBLE PRECISION,
+  active BOOLEAN,
+  created TIMESTAMP WITH TIME ZONE,
+  data BYTEA
+)`
+
+// NewPostgresTable returns the *storage.PostgresTable for a table with the given name created via
+// CreatePostgresTableFmt.
+func NewPostgresTable(name string) *storage.PostgresTable {
+	return &storage.PostgresTable{
+		Name:      name,
+		KeyCol:    postgresKeyCol,
+		FieldCols: []string{NameField, AgeField, ScoreField, ActiveField, CreatedField, DataField},
+	}
+}
+
+// Factory creates a new, empty storage.Storer for a conformance test along with a function to tear
+// it down at the end of the test.
+type Factory func(t *testing.T) (s storage.Storer, tearDown func())
+
+// RunConformance runs the conformance test suite against the Storers created by the given factory.
+// Each test gets a new Storer. Backends must store records with the fields (and value types)
+// defined above.
+func RunConformance(t *testing.T, newStorer Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storer)
+	}{
+		{"PutGet", testPutGet},
+		{"PutOverwrite", testPutOverwrite},
+		{"PutErr", testPutErr},
+		{"GetMulti", testGetMulti},
+		{"Delete", testDelete},
+		{"ConcurrentWrites", testConcurrentWrites},
+		{"QueryFilters", testQueryFilters},
+		{"QueryOrder", testQueryOrder},
+		{"QueryPaging", testQueryPaging},
+		{"QueryErr", testQueryErr},
+	}
+	for _, tt := range tests {
+		test := tt.test
+		t.Run(tt.name, func(t *testing.T) {
+			s, tearDown := newStorer(t)
+			defer tearDown()
+			test(t, s)
+		})
+	}
+}
+
+var created = time.Date(2018, 3, 14, 15, 9, 26, 535000, time.UTC)
+
+// people are the records used in the query tests, in key order.
+var people = []*storage.Record{
+	{Key: "p01", Fields: map[string]interface{}{NameField: "alice", AgeField: 30}},
+	{Key: "p02", Fields: map[string]interface{}{NameField: "bob", AgeField: 25}},
+	{Key: "p03", Fields: map[string]interface{}{NameField: "carol", AgeField: 35}},
+	{Key: "p04", Fields: map[string]interface{}{NameField: "dave", AgeField: 30}},
+	{Key: "p05", Fields: map[string]interface{}{NameField: "eve", AgeField: 40}},
+	{Key: "p06", Fields: map[string]interface{}{NameField: "alice", AgeField: 22}},
+	{Key: "p07", Fields: map[string]interface{}{NameField: "frank"}},
+}
+
+func testPutGet(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	r := &storage.Record{
+		Key: "key1",
+		Fields: map[string]interface{}{
+			NameField:    "alice",
+			AgeField:     int64(30),
+			ScoreField:   0.75,
+			ActiveField:  true,
+			CreatedField: created,
+			DataField:    []byte{0, 1, 2, 255},
+		},
+	}
+	err := s.Put(ctx, r)
+	assert.Nil(t, err)
+
+	got, err := s.Get(ctx, r.Key)
+	assert.Nil(t, err)
+	assert.Equal(t, r, got)
+
+	// other numeric types are stored as their canonical types
+	err = s.Put(ctx, &storage.Record{
+		Key:    "key2",
+		Fields: map[string]interface{}{AgeField: 30, ScoreField: float32(0.5)},
+	})
+	assert.Nil(t, err)
+	got, err = s.Get(ctx, "key2")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), got.Fields[AgeField])
+	assert.Equal(t, 0.5, got.Fields[ScoreField])
+
+	got, err = s.Get(ctx, "missing")
+	assert.Equal(t, storage.ErrNotFound, err)
+	assert.Nil(t, got)
+}
+
+func testPutOverwrite(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.Put(ctx, &storage.Record{
+		Key:    "key1",
+		Fields: map[string]interface{}{NameField: "alice", AgeField: 30},
+	})
+	assert.Nil(t, err)
+	r := &storage.Record{
+		Key:    "key1",
+		Fields: map[string]interface{}{NameField: "bob"},
+	}
+	err = s.Put(ctx, r)
+	assert.Nil(t, err)
+
+	// overwritten record replaces all fields
+	got, err := s.Get(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, r, got)
+}
+
+func testPutErr(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	err := s.Put(ctx, &storage.Record{Fields: map[string]interface{}{NameField: "alice"}})
+	assert.Equal(t, storage.ErrEmptyKey, err)
+
+	err = s.Put(ctx, &storage.Record{
+		Key:    "key1",
+		Fields: map[string]interface{}{NameField: struct{}{}},
+	})
+	assert.Equal(t, storage.ErrInvalidFieldValue, err)
+}
+
+func testGetMulti(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	putAll(t, s, people)
+
+	got, err := s.GetMulti(ctx, []string{"p03", "missing", "p01"})
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Record{normalized(people[2]), nil, normalized(people[0])}, got)
+
+	got, err = s.GetMulti(ctx, []string{})
+	assert.Nil(t, err)
+	assert.Len(t, got, 0)
+}
+
+func testDelete(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	putAll(t, s, people)
+
+	err := s.Delete(ctx, "p01", "p02", "missing")
+	assert.Nil(t, err)
+	got, err := s.GetMulti(ctx, []string{"p01", "p02", "p03"})
+	assert.Nil(t, err)
+	assert.Equal(t, []*storage.Record{nil, nil, normalized(people[2])}, got)
+
+	// no keys is a no-op
+	err = s.Delete(ctx)
+	assert.Nil(t, err)
+}
+
+func testConcurrentWrites(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	nWorkers, nWrites := 32, 25
+
+	// concurrent Puts and Deletes of the same key all succeed, with the last writer winning
+	wg := new(sync.WaitGroup)
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < nWrites; j++ {
+				var err error
+				if (i+j)%3 == 0 {
+					err = s.Delete(ctx, "key1")
+				} else {
+					err = s.Put(ctx, &storage.Record{
+						Key:    "key1",
+						Fields: map[string]interface{}{AgeField: int64(i*nWrites + j)},
+					})
+				}
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := s.Get(ctx, "key1")
+	if err != storage.ErrNotFound {
+		assert.Nil(t, err)
+		assert.Contains(t, got.Fields, AgeField)
+	}
+	r := &storage.Record{Key: "key1", Fields: map[string]interface{}{NameField: "alice"}}
+	assert.Nil(t, s.Put(ctx, r))
+	got, err = s.Get(ctx, "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, r, got)
+}
+
+func testQueryFilters(t *testing.T, s storage.Storer) {
+	putAll(t, s, people)
+	cases := map[string]struct {
+		filters  []*storage.Filter
+		expected []string
+	}{
+		"none": {
+			expected: []string{"p01", "p02", "p03", "p04", "p05", "p06", "p07"},
+		},
+		"equal string": {
+			filters:  []*storage.Filter{{Field: NameField, Op: storage.Equal, Value: "alice"}},
+			expected: []string{"p01", "p06"},
+		},
+		"equal int": {
+			filters:  []*storage.Filter{{Field: AgeField, Op: storage.Equal, Value: 30}},
+			expected: []string{"p01", "p04"},
+		},
+		"less than": {
+			filters:  []*storage.Filter{{Field: AgeField, Op: storage.LessThan, Value: 30}},
+			expected: []string{"p06", "p02"},
+		},
+		"less than or equal": {
+			filters: []*storage.Filter{
+				{Field: AgeField, Op: storage.LessThanOrEqual, Value: 30},
+			},
+			expected: []string{"p06", "p02", "p01", "p04"},
+		},
+		"greater than": {
+			filters:  []*storage.Filter{{Field: AgeField, Op: storage.GreaterThan, Value: 30}},
+			expected: []string{"p03", "p05"},
+		},
+		"greater than or equal": {
+			filters: []*storage.Filter{
+				{Field: AgeField, Op: storage.GreaterThanOrEqual, Value: 30},
+			},
+			expected: []string{"p01", "p04", "p03", "p05"},
+		},
+		"range": {
+			filters: []*storage.Filter{
+				{Field: AgeField, Op: storage.GreaterThan, Value: 22},
+				{Field: AgeField, Op: storage.LessThan, Value: 40},
+			},
+			expected: []string{"p02", "p01", "p04", "p03"},
+		},
+		"equal and range": {
+			filters: []*storage.Filter{
+				{Field: NameField, Op: storage.Equal, Value: "alice"},
+				{Field: AgeField, Op: storage.GreaterThan, Value: 25},
+			},
+			expected: []string{"p01"},
+		},
+		"no matches": {
+			filters:  []*storage.Filter{{Field: NameField, Op: storage.Equal, Value: "zed"}},
+			expected: []string{},
+		},
+	}
+	for desc, c := range cases {
+		got := queryAll(t, s, &storage.Query{Filters: c.filters}, desc)
+		assert.Equal(t, c.expected, got, desc)
+	}
+}
+
+func testQueryOrder(t *testing.T, s storage.Storer) {
+	putAll(t, s, people)
+	cases := map[string]struct {
+		order    *storage.Order
+		expected []string
+	}{
+		"key": {
+			expected: []string{"p01", "p02", "p03", "p04", "p05", "p06", "p07"},
+		},
+		"ascending": {
+			order:    &storage.Order{Field: AgeField},
+			expected: []string{"p06", "p02", "p01", "p04", "p03", "p05"},
+		},
+		"descending": {
+			order:    &storage.Order{Field: AgeField, Descending: true},
+			expected: []string{"p05", "p03", "p04", "p01", "p02", "p06"},
+		},
+		"string": {
+			order:    &storage.Order{Field: NameField},
+			expected: []string{"p01", "p06", "p02", "p03", "p04", "p05", "p07"},
+		},
+	}
+	for desc, c := range cases {
+		got := queryAll(t, s, &storage.Query{Order: c.order}, desc)
+		assert.Equal(t, c.expected, got, desc)
+	}
+}
+
+func testQueryPaging(t *testing.T, s storage.Storer) {
+	putAll(t, s, people)
+	queries := map[string]*storage.Query{
+		"key":        {},
+		"ascending":  {Order: &storage.Order{Field: AgeField}},
+		"descending": {Order: &storage.Order{Field: AgeField, Descending: true}},
+		"filtered": {
+			Filters: []*storage.Filter{
+				{Field: AgeField, Op: storage.GreaterThanOrEqual, Value: 25},
+			},
+		},
+	}
+	for desc, q := range queries {
+		expected := queryAll(t, s, q, desc)
+		for _, limit := range []uint{1, 2, 3, uint(len(expected)), uint(len(expected)) + 1} {
+			pageQ := *q
+			pageQ.Limit = limit
+			got := make([]string, 0)
+			for i := 0; i <= len(expected); i++ {
+				result, err := s.Query(context.Background(), &pageQ)
+				assert.Nil(t, err, desc)
+				if err != nil {
+					return
+				}
+				assert.True(t, uint(len(result.Records)) <= limit, desc)
+				got = append(got, keys(result.Records)...)
+				if result.NextPageToken == "" {
+					break
+				}
+				pageQ.PageToken = result.NextPageToken
+			}
+			assert.Equal(t, expected, got, fmt.Sprintf("%s (limit %d)", desc, limit))
+		}
+	}
+}
+
+func testQueryErr(t *testing.T, s storage.Storer) {
+	ctx := context.Background()
+	putAll(t, s, people)
+	qs := map[string]*storage.Query{
+		"inequalities on multiple fields": {
+			Filters: []*storage.Filter{
+				{Field: AgeField, Op: storage.GreaterThan, Value: 25},
+				{Field: NameField, Op: storage.LessThan, Value: "bob"},
+			},
+		},
+		"inequality not on order field": {
+			Filters: []*storage.Filter{{Field: AgeField, Op: storage.GreaterThan, Value: 25}},
+			Order:   &storage.Order{Field: NameField},
+		},
+	}
+	for desc, q := range qs {
+		result, err := s.Query(ctx, q)
+		assert.Equal(t, storage.ErrInvalidQuery, err, desc)
+		assert.Nil(t, result, desc)
+	}
+
+	result, err := s.Query(ctx, &storage.Query{PageToken: "not a page token!"})
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, result)
+
+	// page tokens are only valid for the query they were issued for
+	result, err = s.Query(ctx, &storage.Query{Limit: 1})
+	assert.Nil(t, err)
+	if err != nil {
+		return
+	}
+	token := result.NextPageToken
+	q := &storage.Query{Order: &storage.Order{Field: AgeField}, Limit: 1, PageToken: token}
+	result, err = s.Query(ctx, q)
+	assert.Equal(t, storage.ErrPageTokenMismatch, err)
+	assert.Nil(t, result)
+
+	// and can't be altered
+	q = &storage.Query{Limit: 1, PageToken: token[:len(token)-2] + "AA"}
+	if q.PageToken == token {
+		q.PageToken = token[:len(token)-2] + "BB"
+	}
+	result, err = s.Query(ctx, q)
+	assert.Equal(t, storage.ErrInvalidPageToken, err)
+	assert.Nil(t, result)
+}
+
+func putAll(t *testing.T, s storage.Storer, rs []*storage.Record) {
+	for _, r := range rs {
+		err := s.Put(context.Background(), r)
+		assert.Nil(t, err)
+	}
+}
+
+func queryAll(t *testing.T, s storage.Storer, q *storage.Query, desc string) []string {
+	result, err := s.Query(context.Background(), q)
+	assert.Nil(t, err, desc)
+	if err != nil {
+		return nil
+	}
+	assert.Empty(t, result.NextPageToken, desc)
+	return keys(result.Records)
+}
+
+func keys(rs []*storage.Record) []string {
+	ks := make([]string, len(rs))
+	for i, r := range rs {
+		ks[i] = r.Key
+	}
+	return ks
+}
+
+// normalized returns a copy of the record with its int field values converted to int64s.
+func normalized(r *storage.Record) *storage.Record {
+	fields := make(map[string]interface{}, len(r.Fields))
+	for name, value := range r.Fields {
+		if v, ok := value.(int); ok {
+			value = int64(v)
+		}
+		fields[name] = value
+	}
+	return &storage.Record{Key: r.Key, Fields: fields}
+}
@@ -0,0 +1,101 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/elixirhealth/service-base/pkg/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	conformanceTable = "public.conformance"
+	conformanceKind  = "conformance"
+)
+
+var dbURL string
+
+func TestMain(m *testing.M) {
+	var cleanup func() error
+	var err error
+	dbURL, cleanup, err = storage.StartTestPostgres()
+	if err != nil {
+		if err2 := cleanup(); err2 != nil {
+			log.Fatal("test postgres cleanup error: " + err2.Error())
+		}
+		log.Fatal("test postgres start error: " + err.Error())
+	}
+
+	code := m.Run()
+
+	// You can't defer this because os.Exit doesn't care for defer
+	if err := cleanup(); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	os.Exit(code)
+}
+
+func TestRunConformance_memory(t *testing.T) {
+	RunConformance(t, func(t *testing.T) (storage.Storer, func()) {
+		s, err := storage.NewMemoryStorer(storage.NewMemoryDB(), conformanceKind,
+			newTestPaginator(t))
+		assert.Nil(t, err)
+		return s, func() {}
+	})
+}
+
+func TestRunConformance_postgres(t *testing.T) {
+	db, err := sql.Open("postgres", dbURL)
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, db.Close()) }()
+
+	RunConformance(t, func(t *testing.T) (storage.Storer, func()) {
+		_, err := db.Exec(fmt.Sprintf(CreatePostgresTableFmt, conformanceTable))
+		assert.Nil(t, err)
+		tearDown := func() {
+			_, err := db.Exec("DROP TABLE " + conformanceTable)
+			assert.Nil(t, err)
+		}
+		s := storage.NewPostgresStorer(db, storage.NewQuerier(),
+			NewPostgresTable(conformanceTable), newTestPaginator(t))
+		return s, tearDown
+	})
+}
+
+func TestRunConformance_datastoreFake(t *testing.T) {
+	RunConformance(t, func(t *testing.T) (storage.Storer, func()) {
+		client := storage.NewFakeDatastoreClient()
+		return storage.NewDatastoreStorer(client, conformanceKind, newTestPaginator(t)),
+			func() {}
+	})
+}
+
+func TestRunConformance_datastore(t *testing.T) {
+	emulator := storage.StartTestDatastoreEmulator(t)
+	client, err := emulator.NewClient(context.Background())
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, client.Close()) }()
+	dsClient := &storage.DatastoreClientImpl{Inner: client}
+
+	RunConformance(t, func(t *testing.T) (storage.Storer, func()) {
+		s := storage.NewDatastoreStorer(dsClient, conformanceKind, newTestPaginator(t))
+		tearDown := func() {
+			assert.Nil(t, emulator.Reset())
+		}
+		return s, tearDown
+	})
+}
+
+func newTestPaginator(t *testing.T) *storage.Paginator {
+	params := storage.NewDefaultPaginationParameters()
+	params.Key = bytes.Repeat([]byte{1}, storage.MinPageTokenKeyLen)
+	p, err := storage.NewPaginator(params)
+	assert.Nil(t, err)
+	return p
+}
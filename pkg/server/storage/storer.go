@@ -1,5 +1,32 @@
 package storage
 
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidType indicates when a storage type is not one of the known types.
+	ErrInvalidType = errors.New("invalid storage type")
+
+	// ErrEmptyKey indicates when a record has an empty key.
+	ErrEmptyKey = errors.New("record key is empty")
+
+	// ErrInvalidFieldValue indicates when a record field or filter value has an unsupported type.
+	ErrInvalidFieldValue = errors.New("invalid field value type")
+
+	// ErrInvalidQuery indicates when a query has an invalid combination of filters and order.
+	ErrInvalidQuery = errors.New("invalid query")
+
+	// ErrInvalidPageToken indicates when a query page token is malformed.
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
 // Type indicates the storage backend type.
 type Type int
 
@@ -9,7 +36,8 @@ const (
 	Unspecified Type = iota
 
 	// Memory indicates an ephemeral, in-memory (and thus not highly available) storage. This
-	// storage layer should generally only be used during testing and not in production.
+	// storage layer should generally only be used during testing and not in production. It is
+	// backed by a MemoryDB.
 	Memory
 
 	// DataStore indicates a (highly available) storage backed by GCP DataStore.
@@ -32,3 +60,378 @@ func (t Type) String() string {
 		return "Unspecified"
 	}
 }
+
+// ParseType returns the Type with the given (case-insensitive) string representation. The empty
+// string is parsed as Unspecified.
+func ParseType(s string) (Type, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "unspecified":
+		return Unspecified, nil
+	case "memory":
+		return Memory, nil
+	case "datastore":
+		return DataStore, nil
+	case "postgres":
+		return Postgres, nil
+	default:
+		return Unspecified, ErrInvalidType
+	}
+}
+
+// MarshalText returns the string representation of the type, which is also used when marshaling
+// the type to JSON.
+func (t Type) MarshalText() ([]byte, error) {
+	if t < Unspecified || t > Postgres {
+		return nil, ErrInvalidType
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText sets the type from its string representation, which is also used when
+// unmarshaling the type from JSON.
+func (t *Type) UnmarshalText(text []byte) error {
+	parsed, err := ParseType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Record is an entity stored by a Storer, comprising a unique key and named field values. Field
+// values must be strings, int64s, float64s, bools, []bytes, or time.Times. Other integer and float
+// types are converted to int64s and float64s, respectively.
+type Record struct {
+	Key    string
+	Fields map[string]interface{}
+}
+
+// Operator is a comparison operator used in a query Filter.
+type Operator int
+
+const (
+	// Equal matches field values equal to the filter value.
+	Equal Operator = iota
+
+	// LessThan matches field values less than the filter value.
+	LessThan
+
+	// LessThanOrEqual matches field values less than or equal to the filter value.
+	LessThanOrEqual
+
+	// GreaterThan matches field values greater than the filter value.
+	GreaterThan
+
+	// GreaterThanOrEqual matches field values greater than or equal to the filter value.
+	GreaterThanOrEqual
+)
+
+// String returns the comparison symbol of the operator.
+func (o Operator) String() string {
+	switch o {
+	case Equal:
+		return "="
+	case LessThan:
+		return "<"
+	case LessThanOrEqual:
+		return "<="
+	case GreaterThan:
+		return ">"
+	case GreaterThanOrEqual:
+		return ">="
+	default:
+		return "?"
+	}
+}
+
+// Filter restricts query results to records whose field value satisfies the comparison with the
+// filter value. Records without the field never match.
+type Filter struct {
+	Field string
+	Op    Operator
+	Value interface{}
+}
+
+// Order defines the ordering of query results by a field. Records without the field are excluded
+// from the results. Records with equal field values are ordered by key in the same direction.
+type Order struct {
+	Field      string
+	Descending bool
+}
+
+// Query defines the records to return from a Storer. As with DataStore, inequality filters may only
+// be on a single field, which must also be the Order field if one is given.
+type Query struct {
+	// Filters are the (AND-ed) filters each result must match.
+	Filters []*Filter
+
+	// Order is the result order. If nil, results are ordered by the inequality filter field if
+	// there is one and by key otherwise.
+	Order *Order
+
+	// Limit is the maximum number of results to return. If zero, all results are returned.
+	Limit uint
+
+	// PageToken is the NextPageToken from a previous QueryResult for the same query (i.e., with
+	// the same filters and order).
+	PageToken string
+}
+
+// QueryResult contains a page of results from a Query.
+type QueryResult struct {
+	Records []*Record
+
+	// NextPageToken is the token for the next page of results, or empty if there are none. It
+	// is signed by the Storer's Paginator.
+	NextPageToken string
+}
+
+// Storer is a generic store of records. Its semantics are defined by the storagetest conformance
+// suite, which every implementation should pass.
+type Storer interface {
+	// Put creates or replaces the record with the given record's key.
+	Put(ctx context.Context, r *Record) error
+
+	// Get returns the record with the given key or ErrNotFound if it doesn't exist.
+	Get(ctx context.Context, key string) (*Record, error)
+
+	// GetMulti returns the records with the given keys, with a nil record for each key that
+	// doesn't exist.
+	GetMulti(ctx context.Context, keys []string) ([]*Record, error)
+
+	// Delete removes the records with the given keys. Deleting a missing key is a no-op.
+	Delete(ctx context.Context, keys ...string) error
+
+	// Query returns a page of the records matching the given query. It returns
+	// ErrInvalidPageToken, ErrExpiredPageToken, or ErrPageTokenMismatch if the query's page token
+	// is malformed or tampered with, expired, or issued for a different query, respectively.
+	Query(ctx context.Context, q *Query) (*QueryResult, error)
+}
+
+// normalizeRecord validates the record key and converts its field values to their canonical types.
+func normalizeRecord(r *Record) (*Record, error) {
+	if r.Key == "" {
+		return nil, ErrEmptyKey
+	}
+	fields := make(map[string]interface{}, len(r.Fields))
+	for name, value := range r.Fields {
+		if value == nil {
+			continue
+		}
+		normalized, err := normalizeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = normalized
+	}
+	return &Record{Key: r.Key, Fields: fields}, nil
+}
+
+// normalizeValue converts the given field value to its canonical type, returning
+// ErrInvalidFieldValue if it has no such type.
+func normalizeValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, int64, float64, bool:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case []byte:
+		return copyBytes(v), nil
+	case time.Time:
+		return v.UTC(), nil
+	}
+	return nil, ErrInvalidFieldValue
+}
+
+// normalizeQuery validates the given query and normalizes its filter values.
+func normalizeQuery(q *Query) (*Query, error) {
+	normalized := *q
+	normalized.Filters = make([]*Filter, len(q.Filters))
+	inequalityField := ""
+	for i, f := range q.Filters {
+		if f.Op < Equal || f.Op > GreaterThanOrEqual {
+			return nil, ErrInvalidQuery
+		}
+		if f.Op != Equal {
+			if inequalityField != "" && inequalityField != f.Field {
+				return nil, ErrInvalidQuery
+			}
+			if q.Order != nil && q.Order.Field != f.Field {
+				return nil, ErrInvalidQuery
+			}
+			inequalityField = f.Field
+		}
+		value, err := normalizeValue(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		normalized.Filters[i] = &Filter{Field: f.Field, Op: f.Op, Value: value}
+	}
+	if normalized.Order == nil && inequalityField != "" {
+		normalized.Order = &Order{Field: inequalityField}
+	}
+	return &normalized, nil
+}
+
+// compareValues returns -1, 0, or 1 when the first value is less than, equal to, or greater than
+// the second, and false if the values are not comparable.
+func compareValues(value1, value2 interface{}) (int, bool) {
+	switch v1 := value1.(type) {
+	case string:
+		if v2, ok := value2.(string); ok {
+			return strings.Compare(v1, v2), true
+		}
+	case int64:
+		if v2, ok := value2.(int64); ok {
+			return compareFloats(float64(v1), float64(v2)), true
+		}
+	case float64:
+		if v2, ok := value2.(float64); ok {
+			return compareFloats(v1, v2), true
+		}
+	case bool:
+		if v2, ok := value2.(bool); ok {
+			switch {
+			case v1 == v2:
+				return 0, true
+			case v2:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case []byte:
+		if v2, ok := value2.([]byte); ok {
+			return bytes.Compare(v1, v2), true
+		}
+	case time.Time:
+		if v2, ok := value2.(time.Time); ok {
+			switch {
+			case v1.Before(v2):
+				return -1, true
+			case v1.After(v2):
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func compareFloats(v1, v2 float64) int {
+	switch {
+	case v1 < v2:
+		return -1
+	case v1 > v2:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// matches returns whether the given field value satisfies the filter.
+func (f *Filter) matches(value interface{}) bool {
+	cmp, ok := compareValues(value, f.Value)
+	if !ok {
+		return false
+	}
+	switch f.Op {
+	case Equal:
+		return cmp == 0
+	case LessThan:
+		return cmp < 0
+	case LessThanOrEqual:
+		return cmp <= 0
+	case GreaterThan:
+		return cmp > 0
+	case GreaterThanOrEqual:
+		return cmp >= 0
+	}
+	return false
+}
+
+// pageCursor is the position after the last result of a page, encoded in its NextPageToken.
+type pageCursor struct {
+	Key   string
+	Value interface{}
+}
+
+// newPagedResult returns the QueryResult for the given (sorted) records, which include at most one
+// more than the query limit to indicate that there is a next page.
+func newPagedResult(rs []*Record, q *Query, p *Paginator, scope []byte) (*QueryResult, error) {
+	result := &QueryResult{Records: rs}
+	if q.Limit == 0 || uint(len(rs)) <= q.Limit {
+		return result, nil
+	}
+	result.Records = rs[:q.Limit]
+	last := result.Records[q.Limit-1]
+	next := &pageCursor{Key: last.Key}
+	if q.Order != nil {
+		next.Value = last.Fields[q.Order.Field]
+	}
+	var err error
+	if result.NextPageToken, err = p.encodePageCursor(scope, next); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// storerPageScope returns a digest identifying the query (on the given table or kind) a Storer's
+// page tokens are issued for.
+func storerPageScope(source string, q *Query) []byte {
+	parts := []string{"storer", source}
+	for _, f := range q.Filters {
+		parts = append(parts, fmt.Sprintf("%s %s %#v", f.Field, f.Op, f.Value))
+	}
+	if q.Order != nil {
+		parts = append(parts, fmt.Sprintf("order %s %t", q.Order.Field, q.Order.Descending))
+	}
+	return pageScope(parts...)
+}
+
+func (p *Paginator) encodePageCursor(scope []byte, c *pageCursor) (string, error) {
+	values := []interface{}{c.Key}
+	if c.Value != nil {
+		values = append(values, c.Value)
+	}
+	return p.encode(&pageToken{Scope: scope, Values: values})
+}
+
+func (p *Paginator) decodePageCursor(token string, scope []byte) (*pageCursor, error) {
+	pt, err := p.decode(token, scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(pt.Values) == 0 || len(pt.Values) > 2 {
+		return nil, ErrInvalidPageToken
+	}
+	c := &pageCursor{}
+	var ok bool
+	if c.Key, ok = pt.Values[0].(string); !ok {
+		return nil, ErrInvalidPageToken
+	}
+	if len(pt.Values) == 2 {
+		c.Value = pt.Values[1]
+	}
+	return c, nil
+}
+
+func init() {
+	// allow time.Time field values in gob-encoded interface{} values
+	gob.Register(time.Time{})
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,3 +14,164 @@ func TestType_String(t *testing.T) {
 		assert.NotEmpty(t, tp.String())
 	}
 }
+
+func TestOperator_String(t *testing.T) {
+	ops := []Operator{Equal, LessThan, LessThanOrEqual, GreaterThan, GreaterThanOrEqual}
+	for _, op := range ops {
+		assert.NotEqual(t, "?", op.String())
+	}
+	assert.Equal(t, "?", Operator(-1).String())
+}
+
+func TestNormalizeValue(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{value: "a", expected: "a"},
+		{value: 1, expected: int64(1)},
+		{value: int32(1), expected: int64(1)},
+		{value: uint16(1), expected: int64(1)},
+		{value: float32(0.5), expected: 0.5},
+		{value: true, expected: true},
+		{value: []byte{1}, expected: []byte{1}},
+		{value: now, expected: now.UTC()},
+	}
+	for _, c := range cases {
+		value, err := normalizeValue(c.value)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, value)
+	}
+
+	for _, value := range []interface{}{uint64(1), struct{}{}, []string{"a"}} {
+		_, err := normalizeValue(value)
+		assert.Equal(t, ErrInvalidFieldValue, err)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		value1, value2 interface{}
+		expected       int
+	}{
+		{value1: "a", value2: "b", expected: -1},
+		{value1: int64(2), value2: int64(1), expected: 1},
+		{value1: 1.0, value2: 1.0, expected: 0},
+		{value1: false, value2: true, expected: -1},
+		{value1: []byte{2}, value2: []byte{1, 2}, expected: 1},
+		{value1: now, value2: now.Add(time.Second), expected: -1},
+	}
+	for _, c := range cases {
+		cmp, ok := compareValues(c.value1, c.value2)
+		assert.True(t, ok)
+		assert.Equal(t, c.expected, cmp)
+	}
+
+	_, ok := compareValues("1", int64(1))
+	assert.False(t, ok)
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	q, err := normalizeQuery(&Query{
+		Filters: []*Filter{{Field: "age", Op: GreaterThan, Value: 1}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), q.Filters[0].Value)
+	assert.Equal(t, &Order{Field: "age"}, q.Order)
+
+	_, err = normalizeQuery(&Query{Filters: []*Filter{{Field: "age", Op: Operator(10)}}})
+	assert.Equal(t, ErrInvalidQuery, err)
+}
+
+func TestPaginator_pageCursor(t *testing.T) {
+	p := newTestPaginator(t)
+	q := &Query{Order: &Order{Field: "age"}}
+	scope := storerPageScope("memory:table", q)
+	cursors := []*pageCursor{
+		{Key: "a"},
+		{Key: "a", Value: "b"},
+		{Key: "a", Value: int64(1)},
+		{Key: "a", Value: time.Date(2018, 1, 2, 3, 4, 5, 6, time.UTC)},
+	}
+	for _, c := range cursors {
+		token, err := p.encodePageCursor(scope, c)
+		assert.Nil(t, err)
+		decoded, err := p.decodePageCursor(token, scope)
+		assert.Nil(t, err)
+		assert.Equal(t, c, decoded)
+	}
+
+	token, err := p.encodePageCursor(scope, cursors[0])
+	assert.Nil(t, err)
+	_, err = p.decodePageCursor(token, storerPageScope("memory:table", &Query{}))
+	assert.Equal(t, ErrPageTokenMismatch, err)
+	_, err = p.decodePageCursor("not a page token!", scope)
+	assert.Equal(t, ErrInvalidPageToken, err)
+	_, err = p.decodePageCursor("AAAA", scope)
+	assert.Equal(t, ErrInvalidPageToken, err)
+
+	// signed tokens without a string key are invalid
+	token, err = p.encode(&pageToken{Scope: scope, Values: []interface{}{int64(1)}})
+	assert.Nil(t, err)
+	_, err = p.decodePageCursor(token, scope)
+	assert.Equal(t, ErrInvalidPageToken, err)
+}
+
+func TestParseType(t *testing.T) {
+	cases := map[string]Type{
+		"":            Unspecified,
+		"Unspecified": Unspecified,
+		"Memory":      Memory,
+		"memory":      Memory,
+		"DataStore":   DataStore,
+		"datastore":   DataStore,
+		" Postgres ":  Postgres,
+		"POSTGRES":    Postgres,
+	}
+	for s, expected := range cases {
+		tp, err := ParseType(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, tp, s)
+	}
+
+	tp, err := ParseType("mysql")
+	assert.Equal(t, ErrInvalidType, err)
+	assert.Equal(t, Unspecified, tp)
+}
+
+func TestType_MarshalText(t *testing.T) {
+	for _, tp := range []Type{Unspecified, Memory, DataStore, Postgres} {
+		text, err := tp.MarshalText()
+		assert.Nil(t, err)
+		var unmarshaled Type
+		err = unmarshaled.UnmarshalText(text)
+		assert.Nil(t, err)
+		assert.Equal(t, tp, unmarshaled)
+	}
+
+	_, err := Type(-1).MarshalText()
+	assert.Equal(t, ErrInvalidType, err)
+	tp := Memory
+	err = tp.UnmarshalText([]byte("mysql"))
+	assert.Equal(t, ErrInvalidType, err)
+	assert.Equal(t, Memory, tp)
+}
+
+func TestType_json(t *testing.T) {
+	params := struct {
+		Type Type `json:"type"`
+	}{Type: Postgres}
+	buf, err := json.Marshal(params)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type":"Postgres"}`, string(buf))
+
+	params.Type = Unspecified
+	err = json.Unmarshal([]byte(`{"type":"datastore"}`), &params)
+	assert.Nil(t, err)
+	assert.Equal(t, DataStore, params.Type)
+
+	err = json.Unmarshal([]byte(`{"type":"mysql"}`), &params)
+	assert.Equal(t, ErrInvalidType, err)
+}
@@ -1,14 +1,17 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
-// source: pkg/base/server/test/pingpong.proto
+// source: pkg/server/test/pingpong.proto
 
 /*
 Package test is a generated protocol buffer package.
 
 It is generated from these files:
-	pkg/base/server/test/pingpong.proto
+
+	pkg/server/test/pingpong.proto
 
 It has these top-level messages:
+
 	PingRequest
+	PingsRequest
 	PingResponse
 */
 package test
@@ -16,6 +19,7 @@ package test
 import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
+import _ "google.golang.org/genproto/googleapis/api/annotations"
 
 import (
 	context "golang.org/x/net/context"
@@ -49,6 +53,22 @@ func (m *PingRequest) GetPing() bool {
 	return false
 }
 
+type PingsRequest struct {
+	Count uint32 `protobuf:"varint,1,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *PingsRequest) Reset()                    { *m = PingsRequest{} }
+func (m *PingsRequest) String() string            { return proto.CompactTextString(m) }
+func (*PingsRequest) ProtoMessage()               {}
+func (*PingsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *PingsRequest) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
 type PingResponse struct {
 	Pong bool `protobuf:"varint,1,opt,name=pong" json:"pong,omitempty"`
 }
@@ -56,7 +76,7 @@ type PingResponse struct {
 func (m *PingResponse) Reset()                    { *m = PingResponse{} }
 func (m *PingResponse) String() string            { return proto.CompactTextString(m) }
 func (*PingResponse) ProtoMessage()               {}
-func (*PingResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*PingResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
 func (m *PingResponse) GetPong() bool {
 	if m != nil {
@@ -67,6 +87,7 @@ func (m *PingResponse) GetPong() bool {
 
 func init() {
 	proto.RegisterType((*PingRequest)(nil), "test.PingRequest")
+	proto.RegisterType((*PingsRequest)(nil), "test.PingsRequest")
 	proto.RegisterType((*PingResponse)(nil), "test.PingResponse")
 }
 
@@ -82,6 +103,8 @@ const _ = grpc.SupportPackageIsVersion4
 
 type PingPongClient interface {
 	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
+	// Pings streams back the requested number of responses.
+	Pings(ctx context.Context, in *PingsRequest, opts ...grpc.CallOption) (PingPong_PingsClient, error)
 }
 
 type pingPongClient struct {
@@ -101,10 +124,44 @@ func (c *pingPongClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc
 	return out, nil
 }
 
+func (c *pingPongClient) Pings(ctx context.Context, in *PingsRequest, opts ...grpc.CallOption) (PingPong_PingsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PingPong_serviceDesc.Streams[0], c.cc, "/test.PingPong/Pings", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pingPongPingsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PingPong_PingsClient interface {
+	Recv() (*PingResponse, error)
+	grpc.ClientStream
+}
+
+type pingPongPingsClient struct {
+	grpc.ClientStream
+}
+
+func (x *pingPongPingsClient) Recv() (*PingResponse, error) {
+	m := new(PingResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for PingPong service
 
 type PingPongServer interface {
 	Ping(context.Context, *PingRequest) (*PingResponse, error)
+	// Pings streams back the requested number of responses.
+	Pings(*PingsRequest, PingPong_PingsServer) error
 }
 
 func RegisterPingPongServer(s *grpc.Server, srv PingPongServer) {
@@ -129,6 +186,27 @@ func _PingPong_Ping_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PingPong_Pings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PingsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PingPongServer).Pings(m, &pingPongPingsServer{stream})
+}
+
+type PingPong_PingsServer interface {
+	Send(*PingResponse) error
+	grpc.ServerStream
+}
+
+type pingPongPingsServer struct {
+	grpc.ServerStream
+}
+
+func (x *pingPongPingsServer) Send(m *PingResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _PingPong_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "test.PingPong",
 	HandlerType: (*PingPongServer)(nil),
@@ -138,22 +216,32 @@ var _PingPong_serviceDesc = grpc.ServiceDesc{
 			Handler:    _PingPong_Ping_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
-	Metadata: "pkg/base/server/test/pingpong.proto",
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Pings",
+			Handler:       _PingPong_Pings_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/server/test/pingpong.proto",
 }
 
-func init() { proto.RegisterFile("pkg/base/server/test/pingpong.proto", fileDescriptor0) }
+func init() { proto.RegisterFile("pkg/server/test/pingpong.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 151 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0xce, 0xb1, 0x0a, 0x02, 0x31,
-	0x0c, 0x06, 0x60, 0x0f, 0x0e, 0x39, 0xa2, 0x8b, 0x99, 0xc4, 0x49, 0xeb, 0xe2, 0xd4, 0x82, 0x8e,
-	0xbe, 0xc4, 0x71, 0x6f, 0xe0, 0x41, 0x08, 0x87, 0x90, 0xd4, 0xa6, 0xfa, 0xfc, 0xd2, 0xde, 0xd2,
-	0xed, 0xe7, 0xe7, 0xe3, 0x4f, 0xe0, 0x1a, 0xdf, 0x1c, 0xe6, 0x97, 0x51, 0x30, 0x4a, 0x3f, 0x4a,
-	0x21, 0x93, 0xe5, 0x10, 0x17, 0xe1, 0xa8, 0xc2, 0x3e, 0x26, 0xcd, 0x8a, 0x7d, 0x29, 0xdd, 0x05,
-	0x76, 0xe3, 0x22, 0x3c, 0xd1, 0xe7, 0x4b, 0x96, 0x11, 0xa1, 0x2f, 0xec, 0xd8, 0x9d, 0xbb, 0xdb,
-	0x30, 0xd5, 0xec, 0x1c, 0xec, 0x57, 0x62, 0x51, 0xc5, 0xa8, 0x1a, 0x6d, 0x8c, 0x0a, 0xdf, 0x9f,
-	0x30, 0x14, 0x33, 0xaa, 0x30, 0x06, 0xe8, 0x4b, 0xc6, 0x83, 0x2f, 0x17, 0x7c, 0x33, 0x7f, 0xc2,
-	0xb6, 0x5a, 0xe7, 0xdc, 0x66, 0xde, 0xd6, 0x87, 0x1e, 0xff, 0x00, 0x00, 0x00, 0xff, 0xff, 0xda,
-	0xa4, 0xdd, 0xd3, 0xb7, 0x00, 0x00, 0x00,
+	// 223 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x8f, 0x41, 0x4a, 0x04, 0x31,
+	0x10, 0x45, 0x8d, 0xf4, 0x48, 0x53, 0x3a, 0x0b, 0x4b, 0x17, 0x32, 0x88, 0x68, 0x70, 0x21, 0x2e,
+	0x3a, 0xea, 0xec, 0x5c, 0x7a, 0x82, 0xa1, 0x6f, 0xd0, 0x4a, 0x51, 0x34, 0x4a, 0x55, 0xec, 0xca,
+	0xcc, 0x01, 0xf4, 0x08, 0x1e, 0xcd, 0x2b, 0x78, 0x10, 0x49, 0x1a, 0x21, 0x2b, 0x77, 0x95, 0xcf,
+	0xcb, 0xe3, 0x7f, 0xb8, 0x88, 0xaf, 0x1c, 0x8c, 0xa6, 0x1d, 0x4d, 0x21, 0x91, 0xa5, 0x10, 0x47,
+	0xe1, 0xa8, 0xc2, 0x5d, 0x9c, 0x34, 0x29, 0x36, 0x39, 0x5c, 0x9d, 0xb3, 0x2a, 0xbf, 0x51, 0x18,
+	0xe2, 0x18, 0x06, 0x11, 0x4d, 0x43, 0x1a, 0x55, 0x6c, 0x66, 0xfc, 0x15, 0x1c, 0x6e, 0x46, 0xe1,
+	0x9e, 0xde, 0xb7, 0x64, 0x09, 0x11, 0x9a, 0x2c, 0x39, 0x73, 0x97, 0xee, 0xa6, 0xed, 0xcb, 0xed,
+	0xaf, 0xe1, 0x28, 0x23, 0xf6, 0xc7, 0x9c, 0xc2, 0xe2, 0x45, 0xb7, 0x92, 0x0a, 0xb4, 0xec, 0xe7,
+	0x87, 0xf7, 0x33, 0xd5, 0x93, 0x45, 0x15, 0xa3, 0x62, 0xd2, 0xca, 0xa4, 0xc2, 0x0f, 0x9f, 0x0e,
+	0xda, 0x0c, 0x6d, 0x54, 0x18, 0x9f, 0xa0, 0xc9, 0x37, 0x1e, 0x77, 0xb9, 0x66, 0x57, 0xb5, 0x58,
+	0x61, 0x1d, 0xcd, 0x3e, 0x7f, 0xf2, 0xf1, 0xfd, 0xf3, 0xb5, 0xbf, 0xf4, 0x6d, 0xd8, 0xdd, 0x97,
+	0xa1, 0x8f, 0xee, 0x16, 0xd7, 0xb0, 0x28, 0xd5, 0xb0, 0xfa, 0x61, 0xff, 0x59, 0xf6, 0xee, 0xdc,
+	0xf3, 0x41, 0x59, 0xbe, 0xfe, 0x1d, 0x00, 0x8f, 0x37, 0x1e, 0xe7, 0x3f, 0x01, 0x00, 0x00,
 }